@@ -125,6 +125,10 @@ func run() error {
 		LogWriter:                  io.Discard,
 		Events:                     events,
 	}
+	err = config.Validate()
+	if err != nil {
+		return err
+	}
 	agent := agentpkg.NewAgent(config)
 	done := make(chan struct{})
 	go func() {
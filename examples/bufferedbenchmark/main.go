@@ -135,6 +135,10 @@ func run() error {
 		LogWriter:                  io.Discard,
 		Events:                     underlyingEvents,
 	}
+	err = config.Validate()
+	if err != nil {
+		return err
+	}
 	underlyingAgent := agentpkg.NewAgent(config)
 	events := make(chan interface{})
 	bufferedConfig := bufferedagent.Config{
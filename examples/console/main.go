@@ -181,6 +181,7 @@ func run() error {
 		SubmitTxer:        &horizon.Submitter{HorizonClient: horizonClient},
 		NetworkPassphrase: networkDetails.NetworkPassphrase,
 		BaseFee:           txnbuild.MinBaseFee,
+		BaseFeeProvider:   &horizon.BaseFeeProvider{HorizonClient: horizonClient},
 		FeeAccount:        accountKey,
 		FeeAccountSigners: []*keypair.Full{signerKey},
 	}
@@ -231,6 +232,9 @@ func run() error {
 			ChannelAccountSigner:       signerKey,
 			LogWriter:                  io.Discard,
 			Events:                     underlyingEvents,
+			// The open command opens channels in assets issued by the
+			// initiator's signer, so accept opens in any asset.
+			AllowAnyAsset: true,
 		}
 		if filename != "" {
 			config.Snapshotter = JSONFileSnapshotter{
@@ -241,6 +245,10 @@ func run() error {
 				ChannelAccountKey:          channelAccountKey,
 			}
 		}
+		err = config.Validate()
+		if err != nil {
+			return err
+		}
 		underlyingAgent = agentpkg.NewAgent(config)
 
 		tx, err := txbuild.CreateChannelAccount(txbuild.CreateChannelAccountParams{
@@ -285,6 +293,7 @@ func run() error {
 			ChannelAccountSigner: signerKey,
 			LogWriter:            io.Discard,
 			Events:               underlyingEvents,
+			AllowAnyAsset:        true,
 		}
 		underlyingAgent = agentpkg.NewAgentFromSnapshot(config, file.Snapshot)
 	}
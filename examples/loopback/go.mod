@@ -0,0 +1,17 @@
+module github.com/stellar/starlight/examples/loopback
+
+go 1.17
+
+replace github.com/stellar/starlight/sdk => ../../sdk
+
+require (
+	github.com/stellar/go v0.0.0-20211104231909-68ccd74d8906
+	github.com/stellar/starlight/sdk v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v2 v2.3.0 // indirect
+)
+
+require (
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/stellar/go-xdr v0.0.0-20211103144802-8017fc4bdfee // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
+)
@@ -0,0 +1,267 @@
+// Command loopback runs two agents in a single process that connect to each
+// other over a loopback TCP connection, open a channel, make payments in both
+// directions, and close the channel. The agents use the in-memory ledger of
+// the agenttest package in place of the Stellar network, so the example runs
+// without a network.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	agentpkg "github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/agent/agenttest"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild"
+)
+
+const (
+	observationPeriodTime      = 10 * time.Second
+	observationPeriodLedgerGap = 1
+	maxOpenExpiry              = 5 * time.Minute
+	timeout                    = 30 * time.Second
+)
+
+func main() {
+	err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stdout, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	showHelp := false
+	payments := 5
+	paymentAmount := int64(1_0000000)
+	verbose := false
+
+	fs := flag.NewFlagSet("loopback", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.BoolVar(&showHelp, "h", showHelp, "Show this help")
+	fs.IntVar(&payments, "payments", payments, "Number of payments each participant makes")
+	fs.Int64Var(&paymentAmount, "amount", paymentAmount, "Amount in stroops of each payment")
+	fs.BoolVar(&verbose, "v", verbose, "Write the logs of the agents to stderr")
+	err := fs.Parse(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	if showHelp {
+		fs.Usage()
+		return nil
+	}
+
+	logWriter := io.Discard
+	if verbose {
+		logWriter = os.Stderr
+	}
+
+	ledger := agenttest.NewLedger()
+	initiator, err := newParticipant(ledger, "initiator", 100_0000000, logWriter)
+	if err != nil {
+		return err
+	}
+	responder, err := newParticipant(ledger, "responder", 100_0000000, logWriter)
+	if err != nil {
+		return err
+	}
+
+	// The responder serves and the initiator connects on a loopback address
+	// chosen by the operating system.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- responder.Agent.ServeTCP(addr)
+	}()
+	for i := 0; ; i++ {
+		err = initiator.Agent.ConnectTCP(addr)
+		if err == nil || i == 100 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+	err = <-served
+	if err != nil {
+		return fmt.Errorf("serving: %w", err)
+	}
+	if err = waitAll(initiator.Connected, responder.Connected); err != nil {
+		return fmt.Errorf("waiting for connect: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "connected on %s\n", addr)
+
+	err = initiator.Agent.Open(state.NativeAsset)
+	if err != nil {
+		return fmt.Errorf("opening: %w", err)
+	}
+	if err = waitAll(initiator.Opened, responder.Opened); err != nil {
+		return fmt.Errorf("waiting for open: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "opened channel\n")
+
+	for i := 0; i < payments; i++ {
+		for _, p := range []*participant{initiator, responder} {
+			err = p.Agent.Payment(paymentAmount)
+			if err != nil {
+				return fmt.Errorf("%s paying: %w", p.Name, err)
+			}
+			if err = waitAll(p.PaymentSent); err != nil {
+				return fmt.Errorf("waiting for %s payment: %w", p.Name, err)
+			}
+		}
+	}
+	// The initiator makes a final payment so that the close moves funds.
+	err = initiator.Agent.Payment(paymentAmount)
+	if err != nil {
+		return fmt.Errorf("initiator paying: %w", err)
+	}
+	if err = waitAll(initiator.PaymentSent); err != nil {
+		return fmt.Errorf("waiting for initiator payment: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "made %d payments\n", 2*payments+1)
+
+	err = initiator.Agent.DeclareClose()
+	if err != nil {
+		return fmt.Errorf("declaring close: %w", err)
+	}
+	if err = waitAll(initiator.Closed, responder.Closed); err != nil {
+		return fmt.Errorf("waiting for close: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "closed channel\n")
+
+	for _, p := range []*participant{initiator, responder} {
+		balance, err := ledger.GetBalance(p.ChannelAccount.FromAddress(), state.NativeAsset)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s channel account balance: %s\n", p.Name, amount.StringFromInt64(balance))
+	}
+	return nil
+}
+
+// participant is an agent and the channels that receive its events.
+type participant struct {
+	Name           string
+	ChannelAccount *keypair.Full
+	Agent          *agentpkg.Agent
+
+	Connected   chan struct{}
+	Opened      chan struct{}
+	PaymentSent chan struct{}
+	Closed      chan struct{}
+}
+
+// newParticipant creates and funds a channel account on the ledger, and
+// returns a participant with an agent for the channel account.
+func newParticipant(ledger *agenttest.Ledger, name string, balance int64, logWriter io.Writer) (*participant, error) {
+	signer := keypair.MustRandom()
+	channelAccount := keypair.MustRandom()
+	ledger.CreateAccount(signer.FromAddress(), 2*balance)
+
+	seqNum, err := ledger.GetSequenceNumber(signer.FromAddress())
+	if err != nil {
+		return nil, err
+	}
+	tx, err := txbuild.CreateChannelAccount(txbuild.CreateChannelAccountParams{
+		Creator:        signer.FromAddress(),
+		ChannelAccount: channelAccount.FromAddress(),
+		SequenceNumber: seqNum + 1,
+		Asset:          txnbuild.NativeAsset{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building create channel account tx: %w", err)
+	}
+	err = ledger.SubmitTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("creating channel account: %w", err)
+	}
+	tx, err = txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount: &txnbuild.SimpleAccount{AccountID: signer.Address(), Sequence: seqNum + 2},
+		BaseFee:       txnbuild.MinBaseFee,
+		Timebounds:    txnbuild.NewInfiniteTimeout(),
+		Operations: []txnbuild.Operation{
+			&txnbuild.Payment{Destination: channelAccount.Address(), Asset: txnbuild.NativeAsset{}, Amount: amount.StringFromInt64(balance)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building fund channel account tx: %w", err)
+	}
+	err = ledger.SubmitTx(tx)
+	if err != nil {
+		return nil, fmt.Errorf("funding channel account: %w", err)
+	}
+
+	events := make(chan interface{})
+	p := &participant{
+		Name:           name,
+		ChannelAccount: channelAccount,
+		Connected:      make(chan struct{}, 1),
+		Opened:         make(chan struct{}, 1),
+		PaymentSent:    make(chan struct{}, 1),
+		Closed:         make(chan struct{}, 1),
+	}
+	p.Agent = agentpkg.NewAgent(agentpkg.Config{
+		ObservationPeriodTime:      observationPeriodTime,
+		ObservationPeriodLedgerGap: observationPeriodLedgerGap,
+		MaxOpenExpiry:              maxOpenExpiry,
+		NetworkPassphrase:          network.TestNetworkPassphrase,
+
+		SequenceNumberCollector: ledger,
+		BalanceCollector:        ledger,
+		Submitter:               ledger,
+		Streamer:                ledger,
+
+		ChannelAccountKey:    channelAccount.FromAddress(),
+		ChannelAccountSigner: signer,
+
+		LogWriter: logWriter,
+
+		Events: events,
+	})
+	go func() {
+		for e := range events {
+			switch e := e.(type) {
+			case agentpkg.ErrorEvent:
+				fmt.Fprintf(os.Stderr, "%s error: %v\n", name, e.Err)
+			case agentpkg.ConnectedEvent:
+				p.Connected <- struct{}{}
+			case agentpkg.OpenedEvent:
+				p.Opened <- struct{}{}
+			case agentpkg.PaymentSentEvent:
+				p.PaymentSent <- struct{}{}
+			case agentpkg.ClosedEvent:
+				p.Closed <- struct{}{}
+			}
+		}
+	}()
+	return p, nil
+}
+
+// waitAll waits to receive from each channel, or returns an error if that
+// takes longer than the timeout.
+func waitAll(chs ...chan struct{}) error {
+	deadline := time.After(timeout)
+	for _, ch := range chs {
+		select {
+		case <-ch:
+		case <-deadline:
+			return fmt.Errorf("timed out after %v", timeout)
+		}
+	}
+	return nil
+}
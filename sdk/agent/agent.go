@@ -10,35 +10,146 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/stellar/go/keypair"
 	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
 	"github.com/stellar/starlight/sdk/agent/msg"
 	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild"
 )
 
+// ErrMalformedMessage indicates that a message was received that does not
+// contain the payload required by its type.
+var ErrMalformedMessage = errors.New("malformed message")
+
+// ErrOpenNotSeen indicates that the open transaction was not seen by the
+// Streamer within the configured open timeout.
+var ErrOpenNotSeen = errors.New("open transaction not seen before timeout")
+
+// ErrPaymentNotApproved indicates that a payment proposed by the other
+// participant was rejected by the configured PaymentApprover.
+var ErrPaymentNotApproved = errors.New("payment not approved")
+
+// ErrUnexpectedHello indicates that the other participant sent a hello on a
+// connection that it had already sent a hello on.
+var ErrUnexpectedHello = errors.New("unexpected hello")
+
+// ErrPaymentTooLarge indicates that a payment amount exceeds the maximum
+// payment amount the agent is configured to propose or confirm.
+var ErrPaymentTooLarge = errors.New("payment amount exceeds maximum payment amount")
+
+// ErrAppDataTooLarge indicates that the application data of a payment exceeds
+// the maximum size the agent is configured to send or accept.
+var ErrAppDataTooLarge = errors.New("payment app data exceeds maximum size")
+
+// ErrAssetNotAllowed indicates that an open proposed by the other participant
+// is for an asset the agent is not configured to accept.
+var ErrAssetNotAllowed = errors.New("asset not allowed")
+
+// ErrMaxIterationsReached indicates that the channel has reached the maximum
+// iteration number the agent is configured to make payments up to, and that
+// the channel should be closed and a new channel opened to make further
+// payments.
+var ErrMaxIterationsReached = errors.New("max iterations reached")
+
+// ErrFeeAccountUnderfunded indicates that a Submitter could not submit a
+// transaction because the account paying its fee has insufficient balance.
+// A close that cannot be submitted during a dispute may lose the channel's
+// funds, and so the agent writes an ErrorEvent when it occurs.
+var ErrFeeAccountUnderfunded = errors.New("fee account underfunded")
+
+// ErrAccountNotFound indicates that an account does not exist on the network.
+// SequenceNumberCollector and BalanceCollector implementations should return
+// an error wrapping it when asked about an account that does not exist.
+var ErrAccountNotFound = errors.New("account not found")
+
+// ErrChannelAccountNotReady indicates that the channel account cannot be used
+// to open a channel. Errors wrapping it are either ErrChannelAccountNotFound
+// or ErrChannelAccountUnfunded.
+var ErrChannelAccountNotReady = errors.New("channel account not ready")
+
+// ErrChannelAccountNotFound indicates that the channel account does not exist.
+var ErrChannelAccountNotFound = fmt.Errorf("%w: channel account does not exist", ErrChannelAccountNotReady)
+
+// ErrChannelAccountUnfunded indicates that the channel account exists but has
+// no balance of the channel's asset available to contribute to the channel, or
+// less than the configured contribution.
+var ErrChannelAccountUnfunded = fmt.Errorf("%w: channel account balance insufficient", ErrChannelAccountNotReady)
+
 // BalanceCollector gets the balance of an asset for an account.
 type BalanceCollector interface {
 	GetBalance(account *keypair.FromAddress, asset state.Asset) (int64, error)
 }
 
+// MultiBalanceCollector is an optional interface that a BalanceCollector can
+// implement to get the balances of several assets held by an account at once,
+// such as with a single request, so that the balances are read at the same
+// point in time. The map returned contains every asset asked for, with a zero
+// balance for assets the account does not hold.
+type MultiBalanceCollector interface {
+	GetBalances(account *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error)
+}
+
 // SequenceNumberCollector gets the sequence number for an account.
 type SequenceNumberCollector interface {
 	GetSequenceNumber(account *keypair.FromAddress) (int64, error)
 }
 
 // Submitter submits a transaction to the network.
+//
+// Transactions built by the agent pay no fee, and so a Submitter is expected
+// to wrap them in a fee bump transaction that pays the fee from an account it
+// controls. If a transaction cannot be submitted because that fee account
+// does not have the balance to pay the fee, the error returned should wrap
+// ErrFeeAccountUnderfunded so that the agent can report it distinctly.
 type Submitter interface {
 	SubmitTx(tx *txnbuild.Transaction) error
 }
 
+// FeeReporter is an optional interface that a Submitter can implement to
+// report the base fee per operation it pays for the transactions it submits.
+// A Submitter reporting a base fee higher than a transaction's base fee is
+// expected to wrap the transaction in a fee bump transaction paying it.
+type FeeReporter interface {
+	SubmitBaseFee() int64
+}
+
 // Streamer streams transactions that affect a set of accounts.
 type Streamer interface {
 	StreamTx(cursor string, accounts ...*keypair.FromAddress) (transactions <-chan StreamedTransaction, cancel func())
 }
 
+// StreamStatusReporter is an optional interface that a Streamer can implement
+// to report the health of the stream it is streaming.
+type StreamStatusReporter interface {
+	StreamStatus() StreamStatus
+}
+
+// StreamStatus is the health of a Streamer's stream.
+type StreamStatus struct {
+	// Connected is true if the stream is connected to its source.
+	Connected bool
+
+	// LatestLedger is the sequence number of the latest ledger the stream has
+	// caught up to, having delivered every transaction up to and including
+	// it, and LatestLedgerCloseTime is the time it closed. They are zero if
+	// no ledger has been seen. A Streamer should advance them as ledgers
+	// close even when the ledgers contain no transactions of the accounts
+	// streamed, otherwise the stream of accounts with no activity appears
+	// to fall behind.
+	LatestLedger          int64
+	LatestLedgerCloseTime time.Time
+
+	// Err is the most recent error encountered by the stream since it last
+	// saw a transaction, or nil if there has been none.
+	Err error
+}
+
 // StreamedTransaction is a transaction that has been seen by the
 // Streamer.
 type StreamedTransaction struct {
@@ -49,9 +160,21 @@ type StreamedTransaction struct {
 	// they were executed on the Stellar network.
 	TransactionOrderID int64
 
+	// Ledger is the sequence number of the ledger the transaction was
+	// included in, and LedgerCloseTime is the time the ledger closed. They are
+	// used to estimate the average duration of ledgers, and are optional.
+	Ledger          int64
+	LedgerCloseTime time.Time
+
 	TransactionXDR string
 	ResultXDR      string
 	ResultMetaXDR  string
+
+	// tx, result, and resultMeta cache the XDR fields once they have been
+	// decoded by the Transaction, Result, and ResultMeta methods.
+	tx         *txnbuild.GenericTransaction
+	result     *xdr.TransactionResult
+	resultMeta *xdr.TransactionMeta
 }
 
 // Snapshotter is given a snapshot of the agent and its dependencies whenever
@@ -64,38 +187,366 @@ type Snapshotter interface {
 // Config contains the information that can be supplied to configure the Agent
 // at construction.
 type Config struct {
+	// ObservationPeriodTime and ObservationPeriodLedgerGap are the
+	// observation period of the channels the agent opens. Opens proposed by
+	// the other participant with a different observation period are rejected
+	// with an error wrapping state.ErrObservationPeriodMismatch, except that
+	// a zero value accepts any.
 	ObservationPeriodTime      time.Duration
 	ObservationPeriodLedgerGap int64
 	MaxOpenExpiry              time.Duration
 	NetworkPassphrase          string
 
+	// OpenExpiryMargin is how much earlier than MaxOpenExpiry the open
+	// agreements proposed by the agent expire, so that the other participant
+	// does not reject them because of clock drift when both use the same max
+	// open expiry. It must be greater than zero and less than MaxOpenExpiry.
+	// Deployments with tightly synchronized clocks can use a small margin to
+	// give the open longer to be submitted. Defaults to half of
+	// MaxOpenExpiry.
+	OpenExpiryMargin time.Duration
+
+	// BaseReserve is the base reserve of the network. If non-zero, the
+	// minimum balance that channel accounts must hold to cover their reserves
+	// is excluded from native balances collected by the BalanceCollector,
+	// because that amount cannot be paid out, and opening a channel with a
+	// credit asset fails if the channel account's native balance does not
+	// cover its reserves. It should be zero if the reserves of the channel
+	// accounts are sponsored.
+	BaseReserve int64
+
+	// LedgerDuration is the average duration of a ledger that is assumed when
+	// estimating when a close can be submitted, until enough ledgers have been
+	// observed by the Streamer to estimate it. Defaults to 5 seconds.
+	LedgerDuration time.Duration
+	// LedgerDurationWindow is the number of most recently observed ledgers
+	// used to estimate the average duration of a ledger. Defaults to 50.
+	LedgerDurationWindow int
+
+	// ExpiryWarningThreshold is how long before an expiry of the channel a
+	// ChannelExpiringEvent is written. See Expiry for the expiries that are
+	// monitored. If zero, no events are written.
+	ExpiryWarningThreshold time.Duration
+
+	// StreamLagThreshold is how far the close time of the latest ledger the
+	// stream has caught up to, as reported by a Streamer that implements
+	// StreamStatusReporter, can fall behind the current time before a
+	// StreamLagEvent is written. The lag of a Streamer that does not report
+	// its status is not measured. When set, errors reported by the Streamer
+	// are written as StreamErrorEvents. If zero, the stream is not
+	// monitored.
+	StreamLagThreshold time.Duration
+
+	// StreamResubscribeDelay is how long the agent waits to subscribe to the
+	// Streamer again, from the cursor of the last transaction it ingested,
+	// after the Streamer closes its stream of transactions while the channel
+	// is not closed. A StreamClosedEvent is written each time the stream
+	// closes. If zero, the agent does not subscribe again and stops ingesting.
+	StreamResubscribeDelay time.Duration
+
+	// OpenTimeout is how long after the open is authorized the open
+	// transaction must be seen by the Streamer before an OpenFailedEvent is
+	// written. If zero, no OpenFailedEvent is written for an open that is
+	// never seen.
+	OpenTimeout time.Duration
+
+	// VerifyOpenBalances, if set, has the initiator check with the
+	// BalanceCollector that both channel accounts hold at least their
+	// contributions once the open transaction is seen, writing an
+	// OpenVerifiedEvent or OpenMismatchEvent before the OpenedEvent.
+	VerifyOpenBalances bool
+
+	// MaxPaymentAmount is the largest amount of a single payment that the
+	// agent will propose or confirm. If zero, payments are not limited.
+	MaxPaymentAmount int64
+
+	// MaxAppDataSize is the largest size in bytes of the application data
+	// that the agent will send or accept with a payment. A payment with
+	// larger application data is rejected with ErrAppDataTooLarge. Defaults
+	// to 4096 bytes.
+	MaxAppDataSize int
+
+	// AllowedAssets are the assets the agent accepts in an open proposed by
+	// the other participant. An open in any other asset is rejected with
+	// ErrAssetNotAllowed, so that the other participant cannot have the
+	// agent commit to a channel in an asset it does not want. If empty, only
+	// the native asset is accepted, unless AllowAnyAsset is set.
+	AllowedAssets []state.Asset
+	// AllowAnyAsset, if set and AllowedAssets is empty, accepts opens in any
+	// asset.
+	AllowAnyAsset bool
+
+	// MaxIterations is the largest iteration number the agent will propose
+	// payments up to. Once the latest agreement of the channel has reached
+	// it, payments return ErrMaxIterationsReached and the channel should be
+	// closed and reopened to continue. The open is the first iteration, so a
+	// channel can make MaxIterations-1 payments. Payments proposed by the
+	// other participant are still confirmed. If zero, iterations are not
+	// limited.
+	//
+	// The buffered agent makes one payment, and so uses one iteration, for
+	// each flush of its buffer, regardless of how many payments are in the
+	// buffer.
+	MaxIterations int64
+
+	// ReserveAmount is the amount of the channel's asset that each channel
+	// account must still hold after paying out what a payment agreement owes
+	// from it. See state.Config.ReserveAmount.
+	ReserveAmount int64
+
+	// SignerScheme is the weights and thresholds the open transaction sets on
+	// the channel accounts, such as to let the creator of a channel account
+	// retain its master key as a recovery signer at a low weight. Both
+	// participants must configure the same scheme, and opens proposed with a
+	// different scheme are rejected with an error wrapping
+	// state.ErrSignerSchemeMismatch. The scheme must require both
+	// participants' signers, see txbuild.SignerScheme.Validate. Defaults to
+	// txbuild.DefaultSignerScheme.
+	SignerScheme txbuild.SignerScheme
+
+	// PaymentTimeout is how long the agent waits for the other participant to
+	// confirm a payment it proposes before abandoning it. See
+	// PaymentTimeoutEvent. If zero, proposed payments never time out, unless
+	// ResponseTimeout is set.
+	PaymentTimeout time.Duration
+
+	// ResponseTimeout is how long the agent waits for the other participant
+	// to respond to an open, payment, or close that it proposes. If no
+	// response arrives in time, the pending payment or close is abandoned and
+	// a PaymentTimeoutEvent or CloseResponseTimeoutEvent is written, and a
+	// pending open is reported with an OpenResponseTimeoutEvent. For payments
+	// PaymentTimeout takes precedence if set. If zero, the agent waits for
+	// responses indefinitely.
+	ResponseTimeout time.Duration
+
+	// ConnectRetries is how many more times ConnectTCP tries to connect and
+	// send its hello if either fails, such as when the other participant is
+	// still starting up. It waits ConnectRetryBackoff before the first retry,
+	// doubling the wait for each retry after, and the backoff defaults to 100
+	// milliseconds.
+	ConnectRetries      int
+	ConnectRetryBackoff time.Duration
+	// HelloTimeout is how long the agent waits for the other participant's
+	// hello after connecting before closing the connection and writing a
+	// ConnectFailedEvent. If zero, the agent waits indefinitely.
+	HelloTimeout time.Duration
+	// TCPKeepAlivePeriod is the period of the TCP keepalive probes sent on
+	// connections accepted by ServeTCP and dialed by ConnectTCP, so that the
+	// operating system detects another participant that has vanished, such
+	// as behind a NAT, even when the agent is not sending. If zero, it
+	// defaults to 15 seconds. If negative, keepalive is disabled.
+	TCPKeepAlivePeriod time.Duration
+	// ReplayOnReconnect, if true, has the agent send its last open or payment
+	// request again when the other participant's hello is received on a new
+	// connection, if the request is still unanswered, so that a request that
+	// may not have reached the other participant before the previous
+	// connection ended completes. Only requests the other participant can
+	// recognize when received again are replayed: an open, identified by its
+	// open agreement, and a payment, identified by its iteration number.
+	// Coordinated closes are not replayed.
+	ReplayOnReconnect bool
+	// SendTimeout is how long the agent waits for a message to be written to
+	// the connection, such as when the other participant is slow to read,
+	// before giving up, closing the connection, and writing a
+	// DisconnectedEvent. A payment whose request cannot be sent in time is
+	// discarded and ErrSendTimeout is returned, so that a new payment can be
+	// made. If zero, the agent waits indefinitely.
+	SendTimeout time.Duration
+
+	// PaymentApprover, if set, is called with the amount and memo of each
+	// payment the other participant proposes, before the payment is
+	// confirmed. If it returns an error the payment is rejected and the error
+	// message is sent to the other participant as the reason. If nil, all
+	// valid payments are confirmed.
+	PaymentApprover func(amount int64, memo []byte) error
+
+	// MessageObserver, if set, is called with each message sent to or
+	// received from the other participant, after it is encoded or decoded.
+	// It is called from the agent's send and receive loops, and so it should
+	// return quickly and must not call the agent.
+	MessageObserver func(direction MessageDirection, m msg.Message)
+
+	// ReceiptWriter, if set, is written a Receipt of every payment sent or
+	// received once it is settled, as a journal of payments that is
+	// independent of the Snapshotter. Each receipt is a line of JSON written
+	// with a single call to Write. Receipts are queued and written in the
+	// order the payments settle by a goroutine, so that a slow writer does
+	// not block the agent until ReceiptBufferSize receipts are queued.
+	// ReceiptBufferSize defaults to 256.
+	ReceiptWriter     io.Writer
+	ReceiptBufferSize int
+
+	// Info, if set, is sent to the other participant after the hello to share
+	// operational metadata such as the software version and the assets and
+	// payment amounts the agent accepts. The info the other participant sends
+	// is available from OtherInfo and is written as an InfoReceivedEvent.
+	// Sending info is optional, and participants that do not support it
+	// report the message as unrecognized but are otherwise unaffected.
+	Info *msg.Info
+
+	// Contribution is the amount of the channel's asset this participant
+	// commits to the channel from its channel account, and RemoteContribution
+	// is the amount expected from the other participant. When opening, they
+	// are proposed as the contributions of each participant. When confirming
+	// an open, an agreement stating different contributions is rejected with
+	// state.ErrContributionMismatch, except that a zero value accepts any
+	// contribution. See state.OpenDetails for how contributions relate to
+	// balances.
+	Contribution       int64
+	RemoteContribution int64
+
+	// TrustLimit is the limit of this participant's channel account trustline
+	// for the channel's asset, and RemoteTrustLimit is the limit expected of
+	// the other participant's. The open transaction sets the limit of each
+	// trustline, so they should match the limits the channel accounts were
+	// created with, such as with txbuild.CreateChannelAccount. When
+	// confirming an open, an agreement stating different limits is rejected
+	// with state.ErrTrustLimitMismatch, except that a zero value accepts any
+	// limit. If zero when opening, the trustline has the maximum limit.
+	TrustLimit       int64
+	RemoteTrustLimit int64
+
 	SequenceNumberCollector SequenceNumberCollector
 	BalanceCollector        BalanceCollector
 	Submitter               Submitter
-	Streamer                Streamer
 	Snapshotter             Snapshotter
 
+	// Streamer streams the transactions of the channel accounts so that the
+	// agent sees the channel open, balances change, and closes declared or
+	// submitted by either participant. It is optional. Without a Streamer
+	// the agent does not ingest transactions itself, and the application
+	// must give it the channel's transactions with Agent.IngestTx, otherwise
+	// the channel is never seen to open and payments cannot be made. Stream
+	// lag and stream status are unavailable without a Streamer, and so
+	// StreamLagThreshold and StreamResubscribeDelay have no effect and
+	// Healthy does not check the stream.
+	Streamer Streamer
+
+	// SnapshotPolicy controls how often the Snapshotter is given a snapshot.
+	// Defaults to a snapshot after every change.
+	SnapshotPolicy SnapshotPolicy
+
+	// ChannelAccountKey is the address of the channel account, and
+	// ChannelAccountSigner signs agreements for it. A *keypair.Full can be
+	// used as the signer, or any other state.Signer. Once the signer has been
+	// rotated with Agent.RotateSigner, an agent restored from a snapshot must
+	// be given the new signer.
 	ChannelAccountKey    *keypair.FromAddress
-	ChannelAccountSigner *keypair.Full
+	ChannelAccountSigner state.Signer
 
 	LogWriter io.Writer
 
 	Events chan<- interface{}
 }
 
-// NewAgent constructs a new agent with the given config.
+// ErrInvalidConfig indicates that a Config is missing dependencies that the
+// agent requires.
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Validate checks that the config has the dependencies that the agent
+// requires to operate a channel. The error returned wraps ErrInvalidConfig and
+// lists each missing or invalid field with the operations that require it. NewAgent does
+// not validate the config, and so Validate should be called before NewAgent
+// to fail fast instead of failing when an operation first requires a missing
+// field.
+func (c Config) Validate() error {
+	var problems []string
+	if c.NetworkPassphrase == "" {
+		problems = append(problems, "NetworkPassphrase is required to sign agreements and transactions")
+	}
+	if c.ChannelAccountKey == nil {
+		problems = append(problems, "ChannelAccountKey is required to open or join a channel")
+	}
+	if c.ChannelAccountSigner == nil {
+		problems = append(problems, "ChannelAccountSigner is required to sign agreements and transactions")
+	}
+	if c.SequenceNumberCollector == nil {
+		problems = append(problems, "SequenceNumberCollector is required to open a channel")
+	}
+	if c.BalanceCollector == nil {
+		problems = append(problems, "BalanceCollector is required to open a channel")
+	}
+	if c.Submitter == nil {
+		problems = append(problems, "Submitter is required to open and close a channel")
+	}
+	if c.TrustLimit < 0 || c.RemoteTrustLimit < 0 {
+		problems = append(problems, "TrustLimit and RemoteTrustLimit must not be negative")
+	}
+	if c.OpenExpiryMargin < 0 || (c.OpenExpiryMargin > 0 && c.OpenExpiryMargin >= c.MaxOpenExpiry) {
+		problems = append(problems, "OpenExpiryMargin must be greater than zero and less than MaxOpenExpiry to open a channel")
+	}
+	if err := c.SignerScheme.OrDefault().Validate(); err != nil {
+		problems = append(problems, fmt.Sprintf("SignerScheme must require both participants' signers: %v", err))
+	}
+	if c.LogWriter == nil {
+		problems = append(problems, "LogWriter is required for all operations, use io.Discard to discard logs")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// WithChannelAccount returns a copy of the config with the channel account
+// and its signer replaced, so that the configs of many channels can be derived
+// from one. The copy is shallow, and so the copy shares the config's
+// dependencies, such as its collectors, Submitter, Streamer, Snapshotter,
+// LogWriter, and Events channel, which should be replaced if they must not
+// be shared between agents.
+func (c Config) WithChannelAccount(key *keypair.FromAddress, signer state.Signer) Config {
+	c.ChannelAccountKey = key
+	c.ChannelAccountSigner = signer
+	return c
+}
+
+// NewAgent constructs a new agent with the given config. See Config.Validate
+// for checking that the config has the dependencies the agent requires.
 func NewAgent(c Config) *Agent {
 	agent := &Agent{
 		observationPeriodTime:      c.ObservationPeriodTime,
 		observationPeriodLedgerGap: c.ObservationPeriodLedgerGap,
 		maxOpenExpiry:              c.MaxOpenExpiry,
+		openExpiryMargin:           c.OpenExpiryMargin,
 		networkPassphrase:          c.NetworkPassphrase,
+		baseReserve:                c.BaseReserve,
+		ledgerDuration:             c.LedgerDuration,
+		ledgerDurationWindow:       c.LedgerDurationWindow,
+		expiryWarningThreshold:     c.ExpiryWarningThreshold,
+		streamLagThreshold:         c.StreamLagThreshold,
+		streamResubscribeDelay:     c.StreamResubscribeDelay,
+		openTimeout:                c.OpenTimeout,
+		verifyOpenBalances:         c.VerifyOpenBalances,
+		maxPaymentAmount:           c.MaxPaymentAmount,
+		maxAppDataSize:             c.MaxAppDataSize,
+		maxIterations:              c.MaxIterations,
+		allowedAssets:              c.AllowedAssets,
+		allowAnyAsset:              c.AllowAnyAsset,
+		reserveAmount:              c.ReserveAmount,
+		signerScheme:               c.SignerScheme,
+		paymentTimeout:             c.PaymentTimeout,
+		responseTimeout:            c.ResponseTimeout,
+		connectRetries:             c.ConnectRetries,
+		connectRetryBackoff:        c.ConnectRetryBackoff,
+		helloTimeout:               c.HelloTimeout,
+		tcpKeepAlivePeriod:         c.TCPKeepAlivePeriod,
+		replayOnReconnect:          c.ReplayOnReconnect,
+		sendTimeout:                c.SendTimeout,
+		paymentApprover:            c.PaymentApprover,
+		messageObserver:            c.MessageObserver,
+		receiptWriter:              c.ReceiptWriter,
+		receiptBufferSize:          c.ReceiptBufferSize,
+		info:                       c.Info,
+		contribution:               c.Contribution,
+		remoteContribution:         c.RemoteContribution,
+		trustLimit:                 c.TrustLimit,
+		remoteTrustLimit:           c.RemoteTrustLimit,
 
 		sequenceNumberCollector: c.SequenceNumberCollector,
 		balanceCollector:        c.BalanceCollector,
 		submitter:               c.Submitter,
 		streamer:                c.Streamer,
 		snapshotter:             c.Snapshotter,
+		snapshotPolicy:          c.SnapshotPolicy,
 
 		channelAccountKey:    c.ChannelAccountKey,
 		channelAccountSigner: c.ChannelAccountSigner,
@@ -104,6 +555,14 @@ func NewAgent(c Config) *Agent {
 
 		events: c.Events,
 	}
+	if c.ReceiptWriter != nil {
+		size := c.ReceiptBufferSize
+		if size <= 0 {
+			size = defaultReceiptBufferSize
+		}
+		agent.receipts = make(chan Receipt, size)
+		go agent.receiptLoop(agent.receipts)
+	}
 	return agent
 }
 
@@ -114,6 +573,8 @@ type Snapshot struct {
 	OtherChannelAccount       *keypair.FromAddress
 	OtherChannelAccountSigner *keypair.FromAddress
 	StreamerCursor            string
+	CloseReason               CloseReason
+	OpenToken                 string
 	State                     *struct {
 		Initiator bool
 		Snapshot  state.Snapshot
@@ -123,12 +584,15 @@ type Snapshot struct {
 // NewAgentFromSnapshot creates an agent using a previously generated snapshot
 // so that the new agent has the same state as the previous agent. To restore
 // the channel to its identical state the same config should be provided that
-// was in use when the snapshot was created.
+// was in use when the snapshot was created. ValidateStartingSequence can be
+// used to check the restored channel against the network.
 func NewAgentFromSnapshot(c Config, s Snapshot) *Agent {
 	agent := NewAgent(c)
 	agent.otherChannelAccount = s.OtherChannelAccount
 	agent.otherChannelAccountSigner = s.OtherChannelAccountSigner
 	agent.streamerCursor = s.StreamerCursor
+	agent.closeReason = s.CloseReason
+	agent.openToken = s.OpenToken
 	if s.State != nil {
 		agent.initChannel(s.State.Initiator, &s.State.Snapshot)
 	}
@@ -137,52 +601,152 @@ func NewAgentFromSnapshot(c Config, s Snapshot) *Agent {
 
 // Agent coordinates a payment channel over a TCP connection.
 type Agent struct {
+	// The fields above mu are set at construction from the Config and are
+	// never written afterwards, so they may be read without locking.
+
 	observationPeriodTime      time.Duration
 	observationPeriodLedgerGap int64
 	maxOpenExpiry              time.Duration
+	openExpiryMargin           time.Duration
 	networkPassphrase          string
+	baseReserve                int64
+	ledgerDuration             time.Duration
+	ledgerDurationWindow       int
+	expiryWarningThreshold     time.Duration
+	streamLagThreshold         time.Duration
+	streamResubscribeDelay     time.Duration
+	openTimeout                time.Duration
+	verifyOpenBalances         bool
+	maxPaymentAmount           int64
+	maxAppDataSize             int
+	maxIterations              int64
+	allowedAssets              []state.Asset
+	allowAnyAsset              bool
+	reserveAmount              int64
+	signerScheme               txbuild.SignerScheme
+	paymentTimeout             time.Duration
+	responseTimeout            time.Duration
+	connectRetries             int
+	connectRetryBackoff        time.Duration
+	helloTimeout               time.Duration
+	tcpKeepAlivePeriod         time.Duration
+	replayOnReconnect          bool
+	sendTimeout                time.Duration
+	paymentApprover            func(amount int64, memo []byte) error
+	messageObserver            func(direction MessageDirection, m msg.Message)
+	receiptWriter              io.Writer
+	receiptBufferSize          int
+	info                       *msg.Info
+	contribution               int64
+	remoteContribution         int64
+	trustLimit                 int64
+	remoteTrustLimit           int64
 
 	sequenceNumberCollector SequenceNumberCollector
 	balanceCollector        BalanceCollector
 	submitter               Submitter
 	streamer                Streamer
 	snapshotter             Snapshotter
+	snapshotPolicy          SnapshotPolicy
 
 	channelAccountKey    *keypair.FromAddress
-	channelAccountSigner *keypair.Full
+	channelAccountSigner state.Signer
 
 	logWriter io.Writer
 
 	events chan<- interface{}
 
+	receipts chan Receipt
+
 	// mu is a lock for the mutable fields of this type. It should be locked
 	// when reading or writing any of the mutable fields. The mutable fields are
 	// listed below. If pushing to a chan, such as Events, it is unnecessary to
 	// lock.
 	mu sync.Mutex
 
-	conn                      io.ReadWriter
+	shuttingDown              bool
+	paused                    bool
+	conn                      interface{}
+	connCounters              *connCounters
+	recv                      messageDecoder
+	recvTail                  *tailReader
+	sendQueue                 chan sendRequest
 	otherChannelAccount       *keypair.FromAddress
 	otherChannelAccountSigner *keypair.FromAddress
+	rotationSigner            state.Signer
+	rotatedSigner             state.Signer
+	otherInfo                 *msg.Info
+	helloReceived             bool
+	lastRequest               *msg.Message
 	channel                   *state.Channel
+	openToken                 string
 	streamerTransactions      <-chan StreamedTransaction
 	streamerCursor            string
 	streamerCancel            func()
+	ledgerTimes               []ledgerTime
+	closeDeclaredAt           time.Time
+	closeResuming             bool
+	closeReason               CloseReason
+	expiryWarnings            map[Expiry]time.Time
+	streamStartedAt           time.Time
+	streamLagging             bool
+	streamErr                 error
+	unsnapshottedChanges      int
+	lastSnapshotAt            time.Time
+	lastSnapshotMilestone     snapshotMilestone
 }
 
-// Config returns the configuration that the Agent was constructed with.
+// Config returns the configuration that the Agent was constructed with. The
+// configuration does not change after construction, so Config is safe to call
+// concurrently with any other method. The ChannelAccountSigner is the signer
+// the agent was constructed with even after it has been rotated with
+// RotateSigner.
 func (a *Agent) Config() Config {
 	return Config{
 		ObservationPeriodTime:      a.observationPeriodTime,
 		ObservationPeriodLedgerGap: a.observationPeriodLedgerGap,
 		MaxOpenExpiry:              a.maxOpenExpiry,
+		OpenExpiryMargin:           a.openExpiryMargin,
 		NetworkPassphrase:          a.networkPassphrase,
+		BaseReserve:                a.baseReserve,
+		LedgerDuration:             a.ledgerDuration,
+		LedgerDurationWindow:       a.ledgerDurationWindow,
+		ExpiryWarningThreshold:     a.expiryWarningThreshold,
+		StreamLagThreshold:         a.streamLagThreshold,
+		StreamResubscribeDelay:     a.streamResubscribeDelay,
+		OpenTimeout:                a.openTimeout,
+		VerifyOpenBalances:         a.verifyOpenBalances,
+		MaxPaymentAmount:           a.maxPaymentAmount,
+		MaxAppDataSize:             a.maxAppDataSize,
+		MaxIterations:              a.maxIterations,
+		AllowedAssets:              a.allowedAssets,
+		AllowAnyAsset:              a.allowAnyAsset,
+		ReserveAmount:              a.reserveAmount,
+		SignerScheme:               a.signerScheme,
+		PaymentTimeout:             a.paymentTimeout,
+		ResponseTimeout:            a.responseTimeout,
+		ConnectRetries:             a.connectRetries,
+		ConnectRetryBackoff:        a.connectRetryBackoff,
+		HelloTimeout:               a.helloTimeout,
+		TCPKeepAlivePeriod:         a.tcpKeepAlivePeriod,
+		ReplayOnReconnect:          a.replayOnReconnect,
+		SendTimeout:                a.sendTimeout,
+		PaymentApprover:            a.paymentApprover,
+		MessageObserver:            a.messageObserver,
+		ReceiptWriter:              a.receiptWriter,
+		ReceiptBufferSize:          a.receiptBufferSize,
+		Info:                       a.info,
+		Contribution:               a.contribution,
+		RemoteContribution:         a.remoteContribution,
+		TrustLimit:                 a.trustLimit,
+		RemoteTrustLimit:           a.remoteTrustLimit,
 
 		SequenceNumberCollector: a.sequenceNumberCollector,
 		BalanceCollector:        a.balanceCollector,
 		Submitter:               a.submitter,
 		Streamer:                a.streamer,
 		Snapshotter:             a.snapshotter,
+		SnapshotPolicy:          a.snapshotPolicy,
 
 		ChannelAccountKey:    a.channelAccountKey,
 		ChannelAccountSigner: a.channelAccountSigner,
@@ -200,12 +764,91 @@ func (a *Agent) Snapshot() Snapshot {
 	return a.buildSnapshot()
 }
 
-func (a *Agent) takeSnapshot() {
-	if a.snapshotter == nil {
-		return
+// IterationNumber returns the iteration number of the latest close agreement
+// signed by both participants, or zero if the agent has no channel.
+func (a *Agent) IterationNumber() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.channel == nil {
+		return 0
+	}
+	return a.channel.LatestCloseAgreement().Envelope.Details.IterationNumber
+}
+
+// HasPendingAgreement returns true if an open, payment, or close agreement, or
+// a signer rotation, has been proposed by either participant and is yet to be
+// signed by both, else false.
+func (a *Agent) HasPendingAgreement() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.inFlight()
+}
+
+// OpenParams returns the parameters of the channel's open agreement as
+// confirmed by both participants, so that they can be checked against those
+// intended. ErrNoChannel is returned if the agent has no channel, and an
+// error is returned if its open agreement has not been signed by both
+// participants.
+func (a *Agent) OpenParams() (state.OpenParams, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.channel == nil {
+		return state.OpenParams{}, ErrNoChannel
+	}
+	open := a.channel.OpenAgreement()
+	if !open.Envelope.HasAllSignatures() {
+		return state.OpenParams{}, fmt.Errorf("open agreement not confirmed")
+	}
+	d := open.Envelope.Details
+	return state.OpenParams{
+		ObservationPeriodTime:      d.ObservationPeriodTime,
+		ObservationPeriodLedgerGap: d.ObservationPeriodLedgerGap,
+		Asset:                      d.Asset,
+		ExpiresAt:                  d.ExpiresAt,
+		StartingSequence:           d.StartingSequence,
+		InitiatorContribution:      d.InitiatorContribution,
+		ResponderContribution:      d.ResponderContribution,
+		SignerScheme:               d.SignerScheme,
+		InitiatorTrustLimit:        d.InitiatorTrustLimit,
+		ResponderTrustLimit:        d.ResponderTrustLimit,
+	}, nil
+}
+
+// ValidateStartingSequence checks that the starting sequence of the channel,
+// from which the sequence numbers of its declaration and close transactions
+// are derived, is consistent with the sequence number of the initiator's
+// channel account on the network, as collected by the
+// SequenceNumberCollector. It should be used after an agent is restored with
+// NewAgentFromSnapshot, because a wrong starting sequence makes every
+// declaration and close transaction invalid. An error wrapping
+// state.ErrStartingSequenceMismatch is returned if they are inconsistent. See
+// state.Channel.ValidateStartingSequence.
+func (a *Agent) ValidateStartingSequence() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.channel == nil {
+		return ErrNoChannel
+	}
+	initiatorChannelAccount := a.channelAccountKey
+	if !a.channel.IsInitiator() {
+		initiatorChannelAccount = a.otherChannelAccount
+	}
+	seqNum, err := a.sequenceNumberCollector.GetSequenceNumber(initiatorChannelAccount)
+	if err != nil {
+		return fmt.Errorf("getting sequence number of initiator channel account: %w", err)
+	}
+	return a.channel.ValidateStartingSequence(seqNum)
+}
+
+// OtherInfo returns the info the other participant sent on its current
+// connection, and false if it has not sent any. See Config.Info.
+func (a *Agent) OtherInfo() (msg.Info, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.otherInfo == nil {
+		return msg.Info{}, false
 	}
-	snapshot := a.buildSnapshot()
-	a.snapshotter.Snapshot(a, snapshot)
+	return *a.otherInfo, true
 }
 
 func (a *Agent) buildSnapshot() Snapshot {
@@ -213,6 +856,8 @@ func (a *Agent) buildSnapshot() Snapshot {
 		OtherChannelAccount:       a.otherChannelAccount,
 		OtherChannelAccountSigner: a.otherChannelAccountSigner,
 		StreamerCursor:            a.streamerCursor,
+		CloseReason:               a.closeReason,
+		OpenToken:                 a.openToken,
 	}
 	if a.channel != nil {
 		snapshot.State = &struct {
@@ -231,17 +876,25 @@ func (a *Agent) hello() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	enc := msg.NewEncoder(io.MultiWriter(a.conn, a.logWriter))
-	err := enc.Encode(msg.Message{
+	err := a.send(msg.Message{
 		Type: msg.TypeHello,
 		Hello: &msg.Hello{
 			ChannelAccount: *a.channelAccountKey,
-			Signer:         *a.channelAccountSigner.FromAddress(),
+			Signer:         *a.signer().FromAddress(),
 		},
 	})
 	if err != nil {
 		return fmt.Errorf("sending hello: %w", err)
 	}
+	if a.info != nil {
+		err = a.send(msg.Message{
+			Type: msg.TypeInfo,
+			Info: a.info,
+		})
+		if err != nil {
+			return fmt.Errorf("sending info: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -252,24 +905,74 @@ func (a *Agent) initChannel(initiator bool, snapshot *state.Snapshot) {
 		Initiator:            initiator,
 		LocalChannelAccount:  a.channelAccountKey,
 		RemoteChannelAccount: a.otherChannelAccount,
-		LocalSigner:          a.channelAccountSigner,
+		LocalSigner:          a.signer(),
 		RemoteSigner:         a.otherChannelAccountSigner,
+		LocalContribution:    a.contribution,
+		RemoteContribution:   a.remoteContribution,
+		LocalTrustLimit:      a.trustLimit,
+		RemoteTrustLimit:     a.remoteTrustLimit,
+		ReserveAmount:        a.reserveAmount,
+		SignerScheme:         a.signerScheme,
+
+		ObservationPeriodTime:      a.observationPeriodTime,
+		ObservationPeriodLedgerGap: a.observationPeriodLedgerGap,
 	}
 	if snapshot == nil {
 		a.channel = state.NewChannel(config)
 	} else {
 		a.channel = state.NewChannelFromSnapshot(config, *snapshot)
 	}
+	a.streamStartedAt = time.Now()
+	if r, ok := a.channel.PendingSignerRotation(); ok {
+		a.watchSignerRotation(r)
+	}
+	if a.expiryWarningThreshold > 0 {
+		go a.expiryLoop(a.channel)
+	}
+	if a.streamer == nil {
+		// Without a Streamer the application gives the agent the channel's
+		// transactions with IngestTx.
+		a.streamerCancel = func() {}
+		return
+	}
 	a.streamerTransactions, a.streamerCancel = a.streamer.StreamTx(a.streamerCursor)
-	go a.ingestLoop()
+	go a.ingestLoop(a.streamerTransactions)
+	if a.streamLagThreshold > 0 {
+		go a.streamLoop(a.channel)
+	}
 }
 
 // Open kicks off the open process which will continue after the function
-// returns.
+// returns. The agent is the initiator of the channel.
 func (a *Agent) Open(asset state.Asset) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	return a.open(asset, "", true)
+}
 
+// OpenAsResponder kicks off the open process in the same way as Open, except
+// that the other participant is the initiator of the channel and the agent is
+// the responder, such as when the agent is the participant with the liquidity
+// to move first but the channel's starting sequence should come from the
+// other participant's channel account. The agent proposes the open and
+// submits the open transaction once it is confirmed, and the other
+// participant confirms it as the initiator.
+func (a *Agent) OpenAsResponder(asset state.Asset) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.open(asset, "", false)
+}
+
+// open proposes an open of a new channel in the asset as the initiator or
+// responder of the channel, recording the token the open was started with,
+// if any. It must be called with the mutex locked.
+func (a *Agent) open(asset state.Asset, token string, initiator bool) error {
+	if a.shuttingDown {
+		return ErrShuttingDown
+	}
+	if a.paused {
+		return ErrPaused
+	}
 	if a.conn == nil {
 		return fmt.Errorf("not connected")
 	}
@@ -277,43 +980,114 @@ func (a *Agent) Open(asset state.Asset) error {
 		return fmt.Errorf("channel already exists")
 	}
 
-	seqNum, err := a.sequenceNumberCollector.GetSequenceNumber(a.channelAccountKey)
+	// Check the channel account exists and has a balance to contribute before
+	// proposing, so that the open fails now rather than when the open
+	// transaction is submitted.
+	// The starting sequence of the channel comes from the initiator's channel
+	// account.
+	initiatorChannelAccount := a.channelAccountKey
+	if !initiator {
+		initiatorChannelAccount = a.otherChannelAccount
+	}
+	seqNum, err := a.sequenceNumberCollector.GetSequenceNumber(initiatorChannelAccount)
+	if errors.Is(err, ErrAccountNotFound) {
+		return fmt.Errorf("%w: %s", ErrChannelAccountNotFound, initiatorChannelAccount.Address())
+	}
 	if err != nil {
 		return fmt.Errorf("getting sequence number of channel account: %w", err)
 	}
+	// The native balance is collected with a credit asset's balance to check
+	// that it covers the channel account's reserves.
+	assets := []state.Asset{asset}
+	checkReserves := !asset.IsNative() && a.baseReserve != 0
+	if checkReserves {
+		assets = append(assets, state.NativeAsset)
+	}
+	balances, err := a.getBalances(a.channelAccountKey, assets)
+	if errors.Is(err, ErrAccountNotFound) {
+		return fmt.Errorf("%w: %s", ErrChannelAccountNotFound, a.channelAccountKey.Address())
+	}
+	if err != nil {
+		return fmt.Errorf("getting balance of channel account: %w", err)
+	}
+	if checkReserves {
+		minimum := txbuild.MinimumBalance(a.baseReserve, txbuild.ChannelAccountSubentries(asset.Asset()))
+		if balances[state.NativeAsset] < minimum {
+			return fmt.Errorf("%w: %s has %d of native, less than the minimum balance %d for its reserves", ErrChannelAccountUnfunded, a.channelAccountKey.Address(), balances[state.NativeAsset], minimum)
+		}
+	}
+	balance := a.availableBalance(asset, balances[asset])
+	if balance <= 0 {
+		return fmt.Errorf("%w: %s has no %s available", ErrChannelAccountUnfunded, a.channelAccountKey.Address(), asset.StringCanonical())
+	}
+	if balance < a.contribution {
+		return fmt.Errorf("%w: %s has %d of %s available, less than the contribution %d", ErrChannelAccountUnfunded, a.channelAccountKey.Address(), balance, asset.StringCanonical(), a.contribution)
+	}
 
-	a.initChannel(true, nil)
+	openExpiry, err := a.openExpiry()
+	if err != nil {
+		return err
+	}
 
-	// Expire the channel before the max open expiry. If both participants are
-	// using the same max open expiry, we need to set the expiry earlier so that
-	// small amounts of clock drift doesn't cause the open agreement to be
-	// rejected by the other participant.
-	openExpiresAt := time.Now().Add(a.maxOpenExpiry / 2)
+	a.initChannel(initiator, nil)
 
+	openExpiresAt := time.Now().Add(openExpiry)
+
+	initiatorContribution, responderContribution := a.contribution, a.remoteContribution
+	if !initiator {
+		initiatorContribution, responderContribution = responderContribution, initiatorContribution
+	}
+	initiatorTrustLimit, responderTrustLimit := a.trustLimit, a.remoteTrustLimit
+	if !initiator {
+		initiatorTrustLimit, responderTrustLimit = responderTrustLimit, initiatorTrustLimit
+	}
 	open, err := a.channel.ProposeOpen(state.OpenParams{
 		ObservationPeriodTime:      a.observationPeriodTime,
 		ObservationPeriodLedgerGap: a.observationPeriodLedgerGap,
 		Asset:                      asset,
 		ExpiresAt:                  openExpiresAt,
 		StartingSequence:           seqNum + 1,
+		InitiatorContribution:      initiatorContribution,
+		ResponderContribution:      responderContribution,
+		SignerScheme:               a.signerScheme,
+		InitiatorTrustLimit:        initiatorTrustLimit,
+		ResponderTrustLimit:        responderTrustLimit,
 	})
 	if err != nil {
 		return fmt.Errorf("proposing open: %w", err)
 	}
+	a.openToken = token
 	a.takeSnapshot()
 
-	enc := msg.NewEncoder(io.MultiWriter(a.conn, a.logWriter))
-	err = enc.Encode(msg.Message{
-		Type:        msg.TypeOpenRequest,
-		OpenRequest: &open.Envelope,
+	err = a.sendRequest(msg.Message{
+		Type:            msg.TypeOpenRequest,
+		OpenRequest:     &open.Envelope,
+		OpenByResponder: !initiator,
 	})
 	if err != nil {
 		return fmt.Errorf("sending open: %w", err)
 	}
+	a.watchOpenResponse(open)
 
 	return nil
 }
 
+// openExpiry returns how long after it is proposed an open agreement proposed
+// by the agent expires. The channel expires earlier than the max open expiry
+// by the open expiry margin. If both participants are using the same max open
+// expiry, the expiry must be earlier so that small amounts of clock drift
+// don't cause the open agreement to be rejected by the other participant.
+func (a *Agent) openExpiry() (time.Duration, error) {
+	margin := a.openExpiryMargin
+	if margin == 0 {
+		margin = a.maxOpenExpiry / 2
+	}
+	if margin <= 0 || margin >= a.maxOpenExpiry {
+		return 0, fmt.Errorf("open expiry margin %v must be greater than zero and less than max open expiry %v", margin, a.maxOpenExpiry)
+	}
+	return a.maxOpenExpiry - margin, nil
+}
+
 // Payment makes a payment with an empty memo. It is equivalent to calling
 // PaymentWithMemo(paymentAmount, "").
 func (a *Agent) Payment(paymentAmount int64) error {
@@ -327,44 +1101,152 @@ func (a *Agent) Payment(paymentAmount int64) error {
 // participant signs the payment and returns the payment. The memo is attached
 // to the payment.
 func (a *Agent) PaymentWithMemo(paymentAmount int64, memo []byte) error {
+	return a.PaymentWithTypedMemo(paymentAmount, state.MemoTypeNone, memo)
+}
+
+// PaymentWithTypedMemo makes a payment in the same way as PaymentWithMemo,
+// with a memo of the given memo type attached to the payment. The memo must be
+// the size required by the memo type, e.g. 8 bytes for an ID memo or 32 bytes
+// for a hash memo. The memo is the memo of the close transaction of the
+// payment's agreement, and so is seen on the network if the channel closes
+// with it. See state.MemoType.
+func (a *Agent) PaymentWithTypedMemo(paymentAmount int64, memoType state.MemoType, memo []byte) error {
+	return a.payment(paymentAmount, memoType, memo, nil)
+}
+
+// PaymentWithAppData makes a payment in the same way as PaymentWithMemo, and
+// sends the application data to the other participant with the payment. The
+// application data is not part of the agreement or any transaction, and so is
+// not limited in size by the memo, but is limited by Config.MaxAppDataSize of
+// both participants. The other participant receives it in the
+// PaymentReceivedEvent of the payment.
+func (a *Agent) PaymentWithAppData(paymentAmount int64, memo []byte, appData []byte) error {
+	return a.payment(paymentAmount, state.MemoTypeNone, memo, appData)
+}
+
+func (a *Agent) payment(paymentAmount int64, memoType state.MemoType, memo []byte, appData []byte) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if a.shuttingDown {
+		return ErrShuttingDown
+	}
+
 	if a.conn == nil {
 		return fmt.Errorf("not connected")
 	}
 	if a.channel == nil {
 		return fmt.Errorf("no channel")
 	}
+	if a.maxPaymentAmount > 0 && paymentAmount > a.maxPaymentAmount {
+		return fmt.Errorf("proposing payment %d: %w", paymentAmount, ErrPaymentTooLarge)
+	}
+	if max := a.appDataLimit(); len(appData) > max {
+		return fmt.Errorf("proposing payment %d: %w: %d bytes of max %d", paymentAmount, ErrAppDataTooLarge, len(appData), max)
+	}
+	if a.maxIterations > 0 {
+		iteration := a.channel.LatestCloseAgreement().Envelope.Details.IterationNumber
+		if iteration >= a.maxIterations {
+			return fmt.Errorf("proposing payment %d: %w: iteration %d of max %d, close the channel to continue", paymentAmount, ErrMaxIterationsReached, iteration, a.maxIterations)
+		}
+	}
 
-	ca, err := a.channel.ProposePaymentWithMemo(paymentAmount, memo)
+	ca, err := a.channel.ProposePaymentWithTypedMemo(paymentAmount, memoType, memo)
 	if errors.Is(err, state.ErrUnderfunded) {
 		fmt.Fprintf(a.logWriter, "local is underfunded for this payment based on cached account balances, checking channel account...\n")
 		var balance int64
-		balance, err = a.balanceCollector.GetBalance(a.channel.LocalChannelAccount().Address, a.channel.OpenAgreement().Envelope.Details.Asset)
+		balance, err = a.collectBalance(a.channel.LocalChannelAccount().Address, a.channel.OpenAgreement().Envelope.Details.Asset)
 		if err != nil {
 			return err
 		}
 		a.channel.UpdateLocalChannelAccountBalance(balance)
-		ca, err = a.channel.ProposePaymentWithMemo(paymentAmount, memo)
+		ca, err = a.channel.ProposePaymentWithTypedMemo(paymentAmount, memoType, memo)
 	}
 	if err != nil {
 		return fmt.Errorf("proposing payment %d: %w", paymentAmount, err)
 	}
 	a.takeSnapshot()
 
-	enc := msg.NewEncoder(io.MultiWriter(a.conn, a.logWriter))
-	err = enc.Encode(msg.Message{
+	err = a.sendRequest(msg.Message{
 		Type:           msg.TypePaymentRequest,
 		PaymentRequest: &ca.Envelope,
+		AppData:        appData,
 	})
+	if errors.Is(err, ErrSendTimeout) {
+		// The request was not sent in full, and so the other participant
+		// cannot confirm it. Discard the payment so that another can be made.
+		rejectErr := a.channel.RejectPayment(ca.Envelope.Details.IterationNumber)
+		if rejectErr != nil {
+			fmt.Fprintf(a.logWriter, "discarding payment %d: %v\n", ca.Envelope.Details.IterationNumber, rejectErr)
+		}
+		a.lastRequest = nil
+		a.takeSnapshot()
+	}
 	if err != nil {
 		return fmt.Errorf("sending payment: %w", err)
 	}
+	a.watchPayment(ca.Envelope.Details.IterationNumber)
 
 	return nil
 }
 
+// defaultMaxAppDataSize is the largest size in bytes of the application data
+// of a payment if no MaxAppDataSize is configured.
+const defaultMaxAppDataSize = 4096
+
+// appDataLimit returns the largest size in bytes of the application data that
+// the agent sends or accepts with a payment.
+func (a *Agent) appDataLimit() int {
+	if a.maxAppDataSize > 0 {
+		return a.maxAppDataSize
+	}
+	return defaultMaxAppDataSize
+}
+
+// collectBalance gets the balance of the asset held by the account using the
+// balance collector. If the asset is native and a base reserve is configured,
+// the minimum balance the channel account must hold for its reserves is
+// excluded.
+func (a *Agent) collectBalance(account *keypair.FromAddress, asset state.Asset) (int64, error) {
+	balance, err := a.balanceCollector.GetBalance(account, asset)
+	if err != nil {
+		return 0, err
+	}
+	return a.availableBalance(asset, balance), nil
+}
+
+// availableBalance returns the balance of the asset excluding, if the asset
+// is native and a base reserve is configured, the minimum balance the channel
+// account must hold for its reserves.
+func (a *Agent) availableBalance(asset state.Asset, balance int64) int64 {
+	if asset.IsNative() && a.baseReserve != 0 {
+		balance -= txbuild.MinimumBalance(a.baseReserve, txbuild.ChannelAccountSubentries(asset.Asset()))
+		if balance < 0 {
+			balance = 0
+		}
+	}
+	return balance
+}
+
+// getBalances gets the balances of the assets held by the account using the
+// balance collector, with a single call if it implements
+// MultiBalanceCollector, else with a call for each asset. The balances are as
+// collected, and include any reserves.
+func (a *Agent) getBalances(account *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error) {
+	if mbc, ok := a.balanceCollector.(MultiBalanceCollector); ok {
+		return mbc.GetBalances(account, assets)
+	}
+	balances := make(map[state.Asset]int64, len(assets))
+	for _, asset := range assets {
+		balance, err := a.balanceCollector.GetBalance(account, asset)
+		if err != nil {
+			return nil, err
+		}
+		balances[asset] = balance
+	}
+	return balances, nil
+}
+
 // DeclareClose kicks off the close process by submitting a tx to the network to
 // begin the close process, then asynchronously coordinating with the remote
 // participant to coordinate the close. If the participant responds the agent
@@ -383,7 +1265,7 @@ func (a *Agent) DeclareClose() error {
 	}
 
 	// Submit declaration tx.
-	declTx, _, err := a.channel.CloseTxs()
+	declTx, _, err := a.closeTxs()
 	if err != nil {
 		return fmt.Errorf("building declaration tx: %w", err)
 	}
@@ -394,8 +1276,11 @@ func (a *Agent) DeclareClose() error {
 	fmt.Fprintln(a.logWriter, "submitting declaration:", declHash)
 	err = a.submitter.SubmitTx(declTx)
 	if err != nil {
-		return fmt.Errorf("submitting declaration tx: %w", err)
+		err = fmt.Errorf("submitting declaration tx %s: %w", declHash, err)
+		a.reportFeeAccountUnderfunded(err)
+		return err
 	}
+	a.setCloseReason(CloseReasonDeclaredByLocal)
 
 	// Attempt revising the close agreement to close early.
 	fmt.Fprintln(a.logWriter, "proposing a revised close for immediate submission")
@@ -405,18 +1290,30 @@ func (a *Agent) DeclareClose() error {
 	}
 	a.takeSnapshot()
 
-	enc := msg.NewEncoder(io.MultiWriter(a.conn, a.logWriter))
-	err = enc.Encode(msg.Message{
+	err = a.send(msg.Message{
 		Type:         msg.TypeCloseRequest,
 		CloseRequest: &ca.Envelope,
 	})
 	if err != nil {
 		return fmt.Errorf("error: sending the close proposal: %w", err)
 	}
+	a.watchCloseResponse(ca.Envelope.Details.IterationNumber)
 
 	return nil
 }
 
+// closeTxs returns the declaration and close transactions of the latest
+// authorized close agreement, after checking that they match the agreement so
+// that a wrong close is never submitted. It must be called with the mutex
+// locked.
+func (a *Agent) closeTxs() (declTx *txnbuild.Transaction, closeTx *txnbuild.Transaction, err error) {
+	err = a.channel.ValidateCloseTxs()
+	if err != nil {
+		return nil, nil, fmt.Errorf("validating close txs: %w", err)
+	}
+	return a.channel.CloseTxs()
+}
+
 // Close closes the channel. The close must have been declared first either by
 // calling DeclareClose or by the other participant. If the close fails it may
 // be because the channel is already closed, or the participant has submitted
@@ -426,7 +1323,7 @@ func (a *Agent) Close() error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	_, closeTx, err := a.channel.CloseTxs()
+	_, closeTx, err := a.closeTxs()
 	if err != nil {
 		return fmt.Errorf("building close tx: %w", err)
 	}
@@ -438,44 +1335,97 @@ func (a *Agent) Close() error {
 	err = a.submitter.SubmitTx(closeTx)
 	if err != nil {
 		fmt.Fprintln(a.logWriter, "error submitting close tx:", closeHash, ",", err)
-		return fmt.Errorf("submitting close tx %s: %w", closeHash, err)
+		err = fmt.Errorf("submitting close tx %s: %w", closeHash, err)
+		a.reportFeeAccountUnderfunded(err)
+		return err
 	}
 	fmt.Fprintln(a.logWriter, "submitted close tx:", closeHash)
 	return nil
 }
 
+// reportFeeAccountUnderfunded writes an ErrorEvent if the error from
+// submitting a transaction is because the fee account is underfunded. Errors
+// from submitting transactions in message handlers are already written as
+// ErrorEvents, and so it is only used by operations that return their errors
+// to the caller, so that the failure is seen by whatever monitors the events
+// even if the caller does not handle it.
+func (a *Agent) reportFeeAccountUnderfunded(err error) {
+	if a.events != nil && errors.Is(err, ErrFeeAccountUnderfunded) {
+		a.events <- ErrorEvent{Err: err}
+	}
+}
+
 func (a *Agent) receive() error {
-	recv := msg.NewDecoder(io.TeeReader(a.conn, a.logWriter))
-	send := msg.NewEncoder(io.MultiWriter(a.conn, a.logWriter))
 	m := msg.Message{}
-	err := recv.Decode(&m)
+	err := a.recv.Decode(&m)
 	if err == io.EOF {
 		return err
 	}
+	if err != nil && a.recvTail.err == nil {
+		// The bytes read from the connection could not be decoded, rather
+		// than the connection failing, and so include them in the error.
+		return fmt.Errorf("%w: %v: %s", errDecoding, err, a.recvTail.preview())
+	}
 	if err != nil {
-		return fmt.Errorf("reading and decoding: %v", err)
+		return fmt.Errorf("%w: %v", errReading, err)
+	}
+	atomic.AddInt64(&a.connCounters.messagesReceived, 1)
+	if a.messageObserver != nil {
+		a.messageObserver(MessageReceived, m)
 	}
-	err = a.handle(m, send)
+	err = a.handle(m)
 	if err != nil {
 		return fmt.Errorf("handling message: %v", err)
 	}
 	return nil
 }
 
+// errReading indicates that a message could not be read from the connection,
+// and so no further messages can be read from it.
+var errReading = errors.New("reading and decoding")
+
+// errDecoding indicates that the bytes read from the connection could not be
+// decoded as a message, and so no further messages can be read from it.
+var errDecoding = fmt.Errorf("%w: malformed message", errReading)
+
+// receiveLoop receives messages from the connection until it is closed or can
+// no longer be read. If the connection ends while still attached, the agent
+// disconnects from it and writes a DisconnectedEvent, so that it can be
+// connected again.
 func (a *Agent) receiveLoop() {
+	a.mu.Lock()
+	counters := a.connCounters
+	a.mu.Unlock()
 	for {
 		err := a.receive()
-		if err == io.EOF {
-			fmt.Fprintln(a.logWriter, "error receiving: EOF, stopping receiving")
+		if err == nil {
+			continue
+		}
+		if a.disconnected() {
+			fmt.Fprintf(a.logWriter, "error receiving: %v, disconnected, stopping receiving\n", err)
 			break
 		}
-		if err != nil {
-			fmt.Fprintf(a.logWriter, "error receiving: %v\n", err)
+		if err == io.EOF || errors.Is(err, errReading) {
+			fmt.Fprintf(a.logWriter, "error receiving: %v, stopping receiving\n", err)
+			if errors.Is(err, errDecoding) {
+				a.reportReadError(counters, err)
+			}
+			a.dropConn(counters, err)
+			break
 		}
+		fmt.Fprintf(a.logWriter, "error receiving: %v\n", err)
 	}
 }
 
-func (a *Agent) handle(m msg.Message, send *msg.Encoder) error {
+// disconnected returns true if the agent has been disconnected from the
+// remote participant.
+func (a *Agent) disconnected() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.conn == nil
+}
+
+func (a *Agent) handle(m msg.Message) error {
 	fmt.Fprintf(a.logWriter, "handling %v\n", m.Type)
 	handler := handlerMap[m.Type]
 	if handler == nil {
@@ -485,7 +1435,7 @@ func (a *Agent) handle(m msg.Message, send *msg.Encoder) error {
 		}
 		return err
 	}
-	err := handler(a, m, send)
+	err := handler(a, m)
 	if err != nil {
 		err = fmt.Errorf("handling message %d: %w", m.Type, err)
 		if a.events != nil {
@@ -496,17 +1446,29 @@ func (a *Agent) handle(m msg.Message, send *msg.Encoder) error {
 	return nil
 }
 
-var handlerMap = map[msg.Type]func(*Agent, msg.Message, *msg.Encoder) error{
+var handlerMap = map[msg.Type]func(*Agent, msg.Message) error{
 	msg.TypeHello:           (*Agent).handleHello,
+	msg.TypeInfo:            (*Agent).handleInfo,
 	msg.TypeOpenRequest:     (*Agent).handleOpenRequest,
 	msg.TypeOpenResponse:    (*Agent).handleOpenResponse,
 	msg.TypePaymentRequest:  (*Agent).handlePaymentRequest,
 	msg.TypePaymentResponse: (*Agent).handlePaymentResponse,
 	msg.TypeCloseRequest:    (*Agent).handleCloseRequest,
 	msg.TypeCloseResponse:   (*Agent).handleCloseResponse,
+	msg.TypeReject:          (*Agent).handleReject,
+
+	msg.TypeSignerRotationRequest:  (*Agent).handleSignerRotationRequest,
+	msg.TypeSignerRotationResponse: (*Agent).handleSignerRotationResponse,
+
+	msg.TypeReconcileRequest:  (*Agent).handleReconcileRequest,
+	msg.TypeReconcileResponse: (*Agent).handleReconcileResponse,
 }
 
-func (a *Agent) handleHello(m msg.Message, send *msg.Encoder) error {
+func (a *Agent) handleHello(m msg.Message) error {
+	if m.Hello == nil {
+		return fmt.Errorf("%w: hello missing", ErrMalformedMessage)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -514,6 +1476,13 @@ func (a *Agent) handleHello(m msg.Message, send *msg.Encoder) error {
 
 	h := m.Hello
 
+	// A participant identifies itself once per connection, so that it
+	// cannot swap the account it participates with mid-session. Connecting
+	// again starts a new connection.
+	if a.helloReceived {
+		return a.reject(msg.TypeHello, 0, fmt.Errorf("%w: hello already received on this connection from %s", ErrUnexpectedHello, a.otherChannelAccount.Address()))
+	}
+
 	if a.otherChannelAccount != nil && !a.otherChannelAccount.Equal(&h.ChannelAccount) {
 		return fmt.Errorf("hello received with unexpected channel account: %s expected: %s", h.ChannelAccount.Address(), a.otherChannelAccount.Address())
 	}
@@ -523,36 +1492,84 @@ func (a *Agent) handleHello(m msg.Message, send *msg.Encoder) error {
 
 	a.otherChannelAccount = &h.ChannelAccount
 	a.otherChannelAccountSigner = &h.Signer
+	a.otherInfo = nil
+	a.helloReceived = true
+	defer a.replayLastRequest()
 
 	fmt.Fprintf(a.logWriter, "other's channel account: %v\n", a.otherChannelAccount.Address())
 	fmt.Fprintf(a.logWriter, "other's signer: %v\n", a.otherChannelAccountSigner.Address())
 
 	if a.events != nil {
-		a.events <- ConnectedEvent{ChannelAccount: &h.ChannelAccount, Signer: &h.Signer}
+		remoteAddr, tlsState := connInfo(a.conn)
+		a.events <- ConnectedEvent{
+			ChannelAccount: &h.ChannelAccount,
+			Signer:         &h.Signer,
+			RemoteAddr:     remoteAddr,
+			TLS:            tlsState,
+		}
 	}
 
 	return nil
 }
 
-func (a *Agent) handleOpenRequest(m msg.Message, send *msg.Encoder) error {
+func (a *Agent) handleInfo(m msg.Message) error {
+	if m.Info == nil {
+		return fmt.Errorf("%w: info missing", ErrMalformedMessage)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.channel != nil {
-		return fmt.Errorf("channel already exists")
+	info := *m.Info
+	a.otherInfo = &info
+
+	fmt.Fprintf(a.logWriter, "other's info: version %q\n", info.Version)
+
+	if a.events != nil {
+		a.events <- InfoReceivedEvent{Info: info}
 	}
+	return nil
+}
 
-	a.initChannel(false, nil)
+func (a *Agent) handleOpenRequest(m msg.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if m.OpenRequest == nil {
+		return a.reject(msg.TypeOpenRequest, 0, fmt.Errorf("%w: open request missing", ErrMalformedMessage))
+	}
 
 	openIn := *m.OpenRequest
+	if a.channel != nil {
+		return a.handleRepeatedOpenRequest(openIn)
+	}
+	if a.paused {
+		return a.reject(msg.TypeOpenRequest, 0, ErrPaused)
+	}
+
+	if !a.assetAllowed(openIn.Details.Asset) {
+		return a.reject(msg.TypeOpenRequest, 0, fmt.Errorf("%w: %s", ErrAssetNotAllowed, openIn.Details.Asset))
+	}
+
+	// The agent is the responder unless the open is proposed by the
+	// responder.
+	a.initChannel(m.OpenByResponder, nil)
+
 	open, err := a.channel.ConfirmOpen(openIn)
 	if err != nil {
-		return fmt.Errorf("confirming open: %w", err)
+		// Discard the channel so that the other participant can propose a
+		// corrected open. The expiry and stream loops stop once the channel
+		// is discarded.
+		a.streamerCancel()
+		a.streamerTransactions = nil
+		a.channel = nil
+		return a.reject(msg.TypeOpenRequest, 0, fmt.Errorf("confirming open: %w", err))
 	}
 	a.takeSnapshot()
 	fmt.Fprintf(a.logWriter, "open authorized\n")
+	a.watchOpen()
 
-	err = send.Encode(msg.Message{
+	err = a.send(msg.Message{
 		Type:         msg.TypeOpenResponse,
 		OpenResponse: &open.Envelope.ConfirmerSignatures,
 	})
@@ -562,7 +1579,11 @@ func (a *Agent) handleOpenRequest(m msg.Message, send *msg.Encoder) error {
 	return nil
 }
 
-func (a *Agent) handleOpenResponse(m msg.Message, send *msg.Encoder) error {
+func (a *Agent) handleOpenResponse(m msg.Message) error {
+	if m.OpenResponse == nil {
+		return fmt.Errorf("%w: open response missing", ErrMalformedMessage)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -572,7 +1593,7 @@ func (a *Agent) handleOpenResponse(m msg.Message, send *msg.Encoder) error {
 
 	openEnvelope := a.channel.OpenAgreement().Envelope
 	openEnvelope.ConfirmerSignatures = *m.OpenResponse
-	_, err := a.channel.ConfirmOpen(openEnvelope)
+	open, err := a.channel.ConfirmOpen(openEnvelope)
 	if err != nil {
 		return fmt.Errorf("confirming open: %w", err)
 	}
@@ -585,48 +1606,198 @@ func (a *Agent) handleOpenResponse(m msg.Message, send *msg.Encoder) error {
 	}
 	err = a.submitter.SubmitTx(openTx)
 	if err != nil {
-		return fmt.Errorf("submitting open tx: %w", err)
+		err = fmt.Errorf("submitting open tx: %w", err)
+		if a.events != nil {
+			a.events <- OpenFailedEvent{OpenAgreement: open, Err: err}
+		}
+		return err
 	}
+	a.watchOpen()
 	return nil
 }
 
-func (a *Agent) handlePaymentRequest(m msg.Message, send *msg.Encoder) error {
+// assetAllowed returns true if the agent accepts an open proposed by the other
+// participant in the asset.
+func (a *Agent) assetAllowed(asset state.Asset) bool {
+	if len(a.allowedAssets) == 0 {
+		return a.allowAnyAsset || asset.IsNative()
+	}
+	for _, allowed := range a.allowedAssets {
+		if allowed == asset || (allowed.IsNative() && asset.IsNative()) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchOpen writes an OpenFailedEvent if the channel is not open when the open
+// timeout passes. The channel is only open once the Streamer has seen the
+// open transaction, and so an open transaction that fails or is never
+// executed leaves the channel unopened. It must be called with the mutex
+// locked.
+func (a *Agent) watchOpen() {
+	if a.openTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(a.openTimeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.channel == nil {
+			return
+		}
+		s, err := a.channel.State()
+		if err != nil || s != state.StateNone {
+			return
+		}
+		fmt.Fprintf(a.logWriter, "open not seen after %v\n", a.openTimeout)
+		if a.events != nil {
+			a.events <- OpenFailedEvent{OpenAgreement: a.channel.OpenAgreement(), Err: ErrOpenNotSeen}
+		}
+	})
+}
+
+func (a *Agent) handlePaymentRequest(m msg.Message) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	if a.channel == nil {
-		return fmt.Errorf("no channel")
+	if m.PaymentRequest == nil {
+		return a.reject(msg.TypePaymentRequest, 0, fmt.Errorf("%w: payment request missing", ErrMalformedMessage))
 	}
 
 	paymentIn := *m.PaymentRequest
+	if a.isRepeatedPaymentRequest(paymentIn) {
+		return a.handleRepeatedPaymentRequest(paymentIn)
+	}
+	if a.events != nil {
+		a.events <- PaymentRequestedEvent{
+			IterationNumber: paymentIn.Details.IterationNumber,
+			Amount:          paymentIn.Details.PaymentAmount,
+			MemoType:        paymentIn.Details.MemoType,
+			Memo:            paymentIn.Details.Memo,
+		}
+	}
+	if a.paused {
+		return a.rejectPayment(paymentIn, ErrPaused)
+	}
+	if max := a.appDataLimit(); len(m.AppData) > max {
+		return a.rejectPayment(paymentIn, fmt.Errorf("confirming payment: %w: %d bytes of max %d", ErrAppDataTooLarge, len(m.AppData), max))
+	}
+	payment, err := a.confirmPayment(paymentIn)
+	if err != nil {
+		return a.rejectPayment(paymentIn, err)
+	}
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "payment authorized\n")
+
+	err = a.send(msg.Message{Type: msg.TypePaymentResponse, PaymentResponse: &payment.Envelope.ConfirmerSignatures})
+	a.writeReceipt(PaymentDirectionReceived, payment)
+	if a.events != nil {
+		a.events <- PaymentReceivedEvent{CloseAgreement: payment, AppData: m.AppData}
+	}
+	if err != nil {
+		return fmt.Errorf("encoding payment to send back: %w", err)
+	}
+	return nil
+}
+
+// confirmPayment checks the payment proposed by the other participant against
+// the agent's limits and approver, and confirms it. It must be called with the
+// lock held.
+func (a *Agent) confirmPayment(paymentIn state.CloseEnvelope) (state.CloseAgreement, error) {
+	if a.channel == nil {
+		return state.CloseAgreement{}, fmt.Errorf("no channel")
+	}
+	if a.maxPaymentAmount > 0 && paymentIn.Details.PaymentAmount > a.maxPaymentAmount {
+		return state.CloseAgreement{}, fmt.Errorf("confirming payment %d: %w", paymentIn.Details.PaymentAmount, ErrPaymentTooLarge)
+	}
+	if _, pending := a.channel.LatestUnauthorizedCloseAgreement(); a.paymentApprover != nil && !pending {
+		err := a.paymentApprover(paymentIn.Details.PaymentAmount, paymentIn.Details.Memo)
+		if err != nil {
+			return state.CloseAgreement{}, fmt.Errorf("%w: %v", ErrPaymentNotApproved, err)
+		}
+	}
 	payment, err := a.channel.ConfirmPayment(paymentIn)
 	if errors.Is(err, state.ErrUnderfunded) {
 		fmt.Fprintf(a.logWriter, "remote is underfunded for this payment based on cached account balances, checking their channel account...\n")
 		var balance int64
-		balance, err = a.balanceCollector.GetBalance(a.channel.RemoteChannelAccount().Address, a.channel.OpenAgreement().Envelope.Details.Asset)
+		balance, err = a.collectBalance(a.channel.RemoteChannelAccount().Address, a.channel.OpenAgreement().Envelope.Details.Asset)
 		if err != nil {
-			return err
+			return state.CloseAgreement{}, err
 		}
 		a.channel.UpdateRemoteChannelAccountBalance(balance)
 		payment, err = a.channel.ConfirmPayment(paymentIn)
 	}
 	if err != nil {
-		return fmt.Errorf("confirming payment: %w", err)
+		return state.CloseAgreement{}, fmt.Errorf("confirming payment: %w", err)
 	}
-	a.takeSnapshot()
-	fmt.Fprintf(a.logWriter, "payment authorized\n")
+	return payment, nil
+}
 
-	err = send.Encode(msg.Message{Type: msg.TypePaymentResponse, PaymentResponse: &payment.Envelope.ConfirmerSignatures})
-	if a.events != nil {
-		a.events <- PaymentReceivedEvent{CloseAgreement: payment}
+// rejectPayment rejects the payment proposed by the other participant, and
+// sends them the reason. The rejected iteration is recorded so that it is not
+// reused, unless this participant has a payment of its own pending, in which
+// case the proposals collided and the iteration is recorded when the other
+// participant rejects this participant's payment. It must be called with the
+// lock held.
+func (a *Agent) rejectPayment(payment state.CloseEnvelope, reason error) error {
+	iterationNumber := payment.Details.IterationNumber
+	if a.channel != nil {
+		if _, pending := a.channel.LatestUnauthorizedCloseAgreement(); !pending {
+			err := a.channel.RejectPayment(iterationNumber)
+			if err != nil {
+				fmt.Fprintf(a.logWriter, "not recording rejected payment: %v\n", err)
+			} else {
+				a.takeSnapshot()
+			}
+		}
 	}
+	fmt.Fprintf(a.logWriter, "payment rejected: %v\n", reason)
+	return a.reject(msg.TypePaymentRequest, iterationNumber, reason)
+}
+
+// reject sends the other participant a rejection of its request of the given
+// type, with a code and reason derived from the error that caused the
+// rejection. The error is returned.
+func (a *Agent) reject(requestType msg.Type, iterationNumber int64, reason error) error {
+	err := a.send(msg.Message{
+		Type: msg.TypeReject,
+		Reject: &msg.Reject{
+			Type:            requestType,
+			IterationNumber: iterationNumber,
+			Code:            rejectCode(reason),
+			Reason:          reason.Error(),
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("encoding payment to send back: %w", err)
+		return fmt.Errorf("%w (sending rejection: %v)", reason, err)
 	}
-	return nil
+	return reason
 }
 
-func (a *Agent) handlePaymentResponse(m msg.Message, send *msg.Encoder) error {
+// rejectCode returns the code of a rejection caused by the error.
+func rejectCode(err error) msg.RejectCode {
+	switch {
+	case errors.Is(err, ErrPaymentNotApproved):
+		return msg.RejectCodeNotApproved
+	case errors.Is(err, state.ErrUnderfunded):
+		return msg.RejectCodeUnderfunded
+	case errors.Is(err, ErrPaymentTooLarge):
+		return msg.RejectCodeTooLarge
+	case errors.Is(err, state.ErrContributionMismatch):
+		return msg.RejectCodeContributionMismatch
+	case errors.Is(err, ErrAssetNotAllowed):
+		return msg.RejectCodeAssetNotAllowed
+	case errors.Is(err, ErrPaused):
+		return msg.RejectCodeTryLater
+	}
+	return msg.RejectCodeInvalid
+}
+
+func (a *Agent) handlePaymentResponse(m msg.Message) error {
+	if m.PaymentResponse == nil {
+		return fmt.Errorf("%w: payment response missing", ErrMalformedMessage)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -642,29 +1813,35 @@ func (a *Agent) handlePaymentResponse(m msg.Message, send *msg.Encoder) error {
 	a.takeSnapshot()
 	fmt.Fprintf(a.logWriter, "payment authorized\n")
 
+	a.writeReceipt(PaymentDirectionSent, payment)
 	if a.events != nil {
 		a.events <- PaymentSentEvent{CloseAgreement: payment}
 	}
 	return nil
 }
 
-func (a *Agent) handleCloseRequest(m msg.Message, send *msg.Encoder) error {
+func (a *Agent) handleCloseRequest(m msg.Message) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if m.CloseRequest == nil {
+		return a.reject(msg.TypeCloseRequest, 0, fmt.Errorf("%w: close request missing", ErrMalformedMessage))
+	}
+
+	closeIn := *m.CloseRequest
 	if a.channel == nil {
-		return fmt.Errorf("no channel")
+		return a.reject(msg.TypeCloseRequest, closeIn.Details.IterationNumber, fmt.Errorf("no channel"))
 	}
 
 	// Agree to the close and send it back to requesting participant.
-	closeIn := *m.CloseRequest
 	close, err := a.channel.ConfirmClose(closeIn)
 	if err != nil {
-		return fmt.Errorf("confirming close: %v\n", err)
+		return a.reject(msg.TypeCloseRequest, closeIn.Details.IterationNumber, fmt.Errorf("confirming close: %w", err))
 	}
+	a.setCloseReason(CloseReasonCoordinated)
 	a.takeSnapshot()
 
-	err = send.Encode(msg.Message{
+	err = a.send(msg.Message{
 		Type:          msg.TypeCloseResponse,
 		CloseResponse: &close.Envelope.ConfirmerSignatures,
 	})
@@ -674,7 +1851,7 @@ func (a *Agent) handleCloseRequest(m msg.Message, send *msg.Encoder) error {
 	fmt.Fprintln(a.logWriter, "close ready")
 
 	// Submit the close immediately since it is valid immediately.
-	_, closeTx, err := a.channel.CloseTxs()
+	_, closeTx, err := a.closeTxs()
 	if err != nil {
 		return fmt.Errorf("building close tx: %w", err)
 	}
@@ -685,13 +1862,17 @@ func (a *Agent) handleCloseRequest(m msg.Message, send *msg.Encoder) error {
 	fmt.Fprintln(a.logWriter, "submitting close", hash)
 	err = a.submitter.SubmitTx(closeTx)
 	if err != nil {
-		return fmt.Errorf("submitting close tx: %w", err)
+		return fmt.Errorf("submitting close tx %s: %w", hash, err)
 	}
 	fmt.Fprintln(a.logWriter, "close successful")
 	return nil
 }
 
-func (a *Agent) handleCloseResponse(m msg.Message, send *msg.Encoder) error {
+func (a *Agent) handleCloseResponse(m msg.Message) error {
+	if m.CloseResponse == nil {
+		return fmt.Errorf("%w: close response missing", ErrMalformedMessage)
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
@@ -707,11 +1888,12 @@ func (a *Agent) handleCloseResponse(m msg.Message, send *msg.Encoder) error {
 	if err != nil {
 		return fmt.Errorf("confirming close: %v\n", err)
 	}
+	a.setCloseReason(CloseReasonCoordinated)
 	a.takeSnapshot()
 	fmt.Fprintln(a.logWriter, "close ready")
 
 	// Submit the close immediately since it is valid immediately.
-	_, closeTx, err := a.channel.CloseTxs()
+	_, closeTx, err := a.closeTxs()
 	if err != nil {
 		return fmt.Errorf("building close tx: %w", err)
 	}
@@ -722,8 +1904,79 @@ func (a *Agent) handleCloseResponse(m msg.Message, send *msg.Encoder) error {
 	fmt.Fprintln(a.logWriter, "submitting close", hash)
 	err = a.submitter.SubmitTx(closeTx)
 	if err != nil {
-		return fmt.Errorf("submitting close tx: %w", err)
+		return fmt.Errorf("submitting close tx %s: %w", hash, err)
 	}
 	fmt.Fprintln(a.logWriter, "close successful")
 	return nil
 }
+
+func (a *Agent) handleReject(m msg.Message) error {
+	if m.Reject == nil {
+		return fmt.Errorf("%w: reject missing", ErrMalformedMessage)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := m.Reject
+	if r.Type == msg.TypeHello {
+		return fmt.Errorf("hello rejected by remote: %s", r.Reason)
+	}
+
+	if a.channel == nil {
+		return fmt.Errorf("no channel")
+	}
+
+	switch r.Type {
+	case msg.TypeOpenRequest:
+		open, pending := a.channel.PendingOpen()
+		if !pending {
+			return fmt.Errorf("rejected open is not pending")
+		}
+		// The open agreement is not discarded because the other participant
+		// holds this participant's signatures for it. It expires at its
+		// ExpiresAt if it is never authorized.
+		fmt.Fprintf(a.logWriter, "open rejected by remote: %s\n", r.Reason)
+		if a.events != nil {
+			a.events <- OpenRejectedEvent{OpenAgreement: open, Code: r.Code, Reason: r.Reason}
+		}
+	case msg.TypePaymentRequest:
+		if r.Code == msg.RejectCodeExpired {
+			return a.handlePaymentWithdrawn(r.IterationNumber)
+		}
+		payment, ok := a.channel.LatestUnauthorizedCloseAgreement()
+		if !ok || payment.Envelope.Details.IterationNumber != r.IterationNumber {
+			return fmt.Errorf("rejected payment %d is not pending", r.IterationNumber)
+		}
+		err := a.channel.RejectPayment(r.IterationNumber)
+		if err != nil {
+			return fmt.Errorf("rejecting payment: %w", err)
+		}
+		a.takeSnapshot()
+		fmt.Fprintf(a.logWriter, "payment rejected by remote: %s\n", r.Reason)
+		if a.events != nil {
+			a.events <- PaymentRejectedEvent{CloseAgreement: payment, Code: r.Code, Reason: r.Reason}
+		}
+	case msg.TypeCloseRequest:
+		close, ok := a.channel.LatestUnauthorizedCloseAgreement()
+		if !ok {
+			return fmt.Errorf("rejected close is not pending")
+		}
+		err := a.channel.RejectClose()
+		if err != nil {
+			return fmt.Errorf("rejecting close: %w", err)
+		}
+		a.takeSnapshot()
+		fmt.Fprintf(a.logWriter, "close rejected by remote: %s\n", r.Reason)
+		if a.events != nil {
+			a.events <- CloseRejectedEvent{CloseAgreement: close, Code: r.Code, Reason: r.Reason}
+		}
+	case msg.TypeSignerRotationRequest:
+		return a.handleSignerRotationRejected(r)
+	case msg.TypeReconcileRequest:
+		return fmt.Errorf("reconcile rejected by remote: %s", r.Reason)
+	default:
+		return fmt.Errorf("rejection of unsupported message type %d: %s", r.Type, r.Reason)
+	}
+	return nil
+}
@@ -1,17 +1,31 @@
 package agent
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stellar/go/keypair"
 	"github.com/stellar/go/network"
 	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/agent/msg"
 	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/state/statetest"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -22,6 +36,16 @@ func (f sequenceNumberCollector) GetSequenceNumber(accountID *keypair.FromAddres
 	return f(accountID)
 }
 
+type multiBalanceCollectorFunc func(accountID *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error)
+
+func (f multiBalanceCollectorFunc) GetBalance(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+	panic("GetBalance called instead of GetBalances")
+}
+
+func (f multiBalanceCollectorFunc) GetBalances(accountID *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error) {
+	return f(accountID, assets)
+}
+
 type balanceCollectorFunc func(accountID *keypair.FromAddress, asset state.Asset) (int64, error)
 
 func (f balanceCollectorFunc) GetBalance(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
@@ -58,10 +82,9 @@ func assertAgentSnapshotsAndRestores(t *testing.T, agent *Agent, config Config,
 	// Override the streamer so that multiple agents aren't competing to read
 	// from the same ingestion streamer.
 	config.Streamer = streamerFunc(func(cursor string, accounts ...*keypair.FromAddress) (transactions <-chan StreamedTransaction, cancel func()) {
-		// Create a closed channel since we won't be doing any ingestion with this agent.
-		txs := make(chan StreamedTransaction)
-		close(txs)
-		return txs, func() {}
+		// Create a channel that is never written to since we won't be doing
+		// any ingestion with this agent.
+		return make(chan StreamedTransaction), func() {}
 	})
 
 	restoredAgent := NewAgentFromSnapshot(config, snapshot)
@@ -72,6 +95,7 @@ func assertAgentSnapshotsAndRestores(t *testing.T, agent *Agent, config Config,
 	assert.Equal(t, agent.observationPeriodLedgerGap, restoredAgent.observationPeriodLedgerGap)
 	assert.Equal(t, agent.maxOpenExpiry, restoredAgent.maxOpenExpiry)
 	assert.Equal(t, agent.networkPassphrase, restoredAgent.networkPassphrase)
+	assert.Equal(t, agent.baseReserve, restoredAgent.baseReserve)
 	assert.Equal(t, agent.channelAccountKey, restoredAgent.channelAccountKey)
 	assert.Equal(t, agent.channelAccountSigner, restoredAgent.channelAccountSigner)
 	assert.Equal(t, agent.otherChannelAccount, restoredAgent.otherChannelAccount)
@@ -92,7 +116,7 @@ func TestAgent_openPaymentClose(t *testing.T) {
 		transactionsStream chan StreamedTransaction
 	}{}
 	localVars.transactionsStream = make(chan StreamedTransaction)
-	localEvents := make(chan interface{}, 1)
+	localEvents := make(chan interface{}, 2)
 	localConfig := Config{
 		ObservationPeriodTime:      20 * time.Second,
 		ObservationPeriodLedgerGap: 1,
@@ -133,7 +157,7 @@ func TestAgent_openPaymentClose(t *testing.T) {
 		transactionsStream chan StreamedTransaction
 	}{}
 	remoteVars.transactionsStream = make(chan StreamedTransaction)
-	remoteEvents := make(chan interface{}, 1)
+	remoteEvents := make(chan interface{}, 2)
 	remoteConfig := Config{
 		ObservationPeriodTime:      20 * time.Second,
 		ObservationPeriodLedgerGap: 1,
@@ -175,14 +199,14 @@ func TestAgent_openPaymentClose(t *testing.T) {
 	}
 	localMsgs := bytes.Buffer{}
 	remoteMsgs := bytes.Buffer{}
-	localAgent.conn = ReadWriter{
+	localAgent.attachConn(ReadWriter{
 		Reader: &remoteMsgs,
 		Writer: &localMsgs,
-	}
-	remoteAgent.conn = ReadWriter{
+	})
+	remoteAgent.attachConn(ReadWriter{
 		Reader: &localMsgs,
 		Writer: &remoteMsgs,
-	}
+	})
 	err := localAgent.hello()
 	require.NoError(t, err)
 	err = remoteAgent.receive()
@@ -202,20 +226,29 @@ func TestAgent_openPaymentClose(t *testing.T) {
 		assert.IsType(t, ConnectedEvent{}, remoteEvent)
 	}
 
-	// Extra hellos are allowed and have no consequence.
+	// Extra hellos on the same connection are rejected.
 	err = localAgent.hello()
 	require.NoError(t, err)
 	err = remoteAgent.receive()
-	require.NoError(t, err)
-
-	// Expect connected event.
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected hello")
 	{
 		remoteEvent, ok := <-remoteEvents
 		require.True(t, ok)
-		assert.IsType(t, ConnectedEvent{}, remoteEvent)
+		require.IsType(t, ErrorEvent{}, remoteEvent)
+		assert.ErrorIs(t, remoteEvent.(ErrorEvent).Err, ErrUnexpectedHello)
+	}
+	err = localAgent.receive()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "hello rejected by remote")
+	{
+		localEvent, ok := <-localEvents
+		require.True(t, ok)
+		assert.IsType(t, ErrorEvent{}, localEvent)
 	}
 
-	// Extra hellos with wrong data raise an error.
+	// Hellos on a new connection with wrong data raise an error.
+	remoteAgent.helloReceived = false
 	incorrectChannelAccount := keypair.MustRandom().FromAddress()
 	localAgent.channelAccountKey = incorrectChannelAccount
 	err = localAgent.hello()
@@ -231,7 +264,8 @@ func TestAgent_openPaymentClose(t *testing.T) {
 		assert.IsType(t, ErrorEvent{}, remoteEvent)
 	}
 
-	// Extra hellos with wrong data raise an error.
+	// Hellos on a new connection with wrong data raise an error.
+	remoteAgent.helloReceived = false
 	incorrectSigner := keypair.MustRandom()
 	localAgent.channelAccountSigner = incorrectSigner
 	err = localAgent.hello()
@@ -300,6 +334,9 @@ func TestAgent_openPaymentClose(t *testing.T) {
 		assert.Equal(t, int64(50_0000000), localPaymentEvent.CloseAgreement.Envelope.Details.Balance)
 		remoteEvent, ok := <-remoteEvents
 		require.True(t, ok)
+		assert.IsType(t, PaymentRequestedEvent{}, remoteEvent)
+		remoteEvent, ok = <-remoteEvents
+		require.True(t, ok)
 		remotePaymentEvent, ok := remoteEvent.(PaymentReceivedEvent)
 		require.True(t, ok)
 		assert.Equal(t, int64(2), remotePaymentEvent.CloseAgreement.Envelope.Details.IterationNumber)
@@ -318,6 +355,9 @@ func TestAgent_openPaymentClose(t *testing.T) {
 	{
 		localEvent, ok := <-localEvents
 		require.True(t, ok)
+		assert.IsType(t, PaymentRequestedEvent{}, localEvent)
+		localEvent, ok = <-localEvents
+		require.True(t, ok)
 		localPaymentEvent, ok := localEvent.(PaymentReceivedEvent)
 		require.True(t, ok)
 		assert.Equal(t, int64(3), localPaymentEvent.CloseAgreement.Envelope.Details.IterationNumber)
@@ -342,6 +382,9 @@ func TestAgent_openPaymentClose(t *testing.T) {
 	{
 		localEvent, ok := <-localEvents
 		require.True(t, ok)
+		assert.IsType(t, PaymentRequestedEvent{}, localEvent)
+		localEvent, ok = <-localEvents
+		require.True(t, ok)
 		localPaymentEvent, ok := localEvent.(PaymentReceivedEvent)
 		require.True(t, ok)
 		assert.Equal(t, []byte("memo"), localPaymentEvent.CloseAgreement.Envelope.Details.Memo)
@@ -375,6 +418,9 @@ func TestAgent_openPaymentClose(t *testing.T) {
 	{
 		localEvent, ok := <-localEvents
 		require.True(t, ok)
+		assert.IsType(t, PaymentRequestedEvent{}, localEvent)
+		localEvent, ok = <-localEvents
+		require.True(t, ok)
 		localPaymentEvent, ok := localEvent.(PaymentReceivedEvent)
 		require.True(t, ok)
 		assert.Equal(t, []byte("memo"), localPaymentEvent.CloseAgreement.Envelope.Details.Memo)
@@ -457,10 +503,10 @@ func TestAgent_openPaymentClose(t *testing.T) {
 	{
 		localEvent, ok := <-localEvents
 		require.True(t, ok)
-		assert.Equal(t, localEvent, ClosedEvent{})
+		assert.Equal(t, localEvent, ClosedEvent{Reason: CloseReasonCoordinated})
 		remoteEvent, ok := <-remoteEvents
 		require.True(t, ok)
-		assert.Equal(t, remoteEvent, ClosedEvent{})
+		assert.Equal(t, remoteEvent, ClosedEvent{Reason: CloseReasonCoordinated})
 	}
 }
 
@@ -555,14 +601,14 @@ func TestAgent_concurrency(t *testing.T) {
 	}
 	localReader, localWriter := io.Pipe()
 	remoteReader, remoteWriter := io.Pipe()
-	localAgent.conn = ReadWriter{
+	localAgent.attachConn(ReadWriter{
 		Reader: remoteReader,
 		Writer: localWriter,
-	}
-	remoteAgent.conn = ReadWriter{
+	})
+	remoteAgent.attachConn(ReadWriter{
 		Reader: localReader,
 		Writer: remoteWriter,
-	}
+	})
 	go localAgent.receiveLoop()
 	go remoteAgent.receiveLoop()
 
@@ -629,3 +675,2512 @@ func TestAgent_concurrency(t *testing.T) {
 	<-localPaymentConfirmedOrError
 	<-remotePaymentConfirmedOrError
 }
+
+func TestAgent_collectBalance_baseReserve(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+
+	agent := &Agent{
+		networkPassphrase: network.TestNetworkPassphrase,
+		balanceCollector: balanceCollectorFunc(func(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+			return 100_0000000, nil
+		}),
+		channelAccountKey:    localChannelAccount.FromAddress(),
+		channelAccountSigner: localSigner,
+		logWriter:            io.Discard,
+		channel: state.NewChannel(state.Config{
+			NetworkPassphrase:   network.TestNetworkPassphrase,
+			LocalChannelAccount: localChannelAccount.FromAddress(),
+			LocalSigner:         localSigner,
+		}),
+	}
+
+	// Without a base reserve the full balance is available.
+	balance, err := agent.collectBalance(localChannelAccount.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100_0000000), balance)
+
+	// With a base reserve the minimum balance of the channel account, which
+	// holds two signers for a native channel, is excluded.
+	agent.baseReserve = 5000000
+	balance, err = agent.collectBalance(localChannelAccount.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(98_0000000), balance)
+}
+
+func TestAgent_Open_channelAccountNotReady(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+
+	newAgent := func(seqNumErr error, balance int64, balanceErr error) *Agent {
+		agent := &Agent{
+			networkPassphrase: network.TestNetworkPassphrase,
+			sequenceNumberCollector: sequenceNumberCollector(func(accountID *keypair.FromAddress) (int64, error) {
+				return 28037546508288, seqNumErr
+			}),
+			balanceCollector: balanceCollectorFunc(func(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+				return balance, balanceErr
+			}),
+			channelAccountKey:    localChannelAccount.FromAddress(),
+			channelAccountSigner: localSigner,
+			logWriter:            io.Discard,
+		}
+		type ReadWriter struct {
+			io.Reader
+			io.Writer
+		}
+		agent.attachConn(ReadWriter{Reader: bytes.NewReader(nil), Writer: io.Discard})
+		return agent
+	}
+
+	t.Run("sequenceNumberNotFound", func(t *testing.T) {
+		agent := newAgent(fmt.Errorf("getting account: %w", ErrAccountNotFound), 0, nil)
+		err := agent.Open(state.NativeAsset)
+		assert.EqualError(t, err, "channel account not ready: channel account does not exist: "+localChannelAccount.Address())
+		assert.ErrorIs(t, err, ErrChannelAccountNotFound)
+		assert.ErrorIs(t, err, ErrChannelAccountNotReady)
+		assert.Nil(t, agent.channel)
+	})
+
+	t.Run("balanceNotFound", func(t *testing.T) {
+		agent := newAgent(nil, 0, fmt.Errorf("getting account: %w", ErrAccountNotFound))
+		err := agent.Open(state.NativeAsset)
+		assert.ErrorIs(t, err, ErrChannelAccountNotFound)
+		assert.Nil(t, agent.channel)
+	})
+
+	t.Run("unfunded", func(t *testing.T) {
+		agent := newAgent(nil, 0, nil)
+		err := agent.Open(state.NativeAsset)
+		assert.EqualError(t, err, "channel account not ready: channel account balance insufficient: "+localChannelAccount.Address()+" has no native available")
+		assert.ErrorIs(t, err, ErrChannelAccountUnfunded)
+		assert.ErrorIs(t, err, ErrChannelAccountNotReady)
+		assert.NotErrorIs(t, err, ErrChannelAccountNotFound)
+		assert.Nil(t, agent.channel)
+	})
+
+	t.Run("lessThanContribution", func(t *testing.T) {
+		agent := newAgent(nil, 2_0000000, nil)
+		agent.contribution = 3_0000000
+		err := agent.Open(state.NativeAsset)
+		assert.EqualError(t, err, "channel account not ready: channel account balance insufficient: "+localChannelAccount.Address()+" has 20000000 of native available, less than the contribution 30000000")
+		assert.ErrorIs(t, err, ErrChannelAccountUnfunded)
+		assert.Nil(t, agent.channel)
+	})
+
+	t.Run("onlyReserves", func(t *testing.T) {
+		agent := newAgent(nil, 2_0000000, nil)
+		agent.baseReserve = 5000000
+		err := agent.Open(state.NativeAsset)
+		assert.ErrorIs(t, err, ErrChannelAccountUnfunded)
+		assert.Nil(t, agent.channel)
+	})
+
+	credit := state.Asset("ABDC:" + localChannelAccount.Address())
+
+	t.Run("creditWithoutReserves", func(t *testing.T) {
+		agent := newAgent(nil, 0, nil)
+		agent.balanceCollector = balanceCollectorFunc(func(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+			if asset.IsNative() {
+				return 2_0000000, nil
+			}
+			return 10_0000000, nil
+		})
+		agent.baseReserve = 5000000
+		err := agent.Open(credit)
+		assert.EqualError(t, err, "channel account not ready: channel account balance insufficient: "+localChannelAccount.Address()+" has 20000000 of native, less than the minimum balance 25000000 for its reserves")
+		assert.ErrorIs(t, err, ErrChannelAccountUnfunded)
+		assert.Nil(t, agent.channel)
+	})
+
+	t.Run("multiBalanceCollector", func(t *testing.T) {
+		agent := newAgent(nil, 0, nil)
+		calls := [][]state.Asset{}
+		agent.balanceCollector = multiBalanceCollectorFunc(func(accountID *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error) {
+			calls = append(calls, assets)
+			return map[state.Asset]int64{state.NativeAsset: 2_0000000, credit: 10_0000000}, nil
+		})
+		agent.baseReserve = 5000000
+		err := agent.Open(credit)
+		assert.ErrorIs(t, err, ErrChannelAccountUnfunded)
+		assert.Equal(t, [][]state.Asset{{credit, state.NativeAsset}}, calls)
+	})
+}
+
+func TestAgent_handle_malformedMessages(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+
+	events := make(chan interface{}, 1)
+	agent := &Agent{
+		observationPeriodTime:      20 * time.Second,
+		observationPeriodLedgerGap: 1,
+		maxOpenExpiry:              5 * time.Minute,
+		networkPassphrase:          network.TestNetworkPassphrase,
+		channelAccountKey:          localChannelAccount.FromAddress(),
+		channelAccountSigner:       localSigner,
+		logWriter:                  io.Discard,
+		events:                     events,
+	}
+
+	// Messages that have a type but are missing the payload for that type
+	// should be rejected without panicking.
+	types := []msg.Type{
+		msg.TypeHello,
+		msg.TypeOpenRequest,
+		msg.TypeOpenResponse,
+		msg.TypePaymentRequest,
+		msg.TypePaymentResponse,
+		msg.TypeCloseRequest,
+		msg.TypeCloseResponse,
+		msg.TypeReconcileRequest,
+		msg.TypeReconcileResponse,
+	}
+	for _, typ := range types {
+		typ := typ
+		t.Run(strconv.Itoa(int(typ)), func(t *testing.T) {
+			err := agent.handle(msg.Message{Type: typ})
+			assert.ErrorIs(t, err, ErrMalformedMessage)
+
+			// Expect error event.
+			event, ok := <-events
+			require.True(t, ok)
+			errorEvent, ok := event.(ErrorEvent)
+			require.True(t, ok)
+			assert.ErrorIs(t, errorEvent.Err, ErrMalformedMessage)
+		})
+	}
+}
+
+func TestAgent_maxPaymentAmount(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	agent := &Agent{
+		maxPaymentAmount: 100,
+		logWriter:        io.Discard,
+	}
+	type ReadWriter struct {
+		io.Reader
+		io.Writer
+	}
+	agent.attachConn(ReadWriter{Reader: bytes.NewReader(nil), Writer: io.Discard})
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+
+	// Proposing a payment above the maximum is rejected.
+	err := agent.Payment(101)
+	assert.EqualError(t, err, "proposing payment 101: payment amount exceeds maximum payment amount")
+	assert.ErrorIs(t, err, ErrPaymentTooLarge)
+
+	// Proposing a payment at the maximum is not rejected for its size, and
+	// fails only because the channel is not open.
+	err = agent.Payment(100)
+	assert.EqualError(t, err, "proposing payment 100: cannot propose a payment before channel is opened")
+
+	// Confirming a payment above the maximum is rejected.
+	err = agent.handlePaymentRequest(msg.Message{
+		Type: msg.TypePaymentRequest,
+		PaymentRequest: &state.CloseEnvelope{
+			Details: state.CloseDetails{IterationNumber: 1, PaymentAmount: 101},
+		},
+	})
+	assert.EqualError(t, err, "confirming payment 101: payment amount exceeds maximum payment amount")
+	assert.ErrorIs(t, err, ErrPaymentTooLarge)
+
+	// Without a maximum, payments are not limited.
+	agent.maxPaymentAmount = 0
+	err = agent.Payment(101)
+	assert.EqualError(t, err, "proposing payment 101: cannot propose a payment before channel is opened")
+}
+
+func TestAgent_assetAllowed(t *testing.T) {
+	credit := state.Asset("ABC:GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	other := state.Asset("XYZ:GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	testCases := []struct {
+		name          string
+		allowedAssets []state.Asset
+		allowAnyAsset bool
+		asset         state.Asset
+		want          bool
+	}{
+		{"default native", nil, false, state.NativeAsset, true},
+		{"default credit", nil, false, credit, false},
+		{"any credit", nil, true, credit, true},
+		{"list native", []state.Asset{credit}, false, state.NativeAsset, false},
+		{"list credit", []state.Asset{credit}, false, credit, true},
+		{"list other", []state.Asset{credit}, true, other, false},
+		{"list includes native", []state.Asset{state.NativeAsset, credit}, false, state.NativeAsset, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			agent := &Agent{allowedAssets: tc.allowedAssets, allowAnyAsset: tc.allowAnyAsset}
+			assert.Equal(t, tc.want, agent.assetAllowed(tc.asset))
+		})
+	}
+}
+
+func TestAgent_handleOpenRequest_assetNotAllowed(t *testing.T) {
+	sent := []msg.Message{}
+	agent := &Agent{
+		logWriter: io.Discard,
+		messageObserver: func(direction MessageDirection, m msg.Message) {
+			if direction == MessageSent {
+				sent = append(sent, m)
+			}
+		},
+	}
+	agent.attachConn(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(nil), io.Discard})
+	defer agent.disconnect()
+
+	asset := state.Asset("ABC:GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	err := agent.handleOpenRequest(msg.Message{
+		Type:        msg.TypeOpenRequest,
+		OpenRequest: &state.OpenEnvelope{Details: state.OpenDetails{Asset: asset}},
+	})
+	assert.ErrorIs(t, err, ErrAssetNotAllowed)
+	assert.EqualError(t, err, "asset not allowed: ABC:GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	assert.Nil(t, agent.channel)
+
+	// The initiator is sent a rejection with the reason.
+	require.Len(t, sent, 1)
+	require.NotNil(t, sent[0].Reject)
+	assert.Equal(t, msg.Reject{
+		Type:   msg.TypeOpenRequest,
+		Code:   msg.RejectCodeAssetNotAllowed,
+		Reason: err.Error(),
+	}, *sent[0].Reject)
+}
+
+func TestAgent_handleOpenRequest_rejectedThenCorrected(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{})
+	sent := []msg.Message{}
+	agent := &Agent{
+		networkPassphrase:         network.TestNetworkPassphrase,
+		maxOpenExpiry:             2 * time.Hour,
+		allowAnyAsset:             true,
+		channelAccountKey:         p.ResponderChannelAccount,
+		channelAccountSigner:      p.ResponderSigner,
+		otherChannelAccount:       p.InitiatorChannelAccount,
+		otherChannelAccountSigner: p.InitiatorSigner.FromAddress(),
+		remoteContribution:        5,
+		logWriter:                 io.Discard,
+		messageObserver: func(direction MessageDirection, m msg.Message) {
+			if direction == MessageSent {
+				sent = append(sent, m)
+			}
+		},
+	}
+	agent.attachConn(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(nil), io.Discard})
+	defer agent.disconnect()
+
+	proposeOpen := func(contribution int64) msg.Message {
+		initiator := state.NewChannel(state.Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			MaxOpenExpiry:        2 * time.Hour,
+			Initiator:            true,
+			LocalChannelAccount:  p.InitiatorChannelAccount,
+			RemoteChannelAccount: p.ResponderChannelAccount,
+			LocalSigner:          p.InitiatorSigner,
+			RemoteSigner:         p.ResponderSigner.FromAddress(),
+		})
+		open, err := initiator.ProposeOpen(state.OpenParams{
+			Asset:                 state.NativeAsset,
+			ExpiresAt:             time.Now().Add(time.Minute),
+			StartingSequence:      101,
+			InitiatorContribution: contribution,
+		})
+		require.NoError(t, err)
+		return msg.Message{Type: msg.TypeOpenRequest, OpenRequest: &open.Envelope}
+	}
+
+	// An open with a contribution other than the one expected is rejected,
+	// and the channel created for it is discarded.
+	err := agent.handleOpenRequest(proposeOpen(3))
+	assert.ErrorIs(t, err, state.ErrContributionMismatch)
+	assert.Nil(t, agent.channel)
+	require.Len(t, sent, 1)
+	require.NotNil(t, sent[0].Reject)
+	assert.Equal(t, msg.RejectCodeContributionMismatch, sent[0].Reject.Code)
+
+	// A corrected open is confirmed.
+	err = agent.handleOpenRequest(proposeOpen(5))
+	require.NoError(t, err)
+	require.NotNil(t, agent.channel)
+	require.Len(t, sent, 2)
+	assert.NotNil(t, sent[1].OpenResponse)
+	assert.Equal(t, int64(5), agent.channel.OpenAgreement().Envelope.Details.InitiatorContribution)
+}
+
+func TestAgent_appData(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+	_, err := p.Open(state.OpenParams{})
+	require.NoError(t, err)
+
+	sent := []msg.Message{}
+	local := &Agent{
+		maxAppDataSize: 8,
+		channel:        p.Initiator,
+		logWriter:      io.Discard,
+		messageObserver: func(direction MessageDirection, m msg.Message) {
+			if direction == MessageSent {
+				sent = append(sent, m)
+			}
+		},
+	}
+	local.attachConn(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(nil), io.Discard})
+	defer local.disconnect()
+
+	remoteEvents := make(chan interface{}, 10)
+	remote := &Agent{
+		maxAppDataSize: 8,
+		channel:        p.Responder,
+		logWriter:      io.Discard,
+		events:         remoteEvents,
+	}
+	remote.attachConn(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(nil), io.Discard})
+	defer remote.disconnect()
+
+	// App data larger than the maximum is not sent.
+	err = local.PaymentWithAppData(10, nil, []byte("123456789"))
+	assert.ErrorIs(t, err, ErrAppDataTooLarge)
+	assert.Empty(t, sent)
+
+	// App data is sent alongside the payment request and received with the
+	// payment, but is not part of the agreement.
+	err = local.PaymentWithAppData(10, []byte("memo"), []byte("order-1"))
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	assert.Equal(t, []byte("order-1"), sent[0].AppData)
+	assert.Equal(t, []byte("memo"), sent[0].PaymentRequest.Details.Memo)
+	require.NoError(t, remote.handlePaymentRequest(sent[0]))
+	var received PaymentReceivedEvent
+	for e := range remoteEvents {
+		if e, ok := e.(PaymentReceivedEvent); ok {
+			received = e
+			break
+		}
+	}
+	assert.Equal(t, []byte("order-1"), received.AppData)
+	assert.Equal(t, int64(10), received.CloseAgreement.Envelope.Details.PaymentAmount)
+
+	// App data larger than the maximum accepted is rejected.
+	_, err = local.channel.FinalizePayment(received.CloseAgreement.Envelope.ConfirmerSignatures)
+	require.NoError(t, err)
+	remote.maxAppDataSize = 4
+	err = local.PaymentWithAppData(10, nil, []byte("order-2"))
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	err = remote.handlePaymentRequest(sent[1])
+	assert.ErrorIs(t, err, ErrAppDataTooLarge)
+}
+
+func TestAgent_send_concurrentSendsDoNotInterleave(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+
+	agent := &Agent{
+		channelAccountKey:    localChannelAccount.FromAddress(),
+		channelAccountSigner: localSigner,
+		logWriter:            io.Discard,
+	}
+
+	type ReadWriter struct {
+		io.Reader
+		io.Writer
+	}
+	reader, writer := io.Pipe()
+	agent.attachConn(ReadWriter{Reader: bytes.NewReader(nil), Writer: writer})
+
+	// Send many messages from many goroutines at once.
+	const count = 50
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func() {
+			errs <- agent.hello()
+		}()
+	}
+
+	// Every message should be decodable on the other end.
+	received := make(chan msg.Message, count)
+	go func() {
+		dec := msg.NewDecoder(reader)
+		for i := 0; i < count; i++ {
+			m := msg.Message{}
+			err := dec.Decode(&m)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			received <- m
+		}
+	}()
+	for i := 0; i < count; i++ {
+		require.NoError(t, <-errs)
+		m := <-received
+		assert.Equal(t, msg.TypeHello, m.Type)
+		assert.Equal(t, localChannelAccount.Address(), m.Hello.ChannelAccount.Address())
+	}
+}
+
+func TestAgent_Shutdown(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	newAgent := func(t *testing.T) (agent *Agent, remoteReceived <-chan msg.Message, remoteConn net.Conn) {
+		agent = &Agent{
+			observationPeriodTime:      20 * time.Second,
+			observationPeriodLedgerGap: 1,
+			maxOpenExpiry:              5 * time.Minute,
+			networkPassphrase:          network.TestNetworkPassphrase,
+			sequenceNumberCollector: sequenceNumberCollector(func(accountID *keypair.FromAddress) (int64, error) {
+				return 28037546508288, nil
+			}),
+			balanceCollector: balanceCollectorFunc(func(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+				return 100_0000000, nil
+			}),
+			submitter: submitterFunc(func(tx *txnbuild.Transaction) error {
+				return nil
+			}),
+			streamer: streamerFunc(func(cursor string, accounts ...*keypair.FromAddress) (transactions <-chan StreamedTransaction, cancel func()) {
+				return make(chan StreamedTransaction), func() {}
+			}),
+			channelAccountKey:         localChannelAccount.FromAddress(),
+			channelAccountSigner:      localSigner,
+			otherChannelAccount:       remoteChannelAccount.FromAddress(),
+			otherChannelAccountSigner: remoteSigner.FromAddress(),
+			logWriter:                 io.Discard,
+		}
+		localConn, remoteConn := net.Pipe()
+		agent.attachConn(localConn)
+
+		// Collect messages the remote receives until the connection closes.
+		received := make(chan msg.Message, 10)
+		go func() {
+			defer close(received)
+			dec := msg.NewDecoder(remoteConn)
+			for {
+				m := msg.Message{}
+				err := dec.Decode(&m)
+				if err != nil {
+					return
+				}
+				received <- m
+			}
+		}()
+		return agent, received, remoteConn
+	}
+
+	t.Run("idle", func(t *testing.T) {
+		agent, received, _ := newAgent(t)
+		go agent.receiveLoop()
+
+		err := agent.Shutdown(context.Background())
+		require.NoError(t, err)
+
+		// The connection is closed.
+		_, ok := <-received
+		assert.False(t, ok)
+
+		// New opens and payments are rejected.
+		err = agent.Open(state.NativeAsset)
+		assert.ErrorIs(t, err, ErrShuttingDown)
+		err = agent.Payment(1)
+		assert.ErrorIs(t, err, ErrShuttingDown)
+
+		// Shutting down again is a no-op.
+		err = agent.Shutdown(context.Background())
+		require.NoError(t, err)
+	})
+
+	t.Run("inFlightOpen", func(t *testing.T) {
+		agent, received, _ := newAgent(t)
+
+		err := agent.Open(state.NativeAsset)
+		require.NoError(t, err)
+		m := <-received
+		assert.Equal(t, msg.TypeOpenRequest, m.Type)
+
+		// The open is never confirmed so shutdown gives up waiting when the
+		// context is done, and disconnects anyway.
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = agent.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		_, ok := <-received
+		assert.False(t, ok)
+		assert.True(t, agent.disconnected())
+	})
+
+	t.Run("inFlightOpenSettles", func(t *testing.T) {
+		agent, received, remoteConn := newAgent(t)
+		go agent.receiveLoop()
+
+		err := agent.Open(state.NativeAsset)
+		require.NoError(t, err)
+		m := <-received
+		require.Equal(t, msg.TypeOpenRequest, m.Type)
+
+		shutdownErr := make(chan error, 1)
+		go func() {
+			shutdownErr <- agent.Shutdown(context.Background())
+		}()
+
+		// The remote confirms the open after shutdown has started, which
+		// settles the in-flight open and lets shutdown complete.
+		remoteChannel := state.NewChannel(state.Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			MaxOpenExpiry:        5 * time.Minute,
+			Initiator:            false,
+			LocalChannelAccount:  remoteChannelAccount.FromAddress(),
+			RemoteChannelAccount: localChannelAccount.FromAddress(),
+			LocalSigner:          remoteSigner,
+			RemoteSigner:         localSigner.FromAddress(),
+		})
+		open, err := remoteChannel.ConfirmOpen(*m.OpenRequest)
+		require.NoError(t, err)
+		err = msg.NewEncoder(remoteConn).Encode(msg.Message{
+			Type:         msg.TypeOpenResponse,
+			OpenResponse: &open.Envelope.ConfirmerSignatures,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, <-shutdownErr)
+		_, ok := <-received
+		assert.False(t, ok)
+	})
+}
+
+func TestAgent_averageLedgerDuration(t *testing.T) {
+	agent := &Agent{ledgerDurationWindow: 3}
+
+	// Before ledgers are observed the default is assumed.
+	assert.Equal(t, 5*time.Second, agent.averageLedgerDuration())
+	agent.ledgerDuration = 6 * time.Second
+	assert.Equal(t, 6*time.Second, agent.averageLedgerDuration())
+
+	// One ledger is not enough to estimate a duration.
+	start := time.Unix(1000, 0)
+	agent.observeLedger(10, start)
+	assert.Equal(t, 6*time.Second, agent.averageLedgerDuration())
+
+	// Ledgers without close times, and ledgers already observed, are ignored.
+	agent.observeLedger(11, time.Time{})
+	agent.observeLedger(10, start.Add(time.Minute))
+	assert.Equal(t, 6*time.Second, agent.averageLedgerDuration())
+
+	// The average is estimated from observed ledgers.
+	agent.observeLedger(12, start.Add(20*time.Second))
+	assert.Equal(t, 10*time.Second, agent.averageLedgerDuration())
+
+	// Only the most recent ledgers within the window are used.
+	agent.observeLedger(13, start.Add(24*time.Second))
+	agent.observeLedger(14, start.Add(28*time.Second))
+	assert.Equal(t, 4*time.Second, agent.averageLedgerDuration())
+}
+
+func TestAgent_CloseAvailableAt(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	agent := &Agent{}
+	_, err := agent.CloseAvailableAt()
+	assert.EqualError(t, err, "no channel")
+
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+	_, err = agent.channel.ProposeOpen(state.OpenParams{
+		ObservationPeriodTime:      time.Minute,
+		ObservationPeriodLedgerGap: 10,
+		Asset:                      state.NativeAsset,
+		ExpiresAt:                  time.Now().Add(time.Minute),
+		StartingSequence:           101,
+	})
+	require.NoError(t, err)
+
+	_, err = agent.CloseAvailableAt()
+	assert.EqualError(t, err, "close not declared")
+
+	declaredAt := time.Unix(1000, 0)
+	agent.closeDeclaredAt = declaredAt
+
+	// With the assumed ledger duration the ledger gap is shorter than the
+	// observation period time.
+	availableAt, err := agent.CloseAvailableAt()
+	require.NoError(t, err)
+	assert.Equal(t, declaredAt.Add(time.Minute), availableAt)
+
+	// When ledgers are observed to be slower the ledger gap is longer than
+	// the observation period time.
+	agent.observeLedger(1, declaredAt)
+	agent.observeLedger(2, declaredAt.Add(9*time.Second))
+	availableAt, err = agent.CloseAvailableAt()
+	require.NoError(t, err)
+	assert.Equal(t, declaredAt.Add(90*time.Second), availableAt)
+}
+
+func TestAgent_warnExpiring(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		expiryWarningThreshold: time.Minute,
+		logWriter:              io.Discard,
+		events:                 events,
+	}
+
+	// Without a channel there is nothing to expire yet.
+	assert.True(t, agent.warnExpiring(time.Now()))
+
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+	expiresAt := time.Now().Add(4 * time.Minute).Truncate(time.Second)
+	_, err := agent.channel.ProposeOpen(state.OpenParams{
+		ObservationPeriodTime:      time.Minute,
+		ObservationPeriodLedgerGap: 10,
+		Asset:                      state.NativeAsset,
+		ExpiresAt:                  expiresAt,
+		StartingSequence:           101,
+	})
+	require.NoError(t, err)
+
+	// Before the threshold no event is written.
+	assert.True(t, agent.warnExpiring(expiresAt.Add(-2*time.Minute)))
+	assert.Len(t, events, 0)
+
+	// Within the threshold an event is written once.
+	assert.True(t, agent.warnExpiring(expiresAt.Add(-time.Minute)))
+	assert.True(t, agent.warnExpiring(expiresAt.Add(-time.Second)))
+	require.Len(t, events, 1)
+	assert.Equal(t, ChannelExpiringEvent{Expiry: ExpiryOpen, ExpiresAt: expiresAt}, <-events)
+
+	// Once the open agreement has expired without the channel opening there
+	// is nothing further to monitor.
+	assert.False(t, agent.warnExpiring(expiresAt))
+	assert.Len(t, events, 0)
+}
+
+func TestAgent_warnExpiring_disabled(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		logWriter: io.Discard,
+		events:    events,
+	}
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+	expiresAt := time.Now().Add(4 * time.Minute)
+	_, err := agent.channel.ProposeOpen(state.OpenParams{
+		Asset:            state.NativeAsset,
+		ExpiresAt:        expiresAt,
+		StartingSequence: 101,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, agent.warnExpiring(expiresAt.Add(-time.Second)))
+	assert.Len(t, events, 0)
+}
+
+func BenchmarkAgent_send(b *testing.B) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+
+	agent := &Agent{
+		channelAccountKey:    localChannelAccount.FromAddress(),
+		channelAccountSigner: localSigner,
+		logWriter:            io.Discard,
+	}
+	type ReadWriter struct {
+		io.Reader
+		io.Writer
+	}
+	agent.attachConn(ReadWriter{Reader: bytes.NewReader(nil), Writer: io.Discard})
+
+	m := msg.Message{
+		Type: msg.TypePaymentResponse,
+		PaymentResponse: &state.CloseSignatures{
+			Close:       make([]byte, 64),
+			Declaration: make([]byte, 64),
+		},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := agent.send(m)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAgent_ConnStats(t *testing.T) {
+	localAgent := &Agent{
+		networkPassphrase:    network.TestNetworkPassphrase,
+		channelAccountKey:    keypair.MustRandom().FromAddress(),
+		channelAccountSigner: keypair.MustRandom(),
+		logWriter:            io.Discard,
+	}
+	remoteAgent := &Agent{
+		networkPassphrase:    network.TestNetworkPassphrase,
+		channelAccountKey:    keypair.MustRandom().FromAddress(),
+		channelAccountSigner: keypair.MustRandom(),
+		logWriter:            io.Discard,
+	}
+	assert.Equal(t, ConnStats{}, localAgent.ConnStats())
+
+	type ReadWriter struct {
+		io.Reader
+		io.Writer
+	}
+	localMsgs := bytes.Buffer{}
+	remoteMsgs := bytes.Buffer{}
+	localAgent.attachConn(ReadWriter{Reader: &remoteMsgs, Writer: &localMsgs})
+	remoteAgent.attachConn(ReadWriter{Reader: &localMsgs, Writer: &remoteMsgs})
+
+	err := localAgent.hello()
+	require.NoError(t, err)
+	sent := int64(localMsgs.Len())
+	assert.Greater(t, sent, int64(0))
+	err = remoteAgent.receive()
+	require.NoError(t, err)
+
+	assert.Equal(t, ConnStats{MessagesSent: 1, BytesSent: sent}, localAgent.ConnStats())
+	assert.Equal(t, ConnStats{MessagesReceived: 1, BytesReceived: sent}, remoteAgent.ConnStats())
+}
+
+func TestAgent_ServeConn(t *testing.T) {
+	newAgent := func() (*Agent, chan interface{}) {
+		events := make(chan interface{}, 1)
+		return NewAgent(Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+			ChannelAccountSigner: keypair.MustRandom(),
+			LogWriter:            io.Discard,
+			Events:               events,
+		}), events
+	}
+	localAgent, localEvents := newAgent()
+	remoteAgent, remoteEvents := newAgent()
+
+	// Use a loopback TCP connection established outside the agents, because
+	// both agents write a hello before reading and so need a buffered
+	// connection.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	localConn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	remoteConn, err := ln.Accept()
+	require.NoError(t, err)
+
+	errs := make(chan error, 2)
+	go func() { errs <- localAgent.ServeConn(localConn) }()
+	go func() { errs <- remoteAgent.ServeConn(remoteConn) }()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	// Expect each agent to receive the hello of the other.
+	localEvent := <-localEvents
+	require.IsType(t, ConnectedEvent{}, localEvent)
+	assert.Equal(t, remoteAgent.channelAccountKey, localEvent.(ConnectedEvent).ChannelAccount)
+	assert.Equal(t, localConn.RemoteAddr(), localEvent.(ConnectedEvent).RemoteAddr)
+	assert.Nil(t, localEvent.(ConnectedEvent).TLS)
+	remoteEvent := <-remoteEvents
+	require.IsType(t, ConnectedEvent{}, remoteEvent)
+	assert.Equal(t, localAgent.channelAccountKey, remoteEvent.(ConnectedEvent).ChannelAccount)
+	assert.Equal(t, remoteConn.RemoteAddr(), remoteEvent.(ConnectedEvent).RemoteAddr)
+
+	err = localAgent.ServeConn(localConn)
+	assert.EqualError(t, err, "already connected")
+
+	require.NoError(t, localAgent.Shutdown(context.Background()))
+	require.NoError(t, remoteAgent.Shutdown(context.Background()))
+}
+
+func TestAgent_ServeConn_tls(t *testing.T) {
+	// Create a self-signed certificate for the server.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	newAgent := func() (*Agent, chan interface{}) {
+		events := make(chan interface{}, 1)
+		return NewAgent(Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+			ChannelAccountSigner: keypair.MustRandom(),
+			LogWriter:            io.Discard,
+			Events:               events,
+		}), events
+	}
+	serverAgent, serverEvents := newAgent()
+	clientAgent, clientEvents := newAgent()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	clientConn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- serverAgent.ServeConn(tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		}))
+	}()
+	go func() {
+		errs <- clientAgent.ServeConn(tls.Client(clientConn, &tls.Config{
+			ServerName: "localhost",
+			RootCAs:    roots,
+		}))
+	}()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	// Expect the client to see the server's certificate, and both to see
+	// the negotiated cipher suite.
+	clientEvent := <-clientEvents
+	require.IsType(t, ConnectedEvent{}, clientEvent)
+	clientTLS := clientEvent.(ConnectedEvent).TLS
+	require.NotNil(t, clientTLS)
+	assert.True(t, clientTLS.HandshakeComplete)
+	require.Len(t, clientTLS.PeerCertificates, 1)
+	assert.Equal(t, cert.Raw, clientTLS.PeerCertificates[0].Raw)
+	assert.Equal(t, clientConn.RemoteAddr(), clientEvent.(ConnectedEvent).RemoteAddr)
+	serverEvent := <-serverEvents
+	require.IsType(t, ConnectedEvent{}, serverEvent)
+	serverTLS := serverEvent.(ConnectedEvent).TLS
+	require.NotNil(t, serverTLS)
+	assert.Equal(t, clientTLS.CipherSuite, serverTLS.CipherSuite)
+
+	require.NoError(t, serverAgent.Shutdown(context.Background()))
+	require.NoError(t, clientAgent.Shutdown(context.Background()))
+}
+
+func TestAgent_ServeContext_canceled(t *testing.T) {
+	agent := NewAgent(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+		ChannelAccountSigner: keypair.MustRandom(),
+		LogWriter:            io.Discard,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := agent.ServeContext(ctx, "127.0.0.1:0")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAgent_takeSnapshot_policy(t *testing.T) {
+	localChannelAccount := keypair.MustRandom()
+	localSigner := keypair.MustRandom()
+	remoteChannelAccount := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+
+	newAgent := func(policy SnapshotPolicy) (*Agent, *int) {
+		count := 0
+		agent := &Agent{
+			snapshotter:    snapshotterFunc(func(a *Agent, s Snapshot) { count++ }),
+			snapshotPolicy: policy,
+		}
+		agent.channel = state.NewChannel(state.Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			MaxOpenExpiry:        5 * time.Minute,
+			Initiator:            true,
+			LocalChannelAccount:  localChannelAccount.FromAddress(),
+			RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+			LocalSigner:          localSigner,
+			RemoteSigner:         remoteSigner.FromAddress(),
+		})
+		return agent, &count
+	}
+	proposeOpen := func(t *testing.T, agent *Agent) {
+		_, err := agent.channel.ProposeOpen(state.OpenParams{
+			ObservationPeriodTime:      time.Minute,
+			ObservationPeriodLedgerGap: 10,
+			Asset:                      state.NativeAsset,
+			ExpiresAt:                  time.Now().Add(time.Minute),
+			StartingSequence:           101,
+		})
+		require.NoError(t, err)
+	}
+
+	t.Run("everyChange", func(t *testing.T) {
+		agent, count := newAgent(SnapshotPolicy{})
+		agent.takeSnapshot()
+		agent.takeSnapshot()
+		assert.Equal(t, 2, *count)
+	})
+
+	t.Run("openClose", func(t *testing.T) {
+		agent, count := newAgent(SnapshotPolicy{Mode: SnapshotOpenClose})
+
+		// The first change is a milestone because the agent has a channel.
+		agent.takeSnapshot()
+		assert.Equal(t, 1, *count)
+
+		// Changes that are not milestones are not snapshotted.
+		agent.streamerCursor = "1"
+		agent.takeSnapshot()
+		agent.streamerCursor = "2"
+		agent.takeSnapshot()
+		assert.Equal(t, 1, *count)
+
+		// Proposing an open is a milestone.
+		proposeOpen(t, agent)
+		agent.takeSnapshot()
+		assert.Equal(t, 2, *count)
+
+		// Shutting down flushes changes not snapshotted.
+		agent.streamerCursor = "3"
+		agent.takeSnapshot()
+		assert.Equal(t, 2, *count)
+		require.NoError(t, agent.disconnect())
+		assert.Equal(t, 3, *count)
+		require.NoError(t, agent.disconnect())
+		assert.Equal(t, 3, *count)
+	})
+
+	t.Run("periodicChanges", func(t *testing.T) {
+		agent, count := newAgent(SnapshotPolicy{Mode: SnapshotPeriodic, Changes: 3})
+		agent.takeSnapshot()
+		assert.Equal(t, 1, *count)
+		agent.takeSnapshot()
+		agent.takeSnapshot()
+		assert.Equal(t, 1, *count)
+		agent.takeSnapshot()
+		assert.Equal(t, 2, *count)
+
+		// Milestones are snapshotted regardless of the number of changes.
+		proposeOpen(t, agent)
+		agent.takeSnapshot()
+		assert.Equal(t, 3, *count)
+	})
+
+	t.Run("periodicInterval", func(t *testing.T) {
+		agent, count := newAgent(SnapshotPolicy{Mode: SnapshotPeriodic, Interval: time.Hour})
+		agent.takeSnapshot()
+		agent.takeSnapshot()
+		assert.Equal(t, 1, *count)
+		agent.lastSnapshotAt = time.Now().Add(-time.Hour)
+		agent.takeSnapshot()
+		assert.Equal(t, 2, *count)
+	})
+}
+
+func TestAgent_IterationNumberAndHasPendingAgreement(t *testing.T) {
+	localChannelAccount := keypair.MustRandom()
+	localSigner := keypair.MustRandom()
+	remoteChannelAccount := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+
+	agent := &Agent{}
+	assert.Equal(t, int64(0), agent.IterationNumber())
+	assert.False(t, agent.HasPendingAgreement())
+
+	newChannel := func(initiator bool, local, remote, localSigner, remoteSigner *keypair.Full) *state.Channel {
+		return state.NewChannel(state.Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			MaxOpenExpiry:        5 * time.Minute,
+			Initiator:            initiator,
+			LocalChannelAccount:  local.FromAddress(),
+			RemoteChannelAccount: remote.FromAddress(),
+			LocalSigner:          localSigner,
+			RemoteSigner:         remoteSigner.FromAddress(),
+		})
+	}
+	agent.channel = newChannel(true, localChannelAccount, remoteChannelAccount, localSigner, remoteSigner)
+	remoteChannel := newChannel(false, remoteChannelAccount, localChannelAccount, remoteSigner, localSigner)
+
+	open, err := agent.channel.ProposeOpen(state.OpenParams{
+		ObservationPeriodTime:      time.Minute,
+		ObservationPeriodLedgerGap: 10,
+		Asset:                      state.NativeAsset,
+		ExpiresAt:                  time.Now().Add(time.Minute),
+		StartingSequence:           101,
+	})
+	require.NoError(t, err)
+	assert.True(t, agent.HasPendingAgreement())
+	assert.Equal(t, int64(0), agent.IterationNumber())
+
+	open, err = remoteChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	_, err = agent.channel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	assert.False(t, agent.HasPendingAgreement())
+	assert.Equal(t, int64(1), agent.IterationNumber())
+}
+
+type statusStreamer struct {
+	status StreamStatus
+}
+
+func (s *statusStreamer) StreamTx(cursor string, accounts ...*keypair.FromAddress) (<-chan StreamedTransaction, func()) {
+	return nil, func() {}
+}
+
+func (s *statusStreamer) StreamStatus() StreamStatus {
+	return s.status
+}
+
+func TestAgent_checkStream(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	streamer := &statusStreamer{}
+	events := make(chan interface{}, 10)
+	startedAt := time.Unix(1000, 0)
+	agent := &Agent{
+		streamLagThreshold: time.Minute,
+		streamer:           streamer,
+		streamStartedAt:    startedAt,
+		logWriter:          io.Discard,
+		events:             events,
+	}
+
+	// Without a channel there is no stream to check yet.
+	assert.True(t, agent.checkStream(startedAt.Add(time.Hour)))
+	assert.Len(t, events, 0)
+
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+
+	// A stream that has seen no ledgers lags from when it started.
+	assert.True(t, agent.checkStream(startedAt.Add(time.Minute)))
+	assert.Len(t, events, 0)
+	assert.True(t, agent.checkStream(startedAt.Add(2*time.Minute)))
+	require.Len(t, events, 1)
+	assert.Equal(t, StreamLagEvent{Lag: 2 * time.Minute}, <-events)
+
+	// The lag event is written once until the stream catches up.
+	assert.True(t, agent.checkStream(startedAt.Add(3*time.Minute)))
+	assert.Len(t, events, 0)
+	streamer.status = StreamStatus{Connected: true, LatestLedger: 10, LatestLedgerCloseTime: startedAt.Add(3 * time.Minute)}
+	assert.True(t, agent.checkStream(startedAt.Add(3*time.Minute)))
+	assert.Len(t, events, 0)
+
+	// Ledgers of the transactions ingested by the agent do not count towards
+	// the latest ledger, because they only occur while the channel accounts
+	// are active.
+	agent.observeLedger(11, startedAt.Add(4*time.Minute))
+	assert.True(t, agent.checkStream(startedAt.Add(5*time.Minute)))
+	require.Len(t, events, 1)
+	assert.Equal(t, StreamLagEvent{Lag: 2 * time.Minute, LatestLedgerCloseTime: startedAt.Add(3 * time.Minute)}, <-events)
+
+	// A stream whose Streamer keeps its latest ledger current while the
+	// channel is idle does not lag.
+	streamer.status = StreamStatus{Connected: true, LatestLedger: 100, LatestLedgerCloseTime: startedAt.Add(6 * time.Minute)}
+	assert.True(t, agent.checkStream(startedAt.Add(6*time.Minute)))
+	assert.Len(t, events, 0)
+
+	// Errors reported by the streamer are written once each.
+	streamErr := errors.New("disconnected")
+	streamer.status = StreamStatus{Err: streamErr, LatestLedger: 100, LatestLedgerCloseTime: startedAt.Add(6 * time.Minute)}
+	assert.True(t, agent.checkStream(startedAt.Add(6*time.Minute)))
+	assert.True(t, agent.checkStream(startedAt.Add(6*time.Minute)))
+	require.Len(t, events, 1)
+	assert.Equal(t, StreamErrorEvent{Err: streamErr}, <-events)
+}
+
+func TestAgent_streamLag_idleChannel(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{})
+	_, err := p.Open(state.OpenParams{})
+	require.NoError(t, err)
+
+	// The channel has been open for an hour with no transactions of the
+	// channel accounts, while the Streamer has kept up with the network.
+	now := time.Now()
+	streamer := &statusStreamer{status: StreamStatus{
+		Connected:             true,
+		LatestLedger:          1000,
+		LatestLedgerCloseTime: now.Add(-5 * time.Second),
+	}}
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		streamLagThreshold: time.Minute,
+		streamer:           streamer,
+		streamStartedAt:    now.Add(-time.Hour),
+		channel:            p.Initiator,
+		conn:               &bytes.Buffer{},
+		logWriter:          io.Discard,
+		events:             events,
+	}
+	assert.True(t, agent.checkStream(now))
+	assert.Len(t, events, 0)
+	healthy, reason := agent.Healthy()
+	assert.True(t, healthy)
+	assert.Equal(t, "connected, channel open", reason)
+
+	// The lag of a Streamer that does not report its status is not measured.
+	agent.streamer = streamerFunc(func(cursor string, accounts ...*keypair.FromAddress) (<-chan StreamedTransaction, func()) {
+		return nil, func() {}
+	})
+	assert.True(t, agent.checkStream(now))
+	assert.Len(t, events, 0)
+	healthy, reason = agent.Healthy()
+	assert.True(t, healthy)
+	assert.Equal(t, "connected, channel open", reason)
+}
+
+func TestAgent_streamClosed(t *testing.T) {
+	newAgent := func(resubscribeDelay time.Duration, cursors chan<- string, events chan<- interface{}) *Agent {
+		subscriptions := 0
+		p := statetest.NewChannelPair(statetest.PairConfig{})
+		return &Agent{
+			streamResubscribeDelay: resubscribeDelay,
+			streamer: streamerFunc(func(cursor string, accounts ...*keypair.FromAddress) (<-chan StreamedTransaction, func()) {
+				cursors <- cursor
+				subscriptions++
+				txs := make(chan StreamedTransaction)
+				if subscriptions == 1 {
+					// The first stream closes unexpectedly.
+					close(txs)
+				}
+				return txs, func() {}
+			}),
+			streamerCursor: "5",
+			channel:        p.Initiator,
+			logWriter:      io.Discard,
+			events:         events,
+		}
+	}
+
+	t.Run("resubscribe", func(t *testing.T) {
+		cursors := make(chan string, 2)
+		events := make(chan interface{}, 1)
+		agent := newAgent(time.Millisecond, cursors, events)
+		agent.streamerTransactions, agent.streamerCancel = agent.streamer.StreamTx(agent.streamerCursor)
+		go agent.ingestLoop(agent.streamerTransactions)
+
+		assert.Equal(t, StreamClosedEvent{Cursor: "5", Resubscribing: true}, <-events)
+		assert.Equal(t, "5", <-cursors)
+		select {
+		case cursor := <-cursors:
+			assert.Equal(t, "5", cursor)
+		case <-time.After(5 * time.Second):
+			t.Fatal("agent did not resubscribe")
+		}
+	})
+
+	t.Run("no resubscribe", func(t *testing.T) {
+		cursors := make(chan string, 2)
+		events := make(chan interface{}, 1)
+		agent := newAgent(0, cursors, events)
+		agent.streamerTransactions, agent.streamerCancel = agent.streamer.StreamTx(agent.streamerCursor)
+		agent.ingestLoop(agent.streamerTransactions)
+
+		require.Len(t, events, 1)
+		assert.Equal(t, StreamClosedEvent{Cursor: "5", Resubscribing: false}, <-events)
+		assert.Len(t, cursors, 1)
+	})
+}
+
+func TestAgent_IngestTx_malformed(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{})
+	agent := &Agent{
+		streamerCursor: "5",
+		channel:        p.Initiator,
+		logWriter:      io.Discard,
+	}
+
+	// A malformed transaction is reported by returning an error, and does not
+	// block writing an event when the agent has no events channel.
+	ingested := make(chan error, 1)
+	go func() {
+		ingested <- agent.IngestTx(StreamedTransaction{Cursor: "6", TransactionXDR: "AAAA"})
+	}()
+	select {
+	case err := <-ingested:
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "ingesting tx (cursor=6)")
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingesting did not return")
+	}
+
+	// The cursor is not moved past the transaction that was not ingested.
+	assert.Equal(t, "5", agent.streamerCursor)
+
+	// The same transaction from a Streamer is reported once as an event.
+	events := make(chan interface{}, 2)
+	agent.events = events
+	txs := make(chan StreamedTransaction, 1)
+	txs <- StreamedTransaction{Cursor: "6", TransactionXDR: "AAAA"}
+	agent.streamerTransactions = txs
+	err := agent.ingest(txs)
+	require.Error(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, ErrorEvent{Err: err}, <-events)
+	assert.Equal(t, "5", agent.streamerCursor)
+}
+
+func TestAgent_Healthy(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	streamer := &statusStreamer{}
+	agent := &Agent{
+		streamLagThreshold: time.Minute,
+		streamer:           streamer,
+		streamStartedAt:    time.Now(),
+		logWriter:          io.Discard,
+	}
+
+	healthy, reason := agent.Healthy()
+	assert.False(t, healthy)
+	assert.Equal(t, "not connected", reason)
+
+	agent.conn = &bytes.Buffer{}
+	healthy, reason = agent.Healthy()
+	assert.True(t, healthy)
+	assert.Equal(t, "connected", reason)
+
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+	healthy, reason = agent.Healthy()
+	assert.True(t, healthy)
+	assert.Equal(t, "connected, channel not open", reason)
+
+	// A stream that has fallen behind is unhealthy.
+	agent.streamStartedAt = time.Now().Add(-2 * time.Minute)
+	healthy, reason = agent.Healthy()
+	assert.False(t, healthy)
+	assert.Equal(t, "stream lagging by 2m0s", reason)
+
+	// A stream that errored is unhealthy.
+	streamer.status = StreamStatus{Err: errors.New("disconnected")}
+	healthy, reason = agent.Healthy()
+	assert.False(t, healthy)
+	assert.Equal(t, "stream error: disconnected", reason)
+
+	agent.shuttingDown = true
+	healthy, reason = agent.Healthy()
+	assert.False(t, healthy)
+	assert.Equal(t, "shutting down", reason)
+}
+
+func TestAgent_watchOpen(t *testing.T) {
+	localChannelAccount := keypair.MustParseAddress("GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+	localSigner := keypair.MustParseFull("SCBMAMOPWKL2YHWELK63VLAY2R74A6GTLLD4ON223B7K5KZ37MUR6IDF")
+	remoteChannelAccount := keypair.MustParseAddress("GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO")
+	remoteSigner := keypair.MustParseFull("SBM7D2IIDSRX5Y3VMTMTXXPB6AIB4WYGZBC2M64U742BNOK32X6SW4NF")
+
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		openTimeout: 10 * time.Millisecond,
+		logWriter:   io.Discard,
+		events:      events,
+	}
+	agent.channel = state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            true,
+		LocalChannelAccount:  localChannelAccount.FromAddress(),
+		RemoteChannelAccount: remoteChannelAccount.FromAddress(),
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+	})
+	open, err := agent.channel.ProposeOpen(state.OpenParams{
+		Asset:            state.NativeAsset,
+		ExpiresAt:        time.Now().Add(time.Minute),
+		StartingSequence: 101,
+	})
+	require.NoError(t, err)
+
+	// If the open transaction is never seen the open fails once the timeout
+	// passes.
+	agent.mu.Lock()
+	agent.watchOpen()
+	agent.mu.Unlock()
+	select {
+	case e := <-events:
+		assert.Equal(t, OpenFailedEvent{OpenAgreement: open, Err: ErrOpenNotSeen}, e)
+	case <-time.After(time.Second):
+		t.Fatal("no open failed event written")
+	}
+}
+
+func TestAgent_responseTimeout(t *testing.T) {
+	t.Run("open", func(t *testing.T) {
+		p := statetest.NewChannelPair(statetest.PairConfig{})
+		events := make(chan interface{}, 10)
+		agent := &Agent{
+			responseTimeout:      10 * time.Millisecond,
+			channelAccountSigner: p.InitiatorSigner,
+			channel:              p.Initiator,
+			logWriter:            io.Discard,
+			events:               events,
+		}
+		open, err := p.Initiator.ProposeOpen(state.OpenParams{
+			Asset:            state.NativeAsset,
+			ExpiresAt:        time.Now().Add(time.Minute),
+			StartingSequence: 101,
+		})
+		require.NoError(t, err)
+
+		// If no response arrives the open is reported but kept, because the
+		// other participant holds this participant's signatures for it.
+		agent.mu.Lock()
+		agent.watchOpenResponse(open)
+		agent.mu.Unlock()
+		select {
+		case e := <-events:
+			assert.Equal(t, OpenResponseTimeoutEvent{OpenAgreement: open}, e)
+		case <-time.After(time.Second):
+			t.Fatal("no open response timeout event written")
+		}
+		assert.Equal(t, open, p.Initiator.OpenAgreement())
+
+		// An open that has been authorized is not reported.
+		confirmed, err := p.Responder.ConfirmOpen(open.Envelope)
+		require.NoError(t, err)
+		_, err = p.Initiator.ConfirmOpen(confirmed.Envelope)
+		require.NoError(t, err)
+		agent.mu.Lock()
+		agent.expireOpenResponse(open)
+		agent.mu.Unlock()
+		assert.Empty(t, events)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+		_, err := p.Open(state.OpenParams{})
+		require.NoError(t, err)
+		events := make(chan interface{}, 10)
+		agent := &Agent{
+			responseTimeout:      10 * time.Millisecond,
+			channelAccountSigner: p.InitiatorSigner,
+			channel:              p.Initiator,
+			logWriter:            io.Discard,
+			events:               events,
+		}
+		close, err := p.Initiator.ProposeClose()
+		require.NoError(t, err)
+
+		// If no response arrives the close is abandoned.
+		agent.mu.Lock()
+		agent.watchCloseResponse(close.Envelope.Details.IterationNumber)
+		agent.mu.Unlock()
+		select {
+		case e := <-events:
+			assert.Equal(t, CloseResponseTimeoutEvent{CloseAgreement: close}, e)
+		case <-time.After(time.Second):
+			t.Fatal("no close response timeout event written")
+		}
+		_, pending := p.Initiator.LatestUnauthorizedCloseAgreement()
+		assert.False(t, pending)
+
+		// A close that has been authorized is not abandoned.
+		_, err = p.Close(statetest.Initiator)
+		require.NoError(t, err)
+		agent.mu.Lock()
+		agent.expireCloseResponse(close.Envelope.Details.IterationNumber)
+		agent.mu.Unlock()
+		assert.Empty(t, events)
+
+	})
+}
+
+func TestAgent_receive_multipleMessagesInOneRead(t *testing.T) {
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		logWriter: io.Discard,
+		events:    events,
+	}
+
+	// Encode two messages and deliver them to the agent in a single read, so
+	// that the second message is buffered by the decoder while the first is
+	// handled.
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+	remoteSigner := keypair.MustRandom().FromAddress()
+	hello := msg.Message{
+		Type:  msg.TypeHello,
+		Hello: &msg.Hello{ChannelAccount: *remoteChannelAccount, Signer: *remoteSigner},
+	}
+	buf := bytes.Buffer{}
+	enc := msg.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(hello))
+	require.NoError(t, enc.Encode(msg.Message{Type: msg.TypeInfo, Info: &msg.Info{Version: "v1"}}))
+	conn := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(buf.Bytes()), io.Discard}
+
+	agent.attachConn(conn)
+	defer agent.disconnect()
+
+	require.NoError(t, agent.receive())
+	require.NoError(t, agent.receive())
+	assert.Equal(t, io.EOF, agent.receive())
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(2), agent.ConnStats().MessagesReceived)
+}
+
+func TestAgent_MessageObserver(t *testing.T) {
+	type observed struct {
+		Direction MessageDirection
+		Type      msg.Type
+	}
+	observedCh := make(chan observed, 10)
+	agent := &Agent{
+		channelAccountKey:    keypair.MustRandom().FromAddress(),
+		channelAccountSigner: keypair.MustRandom(),
+		logWriter:            io.Discard,
+		messageObserver: func(direction MessageDirection, m msg.Message) {
+			observedCh <- observed{direction, m.Type}
+		},
+	}
+
+	hello := msg.Message{
+		Type:  msg.TypeHello,
+		Hello: &msg.Hello{ChannelAccount: *keypair.MustRandom().FromAddress(), Signer: *keypair.MustRandom().FromAddress()},
+	}
+	in := bytes.Buffer{}
+	require.NoError(t, msg.NewEncoder(&in).Encode(hello))
+	conn := struct {
+		io.Reader
+		io.Writer
+	}{&in, io.Discard}
+
+	agent.attachConn(conn)
+	defer agent.disconnect()
+
+	require.NoError(t, agent.hello())
+	require.NoError(t, agent.receive())
+	require.Len(t, observedCh, 2)
+	assert.Equal(t, observed{MessageSent, msg.TypeHello}, <-observedCh)
+	assert.Equal(t, observed{MessageReceived, msg.TypeHello}, <-observedCh)
+}
+
+func TestAgent_info(t *testing.T) {
+	events := make(chan interface{}, 1)
+	info := &msg.Info{
+		Version:          "test/1.0",
+		Assets:           []state.Asset{state.NativeAsset},
+		MaxPaymentAmount: 100,
+		FeePolicy:        "fee bump base fee 200",
+	}
+	sent := []msg.Type{}
+	agent := &Agent{
+		channelAccountKey:    keypair.MustRandom().FromAddress(),
+		channelAccountSigner: keypair.MustRandom(),
+		logWriter:            io.Discard,
+		events:               events,
+		info:                 info,
+		messageObserver: func(direction MessageDirection, m msg.Message) {
+			if direction == MessageSent {
+				sent = append(sent, m.Type)
+			}
+		},
+	}
+	agent.attachConn(struct {
+		io.Reader
+		io.Writer
+	}{&bytes.Buffer{}, io.Discard})
+	defer agent.disconnect()
+
+	// The info is sent after the hello.
+	require.NoError(t, agent.hello())
+	assert.Equal(t, []msg.Type{msg.TypeHello, msg.TypeInfo}, sent)
+
+	// No info is known until the other participant sends it.
+	_, ok := agent.OtherInfo()
+	assert.False(t, ok)
+
+	otherInfo := msg.Info{Version: "other/2.0", Extra: map[string]string{"region": "eu"}}
+	require.NoError(t, agent.handle(msg.Message{Type: msg.TypeInfo, Info: &otherInfo}))
+	got, ok := agent.OtherInfo()
+	assert.True(t, ok)
+	assert.Equal(t, otherInfo, got)
+	assert.Equal(t, InfoReceivedEvent{Info: otherInfo}, <-events)
+
+	// A malformed info is an error.
+	err := agent.handle(msg.Message{Type: msg.TypeInfo})
+	assert.ErrorIs(t, err, ErrMalformedMessage)
+	<-events
+}
+
+func TestStreamedTransaction_decode(t *testing.T) {
+	resultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildResultMetaXDR(nil)
+	require.NoError(t, err)
+	tx, err := txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount:        &txnbuild.SimpleAccount{AccountID: keypair.MustRandom().Address(), Sequence: 1},
+		BaseFee:              txnbuild.MinBaseFee,
+		Timebounds:           txnbuild.NewInfiniteTimeout(),
+		Operations:           []txnbuild.Operation{&txnbuild.BumpSequence{BumpTo: 2}},
+		IncrementSequenceNum: true,
+	})
+	require.NoError(t, err)
+	txXDR, err := tx.Base64()
+	require.NoError(t, err)
+
+	streamedTx := StreamedTransaction{
+		TransactionXDR: txXDR,
+		ResultXDR:      resultXDR,
+		ResultMetaXDR:  resultMetaXDR,
+	}
+
+	gtx, err := streamedTx.Transaction()
+	require.NoError(t, err)
+	decodedTx, ok := gtx.Transaction()
+	require.True(t, ok)
+	assert.Equal(t, int64(2), decodedTx.SourceAccount().Sequence)
+	result, err := streamedTx.Result()
+	require.NoError(t, err)
+	assert.True(t, result.Successful())
+	resultMeta, err := streamedTx.ResultMeta()
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), resultMeta.V)
+
+	// Decoded values are cached and not decoded again.
+	streamedTx.TransactionXDR = ""
+	streamedTx.ResultXDR = ""
+	streamedTx.ResultMetaXDR = ""
+	gtx2, err := streamedTx.Transaction()
+	require.NoError(t, err)
+	assert.Same(t, gtx, gtx2)
+	_, err = streamedTx.Result()
+	require.NoError(t, err)
+	_, err = streamedTx.ResultMeta()
+	require.NoError(t, err)
+
+	// Invalid XDR errors.
+	_, err = (&StreamedTransaction{}).Transaction()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parsing transaction xdr")
+	_, err = (&StreamedTransaction{}).ResultMeta()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "parsing result meta xdr")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	err := Config{}.Validate()
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "Submitter is required to open and close a channel")
+	assert.Contains(t, err.Error(), "ChannelAccountSigner is required to sign agreements and transactions")
+
+	c := Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+		ChannelAccountSigner: keypair.MustRandom(),
+		SequenceNumberCollector: sequenceNumberCollector(func(accountID *keypair.FromAddress) (int64, error) {
+			return 1, nil
+		}),
+		BalanceCollector: balanceCollectorFunc(func(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+			return 0, nil
+		}),
+		Submitter: submitterFunc(func(tx *txnbuild.Transaction) error {
+			return nil
+		}),
+		Streamer: streamerFunc(func(cursor string, accounts ...*keypair.FromAddress) (<-chan StreamedTransaction, func()) {
+			return nil, func() {}
+		}),
+		LogWriter: io.Discard,
+	}
+	assert.NoError(t, c.Validate())
+
+	// A Streamer is optional.
+	c.Streamer = nil
+	assert.NoError(t, c.Validate())
+}
+
+func TestConfig_WithChannelAccount(t *testing.T) {
+	base := Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxPaymentAmount:     100,
+		ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+		ChannelAccountSigner: keypair.MustRandom(),
+		LogWriter:            io.Discard,
+	}
+	key := keypair.MustRandom().FromAddress()
+	signer := keypair.MustRandom()
+
+	c := base.WithChannelAccount(key, signer)
+	assert.Equal(t, key, c.ChannelAccountKey)
+	assert.Equal(t, signer, c.ChannelAccountSigner)
+	assert.Equal(t, base.NetworkPassphrase, c.NetworkPassphrase)
+	assert.Equal(t, base.MaxPaymentAmount, c.MaxPaymentAmount)
+
+	// The original config is unchanged.
+	assert.NotEqual(t, key, base.ChannelAccountKey)
+	assert.NotEqual(t, signer, base.ChannelAccountSigner)
+}
+
+func TestAgent_openExpiry(t *testing.T) {
+	// Defaults to half the max open expiry.
+	agent := &Agent{maxOpenExpiry: 10 * time.Minute}
+	expiry, err := agent.openExpiry()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, expiry)
+
+	agent = &Agent{maxOpenExpiry: 10 * time.Minute, openExpiryMargin: 30 * time.Second}
+	expiry, err = agent.openExpiry()
+	require.NoError(t, err)
+	assert.Equal(t, 9*time.Minute+30*time.Second, expiry)
+
+	// The expiry must be within the max open expiry and in the future.
+	agent = &Agent{maxOpenExpiry: 10 * time.Minute, openExpiryMargin: 10 * time.Minute}
+	_, err = agent.openExpiry()
+	assert.EqualError(t, err, "open expiry margin 10m0s must be greater than zero and less than max open expiry 10m0s")
+	agent = &Agent{maxOpenExpiry: 10 * time.Minute, openExpiryMargin: -time.Minute}
+	_, err = agent.openExpiry()
+	assert.EqualError(t, err, "open expiry margin -1m0s must be greater than zero and less than max open expiry 10m0s")
+}
+
+func TestAgent_setCloseReason(t *testing.T) {
+	agent := &Agent{}
+	assert.Equal(t, CloseReasonUnknown, agent.closeReason)
+
+	agent.setCloseReason(CloseReasonDeclaredByLocal)
+	assert.Equal(t, CloseReasonDeclaredByLocal, agent.closeReason)
+
+	// An outdated declaration replaces the reason the close was declared.
+	agent.setCloseReason(CloseReasonOutdatedDeclaration)
+	assert.Equal(t, CloseReasonOutdatedDeclaration, agent.closeReason)
+
+	// Once a coordinated close is agreed it is how the channel closes.
+	agent.setCloseReason(CloseReasonCoordinated)
+	agent.setCloseReason(CloseReasonDeclaredByRemote)
+	assert.Equal(t, CloseReasonCoordinated, agent.closeReason)
+
+	// The reason is snapshotted and restored.
+	snapshot := agent.buildSnapshot()
+	assert.Equal(t, CloseReasonCoordinated, snapshot.CloseReason)
+	restored := NewAgentFromSnapshot(Config{LogWriter: io.Discard}, snapshot)
+	assert.Equal(t, CloseReasonCoordinated, restored.closeReason)
+}
+
+func TestCloseReason_String(t *testing.T) {
+	assert.Equal(t, "coordinated", CloseReasonCoordinated.String())
+	assert.Equal(t, "declared by remote", CloseReasonDeclaredByRemote.String())
+	assert.Equal(t, "CloseReason(99)", CloseReason(99).String())
+}
+
+func TestReconnectingAgent(t *testing.T) {
+	remote := NewAgent(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+		ChannelAccountSigner: keypair.MustRandom(),
+		LogWriter:            io.Discard,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	remoteConns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			err = remote.ServeConn(conn)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			remoteConns <- conn
+		}
+	}()
+
+	events := make(chan interface{}, 10)
+	r, err := NewReconnectingAgent(ReconnectingConfig{
+		Agent: Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+			ChannelAccountSigner: keypair.MustRandom(),
+			LogWriter:            io.Discard,
+			Events:               events,
+		},
+		Dial: func(ctx context.Context) (io.ReadWriter, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", ln.Addr().String())
+		},
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	state, _ := r.ConnState()
+	assert.Equal(t, ConnStateStopped, state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- r.Run(ctx) }()
+
+	nextEvent := func() interface{} {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event written")
+			return nil
+		}
+	}
+
+	// The agent connects.
+	require.IsType(t, ConnectedEvent{}, nextEvent())
+	state, _ = r.ConnState()
+	assert.Equal(t, ConnStateConnected, state)
+
+	// When the other participant drops the connection, the agent writes a
+	// disconnected event and connects again.
+	(<-remoteConns).Close()
+	disconnected := nextEvent()
+	require.IsType(t, DisconnectedEvent{}, disconnected)
+	assert.Error(t, disconnected.(DisconnectedEvent).Err)
+	require.IsType(t, ConnectedEvent{}, nextEvent())
+	state, lastErr := r.ConnState()
+	assert.Equal(t, ConnStateConnected, state)
+	assert.Equal(t, disconnected.(DisconnectedEvent).Err, lastErr)
+
+	// Once stopped the agent is disconnected.
+	cancel()
+	assert.ErrorIs(t, <-runErr, context.Canceled)
+	state, _ = r.ConnState()
+	assert.Equal(t, ConnStateStopped, state)
+	assert.True(t, r.Agent().disconnected())
+}
+
+func TestNewReconnectingAgent_restoresFromStore(t *testing.T) {
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+	store := memStore{}
+	id := ChannelID{LocalChannelAccount: localChannelAccount.Address(), RemoteChannelAccount: remoteChannelAccount.Address()}
+	store[id] = Snapshot{OtherChannelAccount: remoteChannelAccount, CloseReason: CloseReasonCoordinated}
+
+	config := ReconnectingConfig{
+		Agent: Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			ChannelAccountKey:    localChannelAccount,
+			ChannelAccountSigner: keypair.MustRandom(),
+			LogWriter:            io.Discard,
+		},
+		Dial: func(ctx context.Context) (io.ReadWriter, error) {
+			return nil, fmt.Errorf("not dialable")
+		},
+		Store:                store,
+		RemoteChannelAccount: remoteChannelAccount,
+	}
+	r, err := NewReconnectingAgent(config)
+	require.NoError(t, err)
+	assert.Equal(t, remoteChannelAccount, r.Agent().otherChannelAccount)
+	assert.Equal(t, CloseReasonCoordinated, r.Agent().closeReason)
+	assert.Equal(t, StoreSnapshotter{Store: store}, r.Agent().snapshotter)
+
+	// Without a snapshot in the store, a new agent is created.
+	config.RemoteChannelAccount = keypair.MustRandom().FromAddress()
+	r, err = NewReconnectingAgent(config)
+	require.NoError(t, err)
+	assert.Nil(t, r.Agent().otherChannelAccount)
+}
+
+// memStore is a Store that holds snapshots in memory.
+type memStore map[ChannelID]Snapshot
+
+func (s memStore) List() ([]ChannelID, error) {
+	ids := []ChannelID{}
+	for id := range s {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s memStore) Load(id ChannelID) (Snapshot, error) {
+	snapshot, ok := s[id]
+	if !ok {
+		return Snapshot{}, ErrChannelNotFound
+	}
+	return snapshot, nil
+}
+
+func (s memStore) Save(id ChannelID, snapshot Snapshot) error {
+	s[id] = snapshot
+	return nil
+}
+
+func TestAgent_verifyOpen(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+	open, err := p.Open(state.OpenParams{InitiatorContribution: 60, ResponderContribution: 40})
+	require.NoError(t, err)
+
+	balances := map[string]int64{}
+	events := make(chan interface{}, 1)
+	agent := &Agent{
+		verifyOpenBalances:  true,
+		channel:             p.Initiator,
+		channelAccountKey:   p.InitiatorChannelAccount,
+		otherChannelAccount: p.ResponderChannelAccount,
+		balanceCollector: balanceCollectorFunc(func(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
+			return balances[accountID.Address()], nil
+		}),
+		logWriter: io.Discard,
+		events:    events,
+	}
+
+	// Both channel accounts hold at least their contributions.
+	balances[p.InitiatorChannelAccount.Address()] = 60
+	balances[p.ResponderChannelAccount.Address()] = 50
+	agent.verifyOpen()
+	require.Len(t, events, 1)
+	assert.Equal(t, OpenVerifiedEvent{OpenAgreement: open}, <-events)
+
+	// The responder's channel account holds less than its contribution.
+	balances[p.ResponderChannelAccount.Address()] = 39
+	agent.verifyOpen()
+	require.Len(t, events, 1)
+	assert.Equal(t, OpenMismatchEvent{
+		OpenAgreement:  open,
+		ChannelAccount: p.ResponderChannelAccount,
+		Contribution:   40,
+		Balance:        39,
+	}, <-events)
+
+	// The responder does not verify the open.
+	agent.channel = p.Responder
+	agent.verifyOpen()
+	assert.Len(t, events, 0)
+
+	// Nothing is verified unless configured.
+	agent.channel = p.Initiator
+	agent.verifyOpenBalances = false
+	agent.verifyOpen()
+	assert.Len(t, events, 0)
+}
+
+func TestAgent_OpenWithToken(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{})
+	newAgent := func(channel *state.Channel, sent *[]msg.Message, submitted *int) *Agent {
+		a := &Agent{
+			channel:        channel,
+			streamerCancel: func() {},
+			submitter: submitterFunc(func(tx *txnbuild.Transaction) error {
+				*submitted++
+				return nil
+			}),
+			logWriter: io.Discard,
+			messageObserver: func(direction MessageDirection, m msg.Message) {
+				if direction == MessageSent {
+					*sent = append(*sent, m)
+				}
+			},
+		}
+		a.attachConn(struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(nil), io.Discard})
+		return a
+	}
+	localSent, remoteSent := []msg.Message{}, []msg.Message{}
+	localSubmitted, remoteSubmitted := 0, 0
+	local := newAgent(p.Initiator, &localSent, &localSubmitted)
+	defer local.disconnect()
+	remote := newAgent(p.Responder, &remoteSent, &remoteSubmitted)
+	defer remote.disconnect()
+
+	open, err := p.Initiator.ProposeOpen(state.OpenParams{
+		Asset:            state.NativeAsset,
+		ExpiresAt:        time.Now().Add(time.Minute),
+		StartingSequence: 101,
+	})
+	require.NoError(t, err)
+	local.openToken = "open-1"
+
+	// A token other than the one the open was started with is not resumed.
+	err = local.OpenWithToken(state.NativeAsset, "open-2")
+	assert.ErrorIs(t, err, ErrOpenTokenMismatch)
+	assert.Empty(t, localSent)
+
+	// An open that has not been confirmed is sent again.
+	require.NoError(t, local.OpenWithToken(state.NativeAsset, "open-1"))
+	require.Len(t, localSent, 1)
+	assert.Equal(t, open.Envelope, *localSent[0].OpenRequest)
+
+	// The other participant sends its confirmation again for an open it has
+	// already confirmed.
+	_, err = p.Responder.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	require.NoError(t, remote.handleOpenRequest(localSent[0]))
+	require.Len(t, remoteSent, 1)
+	require.NoError(t, local.handleOpenResponse(remoteSent[0]))
+	assert.Equal(t, 1, localSubmitted)
+
+	// An open that has been confirmed but not seen is submitted again.
+	require.NoError(t, local.OpenWithToken(state.NativeAsset, "open-1"))
+	assert.Equal(t, 2, localSubmitted)
+
+	// The open cannot be canceled before it expires.
+	err = local.CancelOpen()
+	assert.ErrorIs(t, err, ErrOpenNotCancelable)
+	assert.NotNil(t, local.channel)
+}
+
+func TestAgent_CancelOpen(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{})
+	events := make(chan interface{}, 1)
+	agent := &Agent{
+		channel:        p.Initiator,
+		openToken:      "open-1",
+		streamerCancel: func() {},
+		logWriter:      io.Discard,
+		events:         events,
+	}
+	open, err := p.Initiator.ProposeOpen(state.OpenParams{
+		Asset:            state.NativeAsset,
+		ExpiresAt:        time.Now().Add(-time.Second),
+		StartingSequence: 101,
+	})
+	require.NoError(t, err)
+
+	// An expired open cannot be resumed.
+	err = agent.OpenWithToken(state.NativeAsset, "open-1")
+	assert.ErrorIs(t, err, ErrOpenExpired)
+
+	// An expired open is discarded along with its token.
+	require.NoError(t, agent.CancelOpen())
+	assert.Nil(t, agent.channel)
+	assert.Empty(t, agent.openToken)
+	require.Len(t, events, 1)
+	assert.Equal(t, OpenCanceledEvent{OpenAgreement: open}, <-events)
+
+	// There is then nothing to cancel.
+	err = agent.CancelOpen()
+	assert.ErrorIs(t, err, ErrOpenNotCancelable)
+}
+
+type feeReportingSubmitter struct {
+	baseFee int64
+}
+
+func (s feeReportingSubmitter) SubmitTx(tx *txnbuild.Transaction) error {
+	return nil
+}
+
+func (s feeReportingSubmitter) SubmitBaseFee() int64 {
+	return s.baseFee
+}
+
+func TestAgent_EstimatedFees(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+	agent := &Agent{
+		submitter: submitterFunc(func(tx *txnbuild.Transaction) error { return nil }),
+		logWriter: io.Discard,
+	}
+
+	// A submitter that does not report its base fee cannot be estimated for.
+	_, err := agent.EstimatedFees()
+	assert.ErrorIs(t, err, ErrFeesUnknown)
+
+	// Fees cannot be estimated without an open agreement.
+	agent.submitter = feeReportingSubmitter{baseFee: 100}
+	_, err = agent.EstimatedFees()
+	assert.EqualError(t, err, "no channel")
+
+	// The channel's transactions are built without a fee and are fee bumped
+	// at the submitter's base fee.
+	_, err = p.Open(state.OpenParams{})
+	require.NoError(t, err)
+	_, err = p.Pay(statetest.Initiator, 10, nil)
+	require.NoError(t, err)
+	agent.channel = p.Initiator
+	fees, err := agent.EstimatedFees()
+	require.NoError(t, err)
+	assert.Equal(t, FeeBreakdown{
+		BaseFee:     100,
+		Open:        TransactionFee{Operations: 12, FeeBump: true, Fee: 1300},
+		Declaration: TransactionFee{Operations: 1, FeeBump: true, Fee: 200},
+		Close:       TransactionFee{Operations: 3, FeeBump: true, Fee: 400},
+	}, fees)
+	assert.Equal(t, int64(1900), fees.Total())
+}
+
+func TestAgent_Pause(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+	_, err := p.Open(state.OpenParams{})
+	require.NoError(t, err)
+
+	newAgent := func(channel *state.Channel, sent *[]msg.Message, events chan<- interface{}) *Agent {
+		a := &Agent{
+			channel:   channel,
+			logWriter: io.Discard,
+			events:    events,
+			messageObserver: func(direction MessageDirection, m msg.Message) {
+				if direction == MessageSent {
+					*sent = append(*sent, m)
+				}
+			},
+		}
+		a.attachConn(struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(nil), io.Discard})
+		return a
+	}
+	localSent, remoteSent := []msg.Message{}, []msg.Message{}
+	localEvents, remoteEvents := make(chan interface{}, 10), make(chan interface{}, 10)
+	local := newAgent(p.Initiator, &localSent, localEvents)
+	defer local.disconnect()
+	remote := newAgent(p.Responder, &remoteSent, remoteEvents)
+	defer remote.disconnect()
+
+	remote.Pause()
+	remote.Pause()
+	assert.True(t, remote.Paused())
+	require.Len(t, remoteEvents, 1)
+	assert.Equal(t, PausedEvent{}, <-remoteEvents)
+
+	// A paused agent does not open.
+	err = remote.Open(state.NativeAsset)
+	assert.ErrorIs(t, err, ErrPaused)
+
+	// Payments proposed to a paused agent are rejected to be tried later.
+	require.NoError(t, local.Payment(10))
+	require.Len(t, localSent, 1)
+	err = remote.handlePaymentRequest(localSent[0])
+	assert.ErrorIs(t, err, ErrPaused)
+	require.Len(t, remoteSent, 1)
+	assert.Equal(t, msg.RejectCodeTryLater, remoteSent[0].Reject.Code)
+	require.NoError(t, local.handleReject(remoteSent[0]))
+	assert.Equal(t, int64(0), local.channel.Balance())
+
+	// Once resumed, payments are confirmed again.
+	for len(remoteEvents) > 0 {
+		<-remoteEvents
+	}
+	remote.Resume()
+	assert.False(t, remote.Paused())
+	require.Len(t, remoteEvents, 1)
+	assert.Equal(t, ResumedEvent{}, <-remoteEvents)
+	require.NoError(t, local.Payment(10))
+	require.Len(t, localSent, 2)
+	require.NoError(t, remote.handlePaymentRequest(localSent[1]))
+	assert.Equal(t, msg.TypePaymentResponse, remoteSent[1].Type)
+}
+
+func TestAgent_AttestState(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 200})
+	_, err := p.Open(state.OpenParams{})
+	require.NoError(t, err)
+	latest, err := p.Pay(statetest.Responder, 30, nil)
+	require.NoError(t, err)
+
+	agent := &Agent{
+		networkPassphrase:    network.TestNetworkPassphrase,
+		channelAccountSigner: p.ResponderSigner,
+		channel:              p.Responder,
+		logWriter:            io.Discard,
+	}
+	b, err := agent.AttestState()
+	require.NoError(t, err)
+
+	attestation, err := VerifyAttestation(b)
+	require.NoError(t, err)
+	assert.Equal(t, network.TestNetworkPassphrase, attestation.NetworkPassphrase)
+	assert.Equal(t, p.InitiatorChannelAccount.Address(), attestation.InitiatorChannelAccount.Address())
+	assert.Equal(t, p.ResponderChannelAccount.Address(), attestation.ResponderChannelAccount.Address())
+	assert.Equal(t, int64(100), attestation.InitiatorChannelAccountBalance)
+	assert.Equal(t, int64(200), attestation.ResponderChannelAccountBalance)
+	assert.True(t, latest.Envelope.Equal(attestation.CloseAgreement))
+	assert.Equal(t, int64(-30), attestation.CloseAgreement.Details.Balance)
+	assert.Equal(t, p.ResponderSigner.Address(), attestation.Attester.Address())
+
+	// An attestation that has been altered is not valid.
+	signed := signedAttestation{}
+	require.NoError(t, json.Unmarshal(b, &signed))
+	altered := attestation
+	altered.ResponderChannelAccountBalance = 1000
+	signed.Attestation, err = json.Marshal(altered)
+	require.NoError(t, err)
+	b, err = json.Marshal(signed)
+	require.NoError(t, err)
+	_, err = VerifyAttestation(b)
+	assert.ErrorIs(t, err, ErrInvalidAttestation)
+
+	// An attestation by someone other than the participants is not valid.
+	agent.channelAccountSigner = keypair.MustRandom()
+	b, err = agent.AttestState()
+	require.NoError(t, err)
+	_, err = VerifyAttestation(b)
+	assert.ErrorIs(t, err, ErrInvalidAttestation)
+
+	// Without an agreement signed by both participants there is nothing to
+	// attest.
+	agent.channel = statetest.NewChannelPair(statetest.PairConfig{}).Initiator
+	_, err = agent.AttestState()
+	assert.EqualError(t, err, "no close agreement signed by both participants")
+}
+
+func TestAgent_ConnectTCP_retries(t *testing.T) {
+	// Find a free address that nothing is listening on yet.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	agent := &Agent{
+		connectRetries:       5,
+		connectRetryBackoff:  20 * time.Millisecond,
+		channelAccountKey:    keypair.MustRandom().FromAddress(),
+		channelAccountSigner: keypair.MustRandom(),
+		logWriter:            io.Discard,
+	}
+	connected := make(chan error, 1)
+	go func() { connected <- agent.ConnectTCP(addr) }()
+
+	// The other participant starts listening after the first attempt to
+	// connect has failed.
+	time.Sleep(30 * time.Millisecond)
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	defer ln.Close()
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	go io.Copy(io.Discard, conn)
+
+	require.NoError(t, <-connected)
+	assert.False(t, agent.disconnected())
+	require.NoError(t, agent.disconnect())
+}
+
+func TestAgent_setKeepAlive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	for _, period := range []time.Duration{0, -1, time.Second} {
+		t.Run(period.String(), func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			log := bytes.Buffer{}
+			agent := &Agent{tcpKeepAlivePeriod: period, logWriter: &log}
+			agent.setKeepAlive(conn)
+			assert.Empty(t, log.String())
+		})
+	}
+
+	// Connections other than TCP connections are left as is.
+	localConn, remoteConn := net.Pipe()
+	defer localConn.Close()
+	defer remoteConn.Close()
+	log := bytes.Buffer{}
+	agent := &Agent{logWriter: &log}
+	agent.setKeepAlive(localConn)
+	assert.Empty(t, log.String())
+}
+
+func TestAgent_helloTimeout(t *testing.T) {
+	localConn, remoteConn := net.Pipe()
+	defer remoteConn.Close()
+	go io.Copy(io.Discard, remoteConn)
+
+	events := make(chan interface{}, 1)
+	agent := &Agent{
+		helloTimeout:         10 * time.Millisecond,
+		channelAccountKey:    keypair.MustRandom().FromAddress(),
+		channelAccountSigner: keypair.MustRandom(),
+		logWriter:            io.Discard,
+		events:               events,
+	}
+	require.NoError(t, agent.ServeConn(localConn))
+
+	// The other participant never sends a hello.
+	select {
+	case e := <-events:
+		failed, ok := e.(ConnectFailedEvent)
+		require.True(t, ok, "unexpected event %#v", e)
+		assert.ErrorIs(t, failed.Err, ErrHelloTimeout)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connect failed event written")
+	}
+	assert.True(t, agent.disconnected())
+}
+
+func TestAgent_Reconcile(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+	_, err := p.Open(state.OpenParams{})
+	require.NoError(t, err)
+
+	newAgent := func(channel *state.Channel, sent *[]msg.Message, events chan interface{}) *Agent {
+		agent := &Agent{
+			channel:   channel,
+			logWriter: io.Discard,
+			events:    events,
+			messageObserver: func(direction MessageDirection, m msg.Message) {
+				if direction == MessageSent {
+					*sent = append(*sent, m)
+				}
+			},
+		}
+		agent.attachConn(struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(nil), io.Discard})
+		return agent
+	}
+	localSent := []msg.Message{}
+	localEvents := make(chan interface{}, 10)
+	local := newAgent(p.Initiator, &localSent, localEvents)
+	defer local.disconnect()
+	remoteSent := []msg.Message{}
+	remoteEvents := make(chan interface{}, 10)
+	remote := newAgent(p.Responder, &remoteSent, remoteEvents)
+	defer remote.disconnect()
+
+	// The remote confirms a payment but the local never receives the
+	// confirmation.
+	payment, err := p.Initiator.ProposePayment(10)
+	require.NoError(t, err)
+	_, err = p.Responder.ConfirmPayment(payment.Envelope)
+	require.NoError(t, err)
+
+	// The local reconciles and catches up to the remote's agreement.
+	require.NoError(t, local.Reconcile())
+	require.Len(t, localSent, 1)
+	assert.Equal(t, msg.TypeReconcileRequest, localSent[0].Type)
+	assert.Equal(t, int64(1), localSent[0].ReconcileRequest.Details.IterationNumber)
+	require.NoError(t, remote.handle(localSent[0]))
+	assert.Empty(t, remoteEvents)
+	require.Len(t, remoteSent, 1)
+	assert.Equal(t, msg.TypeReconcileResponse, remoteSent[0].Type)
+	require.NoError(t, local.handle(remoteSent[0]))
+	e := <-localEvents
+	require.IsType(t, ReconciledEvent{}, e)
+	assert.True(t, e.(ReconciledEvent).CaughtUp)
+	assert.Equal(t, int64(2), e.(ReconciledEvent).CloseAgreement.Envelope.Details.IterationNumber)
+	assert.Equal(t, int64(10), p.Initiator.Balance())
+	_, pending := p.Initiator.LatestUnauthorizedCloseAgreement()
+	assert.False(t, pending)
+
+	// Reconciling again finds the participants agree.
+	require.NoError(t, remote.Reconcile())
+	require.Len(t, remoteSent, 2)
+	require.NoError(t, local.handle(remoteSent[1]))
+	assert.Empty(t, localEvents)
+	require.Len(t, localSent, 2)
+	require.NoError(t, remote.handle(localSent[1]))
+	e = <-remoteEvents
+	assert.Equal(t, ReconciledEvent{CloseAgreement: p.Responder.LatestCloseAgreement(), CaughtUp: false}, e)
+
+	// An agreement that was not signed by both participants is rejected.
+	forged := p.Responder.LatestCloseAgreement().Envelope
+	forged.Details.IterationNumber = 3
+	forged.Details.Balance = 50
+	err = local.handle(msg.Message{Type: msg.TypeReconcileResponse, ReconcileResponse: &forged})
+	assert.EqualError(t, err, "handling message 61: catching up to iteration 3: invalid signature: signature verification failed")
+	assert.Equal(t, int64(10), p.Initiator.Balance())
+}
+
+func TestAgent_receipts(t *testing.T) {
+	p := statetest.NewChannelPair(statetest.PairConfig{InitiatorBalance: 100, ResponderBalance: 100})
+	_, err := p.Open(state.OpenParams{})
+	require.NoError(t, err)
+
+	newAgent := func(channel *state.Channel, receiptWriter io.Writer, sent *[]msg.Message) *Agent {
+		agent := NewAgent(Config{
+			ReceiptWriter: receiptWriter,
+			LogWriter:     io.Discard,
+			MessageObserver: func(direction MessageDirection, m msg.Message) {
+				if direction == MessageSent {
+					*sent = append(*sent, m)
+				}
+			},
+		})
+		agent.channel = channel
+		agent.attachConn(struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(nil), io.Discard})
+		return agent
+	}
+	localReader, localWriter := io.Pipe()
+	localSent := []msg.Message{}
+	local := newAgent(p.Initiator, localWriter, &localSent)
+	defer local.disconnect()
+	remoteReader, remoteWriter := io.Pipe()
+	remoteSent := []msg.Message{}
+	remote := newAgent(p.Responder, remoteWriter, &remoteSent)
+	defer remote.disconnect()
+
+	// A payment is made with a memo.
+	require.NoError(t, local.PaymentWithTypedMemo(10, state.MemoTypeText, []byte("order-1")))
+	require.Len(t, localSent, 1)
+	require.NoError(t, remote.handle(localSent[0]))
+	require.Len(t, remoteSent, 1)
+	require.NoError(t, local.handle(remoteSent[0]))
+
+	// Both participants write a receipt as a line of JSON.
+	for _, c := range []struct {
+		reader    io.Reader
+		direction PaymentDirection
+	}{
+		{localReader, PaymentDirectionSent},
+		{remoteReader, PaymentDirectionReceived},
+	} {
+		line, err := bufio.NewReader(c.reader).ReadBytes('\n')
+		require.NoError(t, err)
+		r := Receipt{}
+		require.NoError(t, json.Unmarshal(line, &r))
+		assert.Equal(t, int64(2), r.IterationNumber)
+		assert.Equal(t, c.direction, r.Direction)
+		assert.Equal(t, int64(10), r.Amount)
+		assert.Equal(t, int64(10), r.Balance)
+		assert.Equal(t, state.MemoTypeText, r.MemoType)
+		assert.Equal(t, []byte("order-1"), r.Memo)
+		assert.WithinDuration(t, time.Now(), r.Time, time.Minute)
+	}
+}
+
+func TestAgent_receive_malformedMessage(t *testing.T) {
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		logWriter: io.Discard,
+		events:    events,
+	}
+	localConn, remoteConn := net.Pipe()
+	defer remoteConn.Close()
+	agent.attachConn(localConn)
+	go agent.receiveLoop()
+
+	// Bytes that are not a gob encoded message, longer than the preview.
+	malformed := append([]byte{0x03, 0xff, 0xff}, bytes.Repeat([]byte("x"), 2*recvPreviewSize)...)
+	go remoteConn.Write(malformed)
+
+	// The error contains the last bytes read, up to the preview size.
+	e := <-events
+	require.IsType(t, ErrorEvent{}, e)
+	err := e.(ErrorEvent).Err
+	assert.ErrorIs(t, err, errReading)
+	assert.Contains(t, err.Error(), fmt.Sprintf("last %d bytes read: ", len(agent.recvTail.tail)))
+	assert.LessOrEqual(t, len(agent.recvTail.tail), recvPreviewSize)
+	assert.Contains(t, err.Error(), fmt.Sprintf("%x", agent.recvTail.tail))
+
+	// The connection can no longer be read and is dropped.
+	e = <-events
+	require.IsType(t, DisconnectedEvent{}, e)
+	assert.Equal(t, err, e.(DisconnectedEvent).Err)
+}
+
+func TestAgent_receive_malformedMessageConn(t *testing.T) {
+	events := make(chan interface{}, 10)
+	agent := &Agent{
+		logWriter: io.Discard,
+		events:    events,
+	}
+	localConn, remoteConn := net.Pipe()
+	defer remoteConn.Close()
+	agent.attachMessageConn(NewStreamMessageConn(localConn))
+	go agent.receiveLoop()
+
+	// A message that is not a gob encoded message, longer than the preview.
+	malformed := append([]byte{0x03, 0xff, 0xff}, bytes.Repeat([]byte("x"), 2*recvPreviewSize)...)
+	go NewStreamMessageConn(remoteConn).SendMessage(malformed)
+
+	// The error contains the end of the message, up to the preview size.
+	e := <-events
+	require.IsType(t, ErrorEvent{}, e)
+	err := e.(ErrorEvent).Err
+	assert.ErrorIs(t, err, errDecoding)
+	assert.Contains(t, err.Error(), fmt.Sprintf("last %d bytes read: %x", recvPreviewSize, malformed[len(malformed)-recvPreviewSize:]))
+
+	// The connection can no longer be read and is dropped.
+	e = <-events
+	require.IsType(t, DisconnectedEvent{}, e)
+	assert.Equal(t, err, e.(DisconnectedEvent).Err)
+}
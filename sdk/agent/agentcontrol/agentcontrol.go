@@ -0,0 +1,354 @@
+// Package agentcontrol contains a HTTP server that controls an agent, so that
+// an agent can be run as a sidecar and operated from other languages.
+//
+// The server exposes the following endpoints:
+//
+//	POST /open    Opens a channel. Body: {"Asset": "native"}
+//	POST /pay     Makes a payment. Body: {"Amount": 10000000, "Memo": "aGk="}
+//	POST /close   Declares a close of the channel.
+//	GET  /status  Returns the agent's config and snapshot.
+//	GET  /events  Streams the agent's events as server-sent events.
+//	GET  /health  Returns whether the agent is healthy, with 503 if not.
+//
+// Amounts are in stroops and memos are base64 encoded. Actions respond with
+// 204 No Content on success. Errors respond with a JSON body containing the
+// error message, {"Error": "..."}, and a status code mapped from the error,
+// e.g. 422 Unprocessable Entity for state.ErrUnderfunded and 503 Service
+// Unavailable for agent.ErrShuttingDown.
+//
+// The server does not authenticate requests and does not allow cross-origin
+// requests, and should only be served on an interface reachable by the
+// processes that operate the agent.
+package agentcontrol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// Config contains the information that can be supplied to configure the
+// Server at construction.
+type Config struct {
+	Agent *agent.Agent
+
+	// AgentEvents is the channel the agent was configured to write events to.
+	// The server reads all events from it and streams them to clients of the
+	// events endpoint. If nil, the events endpoint streams no events.
+	AgentEvents <-chan interface{}
+
+	LogWriter io.Writer
+}
+
+// Server is a http.Handler that controls an agent.
+type Server struct {
+	agent     *agent.Agent
+	logWriter io.Writer
+	mux       *http.ServeMux
+
+	// mu is a lock for the subscribers of the event stream.
+	mu          sync.Mutex
+	subscribers map[chan interface{}]struct{}
+}
+
+// New constructs a new server with the given config. If the config has
+// AgentEvents, the server starts reading events from it immediately.
+func New(c Config) *Server {
+	s := &Server{
+		agent:       c.Agent,
+		logWriter:   c.LogWriter,
+		mux:         http.NewServeMux(),
+		subscribers: map[chan interface{}]struct{}{},
+	}
+	if s.logWriter == nil {
+		s.logWriter = io.Discard
+	}
+	s.mux.HandleFunc("/open", s.handleOpen)
+	s.mux.HandleFunc("/pay", s.handlePay)
+	s.mux.HandleFunc("/close", s.handleClose)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/health", s.handleHealth)
+	if c.AgentEvents != nil {
+		go s.eventLoop(c.AgentEvents)
+	}
+	return s
+}
+
+// ServeHTTP serves the endpoints of the server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// OpenRequest is the body of a request to the open endpoint.
+type OpenRequest struct {
+	Asset state.Asset
+}
+
+// PayRequest is the body of a request to the pay endpoint.
+type PayRequest struct {
+	Amount int64
+	Memo   []byte
+}
+
+// ErrorResponse is the body of a response to a request that errored.
+type ErrorResponse struct {
+	Error string
+}
+
+// Status is the body of a response to the status endpoint.
+type Status struct {
+	Config   StatusConfig
+	Snapshot agent.Snapshot
+}
+
+// Health is the body of a response to the health endpoint.
+type Health struct {
+	Healthy bool
+	Reason  string
+}
+
+// StatusConfig is the config of the agent, with the channel account signer
+// given as its public key.
+type StatusConfig struct {
+	ObservationPeriodTime      time.Duration
+	ObservationPeriodLedgerGap int64
+	MaxOpenExpiry              time.Duration
+	NetworkPassphrase          string
+	ChannelAccountKey          *keypair.FromAddress
+	ChannelAccountSigner       *keypair.FromAddress
+}
+
+func (s *Server) handleOpen(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	req := OpenRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Asset == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("asset is required"))
+		return
+	}
+	s.writeResult(w, s.agent.Open(req.Asset))
+}
+
+func (s *Server) handlePay(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	req := PayRequest{}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	s.writeResult(w, s.agent.PaymentWithMemo(req.Amount, req.Memo))
+}
+
+func (s *Server) handleClose(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	s.writeResult(w, s.agent.DeclareClose())
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	c := s.agent.Config()
+	status := Status{
+		Config: StatusConfig{
+			ObservationPeriodTime:      c.ObservationPeriodTime,
+			ObservationPeriodLedgerGap: c.ObservationPeriodLedgerGap,
+			MaxOpenExpiry:              c.MaxOpenExpiry,
+			NetworkPassphrase:          c.NetworkPassphrase,
+			ChannelAccountKey:          c.ChannelAccountKey,
+		},
+		Snapshot: s.agent.Snapshot(),
+	}
+	if c.ChannelAccountSigner != nil {
+		status.Config.ChannelAccountSigner = c.ChannelAccountSigner.FromAddress()
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	healthy, reason := s.agent.Healthy()
+	code := http.StatusOK
+	if !healthy {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, Health{Healthy: healthy, Reason: reason})
+}
+
+// handleEvents streams events to the client as server-sent events until the
+// client disconnects. Each event's name is the name of the event's type, e.g.
+// OpenedEvent, and its data is the event encoded as JSON. Events that occur
+// while the client is not reading fast enough are dropped.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			name, data, err := encodeEvent(e)
+			if err != nil {
+				fmt.Fprintf(s.logWriter, "encoding event %T: %v\n", e, err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// subscriberBufferSize is the number of events buffered for each client of
+// the events endpoint.
+const subscriberBufferSize = 100
+
+func (s *Server) subscribe() chan interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make(chan interface{}, subscriberBufferSize)
+	s.subscribers[events] = struct{}{}
+	return events
+}
+
+func (s *Server) unsubscribe(events chan interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, events)
+}
+
+// eventLoop reads events from the agent and writes them to every subscriber,
+// dropping events for subscribers whose buffers are full so that a slow client
+// never blocks the agent.
+func (s *Server) eventLoop(agentEvents <-chan interface{}) {
+	for e := range agentEvents {
+		s.mu.Lock()
+		for sub := range s.subscribers {
+			select {
+			case sub <- e:
+			default:
+				fmt.Fprintf(s.logWriter, "dropping event %T for slow events client\n", e)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// encodeEvent returns the name and JSON encoding of an event. Errors held by
+// ErrorEvents are encoded as their message.
+func encodeEvent(e interface{}) (name string, data []byte, err error) {
+	name = reflect.TypeOf(e).Name()
+	// Errors do not encode to JSON, and so events holding them are encoded
+	// with the error's message.
+	switch ev := e.(type) {
+	case agent.ErrorEvent:
+		e = ErrorResponse{Error: ev.Err.Error()}
+	case agent.StreamErrorEvent:
+		e = ErrorResponse{Error: ev.Err.Error()}
+	case agent.OpenFailedEvent:
+		e = struct {
+			OpenAgreement state.OpenAgreement
+			Error         string
+		}{ev.OpenAgreement, ev.Err.Error()}
+	}
+	data, err = json.Marshal(e)
+	return name, data, err
+}
+
+// statusCode returns the HTTP status code for an error returned by the agent.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, agent.ErrShuttingDown),
+		errors.Is(err, agent.ErrPaused):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, agent.ErrChannelAccountNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, state.ErrInvalidAmount):
+		return http.StatusBadRequest
+	case errors.Is(err, state.ErrUnderfunded),
+		errors.Is(err, agent.ErrPaymentTooLarge),
+		errors.Is(err, agent.ErrChannelAccountNotReady),
+		errors.Is(err, state.ErrContributionMismatch),
+		errors.Is(err, agent.ErrMaxIterationsReached),
+		errors.Is(err, agent.ErrAppDataTooLarge):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Server) writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		fmt.Fprintf(s.logWriter, "agent control request failed: %v\n", err)
+		writeError(w, statusCode(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,153 @@
+package agentcontrol
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newServer(t *testing.T) (*agent.Agent, chan interface{}, *httptest.Server) {
+	t.Helper()
+	events := make(chan interface{})
+	a := agent.NewAgent(agent.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		ChannelAccountKey:    keypair.MustRandom().FromAddress(),
+		ChannelAccountSigner: keypair.MustRandom(),
+		LogWriter:            io.Discard,
+		Events:               events,
+	})
+	s := httptest.NewServer(New(Config{Agent: a, AgentEvents: events}))
+	t.Cleanup(s.Close)
+	return a, events, s
+}
+
+func post(t *testing.T, url, body string) (int, ErrorResponse) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	errResp := ErrorResponse{}
+	if resp.StatusCode != http.StatusNoContent {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&errResp))
+	}
+	return resp.StatusCode, errResp
+}
+
+func TestServer_actions(t *testing.T) {
+	a, _, s := newServer(t)
+
+	// Requests are validated.
+	status, errResp := post(t, s.URL+"/pay", `{"Amount":`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, errResp.Error, "decoding request")
+	status, errResp = post(t, s.URL+"/open", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "asset is required", errResp.Error)
+	resp, err := http.Get(s.URL + "/open")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	// Errors from the agent are returned.
+	status, errResp = post(t, s.URL+"/open", `{"Asset":"native"}`)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "not connected", errResp.Error)
+
+	// Typed errors from the agent are mapped to status codes.
+	require.NoError(t, a.Shutdown(context.Background()))
+	status, errResp = post(t, s.URL+"/pay", `{"Amount":10}`)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, "agent is shutting down", errResp.Error)
+}
+
+func TestServer_status(t *testing.T) {
+	a, _, s := newServer(t)
+
+	resp, err := http.Get(s.URL + "/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	status := Status{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, network.TestNetworkPassphrase, status.Config.NetworkPassphrase)
+	assert.Equal(t, a.Config().ChannelAccountKey.Address(), status.Config.ChannelAccountKey.Address())
+	assert.Equal(t, a.Config().ChannelAccountSigner.Address(), status.Config.ChannelAccountSigner.Address())
+}
+
+func TestServer_health(t *testing.T) {
+	_, _, s := newServer(t)
+
+	resp, err := http.Get(s.URL + "/health")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	health := Health{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&health))
+	assert.Equal(t, Health{Healthy: false, Reason: "not connected"}, health)
+}
+
+func TestServer_events(t *testing.T) {
+	_, events, s := newServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL+"/events", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events <- agent.BalanceChangedEvent{PreviousBalance: 1, Balance: 2}
+	events <- agent.ErrorEvent{Err: errors.New("an error")}
+
+	r := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		lines := []string{}
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "event: BalanceChangedEvent\ndata: {\"ChannelAccount\":null,\"PreviousBalance\":1,\"Balance\":2}\n", readEvent())
+	assert.Equal(t, "event: ErrorEvent\ndata: {\"Error\":\"an error\"}\n", readEvent())
+}
+
+func TestStatusCode(t *testing.T) {
+	testCases := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("proposing payment: %w", state.UnderfundedError{}), http.StatusUnprocessableEntity},
+		{fmt.Errorf("proposing payment: %w", agent.ErrPaymentTooLarge), http.StatusUnprocessableEntity},
+		{agent.ErrChannelAccountUnfunded, http.StatusUnprocessableEntity},
+		{fmt.Errorf("proposing payment: %w", agent.ErrMaxIterationsReached), http.StatusUnprocessableEntity},
+		{fmt.Errorf("proposing payment: %w", agent.ErrAppDataTooLarge), http.StatusUnprocessableEntity},
+		{agent.ErrChannelAccountNotFound, http.StatusNotFound},
+		{fmt.Errorf("proposing payment: %w", state.ErrInvalidAmount), http.StatusBadRequest},
+		{agent.ErrShuttingDown, http.StatusServiceUnavailable},
+		{fmt.Errorf("proposing payment: %w", agent.ErrPaused), http.StatusServiceUnavailable},
+		{errors.New("not connected"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, statusCode(tc.err), tc.err.Error())
+	}
+}
@@ -0,0 +1,148 @@
+// Package agentstore contains a file-backed implementation of agent.Store,
+// for persisting the snapshots of many agents' channels in a single
+// directory.
+package agentstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/starlight/sdk/agent"
+)
+
+var _ agent.Store = FileStore{}
+
+// fileExt is the extension of the files holding snapshots.
+const fileExt = ".json"
+
+// FileStore stores the snapshot of each channel as a JSON file in Dir, named
+// by the channel's agent.ChannelID, e.g. GA..._GB....json. Snapshots are
+// written to a temporary file and renamed, so that a snapshot file is never
+// partially written.
+//
+// A FileStore is safe for concurrent use by multiple agents, as long as no
+// two agents save snapshots of the same channel.
+type FileStore struct {
+	Dir string
+
+	// Encrypter, if not nil, encrypts snapshot files when they are saved and
+	// decrypts them when they are loaded. Snapshot files that are not
+	// encrypted cannot be loaded while it is set, and so existing snapshots
+	// are encrypted by loading them with a FileStore without an Encrypter
+	// and saving them with one.
+	Encrypter *Encrypter
+}
+
+// List returns the identifiers of all channels with snapshot files in Dir,
+// ordered by their file names. Files in Dir that are not snapshot files are
+// ignored.
+func (f FileStore) List() ([]agent.ChannelID, error) {
+	entries, err := os.ReadDir(f.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading dir: %w", err)
+	}
+	ids := []agent.ChannelID{}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		id, ok := parseFilename(e.Name())
+		if !ok {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].String() < ids[j].String()
+	})
+	return ids, nil
+}
+
+// Load returns the snapshot of the channel, or an error wrapping
+// agent.ErrChannelNotFound if it has no snapshot file.
+func (f FileStore) Load(id agent.ChannelID) (agent.Snapshot, error) {
+	b, err := os.ReadFile(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return agent.Snapshot{}, fmt.Errorf("loading %s: %w", id, agent.ErrChannelNotFound)
+	}
+	if err != nil {
+		return agent.Snapshot{}, fmt.Errorf("loading %s: %w", id, err)
+	}
+	if f.Encrypter != nil {
+		b, err = f.Encrypter.Decrypt(b)
+		if err != nil {
+			return agent.Snapshot{}, fmt.Errorf("decrypting %s: %w", id, err)
+		}
+	} else if IsEncrypted(b) {
+		return agent.Snapshot{}, fmt.Errorf("decoding %s: snapshot is encrypted", id)
+	}
+	s := agent.Snapshot{}
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return agent.Snapshot{}, fmt.Errorf("decoding %s: %w", id, err)
+	}
+	return s, nil
+}
+
+// Save writes the snapshot to the channel's snapshot file, replacing any
+// previous snapshot of the channel.
+func (f FileStore) Save(id agent.ChannelID, s agent.Snapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", id, err)
+	}
+	if f.Encrypter != nil {
+		b, err = f.Encrypter.Encrypt(b)
+		if err != nil {
+			return fmt.Errorf("encrypting %s: %w", id, err)
+		}
+	}
+	tmp, err := os.CreateTemp(f.Dir, id.String()+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("saving %s: %w", id, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving %s: %w", id, err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("saving %s: %w", id, err)
+	}
+	err = os.Rename(tmp.Name(), f.path(id))
+	if err != nil {
+		return fmt.Errorf("saving %s: %w", id, err)
+	}
+	return nil
+}
+
+func (f FileStore) path(id agent.ChannelID) string {
+	return filepath.Join(f.Dir, id.String()+fileExt)
+}
+
+// parseFilename returns the channel identified by the name of a snapshot
+// file, and false if the name is not of a snapshot file.
+func parseFilename(name string) (agent.ChannelID, bool) {
+	if !strings.HasSuffix(name, fileExt) {
+		return agent.ChannelID{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, fileExt), "_")
+	if len(parts) != 2 {
+		return agent.ChannelID{}, false
+	}
+	for _, p := range parts {
+		if _, err := keypair.ParseAddress(p); err != nil {
+			return agent.ChannelID{}, false
+		}
+	}
+	return agent.ChannelID{LocalChannelAccount: parts[0], RemoteChannelAccount: parts[1]}, true
+}
@@ -0,0 +1,158 @@
+package agentstore
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+
+	ids, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	id1 := agent.ChannelID{
+		LocalChannelAccount:  "GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36",
+		RemoteChannelAccount: "GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO",
+	}
+	id2 := agent.ChannelID{
+		LocalChannelAccount:  "GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36",
+		RemoteChannelAccount: "GDU5LGMB7QQPP5NABMPCI7JINHSEBJ576W7O5EFCTXUUZX63OJUFRNDI",
+	}
+
+	_, err = store.Load(id1)
+	assert.ErrorIs(t, err, agent.ErrChannelNotFound)
+
+	s1 := agent.Snapshot{StreamerCursor: "1"}
+	s2 := agent.Snapshot{StreamerCursor: "2"}
+	require.NoError(t, store.Save(id2, s2))
+	require.NoError(t, store.Save(id1, s1))
+
+	// Files that are not snapshots are ignored.
+	require.NoError(t, os.WriteFile(filepath.Join(store.Dir, "notes.json"), []byte("{}"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(store.Dir, "dir"), 0755))
+
+	ids, err = store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []agent.ChannelID{id1, id2}, ids)
+
+	loaded, err := store.Load(id1)
+	require.NoError(t, err)
+	assert.Equal(t, s1, loaded)
+
+	// Saving replaces the previous snapshot.
+	s1.StreamerCursor = "3"
+	require.NoError(t, store.Save(id1, s1))
+	loaded, err = store.Load(id1)
+	require.NoError(t, err)
+	assert.Equal(t, s1, loaded)
+	entries, err := os.ReadDir(store.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 4)
+}
+
+func TestFileStore_encrypted(t *testing.T) {
+	dir := t.TempDir()
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	store := FileStore{
+		Dir:       dir,
+		Encrypter: &Encrypter{KeyID: "1", Keys: map[string][]byte{"1": key1}},
+	}
+	id := agent.ChannelID{
+		LocalChannelAccount:  "GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36",
+		RemoteChannelAccount: "GBQNGSEHTFC4YGQ3EXHIL7JQBA6265LFANKFFAYKHM7JFGU5CORROEGO",
+	}
+	s := agent.Snapshot{StreamerCursor: "secret-cursor"}
+	require.NoError(t, store.Save(id, s))
+
+	// The snapshot file is encrypted.
+	b, err := os.ReadFile(filepath.Join(dir, id.String()+".json"))
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(b))
+	assert.NotContains(t, string(b), "secret-cursor")
+
+	loaded, err := store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, s, loaded)
+
+	// A store without the encrypter cannot load it.
+	_, err = FileStore{Dir: dir}.Load(id)
+	assert.EqualError(t, err, "decoding "+id.String()+": snapshot is encrypted")
+
+	// Rotating to a new key keeps the old key readable until the snapshot
+	// is saved again.
+	rotated := FileStore{
+		Dir:       dir,
+		Encrypter: &Encrypter{KeyID: "2", Keys: map[string][]byte{"1": key1, "2": key2}},
+	}
+	loaded, err = rotated.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, s, loaded)
+	require.NoError(t, rotated.Save(id, loaded))
+	_, err = store.Load(id)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	onlyNew := FileStore{
+		Dir:       dir,
+		Encrypter: &Encrypter{KeyID: "2", Keys: map[string][]byte{"2": key2}},
+	}
+	loaded, err = onlyNew.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, s, loaded)
+
+	// Snapshots that are not encrypted cannot be loaded with an encrypter,
+	// and are encrypted by loading and saving them again.
+	plain := FileStore{Dir: dir}
+	require.NoError(t, plain.Save(id, s))
+	_, err = store.Load(id)
+	assert.EqualError(t, err, "decrypting "+id.String()+": not encrypted")
+	loaded, err = plain.Load(id)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(id, loaded))
+	loaded, err = store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, s, loaded)
+}
+
+func TestStoreSnapshotter(t *testing.T) {
+	store := FileStore{Dir: t.TempDir()}
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+	a := agent.NewAgent(agent.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		ChannelAccountKey:    localChannelAccount,
+		ChannelAccountSigner: keypair.MustRandom(),
+		LogWriter:            io.Discard,
+	})
+	snapshotter := agent.StoreSnapshotter{Store: store}
+
+	// Snapshots before the other participant is known are not saved.
+	snapshotter.Snapshot(a, agent.Snapshot{})
+	ids, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	s := agent.Snapshot{OtherChannelAccount: remoteChannelAccount, StreamerCursor: "1"}
+	snapshotter.Snapshot(a, s)
+	id := agent.ChannelID{
+		LocalChannelAccount:  localChannelAccount.Address(),
+		RemoteChannelAccount: remoteChannelAccount.Address(),
+	}
+	ids, err = store.List()
+	require.NoError(t, err)
+	assert.Equal(t, []agent.ChannelID{id}, ids)
+	loaded, err := store.Load(id)
+	require.NoError(t, err)
+	assert.Equal(t, s.StreamerCursor, loaded.StreamerCursor)
+	assert.Equal(t, remoteChannelAccount.Address(), loaded.OtherChannelAccount.Address())
+}
@@ -0,0 +1,110 @@
+package agentstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownKey indicates that a snapshot file is encrypted with a key that
+// the Encrypter does not have.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// encryptedMagic begins every encrypted snapshot file, and identifies the
+// format of the header that follows it.
+var encryptedMagic = []byte("starlight-encrypted-snapshot-v1\n")
+
+// Encrypter encrypts snapshot files at rest with AES-256-GCM, an
+// authenticated encryption scheme, so that the files can be neither read nor
+// modified without the key.
+//
+// Each encrypted file starts with a header holding the ID of the key it is
+// encrypted with, which is authenticated with the file. Files are always
+// encrypted with the key identified by KeyID, and are decrypted with
+// whichever key in Keys their header identifies. To rotate keys, add the new
+// key to Keys, set KeyID to its ID, and save each snapshot again, after
+// which the old key can be removed.
+type Encrypter struct {
+	// KeyID is the ID of the key in Keys that files are encrypted with.
+	KeyID string
+	// Keys are the keys, each 32 bytes, that files can be decrypted with,
+	// by ID. IDs must be between 1 and 255 bytes.
+	Keys map[string][]byte
+}
+
+// Encrypt encrypts the plaintext with the key identified by KeyID, and
+// returns it prefixed with a header identifying the key.
+func (e Encrypter) Encrypt(plaintext []byte) ([]byte, error) {
+	if len(e.KeyID) == 0 || len(e.KeyID) > 255 {
+		return nil, fmt.Errorf("key id must be between 1 and 255 bytes, got %d bytes", len(e.KeyID))
+	}
+	aead, err := e.aead(e.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(encryptedMagic)+1+len(e.KeyID))
+	header = append(header, encryptedMagic...)
+	header = append(header, byte(len(e.KeyID)))
+	header = append(header, e.KeyID...)
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("generating nonce: %w", err)
+	}
+	b := append(header, nonce...)
+	return aead.Seal(b, nonce, plaintext, header), nil
+}
+
+// Decrypt decrypts ciphertext returned by Encrypt, with the key its header
+// identifies. It returns an error wrapping ErrUnknownKey if Keys does not
+// contain the key.
+func (e Encrypter) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !IsEncrypted(ciphertext) {
+		return nil, fmt.Errorf("not encrypted")
+	}
+	b := ciphertext[len(encryptedMagic):]
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, fmt.Errorf("header truncated")
+	}
+	keyID := string(b[1 : 1+int(b[0])])
+	headerLen := len(encryptedMagic) + 1 + len(keyID)
+	header := ciphertext[:headerLen]
+	aead, err := e.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	b = ciphertext[headerLen:]
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("nonce truncated")
+	}
+	nonce, sealed := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with key %q: %w", keyID, err)
+	}
+	return plaintext, nil
+}
+
+func (e Encrypter) aead(keyID string) (cipher.AEAD, error) {
+	key, ok := e.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key %q: %w", keyID, ErrUnknownKey)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key %q must be 32 bytes, got %d bytes", keyID, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", keyID, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsEncrypted returns true if the contents of a snapshot file were encrypted
+// by an Encrypter.
+func IsEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, encryptedMagic)
+}
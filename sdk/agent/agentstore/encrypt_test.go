@@ -0,0 +1,63 @@
+package agentstore
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypter(t *testing.T) {
+	key1 := bytes.Repeat([]byte{1}, 32)
+	key2 := bytes.Repeat([]byte{2}, 32)
+	e := Encrypter{KeyID: "1", Keys: map[string][]byte{"1": key1}}
+	plaintext := []byte(`{"StreamerCursor":"1"}`)
+
+	ciphertext, err := e.Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(ciphertext))
+	assert.NotContains(t, string(ciphertext), string(plaintext))
+	decrypted, err := e.Decrypt(ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	// Each encryption uses a new nonce.
+	ciphertext2, err := e.Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.NotEqual(t, ciphertext, ciphertext2)
+
+	// Modifying the ciphertext or the key id in the header is detected.
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = e.Decrypt(tampered)
+	assert.Error(t, err)
+	e2 := Encrypter{KeyID: "2", Keys: map[string][]byte{"1": key1, "2": key1}}
+	relabeled := bytes.Replace(ciphertext, []byte("\n\x011"), []byte("\n\x012"), 1)
+	_, err = e2.Decrypt(relabeled)
+	assert.Error(t, err)
+
+	// Decrypting with a different key fails.
+	_, err = Encrypter{Keys: map[string][]byte{"1": key2}}.Decrypt(ciphertext)
+	assert.Error(t, err)
+
+	// Decrypting without the key fails with ErrUnknownKey.
+	_, err = Encrypter{Keys: map[string][]byte{"2": key2}}.Decrypt(ciphertext)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Plaintext and truncated ciphertext cannot be decrypted.
+	_, err = e.Decrypt(plaintext)
+	assert.EqualError(t, err, "not encrypted")
+	_, err = e.Decrypt(ciphertext[:len(encryptedMagic)+1])
+	assert.EqualError(t, err, "header truncated")
+	_, err = e.Decrypt(ciphertext[:len(encryptedMagic)+2+4])
+	assert.EqualError(t, err, "nonce truncated")
+
+	// Keys and key ids are validated.
+	_, err = Encrypter{KeyID: "1", Keys: map[string][]byte{"1": key1[:16]}}.Encrypt(plaintext)
+	assert.EqualError(t, err, `key "1" must be 32 bytes, got 16 bytes`)
+	_, err = Encrypter{Keys: map[string][]byte{"": key1}}.Encrypt(plaintext)
+	assert.EqualError(t, err, "key id must be between 1 and 255 bytes, got 0 bytes")
+	_, err = Encrypter{KeyID: "3", Keys: map[string][]byte{"1": key1}}.Encrypt(plaintext)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
@@ -0,0 +1,54 @@
+package agenttest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAgent_ConfigAndSnapshot_concurrent checks that the agent's config and
+// snapshot can be read while the agent is opening, paying, and closing a
+// channel. It is most useful when run with the race detector.
+func TestAgent_ConfigAndSnapshot_concurrent(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, p := range []*participant{initiator, responder} {
+		p := p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				c := p.Agent.Config()
+				assert.Equal(t, p.Account.Address(), c.ChannelAccountKey.Address())
+				_ = p.Agent.Snapshot()
+			}
+		}()
+	}
+
+	connect(t, initiator, responder)
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	for i := 0; i < 10; i++ {
+		require.NoError(t, initiator.Agent.Payment(1_0000000))
+		<-initiator.Payments
+	}
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,532 @@
+// Package agenttest contains an in-memory stand-in for the Stellar network that
+// implements the interfaces the sdk/agent uses to interact with the network,
+// so that agents can open, make payments on, and close channels in-process for
+// testing and benchmarking.
+package agenttest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+)
+
+var (
+	_ agent.Submitter               = &Ledger{}
+	_ agent.Streamer                = &Ledger{}
+	_ agent.SequenceNumberCollector = &Ledger{}
+	_ agent.BalanceCollector        = &Ledger{}
+	_ agent.MultiBalanceCollector   = &Ledger{}
+)
+
+// Ledger is an in-memory ledger that applies submitted transactions to the
+// accounts and trustlines it holds, and streams every successfully applied
+// transaction to its streamers along with result meta describing the ledger
+// entries the transaction changed.
+//
+// Ledger supports the operations used to create channel accounts, to open and
+// close channels, and to merge channel accounts. It does not verify
+// signatures, and does not enforce time bounds or the minimum sequence age and
+// ledger gap of transactions, so that closes can be submitted without waiting
+// for the observation period. Each transaction is applied in its own ledger.
+type Ledger struct {
+	mu         sync.Mutex
+	txAdded    *sync.Cond
+	accounts   map[string]xdr.AccountEntry
+	trustlines map[string]xdr.TrustLineEntry
+	submitted  []*txnbuild.Transaction
+	streamed   []agent.StreamedTransaction
+}
+
+// NewLedger creates an empty ledger.
+func NewLedger() *Ledger {
+	l := &Ledger{
+		accounts:   map[string]xdr.AccountEntry{},
+		trustlines: map[string]xdr.TrustLineEntry{},
+	}
+	l.txAdded = sync.NewCond(&l.mu)
+	return l
+}
+
+// CreateAccount adds an account to the ledger with the native balance. The
+// account can be used to create and fund other accounts by submitting
+// transactions.
+func (l *Ledger) CreateAccount(account *keypair.FromAddress, balance int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.accounts[account.Address()] = xdr.AccountEntry{
+		AccountId:  xdr.MustAddress(account.Address()),
+		Balance:    xdr.Int64(balance),
+		SeqNum:     xdr.SequenceNumber(l.currentLedger() << 32),
+		Thresholds: xdr.Thresholds{1, 0, 0, 0},
+	}
+}
+
+// SetTrustLineBalance sets the balance of the account's trustline for the
+// credit asset, creating the trustline if it does not exist.
+func (l *Ledger) SetTrustLineBalance(account *keypair.FromAddress, asset state.Asset, balance int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := trustLineKey(account.Address(), asset)
+	tl, ok := l.trustlines[key]
+	if !ok {
+		tl = newTrustLine(account.Address(), asset)
+	}
+	tl.Balance = xdr.Int64(balance)
+	l.trustlines[key] = tl
+}
+
+// Transactions returns the transactions that have been successfully submitted
+// to the ledger, in the order they were applied.
+func (l *Ledger) Transactions() []*txnbuild.Transaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*txnbuild.Transaction(nil), l.submitted...)
+}
+
+// GetSequenceNumber returns the sequence number of the account.
+func (l *Ledger) GetSequenceNumber(account *keypair.FromAddress) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.accounts[account.Address()]
+	if !ok {
+		return 0, fmt.Errorf("account %s: %w", account.Address(), agent.ErrAccountNotFound)
+	}
+	return int64(a.SeqNum), nil
+}
+
+// GetBalance returns the balance of the asset held by the account, or zero if
+// the account does not have a trustline for the asset.
+func (l *Ledger) GetBalance(account *keypair.FromAddress, asset state.Asset) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.accounts[account.Address()]
+	if !ok {
+		return 0, fmt.Errorf("account %s: %w", account.Address(), agent.ErrAccountNotFound)
+	}
+	if asset.IsNative() {
+		return int64(a.Balance), nil
+	}
+	tl, ok := l.trustlines[trustLineKey(account.Address(), asset)]
+	if !ok {
+		return 0, nil
+	}
+	return int64(tl.Balance), nil
+}
+
+// GetBalances returns the balances of the assets held by the account, with a
+// zero balance for credit assets the account does not have a trustline for.
+func (l *Ledger) GetBalances(account *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	a, ok := l.accounts[account.Address()]
+	if !ok {
+		return nil, fmt.Errorf("account %s: %w", account.Address(), agent.ErrAccountNotFound)
+	}
+	balances := make(map[state.Asset]int64, len(assets))
+	for _, asset := range assets {
+		if asset.IsNative() {
+			balances[asset] = int64(a.Balance)
+			continue
+		}
+		balances[asset] = int64(l.trustlines[trustLineKey(account.Address(), asset)].Balance)
+	}
+	return balances, nil
+}
+
+// SubmitTx applies the transaction to the ledger and streams it to all
+// streamers. If the transaction cannot be applied an error is returned, the
+// ledger is unchanged, and the transaction is not streamed.
+func (l *Ledger) SubmitTx(tx *txnbuild.Transaction) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	changes, err := l.apply(tx)
+	if err != nil {
+		return err
+	}
+
+	txXDR, err := tx.Base64()
+	if err != nil {
+		return fmt.Errorf("encoding tx: %w", err)
+	}
+	resultXDR, err := txbuildtest.BuildResultXDR(true)
+	if err != nil {
+		return fmt.Errorf("encoding tx result: %w", err)
+	}
+	meta := xdr.TransactionMeta{
+		V:  2,
+		V2: &xdr.TransactionMetaV2{Operations: []xdr.OperationMeta{{Changes: changes}}},
+	}
+	resultMetaXDR, err := xdr.MarshalBase64(meta)
+	if err != nil {
+		return fmt.Errorf("encoding tx result meta: %w", err)
+	}
+
+	ledger := l.currentLedger() + 1
+	l.submitted = append(l.submitted, tx)
+	l.streamed = append(l.streamed, agent.StreamedTransaction{
+		Cursor:             strconv.Itoa(len(l.streamed) + 1),
+		TransactionOrderID: ledger << 32,
+		Ledger:             ledger,
+		LedgerCloseTime:    time.Now(),
+		TransactionXDR:     txXDR,
+		ResultXDR:          resultXDR,
+		ResultMetaXDR:      resultMetaXDR,
+	})
+	l.txAdded.Broadcast()
+	return nil
+}
+
+// StreamTx streams transactions submitted to the ledger after the cursor. An
+// empty cursor streams only transactions submitted after the stream starts.
+// All transactions are streamed regardless of the accounts given. The stream
+// can be stopped by calling the cancel function returned.
+func (l *Ledger) StreamTx(cursor string, accounts ...*keypair.FromAddress) (txs <-chan agent.StreamedTransaction, cancel func()) {
+	l.mu.Lock()
+	next := len(l.streamed)
+	l.mu.Unlock()
+	if cursor != "" {
+		// Cursors are always produced by the ledger, so an invalid cursor is
+		// treated as the start of the ledger.
+		next, _ = strconv.Atoi(cursor)
+	}
+
+	txsCh := make(chan agent.StreamedTransaction)
+	cancelCh := make(chan struct{})
+	go func() {
+		defer close(txsCh)
+		for {
+			tx, ok := l.waitForTx(next, cancelCh)
+			if !ok {
+				return
+			}
+			select {
+			case txsCh <- tx:
+				next++
+			case <-cancelCh:
+				return
+			}
+		}
+	}()
+
+	cancelOnce := sync.Once{}
+	cancel = func() {
+		cancelOnce.Do(func() {
+			l.mu.Lock()
+			close(cancelCh)
+			l.txAdded.Broadcast()
+			l.mu.Unlock()
+		})
+	}
+	return txsCh, cancel
+}
+
+// waitForTx waits until the i-th streamed transaction exists and returns it,
+// or returns false if canceled before then.
+func (l *Ledger) waitForTx(i int, cancel <-chan struct{}) (agent.StreamedTransaction, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i >= len(l.streamed) {
+		select {
+		case <-cancel:
+			return agent.StreamedTransaction{}, false
+		default:
+		}
+		l.txAdded.Wait()
+	}
+	return l.streamed[i], true
+}
+
+// currentLedger returns the sequence number of the last ledger closed. Each
+// transaction is applied in its own ledger, following an initial ledger.
+func (l *Ledger) currentLedger() int64 {
+	return int64(len(l.streamed)) + 1
+}
+
+// apply applies the transaction to the ledger, returning the ledger entry
+// changes if successful. If an error is returned the ledger is unchanged.
+func (l *Ledger) apply(tx *txnbuild.Transaction) ([]xdr.LedgerEntryChange, error) {
+	a := applier{
+		ledger:     l,
+		accounts:   map[string]xdr.AccountEntry{},
+		trustlines: map[string]xdr.TrustLineEntry{},
+		merged:     map[string]bool{},
+	}
+
+	source := tx.SourceAccount().AccountID
+	sourceAccount, err := a.account(source)
+	if err != nil {
+		return nil, fmt.Errorf("loading source account: %w", err)
+	}
+	seq := tx.SequenceNumber()
+	if int64(sourceAccount.SeqNum) >= seq {
+		return nil, fmt.Errorf("bad sequence number: account %s has sequence %d, tx has %d", source, sourceAccount.SeqNum, seq)
+	}
+	// Transactions must follow the account's sequence number, unless they
+	// have a minimum sequence number precondition, in which case they can be
+	// applied to any sequence number between the minimum and the tx.
+	minSeq := seq - 1
+	if cond := tx.ToXDR().MustV1().Tx.Cond; cond.General != nil && cond.General.MinSeqNum != nil {
+		minSeq = int64(*cond.General.MinSeqNum)
+	}
+	if int64(sourceAccount.SeqNum) < minSeq {
+		return nil, fmt.Errorf("bad sequence number: account %s has sequence %d, tx has %d", source, sourceAccount.SeqNum, seq)
+	}
+	sourceAccount.SeqNum = xdr.SequenceNumber(seq)
+	a.accounts[source] = sourceAccount
+
+	for i, op := range tx.Operations() {
+		opSource := op.GetSourceAccount()
+		if opSource == "" {
+			opSource = source
+		}
+		err = a.applyOp(opSource, op)
+		if err != nil {
+			return nil, fmt.Errorf("applying operation %d: %w", i, err)
+		}
+	}
+
+	changes := []xdr.LedgerEntryChange{}
+	for id, account := range a.accounts {
+		account := account
+		l.accounts[id] = account
+		changes = append(changes, xdr.LedgerEntryChange{
+			Type:    xdr.LedgerEntryChangeTypeLedgerEntryUpdated,
+			Updated: &xdr.LedgerEntry{Data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeAccount, Account: &account}},
+		})
+	}
+	for id := range a.merged {
+		delete(l.accounts, id)
+		changes = append(changes, xdr.LedgerEntryChange{
+			Type:    xdr.LedgerEntryChangeTypeLedgerEntryRemoved,
+			Removed: &xdr.LedgerKey{Type: xdr.LedgerEntryTypeAccount, Account: &xdr.LedgerKeyAccount{AccountId: xdr.MustAddress(id)}},
+		})
+	}
+	for key, tl := range a.trustlines {
+		tl := tl
+		l.trustlines[key] = tl
+		changes = append(changes, xdr.LedgerEntryChange{
+			Type:    xdr.LedgerEntryChangeTypeLedgerEntryUpdated,
+			Updated: &xdr.LedgerEntry{Data: xdr.LedgerEntryData{Type: xdr.LedgerEntryTypeTrustline, TrustLine: &tl}},
+		})
+	}
+	return changes, nil
+}
+
+// applier holds the ledger entries changed by a transaction until the whole
+// transaction has been applied.
+type applier struct {
+	ledger     *Ledger
+	accounts   map[string]xdr.AccountEntry
+	trustlines map[string]xdr.TrustLineEntry
+	merged     map[string]bool
+}
+
+func (a *applier) account(id string) (xdr.AccountEntry, error) {
+	if a.merged[id] {
+		return xdr.AccountEntry{}, fmt.Errorf("account %s not found", id)
+	}
+	if account, ok := a.accounts[id]; ok {
+		return account, nil
+	}
+	account, ok := a.ledger.accounts[id]
+	if !ok {
+		return xdr.AccountEntry{}, fmt.Errorf("account %s not found", id)
+	}
+	account.Signers = append([]xdr.Signer(nil), account.Signers...)
+	return account, nil
+}
+
+func (a *applier) trustline(id string, asset state.Asset) (xdr.TrustLineEntry, bool) {
+	key := trustLineKey(id, asset)
+	if tl, ok := a.trustlines[key]; ok {
+		return tl, true
+	}
+	tl, ok := a.ledger.trustlines[key]
+	return tl, ok
+}
+
+func (a *applier) applyOp(source string, op txnbuild.Operation) error {
+	switch o := op.(type) {
+	case *txnbuild.BeginSponsoringFutureReserves, *txnbuild.EndSponsoringFutureReserves:
+		// Reserves are not tracked by the ledger.
+		return nil
+	case *txnbuild.CreateAccount:
+		return a.applyCreateAccount(source, o)
+	case *txnbuild.SetOptions:
+		return a.applySetOptions(source, o)
+	case *txnbuild.ChangeTrust:
+		return a.applyChangeTrust(source, o)
+	case *txnbuild.Payment:
+		return a.applyPayment(source, o)
+	case *txnbuild.AccountMerge:
+		return a.applyAccountMerge(source, o)
+	case *txnbuild.BumpSequence:
+		account, err := a.account(source)
+		if err != nil {
+			return err
+		}
+		if o.BumpTo > int64(account.SeqNum) {
+			account.SeqNum = xdr.SequenceNumber(o.BumpTo)
+		}
+		a.accounts[source] = account
+		return nil
+	}
+	return fmt.Errorf("unsupported operation %T", op)
+}
+
+func (a *applier) applyCreateAccount(source string, o *txnbuild.CreateAccount) error {
+	if _, err := a.account(o.Destination); err == nil {
+		return fmt.Errorf("account %s already exists", o.Destination)
+	}
+	amt, err := amount.ParseInt64(o.Amount)
+	if err != nil {
+		return fmt.Errorf("parsing amount: %w", err)
+	}
+	sourceAccount, err := a.account(source)
+	if err != nil {
+		return err
+	}
+	if int64(sourceAccount.Balance) < amt {
+		return fmt.Errorf("account %s underfunded", source)
+	}
+	sourceAccount.Balance -= xdr.Int64(amt)
+	a.accounts[source] = sourceAccount
+	a.accounts[o.Destination] = xdr.AccountEntry{
+		AccountId:  xdr.MustAddress(o.Destination),
+		Balance:    xdr.Int64(amt),
+		SeqNum:     xdr.SequenceNumber((a.ledger.currentLedger() + 1) << 32),
+		Thresholds: xdr.Thresholds{1, 0, 0, 0},
+	}
+	return nil
+}
+
+func (a *applier) applySetOptions(source string, o *txnbuild.SetOptions) error {
+	account, err := a.account(source)
+	if err != nil {
+		return err
+	}
+	if o.MasterWeight != nil {
+		account.Thresholds[0] = byte(*o.MasterWeight)
+	}
+	if o.LowThreshold != nil {
+		account.Thresholds[1] = byte(*o.LowThreshold)
+	}
+	if o.MediumThreshold != nil {
+		account.Thresholds[2] = byte(*o.MediumThreshold)
+	}
+	if o.HighThreshold != nil {
+		account.Thresholds[3] = byte(*o.HighThreshold)
+	}
+	if o.Signer != nil {
+		signers := []xdr.Signer{}
+		for _, s := range account.Signers {
+			if s.Key.Address() != o.Signer.Address {
+				signers = append(signers, s)
+			}
+		}
+		if o.Signer.Weight != 0 {
+			signers = append(signers, xdr.Signer{
+				Key:    xdr.MustSigner(o.Signer.Address),
+				Weight: xdr.Uint32(o.Signer.Weight),
+			})
+		}
+		account.Signers = signers
+	}
+	a.accounts[source] = account
+	return nil
+}
+
+func (a *applier) applyChangeTrust(source string, o *txnbuild.ChangeTrust) error {
+	if _, err := a.account(source); err != nil {
+		return err
+	}
+	asset := state.Asset(o.Line.GetCode() + ":" + o.Line.GetIssuer())
+	if _, ok := a.trustline(source, asset); ok {
+		return nil
+	}
+	a.trustlines[trustLineKey(source, asset)] = newTrustLine(source, asset)
+	return nil
+}
+
+func (a *applier) applyPayment(source string, o *txnbuild.Payment) error {
+	amt, err := amount.ParseInt64(o.Amount)
+	if err != nil {
+		return fmt.Errorf("parsing amount: %w", err)
+	}
+	if o.Asset.IsNative() {
+		from, err := a.account(source)
+		if err != nil {
+			return err
+		}
+		if int64(from.Balance) < amt {
+			return fmt.Errorf("account %s underfunded", source)
+		}
+		from.Balance -= xdr.Int64(amt)
+		a.accounts[source] = from
+		to, err := a.account(o.Destination)
+		if err != nil {
+			return err
+		}
+		to.Balance += xdr.Int64(amt)
+		a.accounts[o.Destination] = to
+		return nil
+	}
+
+	asset := state.Asset(o.Asset.GetCode() + ":" + o.Asset.GetIssuer())
+	from, ok := a.trustline(source, asset)
+	if !ok {
+		return fmt.Errorf("trustline for account %s not found", source)
+	}
+	if int64(from.Balance) < amt {
+		return fmt.Errorf("account %s underfunded", source)
+	}
+	from.Balance -= xdr.Int64(amt)
+	a.trustlines[trustLineKey(source, asset)] = from
+	to, ok := a.trustline(o.Destination, asset)
+	if !ok {
+		return fmt.Errorf("trustline for account %s not found", o.Destination)
+	}
+	to.Balance += xdr.Int64(amt)
+	a.trustlines[trustLineKey(o.Destination, asset)] = to
+	return nil
+}
+
+func (a *applier) applyAccountMerge(source string, o *txnbuild.AccountMerge) error {
+	from, err := a.account(source)
+	if err != nil {
+		return err
+	}
+	to, err := a.account(o.Destination)
+	if err != nil {
+		return err
+	}
+	to.Balance += from.Balance
+	a.accounts[o.Destination] = to
+	delete(a.accounts, source)
+	a.merged[source] = true
+	return nil
+}
+
+func trustLineKey(id string, asset state.Asset) string {
+	return id + "/" + asset.StringCanonical()
+}
+
+func newTrustLine(id string, asset state.Asset) xdr.TrustLineEntry {
+	return xdr.TrustLineEntry{
+		AccountId: xdr.MustAddress(id),
+		Asset:     xdr.MustNewCreditAsset(asset.Code(), asset.Issuer()).ToTrustLineAsset(),
+		Limit:     xdr.Int64(math.MaxInt64),
+		Flags:     xdr.Uint32(xdr.TrustLineFlagsAuthorizedFlag),
+	}
+}
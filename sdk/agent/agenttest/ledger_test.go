@@ -0,0 +1,1104 @@
+package agenttest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/agent/msg"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// participant is an agent connected to the ledger along with channels that
+// receive the agent's events by type.
+type participant struct {
+	Agent        *agent.Agent
+	Signer       *keypair.Full
+	Account      *keypair.Full
+	Connected    chan struct{}
+	Disconnected chan struct{}
+	Opened       chan struct{}
+	Payments     chan struct{}
+	Closed       chan struct{}
+
+	BalanceChanged   chan agent.BalanceChangedEvent
+	PaymentRequested chan agent.PaymentRequestedEvent
+	PaymentRejected  chan agent.PaymentRejectedEvent
+	PaymentTimeout   chan agent.PaymentTimeoutEvent
+	SignerRotated    chan agent.SignerRotatedEvent
+	RotationRejected chan agent.SignerRotationRejectedEvent
+	Errors           chan error
+}
+
+// newParticipant creates a participant with a funded channel account. Options
+// can modify the agent's config before the agent is constructed.
+func newParticipant(t testing.TB, l *Ledger, balance int64, options ...func(*agent.Config)) *participant {
+	t.Helper()
+
+	signer := keypair.MustRandom()
+	channelAccount := keypair.MustRandom()
+	l.CreateAccount(signer.FromAddress(), 2*balance)
+
+	// Create the channel account and fund it.
+	seqNum, err := l.GetSequenceNumber(signer.FromAddress())
+	require.NoError(t, err)
+	tx, err := txbuild.CreateChannelAccount(txbuild.CreateChannelAccountParams{
+		Creator:        signer.FromAddress(),
+		ChannelAccount: channelAccount.FromAddress(),
+		SequenceNumber: seqNum + 1,
+		Asset:          txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.SubmitTx(tx))
+	tx, err = txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount: &txnbuild.SimpleAccount{AccountID: signer.Address(), Sequence: seqNum + 2},
+		BaseFee:       txnbuild.MinBaseFee,
+		Timebounds:    txnbuild.NewInfiniteTimeout(),
+		Operations: []txnbuild.Operation{
+			&txnbuild.Payment{Destination: channelAccount.Address(), Asset: txnbuild.NativeAsset{}, Amount: amount.StringFromInt64(balance)},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.SubmitTx(tx))
+
+	events := make(chan interface{})
+	p := &participant{
+		Signer:       signer,
+		Account:      channelAccount,
+		Connected:    make(chan struct{}, 1),
+		Disconnected: make(chan struct{}, 1),
+		Opened:       make(chan struct{}, 1),
+		Payments:     make(chan struct{}, 1),
+		Closed:       make(chan struct{}, 1),
+
+		BalanceChanged:   make(chan agent.BalanceChangedEvent, 10),
+		PaymentRequested: make(chan agent.PaymentRequestedEvent, 10),
+		PaymentRejected:  make(chan agent.PaymentRejectedEvent, 10),
+		PaymentTimeout:   make(chan agent.PaymentTimeoutEvent, 10),
+		SignerRotated:    make(chan agent.SignerRotatedEvent, 10),
+		RotationRejected: make(chan agent.SignerRotationRejectedEvent, 10),
+		Errors:           make(chan error, 10),
+	}
+	config := agent.Config{
+		ObservationPeriodTime:      10 * time.Second,
+		ObservationPeriodLedgerGap: 1,
+		MaxOpenExpiry:              5 * time.Minute,
+		NetworkPassphrase:          network.TestNetworkPassphrase,
+
+		SequenceNumberCollector: l,
+		BalanceCollector:        l,
+		Submitter:               l,
+		Streamer:                l,
+
+		ChannelAccountKey:    channelAccount.FromAddress(),
+		ChannelAccountSigner: signer,
+
+		LogWriter: io.Discard,
+
+		Events: events,
+	}
+	for _, o := range options {
+		o(&config)
+	}
+	p.Agent = agent.NewAgent(config)
+	go func() {
+		for e := range events {
+			switch e := e.(type) {
+			case agent.ConnectedEvent:
+				p.Connected <- struct{}{}
+			case agent.DisconnectedEvent:
+				// Disconnects are dropped once the buffer is full because
+				// most tests do not read them.
+				select {
+				case p.Disconnected <- struct{}{}:
+				default:
+				}
+			case agent.OpenedEvent:
+				p.Opened <- struct{}{}
+			case agent.PaymentSentEvent:
+				p.Payments <- struct{}{}
+			case agent.ClosedEvent:
+				p.Closed <- struct{}{}
+			case agent.BalanceChangedEvent:
+				p.BalanceChanged <- e
+			case agent.PaymentRequestedEvent:
+				// Payment requests are dropped once the buffer is full
+				// because most tests do not read them.
+				select {
+				case p.PaymentRequested <- e:
+				default:
+				}
+			case agent.PaymentRejectedEvent:
+				p.PaymentRejected <- e
+			case agent.PaymentTimeoutEvent:
+				p.PaymentTimeout <- e
+			case agent.SignerRotatedEvent:
+				p.SignerRotated <- e
+			case agent.SignerRotationRejectedEvent:
+				p.RotationRejected <- e
+			case agent.ErrorEvent:
+				// Errors are dropped once the buffer is full because most
+				// tests do not read them.
+				select {
+				case p.Errors <- e.Err:
+				default:
+				}
+			}
+			// Other events, including errors such as from both participants
+			// submitting the same close, are ignored.
+		}
+	}()
+	return p
+}
+
+// connect connects the two participants over a TCP connection on the local
+// loopback interface.
+func connect(t testing.TB, a, b *participant) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Agent.ServeTCP(addr)
+	}()
+	for i := 0; ; i++ {
+		err = b.Agent.ConnectTCP(addr)
+		if err == nil || i == 100 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	require.NoError(t, <-served)
+
+	// Wait for the hellos to be exchanged.
+	<-a.Connected
+	<-b.Connected
+}
+
+func TestLedger_openPaymentClose(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	_, err := initiator.Agent.OpenParams()
+	require.ErrorIs(t, err, agent.ErrNoChannel)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// Both participants confirmed the same open parameters.
+	params, err := initiator.Agent.OpenParams()
+	require.NoError(t, err)
+	assert.Equal(t, state.NativeAsset, params.Asset)
+	responderParams, err := responder.Agent.OpenParams()
+	require.NoError(t, err)
+	assert.True(t, params.ExpiresAt.Equal(responderParams.ExpiresAt))
+	params.ExpiresAt, responderParams.ExpiresAt = time.Time{}, time.Time{}
+	assert.Equal(t, params, responderParams)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, initiator.Agent.Payment(1_0000000))
+		<-initiator.Payments
+	}
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	// The close transaction paid the responder.
+	balance, err := l.GetBalance(responder.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(110_0000000), balance)
+	balance, err = l.GetBalance(initiator.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(90_0000000), balance)
+}
+
+func TestLedger_openByResponder(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	// The responder proposes the open, and the other participant remains the
+	// initiator of the channel.
+	require.NoError(t, responder.Agent.OpenAsResponder(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	assert.True(t, initiator.Agent.Snapshot().State.Initiator)
+	assert.False(t, responder.Agent.Snapshot().State.Initiator)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, responder.Agent.Payment(1_0000000))
+		<-responder.Payments
+	}
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	balance, err := l.GetBalance(initiator.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(103_0000000), balance)
+	balance, err = l.GetBalance(responder.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(97_0000000), balance)
+}
+
+func TestLedger_deposit(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// The responder deposits into their channel account.
+	seqNum, err := l.GetSequenceNumber(responder.Signer.FromAddress())
+	require.NoError(t, err)
+	tx, err := txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount: &txnbuild.SimpleAccount{AccountID: responder.Signer.Address(), Sequence: seqNum + 1},
+		BaseFee:       txnbuild.MinBaseFee,
+		Timebounds:    txnbuild.NewInfiniteTimeout(),
+		Operations: []txnbuild.Operation{
+			&txnbuild.Payment{Destination: responder.Account.Address(), Asset: txnbuild.NativeAsset{}, Amount: "50"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.SubmitTx(tx))
+
+	// Both participants see the deposit and update their view of the
+	// responder's channel account balance. Balance changes are also seen
+	// when the balances are first ingested with the open, which are skipped.
+	for _, p := range []*participant{initiator, responder} {
+		for e := range p.BalanceChanged {
+			if e.ChannelAccount.Equal(responder.Account.FromAddress()) && e.PreviousBalance == 100_0000000 {
+				assert.Equal(t, int64(150_0000000), e.Balance)
+				break
+			}
+		}
+	}
+}
+
+func TestLedger_rotateSigner(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, initiator.Agent.Payment(1_0000000))
+		<-initiator.Payments
+	}
+
+	// The initiator rotates its signer, and both participants see the
+	// rotation with the agreement signed for the new signer.
+	oldSigner := initiator.Signer
+	newSigner := keypair.MustRandom()
+	require.NoError(t, initiator.Agent.RotateSigner(newSigner))
+	for _, p := range []*participant{initiator, responder} {
+		e := <-p.SignerRotated
+		assert.Equal(t, oldSigner.Address(), e.OldSigner.Address())
+		assert.Equal(t, newSigner.Address(), e.NewSigner.Address())
+		assert.Equal(t, int64(4), e.CloseAgreement.Envelope.Details.IterationNumber)
+		assert.Equal(t, int64(2_0000000), e.CloseAgreement.Envelope.Details.Balance)
+	}
+	assert.Equal(t, int64(4), initiator.Agent.IterationNumber())
+	assert.Equal(t, int64(4), responder.Agent.IterationNumber())
+
+	// Both channel accounts have the new signer in place of the old.
+	l.mu.Lock()
+	for _, account := range []*keypair.Full{initiator.Account, responder.Account} {
+		signers := []string{}
+		for _, s := range l.accounts[account.Address()].Signers {
+			signers = append(signers, s.Key.Address())
+		}
+		assert.ElementsMatch(t, []string{newSigner.Address(), responder.Signer.Address()}, signers)
+	}
+	l.mu.Unlock()
+
+	// Payments continue to be made with the new signer.
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+	require.NoError(t, responder.Agent.Payment(2_0000000))
+	<-responder.Payments
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	balance, err := l.GetBalance(initiator.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(99_0000000), balance)
+	balance, err = l.GetBalance(responder.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(101_0000000), balance)
+}
+
+func TestLedger_rotateSignerRejected(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// The responder rejects the rotation while paused, and the rotation is
+	// discarded without the signers changing.
+	responder.Agent.Pause()
+	require.NoError(t, initiator.Agent.RotateSigner(keypair.MustRandom()))
+	e := <-initiator.RotationRejected
+	assert.Equal(t, msg.RejectCodeTryLater, e.Code)
+	assert.False(t, initiator.Agent.HasPendingAgreement())
+	assert.Equal(t, int64(1), initiator.Agent.IterationNumber())
+
+	// Payments continue to be made with the old signer.
+	responder.Agent.Resume()
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+	assert.Equal(t, int64(2), responder.Agent.IterationNumber())
+}
+
+func TestLedger_SubmitTx_badSequence(t *testing.T) {
+	l := NewLedger()
+	account := keypair.MustRandom()
+	l.CreateAccount(account.FromAddress(), 100)
+	seqNum, err := l.GetSequenceNumber(account.FromAddress())
+	require.NoError(t, err)
+
+	tx, err := txnbuild.NewTransaction(txnbuild.TransactionParams{
+		SourceAccount: &txnbuild.SimpleAccount{AccountID: account.Address(), Sequence: seqNum + 2},
+		BaseFee:       txnbuild.MinBaseFee,
+		Timebounds:    txnbuild.NewInfiniteTimeout(),
+		Operations:    []txnbuild.Operation{&txnbuild.BumpSequence{}},
+	})
+	require.NoError(t, err)
+	err = l.SubmitTx(tx)
+	assert.EqualError(t, err, fmt.Sprintf("bad sequence number: account %s has sequence %d, tx has %d", account.Address(), seqNum, seqNum+2))
+	assert.Empty(t, l.Transactions())
+}
+
+func BenchmarkLedger_payments(b *testing.B) {
+	l := NewLedger()
+	initiator := newParticipant(b, l, 1_000_000_0000000)
+	responder := newParticipant(b, l, 1_000_000_0000000)
+	connect(b, initiator, responder)
+
+	require.NoError(b, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := initiator.Agent.Payment(1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		<-initiator.Payments
+	}
+}
+
+func TestLedger_paymentApprover(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	approved := []int64{}
+	responder := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.PaymentApprover = func(amount int64, memo []byte) error {
+			if string(memo) == "blocked" {
+				return fmt.Errorf("memo not allowed")
+			}
+			approved = append(approved, amount)
+			return nil
+		}
+	})
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+
+	// The rejected payment is discarded and the sender learns why.
+	require.NoError(t, initiator.Agent.PaymentWithMemo(5_0000000, []byte("blocked")))
+	rejected := <-initiator.PaymentRejected
+	assert.Equal(t, msg.RejectCodeNotApproved, rejected.Code)
+	assert.Equal(t, "payment not approved: memo not allowed", rejected.Reason)
+	assert.Equal(t, int64(5_0000000), rejected.CloseAgreement.Envelope.Details.PaymentAmount)
+	assert.False(t, initiator.Agent.HasPendingAgreement())
+
+	// Payments can continue after a rejection, skipping the rejected
+	// iteration.
+	require.NoError(t, initiator.Agent.Payment(2_0000000))
+	<-initiator.Payments
+	assert.Equal(t, int64(4), initiator.Agent.IterationNumber())
+	assert.Equal(t, int64(4), responder.Agent.IterationNumber())
+	assert.Equal(t, []int64{1_0000000, 2_0000000}, approved)
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	balance, err := l.GetBalance(responder.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(103_0000000), balance)
+}
+
+func TestLedger_paymentRequested(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	memos := [][]byte{}
+	responder := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.PaymentApprover = func(amount int64, memo []byte) error {
+			memos = append(memos, memo)
+			return nil
+		}
+	})
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// The memo of a payment is available before it is confirmed, and any
+	// bytes are delivered as they were sent.
+	memo := []byte{0x00, 0xff, 0xfe, '\n', 0x80}
+	require.NoError(t, initiator.Agent.PaymentWithMemo(3_0000000, memo))
+	<-initiator.Payments
+	requested := <-responder.PaymentRequested
+	assert.Equal(t, agent.PaymentRequestedEvent{
+		IterationNumber: 2,
+		Amount:          3_0000000,
+		MemoType:        state.MemoTypeNone,
+		Memo:            memo,
+	}, requested)
+	assert.Equal(t, [][]byte{memo}, memos)
+}
+
+func TestLedger_paymentTooLargeRejected(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.MaxPaymentAmount = 10_0000000
+	})
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// The sender fails promptly rather than waiting for a response that will
+	// never come.
+	require.NoError(t, initiator.Agent.Payment(20_0000000))
+	rejected := <-initiator.PaymentRejected
+	assert.Equal(t, msg.RejectCodeTooLarge, rejected.Code)
+	assert.Contains(t, rejected.Reason, "payment amount exceeds maximum payment amount")
+	assert.False(t, initiator.Agent.HasPendingAgreement())
+
+	require.NoError(t, initiator.Agent.Payment(10_0000000))
+	<-initiator.Payments
+	assert.Equal(t, int64(3), responder.Agent.IterationNumber())
+}
+
+func TestLedger_paymentTimeout(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.PaymentTimeout = 50 * time.Millisecond
+	})
+	responder := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.PaymentApprover = func(amount int64, memo []byte) error {
+			if string(memo) == "slow" {
+				time.Sleep(250 * time.Millisecond)
+			}
+			return nil
+		}
+	})
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// A payment confirmed in time is unaffected by the timeout.
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+
+	// A payment not confirmed in time is abandoned, freeing the channel.
+	require.NoError(t, initiator.Agent.PaymentWithMemo(5_0000000, []byte("slow")))
+	timedOut := <-initiator.PaymentTimeout
+	assert.Equal(t, int64(3), timedOut.CloseAgreement.Envelope.Details.IterationNumber)
+	assert.False(t, initiator.Agent.HasPendingAgreement())
+	assert.Equal(t, int64(2), initiator.Agent.IterationNumber())
+
+	// The responder confirms the payment late. The late confirmation is
+	// rejected by the initiator, and the responder learns the payment it
+	// confirmed was withdrawn.
+	err := <-initiator.Errors
+	assert.Contains(t, err.Error(), "confirming payment")
+	err = <-responder.Errors
+	assert.Contains(t, err.Error(), "payment 3 was withdrawn by the other participant after it was confirmed")
+	assert.Equal(t, int64(2), initiator.Agent.IterationNumber())
+	assert.Equal(t, int64(3), responder.Agent.IterationNumber())
+}
+
+// underfundedSubmitter submits transactions to a ledger until Underfunded is
+// closed, after which it reports that its fee account is underfunded.
+type underfundedSubmitter struct {
+	Ledger      *Ledger
+	Underfunded chan struct{}
+}
+
+func (s underfundedSubmitter) SubmitTx(tx *txnbuild.Transaction) error {
+	select {
+	case <-s.Underfunded:
+		return fmt.Errorf("submitting fee bump tx: %w", agent.ErrFeeAccountUnderfunded)
+	default:
+		return s.Ledger.SubmitTx(tx)
+	}
+}
+
+func TestLedger_feeAccountUnderfunded(t *testing.T) {
+	l := NewLedger()
+	submitter := underfundedSubmitter{Ledger: l, Underfunded: make(chan struct{})}
+	initiator := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.Submitter = submitter
+	})
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// A close that cannot be submitted because the fee account is
+	// underfunded is returned and also written as an error event.
+	close(submitter.Underfunded)
+	err := initiator.Agent.DeclareClose()
+	require.ErrorIs(t, err, agent.ErrFeeAccountUnderfunded)
+	assert.Contains(t, err.Error(), "submitting declaration tx")
+	select {
+	case eventErr := <-initiator.Errors:
+		assert.ErrorIs(t, eventErr, agent.ErrFeeAccountUnderfunded)
+		assert.Equal(t, err.Error(), eventErr.Error())
+	case <-time.After(time.Second):
+		t.Fatal("no error event written")
+	}
+}
+
+func TestLedger_resumeClose(t *testing.T) {
+	l := NewLedger()
+	shortObservationPeriod := func(c *agent.Config) {
+		c.ObservationPeriodTime = 100 * time.Millisecond
+	}
+	initiator := newParticipant(t, l, 100_0000000, shortObservationPeriod)
+	responder := newParticipant(t, l, 100_0000000, shortObservationPeriod)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// Nothing to resume before a close is declared.
+	err := initiator.Agent.ResumeClose()
+	assert.ErrorIs(t, err, agent.ErrCloseNotDeclared)
+
+	// Keep the responder's state from before it pays the initiator.
+	snapshot := responder.Agent.Snapshot()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, responder.Agent.Payment(1_0000000))
+		<-responder.Payments
+	}
+
+	// The responder declares the close with its outdated state directly on
+	// the ledger, without the agents exchanging any messages.
+	channel := state.NewChannelFromSnapshot(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		MaxOpenExpiry:        5 * time.Minute,
+		Initiator:            snapshot.State.Initiator,
+		LocalChannelAccount:  responder.Account.FromAddress(),
+		RemoteChannelAccount: initiator.Account.FromAddress(),
+		LocalSigner:          responder.Signer,
+		RemoteSigner:         initiator.Signer.FromAddress(),
+	}, snapshot.State.Snapshot)
+	declTx, _, err := channel.CloseTxs()
+	require.NoError(t, err)
+	require.NoError(t, l.SubmitTx(declTx))
+
+	// The initiator declares its latest state and closes once it has seen
+	// the declaration and the observation period has passed.
+	require.Eventually(t, func() bool {
+		return initiator.Agent.ResumeClose() == nil
+	}, time.Second, 10*time.Millisecond)
+	<-initiator.Closed
+	<-responder.Closed
+
+	// The close transaction paid the initiator the payments made after the
+	// outdated declaration.
+	balance, err := l.GetBalance(initiator.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(103_0000000), balance)
+	balance, err = l.GetBalance(responder.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(97_0000000), balance)
+}
+
+func TestLedger_maxIterations(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.MaxIterations = 3
+	})
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// The open is the first iteration, leaving two for payments.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, initiator.Agent.Payment(1_0000000))
+		<-initiator.Payments
+	}
+	err := initiator.Agent.Payment(1_0000000)
+	require.ErrorIs(t, err, agent.ErrMaxIterationsReached)
+	assert.EqualError(t, err, "proposing payment 10000000: max iterations reached: iteration 3 of max 3, close the channel to continue")
+
+	// The other participant's payments are not limited.
+	require.NoError(t, responder.Agent.Payment(1_0000000))
+	<-responder.Payments
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+}
+
+func TestLedger_replayOnReconnect(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.ReplayOnReconnect = true
+	})
+	var conn net.Conn
+	responder := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.PaymentApprover = func(amount int64, memo []byte) error {
+			// The connection ends before the confirmation is sent.
+			if string(memo) == "drop" {
+				conn.Close()
+			}
+			return nil
+		}
+	})
+
+	// reconnect connects the participants over a new TCP connection on the
+	// local loopback interface.
+	reconnect := func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			c, _ := ln.Accept()
+			accepted <- c
+		}()
+		responderConn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		conn = <-accepted
+		require.NotNil(t, conn)
+		served := make(chan error, 1)
+		go func() {
+			served <- initiator.Agent.ServeConn(conn)
+		}()
+		require.NoError(t, responder.Agent.ServeConn(responderConn))
+		require.NoError(t, <-served)
+		<-initiator.Connected
+		<-responder.Connected
+	}
+	reconnect()
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	// The responder confirms the payment, but the confirmation is lost with
+	// the connection.
+	require.NoError(t, initiator.Agent.PaymentWithMemo(1_0000000, []byte("drop")))
+	<-initiator.Disconnected
+	<-responder.Disconnected
+	require.Eventually(t, func() bool {
+		return responder.Agent.IterationNumber() == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.True(t, initiator.Agent.HasPendingAgreement())
+	assert.Equal(t, int64(1), initiator.Agent.IterationNumber())
+
+	// On reconnecting the initiator sends the payment again, and the
+	// responder sends its confirmation again without receiving the payment
+	// a second time.
+	reconnect()
+	<-initiator.Payments
+	assert.False(t, initiator.Agent.HasPendingAgreement())
+	assert.Equal(t, int64(2), initiator.Agent.IterationNumber())
+	assert.Equal(t, int64(2), responder.Agent.IterationNumber())
+	assert.Len(t, responder.PaymentRequested, 1)
+
+	// Payments continue on the new connection.
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+}
+
+func TestLedger_withoutStreamer(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.Streamer = nil
+	})
+	connect(t, initiator, responder)
+
+	// Transactions cannot be ingested before there is a channel.
+	err := responder.Agent.IngestTx(agent.StreamedTransaction{})
+	assert.ErrorIs(t, err, agent.ErrNoChannel)
+
+	// The responder's application monitors the network itself and gives the
+	// responder the transactions it sees.
+	txs, cancel := l.StreamTx("")
+	defer cancel()
+	go func() {
+		for tx := range txs {
+			responder.Agent.IngestTx(tx)
+		}
+	}()
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+	require.NoError(t, responder.Agent.Payment(2_0000000))
+	<-responder.Payments
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	balance, err := l.GetBalance(responder.Account.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, int64(99_0000000), balance)
+}
+
+// stallingConn is a connection whose writes block once stalled until it is
+// closed, like a connection to a participant that has stopped reading.
+type stallingConn struct {
+	net.Conn
+	stalled   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *stallingConn) Write(p []byte) (int, error) {
+	select {
+	case <-c.stalled:
+		<-c.closed
+		return 0, net.ErrClosed
+	default:
+		return c.Conn.Write(p)
+	}
+}
+
+func (c *stallingConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestLedger_sendTimeout(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.SendTimeout = 100 * time.Millisecond
+	})
+	responder := newParticipant(t, l, 100_0000000)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	responderConn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	conn := &stallingConn{Conn: <-accepted, stalled: make(chan struct{}), closed: make(chan struct{})}
+	served := make(chan error, 1)
+	go func() {
+		served <- initiator.Agent.ServeConn(conn)
+	}()
+	require.NoError(t, responder.Agent.ServeConn(responderConn))
+	require.NoError(t, <-served)
+	<-initiator.Connected
+	<-responder.Connected
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+
+	// The other participant stops reading, and the payment request cannot be
+	// sent. The payment is discarded and the connection is closed rather
+	// than the agent blocking.
+	close(conn.stalled)
+	err = initiator.Agent.Payment(2_0000000)
+	assert.ErrorIs(t, err, agent.ErrSendTimeout)
+	<-initiator.Disconnected
+	assert.False(t, initiator.Agent.HasPendingAgreement())
+	assert.Equal(t, int64(2), initiator.Agent.IterationNumber())
+}
+
+// chanMessageConn is a MessageConn that exchanges messages over channels.
+type chanMessageConn struct {
+	send      chan<- []byte
+	recv      <-chan []byte
+	closeOnce sync.Once
+}
+
+func newChanMessageConns() (*chanMessageConn, *chanMessageConn) {
+	ab := make(chan []byte, 10)
+	ba := make(chan []byte, 10)
+	return &chanMessageConn{send: ab, recv: ba}, &chanMessageConn{send: ba, recv: ab}
+}
+
+func (c *chanMessageConn) SendMessage(b []byte) error {
+	c.send <- append([]byte(nil), b...)
+	return nil
+}
+
+func (c *chanMessageConn) ReceiveMessage() ([]byte, error) {
+	b, ok := <-c.recv
+	if !ok {
+		return nil, io.EOF
+	}
+	return b, nil
+}
+
+func (c *chanMessageConn) Close() error {
+	c.closeOnce.Do(func() { close(c.send) })
+	return nil
+}
+
+func TestLedger_messageConn(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+
+	initiatorConn, responderConn := newChanMessageConns()
+	require.NoError(t, initiator.Agent.ServeMessageConn(initiatorConn))
+	require.NoError(t, responder.Agent.ServeMessageConn(responderConn))
+	<-initiator.Connected
+	<-responder.Connected
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+
+	// Each message was sent as a single transport message.
+	stats := initiator.Agent.ConnStats()
+	assert.Equal(t, int64(3), stats.MessagesSent)
+	assert.Equal(t, int64(3), stats.MessagesReceived)
+
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+
+	// Closing the connection disconnects the other participant.
+	require.NoError(t, initiatorConn.Close())
+	<-responder.Disconnected
+}
+
+func TestLedger_streamMessageConn(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	responderConn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- initiator.Agent.ServeMessageConn(agent.NewStreamMessageConn(<-accepted))
+	}()
+	require.NoError(t, responder.Agent.ServeMessageConn(agent.NewStreamMessageConn(responderConn)))
+	require.NoError(t, <-served)
+	<-initiator.Connected
+	<-responder.Connected
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+}
+
+func TestLedger_recoverUnopenedAccount(t *testing.T) {
+	l := NewLedger()
+
+	// A channel account that is part of an open channel cannot be recovered.
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	err := responder.Agent.RecoverUnopenedAccount()
+	assert.ErrorIs(t, err, agent.ErrChannelAccountNotRecoverable)
+	assert.EqualError(t, err, "channel account cannot be recovered: open transaction executed")
+
+	// The other participant vanishes after receiving the open request.
+	p := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.MaxOpenExpiry = 2 * time.Second
+	})
+	conn, otherConn := newChanMessageConns()
+	require.NoError(t, p.Agent.ServeMessageConn(conn))
+	otherSigner := keypair.MustRandom()
+	otherChannelAccount := keypair.MustRandom()
+	l.CreateAccount(otherChannelAccount.FromAddress(), 1_0000000)
+	hello := bytes.Buffer{}
+	require.NoError(t, msg.NewEncoder(&hello).Encode(msg.Message{
+		Type: msg.TypeHello,
+		Hello: &msg.Hello{
+			ChannelAccount: *otherChannelAccount.FromAddress(),
+			Signer:         *otherSigner.FromAddress(),
+		},
+	}))
+	require.NoError(t, otherConn.SendMessage(hello.Bytes()))
+	<-p.Connected
+	require.NoError(t, p.Agent.Open(state.NativeAsset))
+
+	// The channel account is not recovered while the open could still be
+	// submitted.
+	err = p.Agent.RecoverUnopenedAccount()
+	assert.ErrorIs(t, err, agent.ErrChannelAccountNotRecoverable)
+	assert.Contains(t, err.Error(), "channel account cannot be recovered: open agreement expires at ")
+
+	// Once the open has expired, the channel account is merged into its
+	// creator.
+	time.Sleep(1100 * time.Millisecond)
+	creatorBalance, err := l.GetBalance(p.Signer.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	require.NoError(t, p.Agent.RecoverUnopenedAccount())
+	balance, err := l.GetBalance(p.Signer.FromAddress(), state.NativeAsset)
+	require.NoError(t, err)
+	assert.Equal(t, creatorBalance+100_0000000, balance)
+	_, err = l.GetSequenceNumber(p.Account.FromAddress())
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), p.Agent.IterationNumber())
+	assert.False(t, p.Agent.HasPendingAgreement())
+}
+
+func TestLedger_signerScheme(t *testing.T) {
+	l := NewLedger()
+	scheme := txbuild.SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4}
+	withScheme := func(c *agent.Config) {
+		c.SignerScheme = scheme
+	}
+	initiator := newParticipant(t, l, 100_0000000, withScheme)
+	responder := newParticipant(t, l, 100_0000000, withScheme)
+	connect(t, initiator, responder)
+
+	// The channel opens, which requires the channel accounts to have the
+	// weights and thresholds of the scheme.
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	params, err := initiator.Agent.OpenParams()
+	require.NoError(t, err)
+	assert.Equal(t, scheme, params.SignerScheme)
+
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+}
+
+func TestLedger_signerSchemeMismatch(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000, func(c *agent.Config) {
+		c.SignerScheme = txbuild.SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4}
+	})
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	err := <-responder.Errors
+	assert.ErrorIs(t, err, state.ErrSignerSchemeMismatch)
+}
+
+func TestLedger_validateStartingSequence(t *testing.T) {
+	l := NewLedger()
+	initiator := newParticipant(t, l, 100_0000000)
+	responder := newParticipant(t, l, 100_0000000)
+	connect(t, initiator, responder)
+
+	assert.ErrorIs(t, responder.Agent.ValidateStartingSequence(), agent.ErrNoChannel)
+
+	require.NoError(t, initiator.Agent.Open(state.NativeAsset))
+	<-initiator.Opened
+	<-responder.Opened
+	require.NoError(t, initiator.Agent.Payment(1_0000000))
+	<-initiator.Payments
+
+	// A channel restored from a snapshot has the starting sequence of the
+	// channel account on the ledger.
+	snapshot := responder.Agent.Snapshot()
+	restored := agent.NewAgentFromSnapshot(responder.Agent.Config(), snapshot)
+	assert.NoError(t, restored.ValidateStartingSequence())
+	params, err := responder.Agent.OpenParams()
+	require.NoError(t, err)
+	restoredParams, err := restored.OpenParams()
+	require.NoError(t, err)
+	assert.Equal(t, params.StartingSequence, restoredParams.StartingSequence)
+
+	// A snapshot with the wrong starting sequence is detected.
+	snapshot.State.Snapshot.OpenAgreement.Envelope.Details.StartingSequence--
+	wrong := agent.NewAgentFromSnapshot(responder.Agent.Config(), snapshot)
+	assert.ErrorIs(t, wrong.ValidateStartingSequence(), state.ErrStartingSequenceMismatch)
+
+	// The starting sequence remains valid once the close is declared.
+	require.NoError(t, initiator.Agent.DeclareClose())
+	<-initiator.Closed
+	<-responder.Closed
+	assert.NoError(t, initiator.Agent.ValidateStartingSequence())
+	assert.NoError(t, restored.ValidateStartingSequence())
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// ErrInvalidAttestation indicates that an attestation could not be decoded or
+// its signatures could not be verified.
+var ErrInvalidAttestation = errors.New("invalid attestation")
+
+// Attestation is a statement of the latest close agreement of a channel
+// signed by both participants, made by one of the participants at a point in
+// time. See Agent.AttestState.
+type Attestation struct {
+	NetworkPassphrase       string
+	InitiatorChannelAccount *keypair.FromAddress
+	ResponderChannelAccount *keypair.FromAddress
+	Asset                   state.Asset
+
+	// InitiatorChannelAccountBalance and ResponderChannelAccountBalance are
+	// the balances of the channel accounts last seen on the network by the
+	// attester.
+	InitiatorChannelAccountBalance int64
+	ResponderChannelAccountBalance int64
+
+	// CloseAgreement is the latest close agreement signed by both
+	// participants, and contains its iteration number, the balance owed
+	// between the participants, and the signatures of both participants.
+	// DeclarationHash and CloseHash are the hashes of its transactions that
+	// the signatures sign.
+	CloseAgreement  state.CloseEnvelope
+	DeclarationHash state.TransactionHash
+	CloseHash       state.TransactionHash
+
+	// Attester is the signer of the participant that made the attestation,
+	// and AttestedAt is when it was made.
+	Attester   *keypair.FromAddress
+	AttestedAt time.Time
+}
+
+// signedAttestation is the encoding of an attestation and the attester's
+// signature of it.
+type signedAttestation struct {
+	Attestation []byte
+	Signature   []byte
+}
+
+// AttestState returns an attestation of the current authorized state of the
+// channel, signed by the agent's channel account signer, that can be presented
+// to a third party and checked with VerifyAttestation. The attestation
+// contains the latest close agreement signed by both participants, with their
+// signatures, and the balances of the channel accounts.
+func (a *Agent) AttestState() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return nil, fmt.Errorf("no channel")
+	}
+	latest := a.channel.LatestCloseAgreement()
+	if !latest.Envelope.ProposerSignatures.HasAllSignatures() || !latest.Envelope.ConfirmerSignatures.HasAllSignatures() {
+		return nil, fmt.Errorf("no close agreement signed by both participants")
+	}
+
+	initiator, responder := a.channel.LocalChannelAccount(), a.channel.RemoteChannelAccount()
+	if !a.channel.IsInitiator() {
+		initiator, responder = responder, initiator
+	}
+	attestation := Attestation{
+		NetworkPassphrase:              a.networkPassphrase,
+		InitiatorChannelAccount:        initiator.Address,
+		ResponderChannelAccount:        responder.Address,
+		Asset:                          a.channel.OpenAgreement().Envelope.Details.Asset,
+		InitiatorChannelAccountBalance: initiator.Balance,
+		ResponderChannelAccountBalance: responder.Balance,
+		CloseAgreement:                 latest.Envelope,
+		DeclarationHash:                latest.Transactions.DeclarationHash,
+		CloseHash:                      latest.Transactions.CloseHash,
+		Attester:                       a.signer().FromAddress(),
+		AttestedAt:                     time.Now().UTC(),
+	}
+	attestationJSON, err := json.Marshal(attestation)
+	if err != nil {
+		return nil, fmt.Errorf("encoding attestation: %w", err)
+	}
+	signature, err := a.signer().Sign(attestationJSON)
+	if err != nil {
+		return nil, fmt.Errorf("signing attestation: %w", err)
+	}
+	signed, err := json.Marshal(signedAttestation{Attestation: attestationJSON, Signature: signature})
+	if err != nil {
+		return nil, fmt.Errorf("encoding signed attestation: %w", err)
+	}
+	return signed, nil
+}
+
+// VerifyAttestation decodes an attestation made by AttestState, and verifies
+// that it is signed by its attester, that the attester is one of the
+// participants of its close agreement, and that both participants signed the
+// transaction hashes of the close agreement. Checking that the transaction
+// hashes are those of the channel requires the details of the channel's open
+// agreement, and is not done. If the attestation is not valid, an error
+// wrapping ErrInvalidAttestation is returned.
+func VerifyAttestation(b []byte) (Attestation, error) {
+	signed := signedAttestation{}
+	err := json.Unmarshal(b, &signed)
+	if err != nil {
+		return Attestation{}, fmt.Errorf("%w: decoding: %v", ErrInvalidAttestation, err)
+	}
+	attestation := Attestation{}
+	err = json.Unmarshal(signed.Attestation, &attestation)
+	if err != nil {
+		return Attestation{}, fmt.Errorf("%w: decoding attestation: %v", ErrInvalidAttestation, err)
+	}
+	if attestation.Attester == nil {
+		return Attestation{}, fmt.Errorf("%w: no attester", ErrInvalidAttestation)
+	}
+	err = attestation.Attester.Verify(signed.Attestation, signed.Signature)
+	if err != nil {
+		return Attestation{}, fmt.Errorf("%w: verifying attester signature: %v", ErrInvalidAttestation, err)
+	}
+
+	ca := attestation.CloseAgreement
+	if ca.SignaturesFor(attestation.Attester) == nil {
+		return Attestation{}, fmt.Errorf("%w: attester %s is not a signer of the close agreement", ErrInvalidAttestation, attestation.Attester.Address())
+	}
+	signers := []struct {
+		signer     *keypair.FromAddress
+		signatures state.CloseSignatures
+	}{
+		{ca.Details.ProposingSigner, ca.ProposerSignatures},
+		{ca.Details.ConfirmingSigner, ca.ConfirmerSignatures},
+	}
+	for _, s := range signers {
+		if s.signer == nil {
+			return Attestation{}, fmt.Errorf("%w: close agreement signer missing", ErrInvalidAttestation)
+		}
+		err = s.signer.Verify(attestation.DeclarationHash[:], s.signatures.Declaration)
+		if err != nil {
+			return Attestation{}, fmt.Errorf("%w: verifying declaration signed by %s: %v", ErrInvalidAttestation, s.signer.Address(), err)
+		}
+		err = s.signer.Verify(attestation.CloseHash[:], s.signatures.Close)
+		if err != nil {
+			return Attestation{}, fmt.Errorf("%w: verifying close signed by %s: %v", ErrInvalidAttestation, s.signer.Address(), err)
+		}
+	}
+	return attestation, nil
+}
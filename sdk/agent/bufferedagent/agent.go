@@ -12,7 +12,9 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/stellar/starlight/sdk/agent"
@@ -23,6 +25,24 @@ import (
 // as configured when the buffered agent was created.
 var ErrBufferFull = errors.New("buffer full")
 
+// ErrNegativeBufferTotal indicates that a reverse entry would make the total
+// of the buffered payments negative, which cannot be paid because payments on
+// a channel are always paid by the participant proposing them.
+var ErrNegativeBufferTotal = errors.New("buffered payments total would be negative")
+
+// ErrBufferTotalMismatch indicates that the sum of the amounts of the buffered
+// payments in a memo does not equal the amount of the payment agreement that
+// carries them.
+var ErrBufferTotalMismatch = errors.New("buffered payments total does not match payment amount")
+
+// ErrFractionsDisabled indicates that a fractional payment was buffered but no
+// FractionDenominator is configured.
+var ErrFractionsDisabled = errors.New("fractional payments are not enabled")
+
+// ErrUnknownBuffer indicates that a buffer is not waiting to be paid, because
+// it is unknown, or because its payment has already been sent.
+var ErrUnknownBuffer = errors.New("unknown or settled buffer")
+
 // Config contains the information that can be supplied to configure the Agent
 // at construction.
 type Config struct {
@@ -31,6 +51,27 @@ type Config struct {
 
 	MaxBufferSize int
 
+	// PaymentLess, if set, orders the buffered payments in each memo, and
+	// reports whether payment a sorts before payment b. If nil, payments are
+	// ordered by memo, then by amount. Payments are sorted before the memo
+	// is encoded so that the same set of payments always encodes to the same
+	// memo bytes, regardless of the order they were buffered in.
+	PaymentLess func(a, b BufferedPayment) bool
+
+	// FractionDenominator, if set, enables PaymentWithFraction, and is the
+	// number of units of a fractional payment that make up one unit of the
+	// asset, e.g. 1000 for fractions of a thousandth of a stroop.
+	FractionDenominator int64
+
+	// MaxSeenBufferIDs is the number of the most recently received buffer IDs
+	// that are remembered to detect a buffer being received more than once.
+	// A payment carrying a buffer whose ID was received within the last
+	// MaxSeenBufferIDs distinct buffers is always detected, is reported as an
+	// ErrorEvent wrapping ErrDuplicateBuffer, and its payments are not
+	// written as a BufferedPaymentsReceivedEvent. Duplicates of buffers older
+	// than that are not detected. Defaults to 1024.
+	MaxSeenBufferIDs int
+
 	LogWriter io.Writer
 
 	Events chan<- interface{}
@@ -38,11 +79,36 @@ type Config struct {
 
 // NewAgent constructs a new buffered agent with the given config.
 func NewAgent(c Config) *Agent {
+	return NewAgentFromSnapshot(c, Snapshot{})
+}
+
+// Snapshot is a snapshot of the state of the buffered agent that is not held
+// by the underlying agent. A Snapshot can be restored into an Agent using
+// NewAgentFromSnapshot.
+type Snapshot struct {
+	// FractionCarry is the remainder of the fractional payments that has not
+	// been paid, in units of one FractionDenominator of the asset.
+	FractionCarry int64
+}
+
+// NewAgentFromSnapshot constructs a new buffered agent with the given config
+// and the state of a previous buffered agent. The snapshot should be taken
+// with the same FractionDenominator configured.
+func NewAgentFromSnapshot(c Config, s Snapshot) *Agent {
+	maxSeenBufferIDs := c.MaxSeenBufferIDs
+	if maxSeenBufferIDs <= 0 {
+		maxSeenBufferIDs = defaultMaxSeenBufferIDs
+	}
 	agent := &Agent{
 		agent:       c.Agent,
 		agentEvents: c.AgentEvents,
 
-		maxbufferSize: c.MaxBufferSize,
+		maxbufferSize:       c.MaxBufferSize,
+		paymentLess:         c.PaymentLess,
+		fractionDenominator: c.FractionDenominator,
+		fractionCarry:       s.FractionCarry,
+
+		seenBufferIDs: newSeenIDs(maxSeenBufferIDs),
 
 		logWriter: c.LogWriter,
 
@@ -65,7 +131,9 @@ func NewAgent(c Config) *Agent {
 // All functions of the Agent are safe to call from multiple goroutines as they
 // use an internal mutex.
 type Agent struct {
-	maxbufferSize int
+	maxbufferSize       int
+	paymentLess         func(a, b BufferedPayment) bool
+	fractionDenominator int64
 
 	logWriter io.Writer
 
@@ -83,9 +151,19 @@ type Agent struct {
 	bufferID          string
 	buffer            []BufferedPayment
 	bufferTotalAmount int64
+	bufferFraction    int64
+	fractionCarry     int64
+	seenBufferIDs     *seenIDs
 	bufferReady       chan struct{}
 	sendingReady      chan struct{}
 	idle              chan struct{}
+
+	// sendingBufferID is the ID of the buffer whose payment is being sent,
+	// and sendingSince is when it was flushed. settleDuration is how long
+	// the payment of the last buffer sent took to be confirmed.
+	sendingBufferID string
+	sendingSince    time.Time
+	settleDuration  time.Duration
 }
 
 // MaxBufferSize returns the maximum buffer size that was configured at
@@ -120,7 +198,26 @@ func (a *Agent) Open(asset state.Asset) error {
 // immediately if the buffer is full. Any errors relating to the payment, and
 // confirmation of the payment, will be returned asynchronously on the events
 // channel.
+//
+// A negative payment amount buffers a reverse entry that is netted against
+// the other payments in the buffer, so that amounts owed in both directions
+// within the same buffer are settled by a single payment for the net amount.
+// The buffer is always paid by this participant, and so a reverse entry that
+// would make the total of the buffer negative is rejected with
+// ErrNegativeBufferTotal. The memo of a reverse entry can be used to identify
+// the payments of the other participant that it nets. A buffer whose payments
+// net to zero is not paid, because a payment must be for more than zero, and
+// instead an ErrorEvent wrapping state.ErrInvalidAmount is written with the
+// buffer's identifier.
+//
+// Each flush of the buffer is one iteration of the channel. If the underlying
+// agent's Config.MaxIterations has been reached, agent.ErrMaxIterationsReached
+// is returned and the payment is not buffered.
 func (a *Agent) PaymentWithMemo(paymentAmount int64, memo string) (bufferID string, err error) {
+	if max := a.agent.Config().MaxIterations; max > 0 && a.agent.IterationNumber() >= max {
+		return "", agent.ErrMaxIterationsReached
+	}
+
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	if a.maxbufferSize != 0 && len(a.buffer) == a.maxbufferSize {
@@ -129,6 +226,9 @@ func (a *Agent) PaymentWithMemo(paymentAmount int64, memo string) (bufferID stri
 	if paymentAmount > math.MaxInt64-a.bufferTotalAmount {
 		return "", ErrBufferFull
 	}
+	if a.bufferTotalAmount+paymentAmount < 0 {
+		return "", ErrNegativeBufferTotal
+	}
 	a.buffer = append(a.buffer, BufferedPayment{Amount: paymentAmount, Memo: memo})
 	a.bufferTotalAmount += paymentAmount
 	bufferID = a.bufferID
@@ -139,6 +239,91 @@ func (a *Agent) PaymentWithMemo(paymentAmount int64, memo string) (bufferID stri
 	return
 }
 
+// PaymentWithFraction buffers a payment of an amount smaller than the smallest
+// unit of the asset, such as for pricing per byte or per second. The fraction
+// is in units of one Config.FractionDenominator of the asset, and must be
+// greater than zero. The identifier for the buffer is returned.
+//
+// Fractions are accumulated and only whole units are paid. When the buffer is
+// flushed, the fractions in the buffer are added to the remainder carried from
+// previous buffers, the whole units of the sum are paid along with the other
+// payments in the buffer, and the remainder that is less than one unit is
+// rounded down and carried forward to the next buffer. The remainder is never
+// negative and never paid early. A buffer holding only fractions that do not
+// add up to a whole unit is not paid, and its fractions are carried forward.
+// The remainder is included in the Snapshot.
+func (a *Agent) PaymentWithFraction(fraction int64, memo string) (bufferID string, err error) {
+	if max := a.agent.Config().MaxIterations; max > 0 && a.agent.IterationNumber() >= max {
+		return "", agent.ErrMaxIterationsReached
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.fractionDenominator <= 0 {
+		return "", ErrFractionsDisabled
+	}
+	if fraction <= 0 {
+		return "", fmt.Errorf("%w: fraction %d", state.ErrInvalidAmount, fraction)
+	}
+	if a.maxbufferSize != 0 && len(a.buffer) == a.maxbufferSize {
+		return "", ErrBufferFull
+	}
+	if fraction > math.MaxInt64-a.bufferFraction-a.fractionCarry {
+		return "", ErrBufferFull
+	}
+	a.buffer = append(a.buffer, BufferedPayment{Fraction: fraction, Memo: memo})
+	a.bufferFraction += fraction
+	bufferID = a.bufferID
+	select {
+	case a.bufferReady <- struct{}{}:
+	default:
+	}
+	return
+}
+
+// Snapshot returns a snapshot of the buffered agent's state. Payments in the
+// buffer that have not been flushed are not included.
+func (a *Agent) Snapshot() Snapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Snapshot{FractionCarry: a.fractionCarry}
+}
+
+// EstimatedSettlement returns when the payment of the buffer with the ID is
+// expected to be confirmed by the other participant, such as for showing how
+// long until a payment buffered with PaymentWithMemo settles.
+//
+// The buffer is flushed as soon as the payment of the previous buffer is
+// confirmed, and so a buffer that is still filling is expected to settle
+// after the payment being sent, if any, and then its own payment. The time a
+// payment takes is estimated as the time the last payment sent took, and is
+// zero until a payment has been sent. The estimate is never earlier than the
+// current time.
+//
+// An error wrapping ErrUnknownBuffer is returned if the buffer is not waiting
+// to be paid, because the ID is unknown, or because its payment has already
+// been sent.
+func (a *Agent) EstimatedSettlement(bufferID string) (time.Time, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	sendingSettles := now
+	if a.sendingBufferID != "" {
+		sendingSettles = a.sendingSince.Add(a.settleDuration)
+		if sendingSettles.Before(now) {
+			sendingSettles = now
+		}
+	}
+	switch {
+	case bufferID == "":
+	case bufferID == a.sendingBufferID:
+		return sendingSettles, nil
+	case bufferID == a.bufferID && len(a.buffer) > 0:
+		return sendingSettles.Add(a.settleDuration), nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %s", ErrUnknownBuffer, bufferID)
+}
+
 // Payment is equivalent to calling PaymentWithMemo with an empty memo.
 func (a *Agent) Payment(paymentAmount int64) (bufferID string, err error) {
 	return a.PaymentWithMemo(paymentAmount, "")
@@ -192,21 +377,31 @@ func (a *Agent) eventLoop() {
 		// sub-payment that was bufferd within them.
 		switch e := ae.(type) {
 		case agent.PaymentReceivedEvent:
-			memo := bufferedPaymentsMemo{}
-			err := memo.UnmarshalBinary(e.CloseAgreement.Envelope.Details.Memo)
+			memo, err := ParseMemo(e.CloseAgreement.Envelope.Details.Memo)
+			if err != nil {
+				a.events <- agent.ErrorEvent{Err: err}
+				continue
+			}
+			err = validateMemoTotal(memo, e.CloseAgreement.Envelope.Details.PaymentAmount)
 			if err != nil {
 				a.events <- agent.ErrorEvent{Err: err}
 				continue
 			}
+			if a.seeBuffer(memo.ID) {
+				a.events <- agent.ErrorEvent{Err: fmt.Errorf("%w: buffer %s received again at iteration %d", ErrDuplicateBuffer, memo.ID, e.CloseAgreement.Envelope.Details.IterationNumber)}
+				continue
+			}
 			a.events <- BufferedPaymentsReceivedEvent{
 				BufferID:       memo.ID,
 				BufferByteSize: len(e.CloseAgreement.Envelope.Details.Memo),
 				Payments:       memo.Payments,
 			}
 		case agent.PaymentSentEvent:
+			memo, err := ParseMemo(e.CloseAgreement.Envelope.Details.Memo)
+			if err == nil {
+				a.settled(memo.ID)
+			}
 			a.sendingReady <- struct{}{}
-			memo := bufferedPaymentsMemo{}
-			err := memo.UnmarshalBinary(e.CloseAgreement.Envelope.Details.Memo)
 			if err != nil {
 				a.events <- agent.ErrorEvent{Err: err}
 				continue
@@ -220,6 +415,26 @@ func (a *Agent) eventLoop() {
 	}
 }
 
+// seeBuffer remembers that the buffer with the ID was received, and returns
+// true if it was already received within the last MaxSeenBufferIDs buffers.
+func (a *Agent) seeBuffer(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.seenBufferIDs.see(id)
+}
+
+// settled records that the payment of the buffer with the ID was confirmed,
+// and how long it took.
+func (a *Agent) settled(bufferID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if bufferID != a.sendingBufferID {
+		return
+	}
+	a.settleDuration = time.Since(a.sendingSince)
+	a.sendingBufferID = ""
+}
+
 func (a *Agent) flushLoop() {
 	defer fmt.Fprintf(a.logWriter, "flush loop stopped\n")
 	fmt.Fprintf(a.logWriter, "flush loop started\n")
@@ -252,6 +467,9 @@ func (a *Agent) flush() {
 	var bufferID string
 	var buffer []BufferedPayment
 	var bufferTotalAmount int64
+	var fractionDenominator int64
+	var fractionCarriedIn, fractionCarriedOut int64
+	var fractionWhole int64
 
 	func() {
 		a.mu.Lock()
@@ -260,18 +478,76 @@ func (a *Agent) flush() {
 		bufferID = a.bufferID
 		buffer = a.buffer
 		bufferTotalAmount = a.bufferTotalAmount
+		// The whole units of the fractions are paid, and the remainder is
+		// rounded down and carried forward. The fractions stay in the carry
+		// until the whole units are paid, so that they are not lost if the
+		// payment is not proposed.
+		if a.fractionDenominator > 0 {
+			fractionDenominator = a.fractionDenominator
+			fractionCarriedIn = a.fractionCarry
+			fractions := a.fractionCarry + a.bufferFraction
+			fractionWhole = fractions / a.fractionDenominator
+			fractionCarriedOut = fractions % a.fractionDenominator
+			a.fractionCarry = fractions
+		}
+		if len(buffer) > 0 {
+			a.sendingBufferID = bufferID
+			a.sendingSince = time.Now()
+		}
 		a.resetbuffer()
 	}()
 
+	// If the buffer is not paid it is no longer being sent.
+	sent := false
+	defer func() {
+		if sent {
+			return
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.sendingBufferID == bufferID {
+			a.sendingBufferID = ""
+		}
+	}()
+
 	if len(buffer) == 0 {
 		a.sendingReady <- struct{}{}
 		return
 	}
+	if bufferTotalAmount == 0 && fractionWhole == 0 && onlyFractions(buffer) {
+		fmt.Fprintf(a.logWriter, "buffer %s of %d fractional payments carried forward\n", bufferID, len(buffer))
+		a.sendingReady <- struct{}{}
+		return
+	}
+	if fractionWhole > math.MaxInt64-bufferTotalAmount {
+		a.events <- agent.ErrorEvent{Err: fmt.Errorf("%w: buffer %s total overflows", ErrBufferFull, bufferID)}
+		a.sendingReady <- struct{}{}
+		return
+	}
+	bufferTotalAmount += fractionWhole
+	if bufferTotalAmount == 0 {
+		err := fmt.Errorf("%w: buffer %s of %d payments nets to zero", state.ErrInvalidAmount, bufferID, len(buffer))
+		a.events <- agent.ErrorEvent{Err: err}
+		a.sendingReady <- struct{}{}
+		return
+	}
 
-	memo := bufferedPaymentsMemo{
+	sortPayments(buffer, a.paymentLess)
+	memo := Memo{
 		ID:       bufferID,
 		Payments: buffer,
 	}
+	if fractionCarriedIn != 0 || fractionCarriedOut != 0 || fractionWhole != 0 {
+		memo.FractionDenominator = fractionDenominator
+		memo.FractionCarriedIn = fractionCarriedIn
+		memo.FractionCarriedOut = fractionCarriedOut
+	}
+	err := validateMemoTotal(memo, bufferTotalAmount)
+	if err != nil {
+		a.events <- agent.ErrorEvent{Err: err}
+		a.sendingReady <- struct{}{}
+		return
+	}
 	memoBytes, err := memo.MarshalBinary()
 	if err != nil {
 		a.events <- agent.ErrorEvent{Err: err}
@@ -285,10 +561,95 @@ func (a *Agent) flush() {
 		a.sendingReady <- struct{}{}
 		return
 	}
+	sent = true
+
+	a.mu.Lock()
+	a.fractionCarry -= fractionWhole * fractionDenominator
+	a.mu.Unlock()
+}
+
+// sortPayments sorts the payments in place with less, or by memo then amount if
+// less is nil. The sort is stable so that payments less considers equal keep
+// the order they were buffered in.
+func sortPayments(payments []BufferedPayment, less func(a, b BufferedPayment) bool) {
+	if less == nil {
+		less = func(a, b BufferedPayment) bool {
+			if a.Memo != b.Memo {
+				return a.Memo < b.Memo
+			}
+			if a.Amount != b.Amount {
+				return a.Amount < b.Amount
+			}
+			return a.Fraction < b.Fraction
+		}
+	}
+	sort.SliceStable(payments, func(i, j int) bool {
+		return less(payments[i], payments[j])
+	})
+}
+
+// validateMemoTotal checks that the amounts of the payments in the memo sum to
+// the amount of the payment that carries the memo, including the whole units
+// of the fractions paid. The memo may have been received from the other
+// participant, and so a memo with a sum that overflows is invalid.
+func validateMemoTotal(memo Memo, paymentAmount int64) error {
+	if memo.FractionCarriedIn < 0 || memo.FractionCarriedOut < 0 {
+		return fmt.Errorf("%w: buffer %s fractions do not add up to whole units", ErrBufferTotalMismatch, memo.ID)
+	}
+	overflowErr := fmt.Errorf("%w: buffer %s total overflows", ErrBufferTotalMismatch, memo.ID)
+	total := int64(0)
+	fractions := memo.FractionCarriedIn - memo.FractionCarriedOut
+	var ok bool
+	for _, p := range memo.Payments {
+		total, ok = addInt64(total, p.Amount)
+		if !ok {
+			return overflowErr
+		}
+		fractions, ok = addInt64(fractions, p.Fraction)
+		if !ok {
+			return overflowErr
+		}
+	}
+	if fractions != 0 || memo.FractionCarriedIn != 0 || memo.FractionCarriedOut != 0 {
+		d := memo.FractionDenominator
+		if d <= 0 || fractions < 0 || fractions%d != 0 || memo.FractionCarriedOut >= d {
+			return fmt.Errorf("%w: buffer %s fractions do not add up to whole units", ErrBufferTotalMismatch, memo.ID)
+		}
+		total, ok = addInt64(total, fractions/d)
+		if !ok {
+			return overflowErr
+		}
+	}
+	if total != paymentAmount {
+		return fmt.Errorf("%w: buffer %s total %d, payment amount %d", ErrBufferTotalMismatch, memo.ID, total, paymentAmount)
+	}
+	return nil
+}
+
+// addInt64 returns the sum of x and y, and false if the sum overflows.
+func addInt64(x, y int64) (int64, bool) {
+	if y > 0 && x > math.MaxInt64-y {
+		return 0, false
+	}
+	if y < 0 && x < math.MinInt64-y {
+		return 0, false
+	}
+	return x + y, true
 }
 
 func (a *Agent) resetbuffer() {
 	a.bufferID = uuid.NewString()
 	a.buffer = nil
 	a.bufferTotalAmount = 0
+	a.bufferFraction = 0
+}
+
+// onlyFractions returns true if all the payments are fractional payments.
+func onlyFractions(payments []BufferedPayment) bool {
+	for _, p := range payments {
+		if p.Amount != 0 || p.Fraction == 0 {
+			return false
+		}
+	}
+	return true
 }
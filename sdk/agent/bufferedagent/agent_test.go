@@ -0,0 +1,348 @@
+package bufferedagent
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMemoTotal(t *testing.T) {
+	memo := Memo{
+		ID: "buffer-1",
+		Payments: []BufferedPayment{
+			{Amount: 1},
+			{Amount: 2},
+		},
+	}
+	assert.NoError(t, validateMemoTotal(memo, 3))
+	assert.ErrorIs(t, validateMemoTotal(memo, 4), ErrBufferTotalMismatch)
+	assert.EqualError(t, validateMemoTotal(memo, 2), "buffered payments total does not match payment amount: buffer buffer-1 total 3, payment amount 2")
+	assert.NoError(t, validateMemoTotal(Memo{}, 0))
+}
+
+func TestSortPayments(t *testing.T) {
+	payments := func() []BufferedPayment {
+		return []BufferedPayment{
+			{Amount: 3, Memo: "b"},
+			{Amount: 2, Memo: "a"},
+			{Amount: 1, Memo: "b"},
+			{Amount: 4, Memo: ""},
+		}
+	}
+
+	// Payments buffered in different orders encode to the same memo.
+	p1 := payments()
+	p2 := payments()
+	p2[0], p2[3] = p2[3], p2[0]
+	p2[1], p2[2] = p2[2], p2[1]
+	sortPayments(p1, nil)
+	sortPayments(p2, nil)
+	assert.Equal(t, []BufferedPayment{
+		{Amount: 4, Memo: ""},
+		{Amount: 2, Memo: "a"},
+		{Amount: 1, Memo: "b"},
+		{Amount: 3, Memo: "b"},
+	}, p1)
+	b1, err := (&Memo{ID: "buffer-1", Payments: p1}).MarshalBinary()
+	require.NoError(t, err)
+	b2, err := (&Memo{ID: "buffer-1", Payments: p2}).MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, b1, b2)
+
+	// A comparator orders by the caller's key, keeping the buffered order of
+	// payments it considers equal.
+	p3 := payments()
+	sortPayments(p3, func(a, b BufferedPayment) bool { return a.Memo > b.Memo })
+	assert.Equal(t, []BufferedPayment{
+		{Amount: 3, Memo: "b"},
+		{Amount: 1, Memo: "b"},
+		{Amount: 2, Memo: "a"},
+		{Amount: 4, Memo: ""},
+	}, p3)
+}
+
+func TestAgent_flush_totalMismatch(t *testing.T) {
+	events := make(chan interface{}, 1)
+	a := &Agent{
+		sendingReady: make(chan struct{}, 1),
+		events:       events,
+	}
+	a.buffer = []BufferedPayment{{Amount: 1}, {Amount: 2}}
+	a.bufferTotalAmount = 4
+
+	// The flush errors before the payment reaches the underlying agent, which
+	// is nil here.
+	a.flush()
+
+	e := <-events
+	require.IsType(t, agent.ErrorEvent{}, e)
+	assert.ErrorIs(t, e.(agent.ErrorEvent).Err, ErrBufferTotalMismatch)
+	assert.Len(t, a.sendingReady, 1)
+	assert.Empty(t, a.buffer)
+}
+
+func TestAgent_flush_netZero(t *testing.T) {
+	events := make(chan interface{}, 1)
+	a := &Agent{
+		sendingReady: make(chan struct{}, 1),
+		events:       events,
+	}
+	a.bufferID = "buffer-1"
+	a.buffer = []BufferedPayment{{Amount: 5, Memo: "a"}, {Amount: -5, Memo: "reverse a"}}
+	a.bufferTotalAmount = 0
+
+	// The flush errors before the payment reaches the underlying agent, which
+	// is nil here.
+	a.flush()
+
+	e := <-events
+	require.IsType(t, agent.ErrorEvent{}, e)
+	err := e.(agent.ErrorEvent).Err
+	assert.ErrorIs(t, err, state.ErrInvalidAmount)
+	assert.EqualError(t, err, "payment amount must be greater than 0: buffer buffer-1 of 2 payments nets to zero")
+	assert.Len(t, a.sendingReady, 1)
+	assert.Empty(t, a.buffer)
+}
+
+func TestAgent_PaymentWithMemo_reverseEntries(t *testing.T) {
+	a := &Agent{
+		agent:       agent.NewAgent(agent.Config{LogWriter: io.Discard}),
+		bufferReady: make(chan struct{}, 1),
+	}
+	a.resetbuffer()
+
+	_, err := a.PaymentWithMemo(-1, "reverse")
+	assert.ErrorIs(t, err, ErrNegativeBufferTotal)
+	assert.Empty(t, a.buffer)
+
+	_, err = a.PaymentWithMemo(10, "a")
+	require.NoError(t, err)
+	_, err = a.PaymentWithMemo(-4, "reverse a")
+	require.NoError(t, err)
+	_, err = a.PaymentWithMemo(-7, "reverse b")
+	assert.ErrorIs(t, err, ErrNegativeBufferTotal)
+	_, err = a.PaymentWithMemo(-6, "reverse b")
+	require.NoError(t, err)
+
+	assert.Equal(t, []BufferedPayment{
+		{Amount: 10, Memo: "a"},
+		{Amount: -4, Memo: "reverse a"},
+		{Amount: -6, Memo: "reverse b"},
+	}, a.buffer)
+	assert.Equal(t, int64(0), a.bufferTotalAmount)
+	assert.NoError(t, validateMemoTotal(Memo{Payments: a.buffer}, a.bufferTotalAmount))
+}
+
+func TestValidateMemoTotal_overflow(t *testing.T) {
+	// Amounts that overflow are invalid, even if the overflowed total equals
+	// the payment amount.
+	memo := Memo{
+		ID: "buffer-1",
+		Payments: []BufferedPayment{
+			{Amount: math.MaxInt64},
+			{Amount: math.MaxInt64},
+			{Amount: 2},
+		},
+	}
+	assert.EqualError(t, validateMemoTotal(memo, 0), "buffered payments total does not match payment amount: buffer buffer-1 total overflows")
+	memo.Payments = []BufferedPayment{{Amount: math.MinInt64}, {Amount: -1}}
+	assert.ErrorIs(t, validateMemoTotal(memo, math.MaxInt64), ErrBufferTotalMismatch)
+
+	// Fractions that overflow are invalid.
+	memo = Memo{
+		ID: "buffer-1",
+		Payments: []BufferedPayment{
+			{Fraction: math.MaxInt64},
+			{Fraction: math.MaxInt64},
+			{Fraction: 2},
+		},
+		FractionDenominator: 1000,
+	}
+	assert.ErrorIs(t, validateMemoTotal(memo, 0), ErrBufferTotalMismatch)
+
+	// The whole units of the fractions are included in the overflow check.
+	memo = Memo{
+		ID: "buffer-1",
+		Payments: []BufferedPayment{
+			{Amount: math.MaxInt64},
+			{Fraction: 1000},
+		},
+		FractionDenominator: 1000,
+	}
+	assert.ErrorIs(t, validateMemoTotal(memo, math.MinInt64), ErrBufferTotalMismatch)
+
+	// Negative carries are invalid.
+	memo = Memo{
+		ID:                  "buffer-1",
+		Payments:            []BufferedPayment{{Fraction: 1}},
+		FractionDenominator: 1000,
+		FractionCarriedIn:   math.MinInt64,
+		FractionCarriedOut:  1,
+	}
+	assert.ErrorIs(t, validateMemoTotal(memo, 0), ErrBufferTotalMismatch)
+}
+
+func TestValidateMemoTotal_fractions(t *testing.T) {
+	memo := Memo{
+		ID: "buffer-1",
+		Payments: []BufferedPayment{
+			{Amount: 1},
+			{Fraction: 700},
+			{Fraction: 600},
+		},
+		FractionDenominator: 1000,
+		FractionCarriedIn:   800,
+		FractionCarriedOut:  100,
+	}
+	// 1 + (800 + 700 + 600 - 100) / 1000 = 3
+	assert.NoError(t, validateMemoTotal(memo, 3))
+	assert.ErrorIs(t, validateMemoTotal(memo, 2), ErrBufferTotalMismatch)
+
+	// Fractions that do not add up to whole units are invalid.
+	memo.FractionCarriedOut = 50
+	assert.ErrorIs(t, validateMemoTotal(memo, 3), ErrBufferTotalMismatch)
+
+	// A remainder of a whole unit or more must have been paid.
+	memo.FractionCarriedOut = 1100
+	assert.ErrorIs(t, validateMemoTotal(memo, 2), ErrBufferTotalMismatch)
+
+	// Fractions require a denominator.
+	assert.ErrorIs(t, validateMemoTotal(Memo{Payments: []BufferedPayment{{Fraction: 1}}}, 0), ErrBufferTotalMismatch)
+}
+
+func TestAgent_PaymentWithFraction(t *testing.T) {
+	events := make(chan interface{}, 10)
+	a := &Agent{
+		agent:        agent.NewAgent(agent.Config{LogWriter: io.Discard}),
+		logWriter:    io.Discard,
+		bufferReady:  make(chan struct{}, 1),
+		sendingReady: make(chan struct{}, 1),
+		events:       events,
+	}
+	a.resetbuffer()
+
+	// Fractions must be enabled and positive.
+	_, err := a.PaymentWithFraction(1, "")
+	assert.ErrorIs(t, err, ErrFractionsDisabled)
+	a.fractionDenominator = 1000
+	_, err = a.PaymentWithFraction(0, "")
+	assert.ErrorIs(t, err, state.ErrInvalidAmount)
+	_, err = a.PaymentWithFraction(-1, "")
+	assert.ErrorIs(t, err, state.ErrInvalidAmount)
+
+	// Fractions that do not add up to a whole unit are not paid, and are
+	// carried forward.
+	_, err = a.PaymentWithFraction(400, "byte 1")
+	require.NoError(t, err)
+	_, err = a.PaymentWithFraction(300, "byte 2")
+	require.NoError(t, err)
+	a.flush()
+	assert.Empty(t, events)
+	assert.Len(t, a.sendingReady, 1)
+	<-a.sendingReady
+	assert.Equal(t, Snapshot{FractionCarry: 700}, a.Snapshot())
+
+	// Once the fractions add up to whole units, the whole units are paid
+	// and the remainder is carried forward. The payment fails here because
+	// the underlying agent is not connected, so the whole units that were
+	// not paid stay in the carry.
+	_, err = a.PaymentWithFraction(2500, "byte 3")
+	require.NoError(t, err)
+	a.flush()
+	e := <-events
+	require.IsType(t, agent.ErrorEvent{}, e)
+	assert.EqualError(t, e.(agent.ErrorEvent).Err, "not connected")
+	assert.Equal(t, Snapshot{FractionCarry: 3200}, a.Snapshot())
+
+	// The carry is restored from a snapshot.
+	restored := NewAgentFromSnapshot(Config{FractionDenominator: 1000, LogWriter: io.Discard}, a.Snapshot())
+	assert.Equal(t, int64(3200), restored.fractionCarry)
+}
+
+func TestAgent_EstimatedSettlement(t *testing.T) {
+	agentEvents := make(chan interface{})
+	events := make(chan interface{}, 10)
+	a := &Agent{
+		agent:        agent.NewAgent(agent.Config{LogWriter: io.Discard}),
+		logWriter:    io.Discard,
+		agentEvents:  agentEvents,
+		bufferReady:  make(chan struct{}, 1),
+		sendingReady: make(chan struct{}, 1),
+		events:       events,
+	}
+	a.resetbuffer()
+
+	// Unknown buffers, and the current buffer while empty, have no estimate.
+	_, err := a.EstimatedSettlement("unknown")
+	assert.ErrorIs(t, err, ErrUnknownBuffer)
+	_, err = a.EstimatedSettlement(a.bufferID)
+	assert.ErrorIs(t, err, ErrUnknownBuffer)
+
+	// Before any payment has been sent a buffered payment is expected to
+	// settle immediately.
+	bufferID, err := a.Payment(10)
+	require.NoError(t, err)
+	before := time.Now()
+	estimate, err := a.EstimatedSettlement(bufferID)
+	require.NoError(t, err)
+	assert.False(t, estimate.Before(before))
+	assert.WithinDuration(t, time.Now(), estimate, time.Second)
+
+	// A buffer waits for the payment being sent to settle, and then for its
+	// own payment.
+	a.mu.Lock()
+	a.sendingBufferID = "sending"
+	a.sendingSince = time.Now().Add(-time.Minute)
+	a.settleDuration = 3 * time.Minute
+	sendingSince := a.sendingSince
+	a.mu.Unlock()
+	estimate, err = a.EstimatedSettlement("sending")
+	require.NoError(t, err)
+	assert.Equal(t, sendingSince.Add(3*time.Minute), estimate)
+	estimate, err = a.EstimatedSettlement(bufferID)
+	require.NoError(t, err)
+	assert.Equal(t, sendingSince.Add(6*time.Minute), estimate)
+
+	// A payment taking longer than estimated is expected to settle now.
+	a.mu.Lock()
+	a.sendingSince = time.Now().Add(-time.Hour)
+	a.mu.Unlock()
+	before = time.Now()
+	estimate, err = a.EstimatedSettlement("sending")
+	require.NoError(t, err)
+	assert.False(t, estimate.Before(before))
+	assert.WithinDuration(t, time.Now(), estimate, time.Second)
+
+	// Once the payment is sent the buffer is settled and the time it took
+	// is used for later estimates.
+	memo, err := (&Memo{ID: "sending", Payments: []BufferedPayment{{Amount: 1}}}).MarshalBinary()
+	require.NoError(t, err)
+	go a.eventLoop()
+	ca := state.CloseAgreement{}
+	ca.Envelope.Details.Memo = memo
+	agentEvents <- agent.PaymentSentEvent{CloseAgreement: ca}
+	<-events
+	<-events
+	<-a.sendingReady
+	_, err = a.EstimatedSettlement("sending")
+	assert.ErrorIs(t, err, ErrUnknownBuffer)
+	a.mu.Lock()
+	assert.GreaterOrEqual(t, a.settleDuration, time.Hour)
+	a.mu.Unlock()
+
+	// A buffer whose payment fails is no longer waiting to be paid. The
+	// payment fails here because the underlying agent is not connected.
+	a.flush()
+	e := <-events
+	require.IsType(t, agent.ErrorEvent{}, e)
+	assert.EqualError(t, e.(agent.ErrorEvent).Err, "not connected")
+	_, err = a.EstimatedSettlement(bufferID)
+	assert.ErrorIs(t, err, ErrUnknownBuffer)
+	close(agentEvents)
+}
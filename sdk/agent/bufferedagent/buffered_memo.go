@@ -3,24 +3,61 @@ package bufferedagent
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 
 	"github.com/klauspost/compress/gzip"
 )
 
-type bufferedPaymentsMemo struct {
+// ErrNotBufferedPaymentsMemo indicates that a memo could not be parsed because
+// it is not the memo of a buffered payments agreement.
+var ErrNotBufferedPaymentsMemo = errors.New("not a buffered payments memo")
+
+// Memo is the memo of a payment agreement made by the buffered agent. It
+// contains the payments that were buffered and sent together in the
+// agreement.
+type Memo struct {
 	ID       string
 	Payments []BufferedPayment
+
+	// FractionDenominator is the number of units of a Fraction of the
+	// payments that make up one unit of the asset. It is zero if none of the
+	// payments have a fraction and no fraction was carried.
+	FractionDenominator int64
+	// FractionCarriedIn is the remainder of the fractions of previous
+	// buffers that was carried into this buffer, and FractionCarriedOut is
+	// the remainder of this buffer's fractions that was not paid and is
+	// carried forward to the next buffer.
+	FractionCarriedIn  int64
+	FractionCarriedOut int64
+}
+
+// ParseMemo parses the memo of a payment agreement made by the buffered agent,
+// such as the memo in the details of a state.CloseAgreement, and returns the
+// payments it contains. If the memo is not a buffered payments memo, an error
+// wrapping ErrNotBufferedPaymentsMemo is returned.
+//
+// The memo of an agreement is exchanged between the participants and is not
+// included in the transactions submitted to the network, so it cannot be
+// recovered from the ledger.
+func ParseMemo(memo []byte) (Memo, error) {
+	m := Memo{}
+	err := m.UnmarshalBinary(memo)
+	if err != nil {
+		return Memo{}, err
+	}
+	return m, nil
 }
 
-func (m *bufferedPaymentsMemo) MarshalBinary() ([]byte, error) {
+// MarshalBinary encodes the memo.
+func (m *Memo) MarshalBinary() ([]byte, error) {
 	b := bytes.Buffer{}
 	z, err := gzip.NewWriterLevel(&b, gzip.BestSpeed)
 	if err != nil {
 		panic(fmt.Errorf("creating gzip writer: %w", err))
 	}
 	enc := gob.NewEncoder(z)
-	type bpm bufferedPaymentsMemo
+	type bpm Memo
 	err = enc.Encode((*bpm)(m))
 	if err != nil {
 		return nil, fmt.Errorf("encoding buffered payments memo: %w", err)
@@ -29,17 +66,20 @@ func (m *bufferedPaymentsMemo) MarshalBinary() ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-func (m *bufferedPaymentsMemo) UnmarshalBinary(b []byte) error {
+// UnmarshalBinary decodes a memo encoded with MarshalBinary. If the bytes are
+// not an encoded memo, an error wrapping ErrNotBufferedPaymentsMemo is
+// returned.
+func (m *Memo) UnmarshalBinary(b []byte) error {
 	r := bytes.NewReader(b)
 	z, err := gzip.NewReader(r)
 	if err != nil {
-		return fmt.Errorf("creating gzip reader: %w", err)
+		return fmt.Errorf("%w: creating gzip reader: %v", ErrNotBufferedPaymentsMemo, err)
 	}
 	dec := gob.NewDecoder(z)
-	type bpm bufferedPaymentsMemo
+	type bpm Memo
 	err = dec.Decode((*bpm)(m))
 	if err != nil {
-		return fmt.Errorf("decoding buffered payments memo: %w", err)
+		return fmt.Errorf("%w: decoding buffered payments memo: %v", ErrNotBufferedPaymentsMemo, err)
 	}
 	return nil
 }
@@ -0,0 +1,45 @@
+package bufferedagent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMemo(t *testing.T) {
+	memo := Memo{
+		ID: "buffer-1",
+		Payments: []BufferedPayment{
+			{Amount: 1, Memo: "a"},
+			{Amount: 2, Memo: "b"},
+		},
+	}
+	b, err := memo.MarshalBinary()
+	require.NoError(t, err)
+
+	parsed, err := ParseMemo(b)
+	require.NoError(t, err)
+	assert.Equal(t, memo, parsed)
+}
+
+func TestParseMemo_notBufferedPaymentsMemo(t *testing.T) {
+	testCases := []struct {
+		name string
+		memo []byte
+	}{
+		{"empty", nil},
+		{"text", []byte("hello")},
+		{"truncated", func() []byte {
+			b, err := (&Memo{ID: "buffer-1"}).MarshalBinary()
+			require.NoError(t, err)
+			return b[:len(b)/2]
+		}()},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseMemo(tc.memo)
+			assert.ErrorIs(t, err, ErrNotBufferedPaymentsMemo)
+		})
+	}
+}
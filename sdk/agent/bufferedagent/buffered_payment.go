@@ -3,6 +3,17 @@ package bufferedagent
 // BufferedPayment contains the details of a payment that is buffered and
 // transmitted in the memo of an agreement on the payment channel.
 type BufferedPayment struct {
+	// Amount is the amount paid by the participant that buffered the payment
+	// to the other participant. A negative amount is a reverse entry that
+	// credits the participant that buffered it, such as for an amount the
+	// other participant owes, and is netted against the other payments in
+	// the same buffer. See Agent.PaymentWithMemo.
 	Amount int64
-	Memo   string
+	// Fraction is an amount smaller than the smallest unit of the asset, in
+	// units of one FractionDenominator of the Memo the payment was sent in.
+	// Fractions are accumulated and only whole units are paid, with any
+	// remainder carried forward to the next buffer. See
+	// Agent.PaymentWithFraction.
+	Fraction int64
+	Memo     string
 }
@@ -0,0 +1,51 @@
+package bufferedagent
+
+import (
+	"container/list"
+	"errors"
+)
+
+// ErrDuplicateBuffer indicates that a payment was received carrying a buffer
+// whose identifier was already received in an earlier payment.
+var ErrDuplicateBuffer = errors.New("duplicate buffer")
+
+// defaultMaxSeenBufferIDs is the number of received buffer IDs remembered if
+// no MaxSeenBufferIDs is configured.
+const defaultMaxSeenBufferIDs = 1024
+
+// seenIDs is a set of identifiers bounded in size, that forgets the least
+// recently seen identifier when adding an identifier to a full set.
+type seenIDs struct {
+	max      int
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newSeenIDs(max int) *seenIDs {
+	return &seenIDs{
+		max:      max,
+		order:    list.New(),
+		elements: map[string]*list.Element{},
+	}
+}
+
+// see adds the identifier to the set as the most recently seen, and returns
+// true if it was already in the set.
+func (s *seenIDs) see(id string) bool {
+	if e, ok := s.elements[id]; ok {
+		s.order.MoveToFront(e)
+		return true
+	}
+	s.elements[id] = s.order.PushFront(id)
+	if s.order.Len() > s.max {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.elements, oldest.Value.(string))
+	}
+	return false
+}
+
+// len returns the number of identifiers in the set.
+func (s *seenIDs) len() int {
+	return s.order.Len()
+}
@@ -0,0 +1,71 @@
+package bufferedagent
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stellar/starlight/sdk/agent"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeenIDs(t *testing.T) {
+	s := newSeenIDs(2)
+	assert.False(t, s.see("a"))
+	assert.False(t, s.see("b"))
+	assert.True(t, s.see("a"))
+
+	// Seeing a third identifier forgets the least recently seen, which is b
+	// because a was seen again.
+	assert.False(t, s.see("c"))
+	assert.Equal(t, 2, s.len())
+	assert.True(t, s.see("a"))
+	assert.False(t, s.see("b"))
+	assert.Equal(t, 2, s.len())
+}
+
+func TestAgent_eventLoop_duplicateBuffer(t *testing.T) {
+	agentEvents := make(chan interface{}, 3)
+	events := make(chan interface{}, 10)
+	a := &Agent{
+		logWriter:     io.Discard,
+		agentEvents:   agentEvents,
+		events:        events,
+		sendingReady:  make(chan struct{}, 1),
+		seenBufferIDs: newSeenIDs(defaultMaxSeenBufferIDs),
+	}
+
+	received := func(id string, iteration int64) agent.PaymentReceivedEvent {
+		memo := Memo{ID: id, Payments: []BufferedPayment{{Amount: 5}}}
+		memoBytes, err := memo.MarshalBinary()
+		require.NoError(t, err)
+		return agent.PaymentReceivedEvent{CloseAgreement: state.CloseAgreement{
+			Envelope: state.CloseEnvelope{Details: state.CloseDetails{
+				IterationNumber: iteration,
+				PaymentAmount:   5,
+				Memo:            memoBytes,
+			}},
+		}}
+	}
+	agentEvents <- received("buffer-1", 2)
+	agentEvents <- received("buffer-2", 3)
+	agentEvents <- received("buffer-1", 4)
+	close(agentEvents)
+	a.eventLoop()
+
+	ids := []string{}
+	errs := []error{}
+	for e := range events {
+		switch e := e.(type) {
+		case BufferedPaymentsReceivedEvent:
+			ids = append(ids, e.BufferID)
+		case agent.ErrorEvent:
+			errs = append(errs, e.Err)
+		}
+	}
+	assert.Equal(t, []string{"buffer-1", "buffer-2"}, ids)
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrDuplicateBuffer)
+	assert.EqualError(t, errs[0], "duplicate buffer: buffer buffer-1 received again at iteration 4")
+}
@@ -0,0 +1,53 @@
+package agent
+
+import "fmt"
+
+// CloseReason is why the channel closed.
+type CloseReason int
+
+const (
+	// CloseReasonUnknown is the reason of a close the agent did not observe
+	// being declared or coordinated.
+	CloseReasonUnknown CloseReason = iota
+	// CloseReasonCoordinated is the reason of a close that both participants
+	// agreed to close immediately, without waiting for the observation
+	// period.
+	CloseReasonCoordinated
+	// CloseReasonDeclaredByLocal is the reason of a close that this
+	// participant declared with DeclareClose and that was not coordinated.
+	CloseReasonDeclaredByLocal
+	// CloseReasonDeclaredByRemote is the reason of a close that the other
+	// participant declared and that was not coordinated.
+	CloseReasonDeclaredByRemote
+	// CloseReasonOutdatedDeclaration is the reason of a close that started
+	// with the declaration of an agreement older than the latest agreement.
+	CloseReasonOutdatedDeclaration
+)
+
+// String returns a name for the close reason.
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReasonUnknown:
+		return "unknown"
+	case CloseReasonCoordinated:
+		return "coordinated"
+	case CloseReasonDeclaredByLocal:
+		return "declared by local"
+	case CloseReasonDeclaredByRemote:
+		return "declared by remote"
+	case CloseReasonOutdatedDeclaration:
+		return "outdated declaration"
+	}
+	return fmt.Sprintf("CloseReason(%d)", int(r))
+}
+
+// setCloseReason records the reason for the close, unless a coordinated close
+// has already been agreed, since a coordinated close can be submitted
+// immediately and so is how the channel closes regardless of how the close
+// was declared. It must be called with the mutex locked.
+func (a *Agent) setCloseReason(r CloseReason) {
+	if a.closeReason == CloseReasonCoordinated {
+		return
+	}
+	a.closeReason = r
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ConnStats contains counts of the messages and bytes sent and received over
+// the agent's connection.
+type ConnStats struct {
+	MessagesSent     int64
+	MessagesReceived int64
+
+	// BytesSent and BytesReceived are the number of bytes written to and read
+	// from the connection, as encoded on the wire.
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// connCounters holds the counters of a connection. The counters are updated
+// and read atomically so that they may be read while the connection is in use.
+type connCounters struct {
+	messagesSent     int64
+	messagesReceived int64
+	bytesSent        int64
+	bytesReceived    int64
+}
+
+func (c *connCounters) stats() ConnStats {
+	return ConnStats{
+		MessagesSent:     atomic.LoadInt64(&c.messagesSent),
+		MessagesReceived: atomic.LoadInt64(&c.messagesReceived),
+		BytesSent:        atomic.LoadInt64(&c.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&c.bytesReceived),
+	}
+}
+
+// countingReader is a reader that adds the number of bytes read from the
+// underlying reader to a counter.
+type countingReader struct {
+	r     io.Reader
+	count *int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddInt64(r.count, int64(n))
+	return n, err
+}
+
+// countingWriter is a writer that adds the number of bytes written to the
+// underlying writer to a counter.
+type countingWriter struct {
+	w     io.Writer
+	count *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddInt64(w.count, int64(n))
+	return n, err
+}
+
+// ConnStats returns the counts of messages and bytes sent and received over
+// the most recent connection. The counts start at zero when a connection is
+// attached, and are zero if the agent has never been connected. ConnStats is
+// safe to call concurrently with any other method.
+func (a *Agent) ConnStats() ConnStats {
+	a.mu.Lock()
+	counters := a.connCounters
+	a.mu.Unlock()
+	if counters == nil {
+		return ConnStats{}
+	}
+	return counters.stats()
+}
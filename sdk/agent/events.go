@@ -1,7 +1,12 @@
 package agent
 
 import (
+	"crypto/tls"
+	"net"
+	"time"
+
 	"github.com/stellar/go/keypair"
+	"github.com/stellar/starlight/sdk/agent/msg"
 	"github.com/stellar/starlight/sdk/state"
 )
 
@@ -12,20 +17,102 @@ type ErrorEvent struct {
 }
 
 // ConnectedEvent occurs when the agent is connected to another participant.
+//
+// RemoteAddr is the network address of the other participant if the
+// connection provides it, such as a net.Conn, else nil. TLS is the state of
+// the connection if it is a TLS connection, such as a *tls.Conn given to
+// ServeConn, including the negotiated cipher suite and the peer's
+// certificates, else nil.
 type ConnectedEvent struct {
 	ChannelAccount *keypair.FromAddress
 	Signer         *keypair.FromAddress
+	RemoteAddr     net.Addr
+	TLS            *tls.ConnectionState
+}
+
+// ConnectFailedEvent occurs when the agent gives up connecting to the other
+// participant after connecting, because its hello could not be sent, or the
+// other participant did not send a hello within the configured hello timeout,
+// and contains the error that connecting failed with. The connection is
+// closed, and the agent can be connected again.
+type ConnectFailedEvent struct {
+	Err error
+}
+
+// DisconnectedEvent occurs when the connection to the other participant ends,
+// such as when the other participant closes it, and contains the error that
+// ended it. The agent can be connected again, and the channel and any
+// agreements are unaffected. It does not occur when the agent disconnects
+// itself with Shutdown.
+type DisconnectedEvent struct {
+	Err error
+}
+
+// InfoReceivedEvent occurs when the other participant sends info about itself
+// after connecting. See Config.Info.
+type InfoReceivedEvent struct {
+	Info msg.Info
 }
 
-// OpenedEvent occurs when the channel has been opened.
+// OpenedEvent occurs when the channel has been opened, which is when the open
+// transaction has been seen by the Streamer.
 type OpenedEvent struct {
 	OpenAgreement state.OpenAgreement
 }
 
+// OpenVerifiedEvent occurs when the agent is configured to verify open
+// balances and the balance collector reports that both channel accounts hold
+// at least the contributions of the open agreement. It is written before the
+// OpenedEvent.
+type OpenVerifiedEvent struct {
+	OpenAgreement state.OpenAgreement
+}
+
+// OpenMismatchEvent occurs when the agent is configured to verify open
+// balances and the balance collector reports that a channel account holds
+// less than its contribution in the open agreement, such as when a fee or
+// reserve was miscalculated. It contains the channel account, its expected
+// contribution, and the balance collected. It is written before the
+// OpenedEvent, and the channel should be closed.
+type OpenMismatchEvent struct {
+	OpenAgreement  state.OpenAgreement
+	ChannelAccount *keypair.FromAddress
+	Contribution   int64
+	Balance        int64
+}
+
+// OpenFailedEvent occurs when the open agreement has been authorized but the
+// channel did not open, either because submitting the open transaction
+// failed, or because the open transaction was not seen by the Streamer within
+// the configured open timeout. It contains the open agreement and the error.
+// The open transaction may still be executed before the open agreement
+// expires, in which case an OpenedEvent follows.
+type OpenFailedEvent struct {
+	OpenAgreement state.OpenAgreement
+	Err           error
+}
+
+// PaymentRequestedEvent occurs when the other participant proposes a payment,
+// before the payment is checked and confirmed, so that the payment can be
+// identified by its memo, e.g. to route a deposit to a user account. The memo
+// is the payment's bytes as proposed, and can be any bytes of any length if
+// the memo type is state.MemoTypeNone. The payment may still be rejected, and
+// is only received once a PaymentReceivedEvent with the same iteration number
+// occurs. See Config.PaymentApprover for deciding whether to confirm it.
+type PaymentRequestedEvent struct {
+	IterationNumber int64
+	Amount          int64
+	MemoType        state.MemoType
+	Memo            []byte
+}
+
 // PaymentReceivedEvent occurs when a payment is received and the balance it
 // agrees to would be the resulting disbursements from the channel if closed.
+// AppData is the application data the other participant sent with the
+// payment, if any. See Agent.PaymentWithAppData.
 type PaymentReceivedEvent struct {
 	CloseAgreement state.CloseAgreement
+	AppData        []byte
 }
 
 // PaymentSentEvent occurs when a payment is sent and the other participant has
@@ -35,13 +122,199 @@ type PaymentSentEvent struct {
 	CloseAgreement state.CloseAgreement
 }
 
+// PaymentRejectedEvent occurs when a payment that was sent is rejected by the
+// other participant, and contains the rejected agreement and the code and
+// reason given for the rejection. The rejected payment is discarded and a new
+// payment can be made.
+type PaymentRejectedEvent struct {
+	CloseAgreement state.CloseAgreement
+	Code           msg.RejectCode
+	Reason         string
+}
+
+// PaymentTimeoutEvent occurs when a payment that was sent is not confirmed by
+// the other participant within the configured payment timeout, or response
+// timeout if no payment timeout is set, and contains the abandoned agreement.
+// The payment is discarded, the other participant is told it was withdrawn,
+// and a new payment can be made. A confirmation of the payment that arrives
+// later is rejected.
+type PaymentTimeoutEvent struct {
+	CloseAgreement state.CloseAgreement
+}
+
+// OpenResponseTimeoutEvent occurs when an open that was proposed is not
+// confirmed by the other participant within the configured response timeout,
+// and contains the pending agreement. The open agreement is not discarded
+// because the other participant holds this participant's signatures for it,
+// and it expires at its ExpiresAt if it is never authorized.
+type OpenResponseTimeoutEvent struct {
+	OpenAgreement state.OpenAgreement
+}
+
+// OpenCanceledEvent occurs when an open proposed by the agent is discarded by
+// CancelOpen, and contains the discarded open agreement.
+type OpenCanceledEvent struct {
+	OpenAgreement state.OpenAgreement
+}
+
+// ChannelAccountRecoveredEvent occurs when RecoverUnopenedAccount merges the
+// agent's channel account into its creator.
+type ChannelAccountRecoveredEvent struct {
+	ChannelAccount *keypair.FromAddress
+	Creator        *keypair.FromAddress
+}
+
+// OpenRejectedEvent occurs when an open that was proposed is rejected by the
+// other participant, and contains the rejected agreement and the code and
+// reason given for the rejection. The open agreement expires at its ExpiresAt
+// if it is never authorized.
+type OpenRejectedEvent struct {
+	OpenAgreement state.OpenAgreement
+	Code          msg.RejectCode
+	Reason        string
+}
+
+// CloseRejectedEvent occurs when a coordinated close that was proposed is
+// rejected by the other participant, and contains the rejected agreement and
+// the code and reason given for the rejection. The rejected close is discarded.
+// The channel can still be closed by calling Close once the observation period
+// has passed.
+type CloseRejectedEvent struct {
+	CloseAgreement state.CloseAgreement
+	Code           msg.RejectCode
+	Reason         string
+}
+
+// CloseResponseTimeoutEvent occurs when a coordinated close that was proposed
+// is not confirmed by the other participant within the configured response
+// timeout, and contains the abandoned agreement. The close is discarded, in
+// the same way as a rejected close, and the channel can still be closed by
+// calling Close once the observation period has passed.
+type CloseResponseTimeoutEvent struct {
+	CloseAgreement state.CloseAgreement
+}
+
+// BalanceChangedEvent occurs when an ingested transaction changes the balance
+// of either participant's channel account, such as when a participant deposits
+// into their channel account, and contains the channel account and its
+// balance before and after the transaction.
+type BalanceChangedEvent struct {
+	ChannelAccount  *keypair.FromAddress
+	PreviousBalance int64
+	Balance         int64
+}
+
 // ClosingEvent occurs when the channel is closing and no new payments should be
 // proposed or confirmed.
 type ClosingEvent struct{}
 
 // ClosingWithOutdatedStateEvent occurs when the channel is closing and no new payments should be
 // proposed or confirmed, and the state it is closing in is not the latest known state.
+// Call ResumeClose to declare and close with the latest state.
 type ClosingWithOutdatedStateEvent struct{}
 
-// ClosedEvent occurs when the channel is successfully closed.
-type ClosedEvent struct{}
+// ChannelExpiringEvent occurs when the channel is within the configured
+// expiry warning threshold of an expiry, and contains the expiry and the time
+// it occurs.
+type ChannelExpiringEvent struct {
+	Expiry    Expiry
+	ExpiresAt time.Time
+}
+
+// StreamLagEvent occurs when the close time of the latest ledger the stream
+// has caught up to, as reported by the Streamer's StreamStatus, falls behind
+// the current time by more than the configured stream lag threshold, and
+// contains how far behind the stream is and the close time of that ledger.
+// While the stream is behind, the agent's view of the channel is stale and it
+// may not see the other participant declare a close with an outdated agreement
+// in time to respond. The event is written again only after the stream has
+// caught up and fallen behind again.
+type StreamLagEvent struct {
+	Lag                   time.Duration
+	LatestLedgerCloseTime time.Time
+}
+
+// StreamErrorEvent occurs when a Streamer that implements
+// StreamStatusReporter reports an error with its stream, and contains the
+// error. The event is written once for each error reported.
+type StreamErrorEvent struct {
+	Err error
+}
+
+// PausedEvent occurs when the agent is paused, and stops accepting new opens
+// and payments until it is resumed.
+type PausedEvent struct{}
+
+// ResumedEvent occurs when a paused agent is resumed, and accepts opens and
+// payments again.
+type ResumedEvent struct{}
+
+// StreamClosedEvent occurs when the Streamer closes its stream of
+// transactions before the channel is closed, such as after a fatal error,
+// and contains the cursor of the last transaction ingested. While the stream
+// is closed the agent does not see transactions that affect the channel,
+// including the other participant declaring a close. Resubscribing is true
+// if the agent will subscribe to the Streamer again from the cursor after the
+// configured stream resubscribe delay.
+type StreamClosedEvent struct {
+	Cursor        string
+	Resubscribing bool
+}
+
+// ReconciledEvent occurs when the agent has compared its latest authorized
+// agreement with the other participant's, either because Reconcile was called
+// and the other participant replied, or because the other participant
+// reconciled and the agent caught up. It contains the latest authorized
+// agreement after reconciling. CaughtUp is true if the agent was behind and
+// caught up to the other participant's agreement.
+type ReconciledEvent struct {
+	CloseAgreement state.CloseAgreement
+	CaughtUp       bool
+}
+
+// SignerRotationConfirmedEvent occurs when a signer rotation proposed with
+// Agent.RotateSigner has been signed by both participants, and contains the
+// rotation. It occurs for the confirmer when it confirms the rotation, and
+// for the proposer when it receives the confirmation and submits the rotation
+// transaction. Until a SignerRotatedEvent or SignerRotationFailedEvent
+// occurs, no payments or closes can be agreed.
+type SignerRotationConfirmedEvent struct {
+	Rotation state.SignerRotation
+}
+
+// SignerRotationRejectedEvent occurs when a signer rotation that was proposed
+// is rejected by the other participant, and contains the rejected rotation and
+// the code and reason given for the rejection. The rejected rotation is
+// discarded.
+type SignerRotationRejectedEvent struct {
+	Rotation state.SignerRotation
+	Code     msg.RejectCode
+	Reason   string
+}
+
+// SignerRotationFailedEvent occurs when a signer rotation that both
+// participants signed is discarded because its transaction failed or expired
+// without executing, and contains the rotation and the error. The signers are
+// unchanged, and the latest agreement signed by the old signer remains valid.
+type SignerRotationFailedEvent struct {
+	Rotation state.SignerRotation
+	Err      error
+}
+
+// SignerRotatedEvent occurs when the transaction of a signer rotation is seen
+// by the Streamer, and contains the signer that was replaced, the signer that
+// replaced it, and the close agreement signed for the new signer that is now
+// the latest agreement. If the local signer was rotated, an agent restored
+// from a snapshot must be given the new signer in
+// Config.ChannelAccountSigner.
+type SignerRotatedEvent struct {
+	OldSigner      *keypair.FromAddress
+	NewSigner      *keypair.FromAddress
+	CloseAgreement state.CloseAgreement
+}
+
+// ClosedEvent occurs when the channel is successfully closed, and contains
+// the reason for the close.
+type ClosedEvent struct {
+	Reason CloseReason
+}
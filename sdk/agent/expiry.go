@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// expiryCheckInterval is how often the agent checks whether the channel is
+// approaching an expiry when an ExpiryWarningThreshold is configured.
+const expiryCheckInterval = time.Second
+
+// Expiry is a deadline that the channel can approach and that an operator may
+// need to act before.
+type Expiry int
+
+const (
+	// ExpiryOpen is the expiry of the open agreement. If the open transaction
+	// has not been executed by the time the open agreement expires, the
+	// channel can no longer be opened with it.
+	ExpiryOpen Expiry = iota
+
+	// ExpiryObservationPeriod is the end of the observation period that
+	// follows the other participant declaring a close with an outdated
+	// agreement. Once the observation period ends, the close transaction of
+	// the outdated agreement can be submitted, and so a close using the
+	// latest agreement should be declared before then.
+	ExpiryObservationPeriod
+)
+
+func (e Expiry) String() string {
+	switch e {
+	case ExpiryOpen:
+		return "open"
+	case ExpiryObservationPeriod:
+		return "observation period"
+	}
+	return fmt.Sprintf("Expiry(%d)", int(e))
+}
+
+// expiryLoop periodically checks whether the channel is approaching an expiry
+// until the channel reaches a state where no expiry remains, or is discarded.
+func (a *Agent) expiryLoop(channel *state.Channel) {
+	ticker := time.NewTicker(expiryCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		a.mu.Lock()
+		more := a.channel == channel && a.warnExpiring(now)
+		a.mu.Unlock()
+		if !more {
+			break
+		}
+	}
+}
+
+// warnExpiring writes a ChannelExpiringEvent for each expiry of the channel
+// that is within the expiry warning threshold of now. An event is written
+// once for each expiry time. It returns false if the channel can no longer
+// approach any expiry, else true. It must be called with the mutex locked.
+func (a *Agent) warnExpiring(now time.Time) bool {
+	if a.channel == nil {
+		return true
+	}
+	s, err := a.channel.State()
+	if err != nil {
+		return true
+	}
+	switch s {
+	case state.StateNone:
+		open := a.channel.OpenAgreement()
+		if open.Envelope.Empty() {
+			return true
+		}
+		expiresAt := open.Envelope.Details.ExpiresAt
+		if !now.Before(expiresAt) {
+			return false
+		}
+		a.warnExpiry(now, ExpiryOpen, expiresAt)
+	case state.StateClosingWithOutdatedState:
+		if a.closeDeclaredAt.IsZero() {
+			return true
+		}
+		a.warnExpiry(now, ExpiryObservationPeriod, a.closeAvailableAt())
+	case state.StateError, state.StateClosed, state.StateClosedWithOutdatedState:
+		return false
+	}
+	return true
+}
+
+// warnExpiry writes a ChannelExpiringEvent for the expiry if it is within the
+// expiry warning threshold of now and has not already been written for the
+// expiry time.
+func (a *Agent) warnExpiry(now time.Time, expiry Expiry, expiresAt time.Time) {
+	if a.expiryWarningThreshold <= 0 || now.Before(expiresAt.Add(-a.expiryWarningThreshold)) {
+		return
+	}
+	if warned, ok := a.expiryWarnings[expiry]; ok && warned.Equal(expiresAt) {
+		return
+	}
+	if a.expiryWarnings == nil {
+		a.expiryWarnings = map[Expiry]time.Time{}
+	}
+	a.expiryWarnings[expiry] = expiresAt
+	fmt.Fprintf(a.logWriter, "channel %s expiry approaching: %v\n", expiry, expiresAt)
+	if a.events != nil {
+		a.events <- ChannelExpiringEvent{Expiry: expiry, ExpiresAt: expiresAt}
+	}
+}
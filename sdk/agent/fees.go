@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/stellar/go/txnbuild"
+)
+
+// ErrFeesUnknown indicates that fees cannot be estimated because the
+// Submitter does not implement FeeReporter.
+var ErrFeesUnknown = errors.New("submitter does not report its base fee")
+
+// TransactionFee is the fee in stroops charged for submitting a transaction
+// of the channel.
+type TransactionFee struct {
+	// Operations is the number of operations in the transaction.
+	Operations int
+	// FeeBump is true if the transaction is wrapped in a fee bump
+	// transaction, which is charged for one more operation than the
+	// transaction it wraps.
+	FeeBump bool
+	// Fee is the fee charged, including the fee bump transaction.
+	Fee int64
+}
+
+// FeeBreakdown is the fees charged for submitting each transaction of the
+// channel, at the base fee of the Submitter.
+type FeeBreakdown struct {
+	BaseFee     int64
+	Open        TransactionFee
+	Declaration TransactionFee
+	Close       TransactionFee
+}
+
+// Total returns the fees charged for opening the channel and then declaring
+// and closing it.
+func (f FeeBreakdown) Total() int64 {
+	return f.Open.Fee + f.Declaration.Fee + f.Close.Fee
+}
+
+// EstimatedFees returns the fees that are charged for submitting the open
+// transaction of the channel and the declaration and close transactions of
+// its latest close agreement, at the base fee reported by the Submitter. The
+// transactions are the same ones the agent submits, and are not submitted.
+// An open must have been proposed, and the Submitter must implement
+// FeeReporter, otherwise ErrFeesUnknown is returned.
+func (a *Agent) EstimatedFees() (FeeBreakdown, error) {
+	reporter, ok := a.submitter.(FeeReporter)
+	if !ok {
+		return FeeBreakdown{}, ErrFeesUnknown
+	}
+	baseFee := reporter.SubmitBaseFee()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return FeeBreakdown{}, fmt.Errorf("no channel")
+	}
+	open := a.channel.OpenAgreement()
+	if open.Envelope.Empty() {
+		return FeeBreakdown{}, fmt.Errorf("no open agreement")
+	}
+	closeTxs := a.channel.LatestCloseAgreement().Transactions
+	if closeTxs.Declaration == nil || closeTxs.Close == nil {
+		closeTxs = open.CloseTransactions
+	}
+	return FeeBreakdown{
+		BaseFee:     baseFee,
+		Open:        transactionFee(open.Transactions.Open, baseFee),
+		Declaration: transactionFee(closeTxs.Declaration, baseFee),
+		Close:       transactionFee(closeTxs.Close, baseFee),
+	}, nil
+}
+
+// transactionFee returns the fee charged for submitting the transaction at
+// the base fee, in the same way that a Submitter wraps a transaction with a
+// lower base fee in a fee bump transaction.
+func transactionFee(tx *txnbuild.Transaction, baseFee int64) TransactionFee {
+	ops := len(tx.Operations())
+	if tx.BaseFee() < baseFee {
+		return TransactionFee{Operations: ops, FeeBump: true, Fee: baseFee * int64(ops+1)}
+	}
+	return TransactionFee{Operations: ops, Fee: tx.BaseFee() * int64(ops)}
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultConnectRetryBackoff is how long ConnectTCP waits before its first
+// retry if no backoff is configured.
+const defaultConnectRetryBackoff = 100 * time.Millisecond
+
+// ErrHelloTimeout indicates that the other participant did not send a hello
+// within the configured hello timeout after connecting.
+var ErrHelloTimeout = errors.New("timed out waiting for hello")
+
+// handshake sends a hello on the attached connection. If the hello cannot be
+// sent, the connection is closed and the error is returned.
+func (a *Agent) handshake() error {
+	a.mu.Lock()
+	counters := a.connCounters
+	a.mu.Unlock()
+
+	err := a.hello()
+	if err != nil {
+		a.mu.Lock()
+		if a.connCounters == counters {
+			closeErr := a.closeConn()
+			if closeErr != nil {
+				fmt.Fprintf(a.logWriter, "closing connection: %v\n", closeErr)
+			}
+		}
+		a.mu.Unlock()
+		return fmt.Errorf("sending hello: %w", err)
+	}
+	a.watchHello(counters)
+	go a.receiveLoop()
+	return nil
+}
+
+// dialAndHandshake dials the address and performs the handshake on the new
+// connection, retrying with backoff up to the configured number of connect
+// retries if either fails. It returns whether the last attempt failed after
+// the connection was established, and the error of the last attempt.
+func (a *Agent) dialAndHandshake(addr string) (handshakeFailed bool, err error) {
+	backoff := a.connectRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultConnectRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		var conn net.Conn
+		conn, err = net.Dial("tcp", addr)
+		handshakeFailed = false
+		if err != nil {
+			err = fmt.Errorf("connecting to %s: %w", addr, err)
+		} else {
+			fmt.Fprintf(a.logWriter, "connected to %v\n", conn.RemoteAddr())
+			a.setKeepAlive(conn)
+			a.attachConn(conn)
+			err = a.handshake()
+			handshakeFailed = err != nil
+		}
+		if err == nil || attempt >= a.connectRetries {
+			return handshakeFailed, err
+		}
+		fmt.Fprintf(a.logWriter, "retrying connect in %v: %v\n", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// connectFailed writes a ConnectFailedEvent with the error that connecting
+// failed with.
+func (a *Agent) connectFailed(reason error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fmt.Fprintf(a.logWriter, "connect failed: %v\n", reason)
+	if a.events != nil {
+		a.events <- ConnectFailedEvent{Err: reason}
+	}
+}
+
+// watchHello closes the connection with the counters and writes a
+// ConnectFailedEvent if the other participant has not sent a hello on it when
+// the hello timeout passes.
+func (a *Agent) watchHello(counters *connCounters) {
+	if a.helloTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(a.helloTimeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.conn == nil || a.connCounters != counters || a.helloReceived {
+			return
+		}
+		reason := fmt.Errorf("%w after %v", ErrHelloTimeout, a.helloTimeout)
+		fmt.Fprintf(a.logWriter, "connect failed: %v\n", reason)
+		err := a.closeConn()
+		if err != nil {
+			fmt.Fprintf(a.logWriter, "closing connection: %v\n", err)
+		}
+		if a.events != nil {
+			a.events <- ConnectFailedEvent{Err: reason}
+		}
+	})
+}
@@ -6,11 +6,7 @@ import (
 	"github.com/stellar/go/txnbuild"
 )
 
-func hashTx(txXDR, networkPassphrase string) (string, error) {
-	tx, err := txnbuild.TransactionFromXDR(txXDR)
-	if err != nil {
-		return "", fmt.Errorf("parsing transaction xdr: %w", err)
-	}
+func hashTx(tx *txnbuild.GenericTransaction, networkPassphrase string) (string, error) {
 	if feeBump, ok := tx.FeeBump(); ok {
 		hash, err := feeBump.HashHex(networkPassphrase)
 		if err != nil {
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// Healthy returns true if the agent is connected to the other participant,
+// its stream of transactions is flowing, and its channel, if it has one, is
+// in a consistent state. If the agent is not healthy, the reason is returned.
+// It is intended for use by readiness and liveness checks.
+//
+// The stream is considered not to be flowing if a Streamer that implements
+// StreamStatusReporter reports an error, or if a StreamLagThreshold is
+// configured and the stream has fallen behind by more than it. See
+// Config.StreamLagThreshold for how the lag is measured.
+func (a *Agent) Healthy() (healthy bool, reason string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.shuttingDown {
+		return false, "shutting down"
+	}
+	if a.conn == nil {
+		return false, "not connected"
+	}
+	if a.channel == nil {
+		return true, "connected"
+	}
+
+	s, err := a.channel.State()
+	if err != nil {
+		return false, fmt.Sprintf("channel state: %v", err)
+	}
+	if s == state.StateError {
+		return false, "channel open executed with error"
+	}
+
+	if a.streamer == nil {
+		return true, "connected, channel " + stateName(s)
+	}
+	status, reported := a.streamStatus()
+	if status.Err != nil {
+		return false, fmt.Sprintf("stream error: %v", status.Err)
+	}
+	if a.streamLagThreshold > 0 {
+		if lag := a.streamLag(time.Now(), status, reported); lag > a.streamLagThreshold {
+			return false, fmt.Sprintf("stream lagging by %v", lag.Round(time.Second))
+		}
+	}
+	return true, "connected, channel " + stateName(s)
+}
+
+// stateName returns a readable name of the channel state.
+func stateName(s state.State) string {
+	switch s {
+	case state.StateNone:
+		return "not open"
+	case state.StateOpen:
+		return "open"
+	case state.StateClosingWithOutdatedState:
+		return "closing with outdated state"
+	case state.StateClosedWithOutdatedState:
+		return "closed with outdated state"
+	case state.StateClosing:
+		return "closing"
+	case state.StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("state %d", int(s))
+}
@@ -11,7 +11,10 @@ import (
 	"github.com/stellar/starlight/sdk/state"
 )
 
-var _ agent.BalanceCollector = &BalanceCollector{}
+var (
+	_ agent.BalanceCollector      = &BalanceCollector{}
+	_ agent.MultiBalanceCollector = &BalanceCollector{}
+)
 
 // BalanceCollector implements an agent's interface for collecting balances by
 // querying Horizon's accounts endpoint for the balance.
@@ -24,6 +27,9 @@ type BalanceCollector struct {
 func (h *BalanceCollector) GetBalance(accountID *keypair.FromAddress, asset state.Asset) (int64, error) {
 	var account horizon.Account
 	account, err := h.HorizonClient.AccountDetail(horizonclient.AccountRequest{AccountID: accountID.Address()})
+	if horizonclient.IsNotFoundError(err) {
+		return 0, fmt.Errorf("getting account details of %s: %w", accountID, agent.ErrAccountNotFound)
+	}
 	if err != nil {
 		return 0, fmt.Errorf("getting account details of %s: %w", accountID, err)
 	}
@@ -38,3 +44,34 @@ func (h *BalanceCollector) GetBalance(accountID *keypair.FromAddress, asset stat
 	}
 	return 0, nil
 }
+
+// GetBalances queries Horizon once for the balances of the given assets on the
+// given account. Assets the account does not hold have a zero balance.
+func (h *BalanceCollector) GetBalances(accountID *keypair.FromAddress, assets []state.Asset) (map[state.Asset]int64, error) {
+	account, err := h.HorizonClient.AccountDetail(horizonclient.AccountRequest{AccountID: accountID.Address()})
+	if horizonclient.IsNotFoundError(err) {
+		return nil, fmt.Errorf("getting account details of %s: %w", accountID, agent.ErrAccountNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting account details of %s: %w", accountID, err)
+	}
+	balances := make(map[state.Asset]int64, len(assets))
+	for _, asset := range assets {
+		balances[asset] = 0
+		for _, b := range account.Balances {
+			if asset.IsNative() != (b.Asset.Type == "native") {
+				continue
+			}
+			if !asset.IsNative() && (b.Asset.Code != asset.Code() || b.Asset.Issuer != asset.Issuer()) {
+				continue
+			}
+			balance, err := amount.ParseInt64(b.Balance)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s balance of %s: %w", asset, accountID, err)
+			}
+			balances[asset] = balance
+			break
+		}
+	}
+	return balances, nil
+}
@@ -0,0 +1,60 @@
+package horizon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/starlight/sdk/agent/submit"
+)
+
+var _ submit.BaseFeeProvider = &BaseFeeProvider{}
+
+// defaultBaseFeeTTL is how long a BaseFeeProvider uses the base fee it
+// queried before querying again if no TTL is configured, which is about the
+// time it takes for a ledger to close.
+const defaultBaseFeeTTL = 5 * time.Second
+
+// BaseFeeProvider implements the submit package's interface for providing a
+// base fee, via Horizon's fee stats. The base fee provided is the 90th
+// percentile of the max fees offered by transactions in recent ledgers, so
+// that transactions are included promptly when the network is congested.
+//
+// The base fee queried is cached for the TTL, which defaults to 5 seconds. If
+// MaxBaseFee is set, the base fee provided is no larger than it.
+type BaseFeeProvider struct {
+	HorizonClient horizonclient.ClientInterface
+	TTL           time.Duration
+	MaxBaseFee    int64
+
+	mu        sync.Mutex
+	baseFee   int64
+	queriedAt time.Time
+}
+
+// BaseFee returns the base fee recommended by Horizon's fee stats.
+func (h *BaseFeeProvider) BaseFee() (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ttl := h.TTL
+	if ttl <= 0 {
+		ttl = defaultBaseFeeTTL
+	}
+	if !h.queriedAt.IsZero() && time.Since(h.queriedAt) < ttl {
+		return h.baseFee, nil
+	}
+
+	feeStats, err := h.HorizonClient.FeeStats()
+	if err != nil {
+		return 0, fmt.Errorf("getting fee stats: %w", buildErr(err))
+	}
+	baseFee := feeStats.MaxFee.P90
+	if h.MaxBaseFee > 0 && baseFee > h.MaxBaseFee {
+		baseFee = h.MaxBaseFee
+	}
+	h.baseFee = baseFee
+	h.queriedAt = time.Now()
+	return baseFee, nil
+}
@@ -0,0 +1,44 @@
+package horizon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/protocols/horizon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseFeeProvider_BaseFee(t *testing.T) {
+	client := &horizonclient.MockClient{}
+	h := BaseFeeProvider{HorizonClient: client, TTL: time.Hour, MaxBaseFee: 1000}
+
+	// The first call queries the fee stats.
+	client.On("FeeStats").Return(horizon.FeeStats{MaxFee: horizon.FeeDistribution{P90: 200}}, nil).Once()
+	baseFee, err := h.BaseFee()
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), baseFee)
+
+	// Calls within the TTL use the cached base fee.
+	baseFee, err = h.BaseFee()
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), baseFee)
+	client.AssertNumberOfCalls(t, "FeeStats", 1)
+
+	// Once the TTL has passed the fee stats are queried again, and the base
+	// fee is capped at the max base fee.
+	h.queriedAt = time.Now().Add(-time.Hour)
+	client.On("FeeStats").Return(horizon.FeeStats{MaxFee: horizon.FeeDistribution{P90: 5000}}, nil).Once()
+	baseFee, err = h.BaseFee()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), baseFee)
+
+	// Errors querying the fee stats are returned.
+	h.queriedAt = time.Time{}
+	client.On("FeeStats").Return(horizon.FeeStats{}, errors.New("horizon unavailable")).Once()
+	_, err = h.BaseFee()
+	assert.EqualError(t, err, "getting fee stats: horizon unavailable")
+	client.AssertExpectations(t)
+}
@@ -19,6 +19,9 @@ type SequenceNumberCollector struct {
 // GetSequenceNumber queries Horizon for the balance of the given account.
 func (h *SequenceNumberCollector) GetSequenceNumber(accountID *keypair.FromAddress) (int64, error) {
 	account, err := h.HorizonClient.AccountDetail(horizonclient.AccountRequest{AccountID: accountID.Address()})
+	if horizonclient.IsNotFoundError(err) {
+		return 0, fmt.Errorf("getting account details of %s: %w", accountID, agent.ErrAccountNotFound)
+	}
 	if err != nil {
 		return 0, fmt.Errorf("getting account details of %s: %w", accountID, err)
 	}
@@ -2,8 +2,11 @@ package horizon
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/stellar/go/clients/horizonclient"
 	"github.com/stellar/go/keypair"
@@ -12,6 +15,7 @@ import (
 )
 
 var _ agent.Streamer = &Streamer{}
+var _ agent.StreamStatusReporter = &Streamer{}
 
 // Streamer implements the agent's interface for streaming transactions that
 // affect a set of accounts, by using the streaming endpoints of Horizon's API
@@ -19,6 +23,34 @@ var _ agent.Streamer = &Streamer{}
 type Streamer struct {
 	HorizonClient horizonclient.ClientInterface
 	ErrorHandler  func(error)
+
+	// HeartbeatInterval is how often the latest ledger of a stream that has
+	// received no transactions is advanced to the latest ledger of Horizon.
+	// Defaults to 5 seconds.
+	HeartbeatInterval time.Duration
+
+	statusMu   sync.Mutex
+	status     agent.StreamStatus
+	txReceived bool
+}
+
+// StreamStatus returns the status of the most recent stream started with
+// StreamTx. The stream is considered connected once Horizon has sent it a
+// transaction, and disconnected when the connection to Horizon ends with an
+// error, until a transaction is received again. The latest ledger is that of
+// the latest transaction received, or while no transactions are being
+// received and there is no error, the latest ledger of Horizon, so that a
+// stream of accounts with no activity does not appear to fall behind.
+func (h *Streamer) StreamStatus() agent.StreamStatus {
+	h.statusMu.Lock()
+	defer h.statusMu.Unlock()
+	return h.status
+}
+
+func (h *Streamer) updateStatus(f func(s *agent.StreamStatus)) {
+	h.statusMu.Lock()
+	defer h.statusMu.Unlock()
+	f(&h.status)
 }
 
 // StreamTx streams transactions that affect the given accounts, sending each
@@ -26,7 +58,8 @@ type Streamer struct {
 // the cancel function returned. If multiple accounts are given the same
 // transaction it may be broadcasted in duplicate if the transaction affects more
 // than one account being monitored. The given cursor suppors resuming a
-// previous stream.
+// previous stream. A cursor for resuming from a ledger or a time can be
+// obtained from CursorForLedger or CursorForTime.
 //
 // TODO: Improve StreamTx so that it only streams transactions that affect the
 // given accounts. At the moment, to reduce complexity and due to limitations in
@@ -36,15 +69,23 @@ func (h *Streamer) StreamTx(cursor string, accounts ...*keypair.FromAddress) (tx
 	// txsCh is the channel that streamed transactions will be written to.
 	txsCh := make(chan agent.StreamedTransaction)
 
+	h.statusMu.Lock()
+	h.status = agent.StreamStatus{}
+	h.txReceived = false
+	h.statusMu.Unlock()
+
 	// cancelCh will be used to signal the streamer to stop.
 	cancelCh := make(chan struct{})
 
 	// Start a streamer that will write txs and stop when
 	// signaled to cancel.
+	done := make(chan struct{})
 	go func() {
 		defer close(txsCh)
+		defer close(done)
 		h.streamTx(cursor, txsCh, cancelCh)
 	}()
+	go h.heartbeat(done)
 
 	cancelOnce := sync.Once{}
 	cancel = func() {
@@ -76,9 +117,18 @@ func (h *Streamer) streamTx(cursor string, txs chan<- agent.StreamedTransaction,
 				return
 			}
 			cursor = pagingToken
+			h.updateStatus(func(s *agent.StreamStatus) {
+				h.txReceived = true
+				s.Connected = true
+				s.LatestLedger = int64(tx.Ledger)
+				s.LatestLedgerCloseTime = tx.LedgerCloseTime
+				s.Err = nil
+			})
 			streamedTx := agent.StreamedTransaction{
 				Cursor:             cursor,
 				TransactionOrderID: txOrderID,
+				Ledger:             int64(tx.Ledger),
+				LedgerCloseTime:    tx.LedgerCloseTime,
 				TransactionXDR:     tx.EnvelopeXdr,
 				ResultXDR:          tx.ResultXdr,
 				ResultMetaXDR:      tx.ResultMetaXdr,
@@ -92,8 +142,112 @@ func (h *Streamer) streamTx(cursor string, txs chan<- agent.StreamedTransaction,
 		if err == nil {
 			break
 		}
+		h.updateStatus(func(s *agent.StreamStatus) {
+			s.Connected = false
+			s.Err = err
+		})
 		if h.ErrorHandler != nil {
 			h.ErrorHandler(err)
 		}
 	}
 }
+
+// heartbeat advances the latest ledger of the stream's status to the latest
+// ledger of Horizon each HeartbeatInterval in which the stream received no
+// transactions and has no error, until done is closed. A stream that is
+// waiting for new transactions has seen every ledger Horizon has.
+func (h *Streamer) heartbeat(done <-chan struct{}) {
+	interval := h.HeartbeatInterval
+	if interval == 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		idle := false
+		h.updateStatus(func(s *agent.StreamStatus) {
+			idle = !h.txReceived && s.Err == nil
+			h.txReceived = false
+		})
+		if !idle {
+			continue
+		}
+		root, err := h.HorizonClient.Root()
+		if err != nil {
+			if h.ErrorHandler != nil {
+				h.ErrorHandler(fmt.Errorf("getting horizon root: %w", err))
+			}
+			continue
+		}
+		h.updateStatus(func(s *agent.StreamStatus) {
+			// A transaction or error received while getting the root is
+			// more recent than the root.
+			if h.txReceived || s.Err != nil {
+				return
+			}
+			if int64(root.HorizonSequence) > s.LatestLedger {
+				s.LatestLedger = int64(root.HorizonSequence)
+				s.LatestLedgerCloseTime = root.HorizonLatestClosedAt
+			}
+		})
+	}
+}
+
+// CursorForLedger returns a cursor that resumes streaming with StreamTx from
+// the first transaction in the ledger with the given sequence number.
+func (h *Streamer) CursorForLedger(ledger int64) (string, error) {
+	if ledger <= 0 || ledger > math.MaxInt32 {
+		return "", fmt.Errorf("invalid ledger sequence number %d", ledger)
+	}
+	// Horizon's paging tokens for transactions are the transaction's TOID, which
+	// holds the ledger sequence number in its upper 32 bits, and the
+	// transaction's order within the ledger, starting at one, in its lower
+	// bits. The TOID with only the ledger sequence number set is the paging
+	// token immediately before the first transaction of the ledger.
+	return strconv.FormatInt(ledger<<32, 10), nil
+}
+
+// CursorForTime returns a cursor that resumes streaming with StreamTx from the
+// first transaction in the first ledger that closed at or after the given
+// time. It can be used to resume streaming when the cursor of a previous
+// stream is lost, but the time the stream stopped is roughly known. If the
+// time is before the oldest ledger held by Horizon, streaming resumes from the
+// oldest ledger. If the time is after the latest ledger, streaming resumes
+// from the next ledger to close.
+//
+// The ledger is found with a binary search using Horizon's ledger endpoint,
+// and so it may make a number of requests to Horizon proportional to the log
+// of the number of ledgers Horizon holds.
+func (h *Streamer) CursorForTime(t time.Time) (string, error) {
+	root, err := h.HorizonClient.Root()
+	if err != nil {
+		return "", fmt.Errorf("getting horizon root: %w", err)
+	}
+	low, high := int64(root.HistoryElderSequence), int64(root.HorizonSequence)
+	if low <= 0 || high < low {
+		return "", fmt.Errorf("horizon has no ledger history")
+	}
+	if !t.After(root.HorizonLatestClosedAt) {
+		// Find the first ledger in [low, high] that closed at or after t.
+		for low < high {
+			mid := low + (high-low)/2
+			ledger, err := h.HorizonClient.LedgerDetail(uint32(mid))
+			if err != nil {
+				return "", fmt.Errorf("getting ledger %d: %w", mid, err)
+			}
+			if ledger.ClosedAt.Before(t) {
+				low = mid + 1
+			} else {
+				high = mid
+			}
+		}
+	} else {
+		low = high + 1
+	}
+	return h.CursorForLedger(low)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stellar/go/clients/horizonclient"
 	"github.com/stellar/go/keypair"
@@ -11,6 +12,7 @@ import (
 	"github.com/stellar/starlight/sdk/agent"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStreamer_StreamTx_longBlock(t *testing.T) {
@@ -27,10 +29,12 @@ func TestStreamer_StreamTx_longBlock(t *testing.T) {
 		ctx := args[0].(context.Context)
 		handler := args[2].(horizonclient.TransactionHandler)
 		handler(horizon.Transaction{
-			PT:            "1",
-			EnvelopeXdr:   "a-txxdr",
-			ResultXdr:     "a-resultxdr",
-			ResultMetaXdr: "a-resultmetaxdr",
+			PT:              "1",
+			Ledger:          2,
+			LedgerCloseTime: time.Unix(1000, 0),
+			EnvelopeXdr:     "a-txxdr",
+			ResultXdr:       "a-resultxdr",
+			ResultMetaXdr:   "a-resultmetaxdr",
 		})
 		// Simulate long block on new data from Horizon.
 		<-ctx.Done()
@@ -51,6 +55,8 @@ func TestStreamer_StreamTx_longBlock(t *testing.T) {
 			{
 				Cursor:             "1",
 				TransactionOrderID: 1,
+				Ledger:             2,
+				LedgerCloseTime:    time.Unix(1000, 0),
 				TransactionXDR:     "a-txxdr",
 				ResultXDR:          "a-resultxdr",
 				ResultMetaXDR:      "a-resultmetaxdr",
@@ -236,3 +242,153 @@ func TestHorizonStreamer_StreamTx_error(t *testing.T) {
 	_, open := <-txsCh
 	assert.False(t, open, "txs channel not closed but should be after cancel called")
 }
+
+func TestStreamer_CursorForLedger(t *testing.T) {
+	h := Streamer{}
+
+	cursor, err := h.CursorForLedger(2)
+	require.NoError(t, err)
+	assert.Equal(t, "8589934592", cursor)
+
+	_, err = h.CursorForLedger(0)
+	assert.EqualError(t, err, "invalid ledger sequence number 0")
+}
+
+func TestStreamer_CursorForTime(t *testing.T) {
+	client := &horizonclient.MockClient{}
+	h := Streamer{HorizonClient: client}
+
+	// Ledgers 10 to 20 closed every 5 seconds from time 1000.
+	client.On("Root").Return(horizon.Root{
+		HistoryElderSequence:  10,
+		HorizonSequence:       20,
+		HorizonLatestClosedAt: time.Unix(1050, 0),
+	}, nil)
+	for seq := uint32(10); seq <= 20; seq++ {
+		client.On("LedgerDetail", seq).Return(horizon.Ledger{
+			Sequence: int32(seq),
+			ClosedAt: time.Unix(1000+5*int64(seq-10), 0),
+		}, nil)
+	}
+
+	testCases := []struct {
+		time   time.Time
+		ledger int64
+	}{
+		{time.Unix(900, 0), 10},
+		{time.Unix(1000, 0), 10},
+		{time.Unix(1001, 0), 11},
+		{time.Unix(1025, 0), 15},
+		{time.Unix(1049, 0), 20},
+		{time.Unix(1050, 0), 20},
+		{time.Unix(1100, 0), 21},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.time.String(), func(t *testing.T) {
+			cursor, err := h.CursorForTime(tc.time)
+			require.NoError(t, err)
+			wantCursor, err := h.CursorForLedger(tc.ledger)
+			require.NoError(t, err)
+			assert.Equal(t, wantCursor, cursor)
+		})
+	}
+}
+
+func TestStreamer_CursorForTime_rootError(t *testing.T) {
+	client := &horizonclient.MockClient{}
+	h := Streamer{HorizonClient: client}
+	client.On("Root").Return(horizon.Root{}, errors.New("unavailable"))
+
+	_, err := h.CursorForTime(time.Unix(1000, 0))
+	assert.EqualError(t, err, "getting horizon root: unavailable")
+}
+
+func TestStreamer_StreamStatus(t *testing.T) {
+	client := &horizonclient.MockClient{}
+
+	errorsSeen := make(chan error, 1)
+	h := Streamer{
+		HorizonClient: client,
+		ErrorHandler: func(err error) {
+			errorsSeen <- err
+		},
+	}
+	assert.Equal(t, agent.StreamStatus{}, h.StreamStatus())
+
+	resume := make(chan struct{})
+	client.On(
+		"StreamTransactions",
+		mock.Anything,
+		horizonclient.TransactionRequest{},
+		mock.Anything,
+	).Return(errors.New("an error")).Once()
+	client.On(
+		"StreamTransactions",
+		mock.Anything,
+		horizonclient.TransactionRequest{},
+		mock.Anything,
+	).Return(nil).Run(func(args mock.Arguments) {
+		ctx := args[0].(context.Context)
+		handler := args[2].(horizonclient.TransactionHandler)
+		<-resume
+		handler(horizon.Transaction{
+			PT:              "1",
+			Ledger:          2,
+			LedgerCloseTime: time.Unix(1000, 0),
+		})
+		<-ctx.Done()
+	})
+
+	txsCh, cancel := h.StreamTx("")
+	defer cancel()
+
+	// An error disconnects the stream until a transaction is received.
+	assert.EqualError(t, <-errorsSeen, "an error")
+	status := h.StreamStatus()
+	assert.False(t, status.Connected)
+	assert.EqualError(t, status.Err, "an error")
+
+	close(resume)
+	<-txsCh
+	assert.Equal(t, agent.StreamStatus{
+		Connected:             true,
+		LatestLedger:          2,
+		LatestLedgerCloseTime: time.Unix(1000, 0),
+	}, h.StreamStatus())
+}
+
+func TestStreamer_StreamStatus_heartbeat(t *testing.T) {
+	client := &horizonclient.MockClient{}
+	h := Streamer{
+		HorizonClient:     client,
+		HeartbeatInterval: 10 * time.Millisecond,
+	}
+
+	// The accounts streamed have no activity, and so no transactions are
+	// received.
+	client.On(
+		"StreamTransactions",
+		mock.Anything,
+		horizonclient.TransactionRequest{},
+		mock.Anything,
+	).Return(nil).Run(func(args mock.Arguments) {
+		ctx := args[0].(context.Context)
+		<-ctx.Done()
+	})
+	client.On("Root").Return(horizon.Root{
+		HorizonSequence:       100,
+		HorizonLatestClosedAt: time.Unix(5000, 0),
+	}, nil)
+
+	_, cancel := h.StreamTx("")
+	defer cancel()
+
+	// The latest ledger of the idle stream is that of Horizon.
+	require.Eventually(t, func() bool {
+		return h.StreamStatus().LatestLedger == 100
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, agent.StreamStatus{
+		LatestLedger:          100,
+		LatestLedgerCloseTime: time.Unix(5000, 0),
+	}, h.StreamStatus())
+}
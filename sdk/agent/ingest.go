@@ -3,25 +3,71 @@ package agent
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/stellar/starlight/sdk/state"
 )
 
 var ingestingFinished = errors.New("ingesting finished")
 
-func (a *Agent) ingest() error {
-	tx, ok := <-a.streamerTransactions
+// errStreamClosed indicates that the Streamer closed its stream of
+// transactions before the channel was closed, and that the agent will
+// subscribe again.
+var errStreamClosed = errors.New("stream closed before the channel closed")
+
+// ErrNoChannel indicates that a transaction cannot be ingested because the
+// agent has no channel.
+var ErrNoChannel = errors.New("no channel")
+
+// IngestTx ingests a transaction that affects the channel accounts of the
+// channel, in the same way as the transactions of a Streamer. It allows an
+// agent constructed without a Streamer to be given the transactions of the
+// channel by an application that monitors the network itself. Transactions
+// must be given in the order they were executed, and duplicates are ignored.
+// ErrNoChannel is returned if the agent has no channel. An error ingesting
+// the transaction is returned and, unlike for the transactions of a
+// Streamer, is not written as an ErrorEvent.
+func (a *Agent) IngestTx(tx StreamedTransaction) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.channel == nil {
+		return ErrNoChannel
+	}
+	return a.ingestTx(tx)
+}
+
+func (a *Agent) ingest(txs <-chan StreamedTransaction) error {
+	tx, ok := <-txs
 	if !ok {
-		return ingestingFinished
+		return a.streamClosed(txs)
 	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	// The channel may have been discarded, such as by CancelOpen or
+	// RecoverUnopenedAccount, while the transaction was being received.
+	if a.channel == nil || a.streamerTransactions != txs {
+		return nil
+	}
+	err := a.ingestTx(tx)
+	if err != nil && a.events != nil {
+		a.events <- ErrorEvent{Err: err}
+	}
+	return err
+}
 
-	txHash, err := hashTx(tx.TransactionXDR, a.networkPassphrase)
+// ingestTx ingests the transaction into the channel and writes the events of
+// any changes it makes. Errors are returned and not written as events. It
+// must be called with the mutex locked.
+func (a *Agent) ingestTx(tx StreamedTransaction) error {
+	gtx, err := tx.Transaction()
+	if err != nil {
+		err = fmt.Errorf("ingesting tx (cursor=%s): %w", tx.Cursor, err)
+		return err
+	}
+	txHash, err := hashTx(gtx, a.networkPassphrase)
 	if err != nil {
 		err = fmt.Errorf("ingesting tx (cursor=%s): hashing tx: %w", tx.Cursor, err)
-		a.events <- ErrorEvent{Err: err}
 		return err
 	}
 	fmt.Fprintf(a.logWriter, "ingesting cursor: %s tx: %s\n", tx.Cursor, txHash)
@@ -29,54 +75,167 @@ func (a *Agent) ingest() error {
 	stateBefore, err := a.channel.State()
 	if err != nil {
 		err = fmt.Errorf("ingesting tx (cursor=%s hash=%s): getting channel state before: %w", tx.Cursor, txHash, err)
-		a.events <- ErrorEvent{Err: err}
 		return err
 	}
 	fmt.Fprintf(a.logWriter, "state before: %v\n", stateBefore)
 
 	defer a.takeSnapshot()
 
-	err = a.channel.IngestTx(tx.TransactionOrderID, tx.TransactionXDR, tx.ResultXDR, tx.ResultMetaXDR)
+	localBalanceBefore := a.channel.LocalChannelAccount().Balance
+	remoteBalanceBefore := a.channel.RemoteChannelAccount().Balance
+
+	txResult, err := tx.Result()
+	if err != nil {
+		err = fmt.Errorf("ingesting tx (cursor=%s hash=%s): %w", tx.Cursor, txHash, err)
+		return err
+	}
+	txResultMeta, err := tx.ResultMeta()
+	if err != nil {
+		err = fmt.Errorf("ingesting tx (cursor=%s hash=%s): %w", tx.Cursor, txHash, err)
+		return err
+	}
+	rotation, rotationPending := a.channel.PendingSignerRotation()
+	err = a.channel.IngestDecodedTx(tx.TransactionOrderID, gtx, txResult, txResultMeta)
 	if err != nil {
 		err = fmt.Errorf("ingesting tx (cursor=%s hash=%s): ingesting xdr: %w", tx.Cursor, txHash, err)
-		a.events <- ErrorEvent{Err: err}
 		return err
 	}
+	if rotationPending {
+		a.signerRotationIngested(rotation)
+	}
+
+	// Remember the cursor so that the stream can be resumed after the last
+	// transaction ingested.
+	a.streamerCursor = tx.Cursor
 
 	stateAfter, err := a.channel.State()
 	if err != nil {
 		err = fmt.Errorf("ingesting tx (cursor=%s hash=%s): getting channel state after: %w", tx.Cursor, txHash, err)
-		a.events <- ErrorEvent{Err: err}
 		return err
 	}
 	fmt.Fprintf(a.logWriter, "state after: %v\n", stateAfter)
 
+	a.observeLedger(tx.Ledger, tx.LedgerCloseTime)
+
+	// Record when the close was declared so that the time the close can be
+	// submitted can be estimated.
+	if stateAfter != stateBefore && (stateAfter == state.StateClosing || stateAfter == state.StateClosingWithOutdatedState) {
+		a.closeDeclaredAt = tx.LedgerCloseTime
+		if a.closeDeclaredAt.IsZero() {
+			a.closeDeclaredAt = time.Now()
+		}
+	}
+	if stateAfter != stateBefore {
+		switch {
+		case stateAfter == state.StateClosingWithOutdatedState:
+			a.setCloseReason(CloseReasonOutdatedDeclaration)
+		case stateAfter == state.StateClosing && a.closeReason == CloseReasonUnknown:
+			a.setCloseReason(CloseReasonDeclaredByRemote)
+		}
+	}
+
 	if a.events != nil {
+		// The channel updates the balances of the channel accounts from the
+		// ledger entries the transaction changed, such as when either
+		// participant deposits into their channel account.
+		local := a.channel.LocalChannelAccount()
+		if local.Balance != localBalanceBefore {
+			a.events <- BalanceChangedEvent{ChannelAccount: local.Address, PreviousBalance: localBalanceBefore, Balance: local.Balance}
+		}
+		remote := a.channel.RemoteChannelAccount()
+		if remote.Balance != remoteBalanceBefore {
+			a.events <- BalanceChangedEvent{ChannelAccount: remote.Address, PreviousBalance: remoteBalanceBefore, Balance: remote.Balance}
+		}
+
 		if stateAfter != stateBefore {
 			fmt.Fprintf(a.logWriter, "writing event: %v\n", stateAfter)
 			switch stateAfter {
 			case state.StateOpen:
+				a.verifyOpen()
 				a.events <- OpenedEvent{a.channel.OpenAgreement()}
+			case state.StateError:
+				a.events <- OpenFailedEvent{OpenAgreement: a.channel.OpenAgreement(), Err: fmt.Errorf("open transaction executed with unexpected results")}
 			case state.StateClosing:
 				a.events <- ClosingEvent{}
 			case state.StateClosingWithOutdatedState:
 				a.events <- ClosingWithOutdatedStateEvent{}
 			case state.StateClosed:
 				a.streamerCancel()
-				a.events <- ClosedEvent{}
+				a.events <- ClosedEvent{Reason: a.closeReason}
 			}
 		}
 	}
 
+	// Check expiries using the time the ledger closed so that an approaching
+	// expiry is noticed as soon as the network reaches it.
+	if !tx.LedgerCloseTime.IsZero() {
+		a.warnExpiring(tx.LedgerCloseTime)
+		a.expireSignerRotation(tx.LedgerCloseTime)
+	}
+
 	return nil
 }
 
-func (a *Agent) ingestLoop() {
+// streamClosed is called when the stream of transactions txs has been closed.
+// If the stream was replaced, or the channel is closed or discarded, the
+// stream is no longer needed and ingestingFinished is returned. Otherwise a
+// StreamClosedEvent is written, and errStreamClosed is returned if the agent
+// is configured to subscribe again, else ingestingFinished.
+func (a *Agent) streamClosed(txs <-chan StreamedTransaction) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil || a.streamerTransactions != txs {
+		return ingestingFinished
+	}
+	s, err := a.channel.State()
+	if err == nil && (s == state.StateClosed || s == state.StateClosedWithOutdatedState) {
+		return ingestingFinished
+	}
+
+	resubscribing := a.streamResubscribeDelay > 0
+	fmt.Fprintf(a.logWriter, "stream closed at cursor %q, resubscribing: %v\n", a.streamerCursor, resubscribing)
+	if a.events != nil {
+		a.events <- StreamClosedEvent{Cursor: a.streamerCursor, Resubscribing: resubscribing}
+	}
+	if resubscribing {
+		return errStreamClosed
+	}
+	return ingestingFinished
+}
+
+// resubscribe subscribes to the Streamer again from the cursor of the last
+// transaction ingested, replacing the closed stream txs, and returns the new
+// stream. It returns false if the stream was replaced or the channel
+// discarded while waiting to subscribe again.
+func (a *Agent) resubscribe(txs <-chan StreamedTransaction) (<-chan StreamedTransaction, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.channel == nil || a.streamerTransactions != txs {
+		return nil, false
+	}
+	a.streamerTransactions, a.streamerCancel = a.streamer.StreamTx(a.streamerCursor)
+	a.streamStartedAt = time.Now()
+	return a.streamerTransactions, true
+}
+
+// ingestLoop ingests the transactions of the stream txs until the stream is
+// no longer needed.
+func (a *Agent) ingestLoop(txs <-chan StreamedTransaction) {
 	for {
-		err := a.ingest()
+		err := a.ingest(txs)
 		if err != nil {
 			fmt.Fprintf(a.logWriter, "error ingesting: %v\n", err)
 		}
+		if errors.Is(err, errStreamClosed) {
+			time.Sleep(a.streamResubscribeDelay)
+			var ok bool
+			txs, ok = a.resubscribe(txs)
+			if !ok {
+				break
+			}
+			continue
+		}
 		if errors.Is(err, ingestingFinished) {
 			break
 		}
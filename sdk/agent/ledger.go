@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultLedgerDuration is the average duration of a ledger assumed until
+	// enough ledgers have been observed to estimate it.
+	defaultLedgerDuration = 5 * time.Second
+
+	// defaultLedgerDurationWindow is the number of most recently observed
+	// ledgers used to estimate the average duration of a ledger.
+	defaultLedgerDurationWindow = 50
+)
+
+// ledgerTime is the time a ledger closed.
+type ledgerTime struct {
+	Ledger    int64
+	CloseTime time.Time
+}
+
+// observeLedger records the close time of a ledger seen in a streamed
+// transaction, keeping only the most recent ledgers within the window.
+// Ledgers are expected to be observed in order, and ledgers already observed
+// or older than the most recent ledger observed are ignored.
+func (a *Agent) observeLedger(ledger int64, closeTime time.Time) {
+	if ledger == 0 || closeTime.IsZero() {
+		return
+	}
+	if len(a.ledgerTimes) > 0 && ledger <= a.ledgerTimes[len(a.ledgerTimes)-1].Ledger {
+		return
+	}
+	a.ledgerTimes = append(a.ledgerTimes, ledgerTime{Ledger: ledger, CloseTime: closeTime})
+	window := a.ledgerDurationWindow
+	if window <= 0 {
+		window = defaultLedgerDurationWindow
+	}
+	if len(a.ledgerTimes) > window {
+		a.ledgerTimes = a.ledgerTimes[len(a.ledgerTimes)-window:]
+	}
+}
+
+// averageLedgerDuration returns the average duration of the ledgers observed,
+// or the configured ledger duration if too few ledgers have been observed.
+func (a *Agent) averageLedgerDuration() time.Duration {
+	if len(a.ledgerTimes) < 2 {
+		if a.ledgerDuration > 0 {
+			return a.ledgerDuration
+		}
+		return defaultLedgerDuration
+	}
+	first := a.ledgerTimes[0]
+	last := a.ledgerTimes[len(a.ledgerTimes)-1]
+	return last.CloseTime.Sub(first.CloseTime) / time.Duration(last.Ledger-first.Ledger)
+}
+
+// CloseAvailableAt returns the estimated time that the close transaction can
+// be submitted after the channel's close was declared. The observation period
+// time is relative to the close time of the ledger the declaration was
+// included in, and the observation period ledger gap is converted to a
+// duration using the average duration of recently observed ledgers. The later
+// of the two is returned.
+func (a *Agent) CloseAvailableAt() (time.Time, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return time.Time{}, fmt.Errorf("no channel")
+	}
+	if a.closeDeclaredAt.IsZero() {
+		return time.Time{}, fmt.Errorf("close not declared")
+	}
+	return a.closeAvailableAt(), nil
+}
+
+// closeAvailableAt returns the estimated time that the close transaction can
+// be submitted. It must be called with the mutex locked and only once the
+// close has been declared.
+func (a *Agent) closeAvailableAt() time.Time {
+	d := a.channel.OpenAgreement().Envelope.Details
+	availableAt := a.closeDeclaredAt.Add(d.ObservationPeriodTime)
+	availableAtByLedgers := a.closeDeclaredAt.Add(time.Duration(d.ObservationPeriodLedgerGap) * a.averageLedgerDuration())
+	if availableAtByLedgers.After(availableAt) {
+		availableAt = availableAtByLedgers
+	}
+	return availableAt
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+)
+
+// MessageConn is a connection over a transport that preserves message
+// boundaries, such as WebSocket, QUIC datagrams, or NATS. Each message the
+// agent exchanges is sent as exactly one transport message, encoded on its
+// own with a msg.Encoder, and so no framing or stream decoding is needed.
+//
+// ReceiveMessage must return io.EOF when the other participant has closed the
+// connection. If a MessageConn implements io.Closer it is closed when the
+// agent is shut down or disconnected.
+type MessageConn interface {
+	SendMessage(b []byte) error
+	ReceiveMessage() ([]byte, error)
+}
+
+// ServeMessageConn uses an established connection over a message-oriented
+// transport to the other participant for establishing a single payment
+// channel. It is the counterpart of ServeConn for transports that preserve
+// message boundaries, and both participants must use the same kind of
+// connection.
+func (a *Agent) ServeMessageConn(conn MessageConn) error {
+	if !a.disconnected() {
+		return fmt.Errorf("already connected")
+	}
+	a.attachMessageConn(conn)
+	return a.start()
+}
+
+// attachMessageConn attaches the message connection to the agent and starts
+// the send loop that owns all writes to the connection.
+func (a *Agent) attachMessageConn(conn MessageConn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counters := &connCounters{}
+	c := &messageConnCodec{
+		conn:      conn,
+		counters:  counters,
+		tail:      &tailReader{},
+		logWriter: a.logWriter,
+	}
+	a.attach(conn, counters, c.tail, c, c)
+}
+
+// messageConnCodec decodes and encodes each message as a single message of a
+// MessageConn. The tail holds the bytes of the last message received so that
+// they can be shown if they cannot be decoded.
+type messageConnCodec struct {
+	conn      MessageConn
+	counters  *connCounters
+	tail      *tailReader
+	logWriter io.Writer
+}
+
+func (c *messageConnCodec) Decode(e interface{}) error {
+	b, err := c.conn.ReceiveMessage()
+	c.tail.err = err
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.counters.bytesReceived, int64(len(b)))
+	c.logWriter.Write(b)
+	c.tail.tail = b
+	if len(b) > recvPreviewSize {
+		c.tail.tail = b[len(b)-recvPreviewSize:]
+	}
+	return msg.NewDecoder(bytes.NewReader(b)).Decode(e)
+}
+
+func (c *messageConnCodec) Encode(e interface{}) error {
+	buf := bytes.Buffer{}
+	err := msg.NewEncoder(&buf).Encode(e)
+	if err != nil {
+		return err
+	}
+	c.logWriter.Write(buf.Bytes())
+	err = c.conn.SendMessage(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.counters.bytesSent, int64(buf.Len()))
+	return nil
+}
+
+// maxStreamMessageSize is the largest message a StreamMessageConn will
+// receive.
+const maxStreamMessageSize = 1 << 20
+
+// StreamMessageConn is a MessageConn over a stream of bytes, such as a TCP
+// connection, that frames each message with its length as a four byte big
+// endian prefix. It allows code written for MessageConn to be used with the
+// transports accepted by ServeConn. If the stream implements io.Closer it is
+// closed when the StreamMessageConn is closed.
+type StreamMessageConn struct {
+	rw io.ReadWriter
+}
+
+// NewStreamMessageConn returns a MessageConn that frames messages on the
+// stream.
+func NewStreamMessageConn(rw io.ReadWriter) *StreamMessageConn {
+	return &StreamMessageConn{rw: rw}
+}
+
+// SendMessage writes the message to the stream prefixed with its length.
+func (c *StreamMessageConn) SendMessage(b []byte) error {
+	if len(b) > maxStreamMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds max size of %d bytes", len(b), maxStreamMessageSize)
+	}
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+// ReceiveMessage reads the next message from the stream. It returns io.EOF if
+// the stream ends between messages.
+func (c *StreamMessageConn) ReceiveMessage() ([]byte, error) {
+	prefix := [4]byte{}
+	_, err := io.ReadFull(c.rw, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(prefix[:])
+	if size > maxStreamMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds max size of %d bytes", size, maxStreamMessageSize)
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(c.rw, b)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close closes the stream if it implements io.Closer.
+func (c *StreamMessageConn) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalCanonical returns the canonical encoding of the message, for when
+// the exact bytes of a message matter, such as when signing a message or
+// comparing transcripts byte for byte. The encoding is JSON with the keys of
+// every object sorted, no insignificant whitespace, no escaping of HTML
+// characters, and numbers written as the integers they hold. The same message
+// always has the same canonical encoding.
+//
+// Messages exchanged between participants are encoded with the Encoder, which
+// is faster and is not canonical.
+func MarshalCanonical(m Message) ([]byte, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("encoding message: %w", err)
+	}
+
+	// Decoding into generic values turns objects into maps, which are encoded
+	// with sorted keys, and keeps numbers as the text they were encoded as.
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	if err != nil {
+		return nil, fmt.Errorf("decoding message: %w", err)
+	}
+
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding canonical message: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// UnmarshalCanonical decodes a message encoded with MarshalCanonical.
+func UnmarshalCanonical(b []byte) (Message, error) {
+	m := Message{}
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return Message{}, fmt.Errorf("decoding canonical message: %w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,60 @@
+package msg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalCanonical(t *testing.T) {
+	m := Message{
+		Type: TypeOpenRequest,
+		Hello: &Hello{
+			ChannelAccount: *keypair.MustRandom().FromAddress(),
+			Signer:         *keypair.MustRandom().FromAddress(),
+		},
+		Info: &Info{
+			Version: "<v1>",
+			Extra:   map[string]string{"z": "1", "a": "2", "m": "3"},
+		},
+		OpenRequest: &state.OpenEnvelope{
+			Details: state.OpenDetails{
+				ObservationPeriodTime:      time.Minute,
+				ObservationPeriodLedgerGap: 1,
+				Asset:                      state.NativeAsset,
+				ExpiresAt:                  time.Unix(1234, 5678).UTC(),
+				StartingSequence:           9007199254740993,
+				ProposingSigner:            keypair.MustRandom().FromAddress(),
+				ConfirmingSigner:           keypair.MustRandom().FromAddress(),
+			},
+		},
+		AppData: []byte{1, 2, 3},
+	}
+
+	b, err := MarshalCanonical(m)
+	require.NoError(t, err)
+
+	// The encoding is the same every time.
+	for i := 0; i < 10; i++ {
+		b2, err := MarshalCanonical(m)
+		require.NoError(t, err)
+		assert.Equal(t, b, b2)
+	}
+
+	// Keys are sorted, numbers are not rounded, and there is no whitespace
+	// or escaping.
+	assert.Contains(t, string(b), `"Extra":{"a":"2","m":"3","z":"1"}`)
+	assert.Contains(t, string(b), `"StartingSequence":9007199254740993`)
+	assert.Contains(t, string(b), `"Version":"<v1>"`)
+	assert.NotContains(t, string(b), " ")
+	assert.NotContains(t, string(b), "\n")
+
+	// The encoding decodes to the same message.
+	m2, err := UnmarshalCanonical(b)
+	require.NoError(t, err)
+	assert.Equal(t, m, m2)
+}
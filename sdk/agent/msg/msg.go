@@ -19,12 +19,20 @@ type Type int
 
 const (
 	TypeHello           Type = 10
+	TypeInfo            Type = 11
 	TypeOpenRequest     Type = 20
 	TypeOpenResponse    Type = 21
 	TypePaymentRequest  Type = 30
 	TypePaymentResponse Type = 31
 	TypeCloseRequest    Type = 40
 	TypeCloseResponse   Type = 41
+	TypeReject          Type = 50
+
+	TypeReconcileRequest  Type = 60
+	TypeReconcileResponse Type = 61
+
+	TypeSignerRotationRequest  Type = 70
+	TypeSignerRotationResponse Type = 71
 )
 
 // Message is a message that can be transmitted to support two participants in a
@@ -34,15 +42,90 @@ type Message struct {
 	Type Type
 
 	Hello *Hello
+	Info  *Info
 
 	OpenRequest  *state.OpenEnvelope
 	OpenResponse *state.OpenSignatures
 
+	// OpenByResponder is true if the OpenRequest is proposed by the
+	// participant that is to be the responder of the channel, in which case
+	// the participant receiving it is to be the initiator. The initiator's
+	// channel account provides the channel's starting sequence regardless of
+	// which participant proposes the open.
+	OpenByResponder bool
+
 	PaymentRequest  *state.CloseEnvelope
 	PaymentResponse *state.CloseSignatures
 
+	// AppData is optional application data that accompanies a
+	// PaymentRequest. It is exchanged only by the participants and is not
+	// part of the agreement or any transaction.
+	AppData []byte
+
 	CloseRequest  *state.CloseEnvelope
 	CloseResponse *state.CloseSignatures
+
+	Reject *Reject
+
+	// ReconcileRequest and ReconcileResponse are the latest authorized
+	// agreement of the participant sending them, exchanged so that a
+	// participant that is behind can catch up. See state.Channel.CatchUp.
+	ReconcileRequest  *state.CloseEnvelope
+	ReconcileResponse *state.CloseEnvelope
+
+	// SignerRotationRequest is a signer rotation proposed by the participant
+	// whose signer is rotated, and SignerRotationResponse is the other
+	// participant's signatures confirming it. See
+	// state.Channel.ProposeSignerRotation.
+	SignerRotationRequest  *state.SignerRotationEnvelope
+	SignerRotationResponse *state.SignerRotationSignatures
+}
+
+// RejectCode is a machine readable reason for a rejection.
+type RejectCode int
+
+const (
+	// RejectCodeInvalid indicates the request was malformed, could not be
+	// validated, or is not valid in the current state of the channel.
+	RejectCodeInvalid RejectCode = 1
+	// RejectCodeNotApproved indicates the request was valid but was not
+	// approved by the participant's policy.
+	RejectCodeNotApproved RejectCode = 2
+	// RejectCodeUnderfunded indicates the proposer's channel account does not
+	// hold enough to make the payment.
+	RejectCodeUnderfunded RejectCode = 3
+	// RejectCodeTooLarge indicates the payment amount is larger than the
+	// participant accepts.
+	RejectCodeTooLarge RejectCode = 4
+	// RejectCodeContributionMismatch indicates the contributions of an open
+	// do not match those the participant expects.
+	RejectCodeContributionMismatch RejectCode = 5
+	// RejectCodeExpired indicates the proposer of a request abandoned it
+	// because it was not answered in time. It is sent by the proposer, rather
+	// than the participant the request was sent to.
+	RejectCodeExpired RejectCode = 6
+	// RejectCodeAssetNotAllowed indicates the asset of an open is not one
+	// the participant accepts.
+	RejectCodeAssetNotAllowed RejectCode = 7
+	// RejectCodeTryLater indicates the participant is temporarily not
+	// accepting requests, such as during maintenance, and the request can be
+	// proposed again later.
+	RejectCodeTryLater RejectCode = 8
+)
+
+// Reject is sent in place of a response to signal that a request was rejected
+// and will not be confirmed.
+type Reject struct {
+	// Type is the type of the request that was rejected.
+	Type Type
+	// IterationNumber is the iteration number of the rejected agreement, if
+	// the request was a payment or close.
+	IterationNumber int64
+	// Code is a machine readable reason the request was rejected.
+	Code RejectCode
+	// Reason is a human readable description of why the request was
+	// rejected.
+	Reason string
 }
 
 // Hello can be used to signal to another participant a minimal amount of
@@ -52,6 +135,24 @@ type Hello struct {
 	Signer         keypair.FromAddress
 }
 
+// Info can be sent after a hello to share operational metadata with the other
+// participant, so that each participant can adapt its behavior to the other.
+// It is optional and participants must not depend on receiving it. Zero
+// values indicate the information is not shared.
+type Info struct {
+	// Version is the name and version of the software the participant runs.
+	Version string
+	// Assets are the assets the participant will open a channel with.
+	Assets []state.Asset
+	// MaxPaymentAmount is the largest payment the participant will confirm.
+	MaxPaymentAmount int64
+	// FeePolicy describes how the participant pays the fees of transactions,
+	// e.g. the fee bump base fee it uses.
+	FeePolicy string
+	// Extra is any other metadata.
+	Extra map[string]string
+}
+
 // Encoder is an encoder that can be used to encode messages.
 // It is currently set as the encoding/gob.Encoder, but may be changed to
 // another type at anytime to facilitate testing or to improve performance.
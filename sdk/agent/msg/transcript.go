@@ -0,0 +1,166 @@
+package msg
+
+import (
+	"fmt"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// VerifyTranscript replays an ordered log of the messages exchanged by two
+// participants of a channel, and verifies that every agreement in it is signed
+// by both participants and follows validly from the agreement before it. It
+// returns the outcome of the channel's agreements.
+//
+// The log must start with the hello of each participant, followed by the open
+// request and response, then any payment requests and responses, and
+// optionally a close request and response. Each response must immediately
+// follow its request. A payment request may instead be followed by a reject,
+// in which case the payment is not agreed to. A request at the end of the log
+// without a response is ignored because it was never agreed to. Info messages
+// may appear anywhere in the log and are ignored, as are reconcile messages.
+// Verification does not depend on the network beyond the network passphrase,
+// and so it does not check balances of the channel accounts or that the open
+// executed. For the same reason a log containing a signer rotation cannot be
+// verified, because the agreements that follow it depend on whether the
+// rotation executed. See state.TranscriptVerifier.
+func VerifyTranscript(networkPassphrase string, messages []Message) (state.TranscriptResult, error) {
+	hellos := []Hello{}
+	var verifier *state.TranscriptVerifier
+	var pending *Message
+
+	for i, m := range messages {
+		m := m
+		if m.Type == TypeInfo || m.Type == TypeReconcileRequest || m.Type == TypeReconcileResponse {
+			// Info carries no agreement, and reconciling only repeats
+			// agreements already made, and both can be sent at any time.
+			continue
+		}
+		err := func() error {
+			if pending != nil && !isResponseTo(m.Type, pending.Type) {
+				return fmt.Errorf("expected response to message type %d, got type %d", pending.Type, m.Type)
+			}
+			switch m.Type {
+			case TypeHello:
+				if m.Hello == nil {
+					return fmt.Errorf("hello missing")
+				}
+				if len(hellos) == 2 || verifier != nil {
+					return fmt.Errorf("unexpected hello")
+				}
+				hellos = append(hellos, *m.Hello)
+			case TypeOpenRequest:
+				if m.OpenRequest == nil {
+					return fmt.Errorf("open request missing")
+				}
+				if len(hellos) != 2 {
+					return fmt.Errorf("open request before hellos of both participants")
+				}
+				if verifier != nil {
+					return fmt.Errorf("unexpected open request")
+				}
+				initiator, responder := hellos[0], hellos[1]
+				if m.OpenRequest.Details.ProposingSigner.Equal(&responder.Signer) {
+					initiator, responder = responder, initiator
+				}
+				verifier = state.NewTranscriptVerifier(state.TranscriptConfig{
+					NetworkPassphrase: networkPassphrase,
+					Initiator:         state.Participant{ChannelAccount: &initiator.ChannelAccount, Signer: &initiator.Signer},
+					Responder:         state.Participant{ChannelAccount: &responder.ChannelAccount, Signer: &responder.Signer},
+				})
+				pending = &m
+			case TypePaymentRequest:
+				if m.PaymentRequest == nil {
+					return fmt.Errorf("payment request missing")
+				}
+				if verifier == nil {
+					return fmt.Errorf("payment request before open")
+				}
+				pending = &m
+			case TypeCloseRequest:
+				if m.CloseRequest == nil {
+					return fmt.Errorf("close request missing")
+				}
+				if verifier == nil {
+					return fmt.Errorf("close request before open")
+				}
+				pending = &m
+			case TypeOpenResponse:
+				if m.OpenResponse == nil || pending == nil {
+					return fmt.Errorf("unexpected open response")
+				}
+				e := *pending.OpenRequest
+				e.ConfirmerSignatures = *m.OpenResponse
+				pending = nil
+				return verifier.VerifyOpen(e)
+			case TypePaymentResponse:
+				if m.PaymentResponse == nil || pending == nil || pending.PaymentRequest == nil {
+					return fmt.Errorf("unexpected payment response")
+				}
+				e := *pending.PaymentRequest
+				e.ConfirmerSignatures = *m.PaymentResponse
+				pending = nil
+				return verifier.VerifyPayment(e)
+			case TypeCloseResponse:
+				if m.CloseResponse == nil || pending == nil || pending.CloseRequest == nil {
+					return fmt.Errorf("unexpected close response")
+				}
+				e := *pending.CloseRequest
+				e.ConfirmerSignatures = *m.CloseResponse
+				pending = nil
+				return verifier.VerifyClose(e)
+			case TypeReject:
+				if m.Reject == nil || pending == nil || m.Reject.Type != pending.Type {
+					return fmt.Errorf("unexpected reject")
+				}
+				switch m.Reject.Type {
+				case TypeOpenRequest:
+					// A rejected open leaves no channel.
+					pending = nil
+					verifier = nil
+					return nil
+				case TypeCloseRequest:
+					// A rejected close does not change the channel.
+					pending = nil
+					return nil
+				}
+				if m.Reject.IterationNumber != pending.PaymentRequest.Details.IterationNumber {
+					return fmt.Errorf("reject iteration number does not match payment request")
+				}
+				e := *pending.PaymentRequest
+				pending = nil
+				return verifier.VerifyRejectedPayment(e)
+			case TypeSignerRotationRequest, TypeSignerRotationResponse:
+				return fmt.Errorf("signer rotations cannot be verified")
+			default:
+				return fmt.Errorf("unknown message type")
+			}
+			return nil
+		}()
+		if err != nil {
+			return state.TranscriptResult{}, fmt.Errorf("message %d (type %d): %w", i, m.Type, err)
+		}
+	}
+
+	if verifier == nil {
+		return state.TranscriptResult{}, fmt.Errorf("no open agreement in transcript")
+	}
+	result := verifier.Result()
+	if result.OpenAgreement.Envelope.Empty() {
+		return state.TranscriptResult{}, fmt.Errorf("no open agreement in transcript")
+	}
+	return result, nil
+}
+
+// isResponseTo returns true if the response type is the response to the
+// request type, or a rejection of it, else false.
+func isResponseTo(response, request Type) bool {
+	switch request {
+	case TypeOpenRequest:
+		return response == TypeOpenResponse || response == TypeReject
+	case TypePaymentRequest:
+		return response == TypePaymentResponse || response == TypeReject
+	case TypeCloseRequest:
+		return response == TypeCloseResponse || response == TypeReject
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package msg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyTranscript(t *testing.T) {
+	initiatorSigner := keypair.MustRandom()
+	responderSigner := keypair.MustRandom()
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	responderChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorChannel := state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          initiatorSigner,
+		RemoteSigner:         responderSigner.FromAddress(),
+		LocalChannelAccount:  initiatorChannelAccount,
+		RemoteChannelAccount: responderChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		LocalSigner:          responderSigner,
+		RemoteSigner:         initiatorSigner.FromAddress(),
+		LocalChannelAccount:  responderChannelAccount,
+		RemoteChannelAccount: initiatorChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Record the messages the participants would exchange.
+	messages := []Message{
+		{Type: TypeHello, Hello: &Hello{ChannelAccount: *responderChannelAccount, Signer: *responderSigner.FromAddress()}},
+		{Type: TypeHello, Hello: &Hello{ChannelAccount: *initiatorChannelAccount, Signer: *initiatorSigner.FromAddress()}},
+	}
+
+	open, err := initiatorChannel.ProposeOpen(state.OpenParams{
+		Asset:                      state.NativeAsset,
+		ExpiresAt:                  time.Now().Add(5 * time.Minute),
+		StartingSequence:           101,
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+	})
+	require.NoError(t, err)
+	messages = append(messages, Message{Type: TypeOpenRequest, OpenRequest: &open.Envelope})
+	open, err = responderChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	messages = append(messages, Message{Type: TypeOpenResponse, OpenResponse: &open.Envelope.ConfirmerSignatures})
+	_, err = initiatorChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+
+	// Ingest the open so that payments can be made.
+	openTx, err := initiatorChannel.OpenTx()
+	require.NoError(t, err)
+	openTxXDR, err := openTx.Base64()
+	require.NoError(t, err)
+	resultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         initiatorSigner.Address(),
+		ResponderSigner:         responderSigner.Address(),
+		InitiatorChannelAccount: initiatorChannelAccount.Address(),
+		ResponderChannelAccount: responderChannelAccount.Address(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	for _, c := range []*state.Channel{initiatorChannel, responderChannel} {
+		require.NoError(t, c.IngestTx(1, openTxXDR, resultXDR, resultMetaXDR))
+		c.UpdateLocalChannelAccountBalance(1000)
+		c.UpdateRemoteChannelAccountBalance(1000)
+	}
+
+	pay := func(proposer, confirmer *state.Channel, amount int64) {
+		ca, err := proposer.ProposePayment(amount)
+		require.NoError(t, err)
+		messages = append(messages, Message{Type: TypePaymentRequest, PaymentRequest: &ca.Envelope})
+		ca, err = confirmer.ConfirmPayment(ca.Envelope)
+		require.NoError(t, err)
+		messages = append(messages, Message{Type: TypePaymentResponse, PaymentResponse: &ca.Envelope.ConfirmerSignatures})
+		_, err = proposer.ConfirmPayment(ca.Envelope)
+		require.NoError(t, err)
+	}
+	pay(initiatorChannel, responderChannel, 100)
+	pay(responderChannel, initiatorChannel, 40)
+	pay(initiatorChannel, responderChannel, 5)
+
+	ca, err := initiatorChannel.ProposeClose()
+	require.NoError(t, err)
+	messages = append(messages, Message{Type: TypeCloseRequest, CloseRequest: &ca.Envelope})
+	ca, err = responderChannel.ConfirmClose(ca.Envelope)
+	require.NoError(t, err)
+	messages = append(messages, Message{Type: TypeCloseResponse, CloseResponse: &ca.Envelope.ConfirmerSignatures})
+
+	t.Run("valid", func(t *testing.T) {
+		result, err := VerifyTranscript(network.TestNetworkPassphrase, messages)
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.Payments)
+		assert.True(t, result.CoordinatedClose)
+		assert.Equal(t, int64(65), result.Balance)
+		assert.Equal(t, int64(-65), result.InitiatorBalance)
+		assert.Equal(t, int64(65), result.ResponderBalance)
+	})
+
+	t.Run("infoIgnored", func(t *testing.T) {
+		info := Message{Type: TypeInfo, Info: &Info{Version: "test"}}
+		m := append(append([]Message{}, messages[:2]...), info)
+		m = append(m, messages[2:]...)
+		m = append(m, info)
+		result, err := VerifyTranscript(network.TestNetworkPassphrase, m)
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.Payments)
+		assert.True(t, result.CoordinatedClose)
+	})
+
+	t.Run("unansweredRequestIgnored", func(t *testing.T) {
+		result, err := VerifyTranscript(network.TestNetworkPassphrase, messages[:len(messages)-1])
+		require.NoError(t, err)
+		assert.Equal(t, 3, result.Payments)
+		assert.False(t, result.CoordinatedClose)
+		assert.Equal(t, int64(65), result.Balance)
+	})
+
+	t.Run("missingPayment", func(t *testing.T) {
+		m := append(append([]Message{}, messages[:4]...), messages[6:]...)
+		_, err := VerifyTranscript(network.TestNetworkPassphrase, m)
+		require.Error(t, err)
+		assert.ErrorIs(t, err, state.ErrIterationGap)
+		assert.Contains(t, err.Error(), "message 5 (type 31)")
+	})
+
+	t.Run("wrongNetwork", func(t *testing.T) {
+		_, err := VerifyTranscript(network.PublicNetworkPassphrase, messages)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "message 3 (type 21): invalid open agreement signatures by proposer")
+	})
+
+	t.Run("responseWithoutRequest", func(t *testing.T) {
+		m := append(append([]Message{}, messages[:4]...), messages[5])
+		_, err := VerifyTranscript(network.TestNetworkPassphrase, m)
+		assert.EqualError(t, err, "message 4 (type 31): unexpected payment response")
+	})
+
+	t.Run("signerRotation", func(t *testing.T) {
+		m := append(append([]Message{}, messages[:4]...), Message{Type: TypeSignerRotationRequest, SignerRotationRequest: &state.SignerRotationEnvelope{}})
+		_, err := VerifyTranscript(network.TestNetworkPassphrase, m)
+		assert.EqualError(t, err, "message 4 (type 70): signer rotations cannot be verified")
+	})
+
+	t.Run("noOpen", func(t *testing.T) {
+		_, err := VerifyTranscript(network.TestNetworkPassphrase, messages[:2])
+		assert.EqualError(t, err, "no open agreement in transcript")
+	})
+}
@@ -0,0 +1,178 @@
+package msg
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateVectors = flag.Bool("update", false, "update the test vectors in testdata/vectors")
+
+// vectorKey returns the keypair with a raw seed of 32 bytes of b.
+func vectorKey(t *testing.T, b byte) *keypair.Full {
+	seed := [32]byte{}
+	for i := range seed {
+		seed[i] = b
+	}
+	kp, err := keypair.FromRawSeed(seed)
+	require.NoError(t, err)
+	return kp
+}
+
+// vectorMessages returns the messages of a channel opened, paid and closed
+// with fixed keys and parameters, keyed by the name of their test vector.
+func vectorMessages(t *testing.T) map[string]Message {
+	initiatorSigner := vectorKey(t, 1)
+	responderSigner := vectorKey(t, 2)
+	initiatorChannelAccount := vectorKey(t, 3).FromAddress()
+	responderChannelAccount := vectorKey(t, 4).FromAddress()
+
+	initiatorChannel := state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          initiatorSigner,
+		RemoteSigner:         responderSigner.FromAddress(),
+		LocalChannelAccount:  initiatorChannelAccount,
+		RemoteChannelAccount: responderChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := state.NewChannel(state.Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		LocalSigner:          responderSigner,
+		RemoteSigner:         initiatorSigner.FromAddress(),
+		LocalChannelAccount:  responderChannelAccount,
+		RemoteChannelAccount: initiatorChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	messages := map[string]Message{}
+	messages["hello"] = Message{
+		Type: TypeHello,
+		Hello: &Hello{
+			ChannelAccount: *initiatorChannelAccount,
+			Signer:         *initiatorSigner.FromAddress(),
+		},
+	}
+
+	open, err := initiatorChannel.ProposeOpen(state.OpenParams{
+		Asset:                      state.NativeAsset,
+		ExpiresAt:                  time.Unix(1_600_000_000, 0).UTC(),
+		StartingSequence:           101,
+		ObservationPeriodTime:      time.Minute,
+		ObservationPeriodLedgerGap: 10,
+	})
+	require.NoError(t, err)
+	openRequest := open.Envelope
+	messages["open_request"] = Message{Type: TypeOpenRequest, OpenRequest: &openRequest}
+	open, err = responderChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	openResponse := open.Envelope.ConfirmerSignatures
+	messages["open_response"] = Message{Type: TypeOpenResponse, OpenResponse: &openResponse}
+	_, err = initiatorChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+
+	openTx, err := initiatorChannel.OpenTx()
+	require.NoError(t, err)
+	openTxXDR, err := openTx.Base64()
+	require.NoError(t, err)
+	resultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         initiatorSigner.Address(),
+		ResponderSigner:         responderSigner.Address(),
+		InitiatorChannelAccount: initiatorChannelAccount.Address(),
+		ResponderChannelAccount: responderChannelAccount.Address(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	for _, c := range []*state.Channel{initiatorChannel, responderChannel} {
+		require.NoError(t, c.IngestTx(1, openTxXDR, resultXDR, resultMetaXDR))
+		c.UpdateLocalChannelAccountBalance(1000)
+		c.UpdateRemoteChannelAccountBalance(1000)
+	}
+
+	ca, err := initiatorChannel.ProposePayment(100)
+	require.NoError(t, err)
+	paymentRequest := ca.Envelope
+	messages["payment_request"] = Message{Type: TypePaymentRequest, PaymentRequest: &paymentRequest}
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	paymentResponse := ca.Envelope.ConfirmerSignatures
+	messages["payment_response"] = Message{Type: TypePaymentResponse, PaymentResponse: &paymentResponse}
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+
+	// The signer rotation is discarded as if it expired, so that the close
+	// that follows is made with the original signers.
+	rotation, err := initiatorChannel.ProposeSignerRotation(vectorKey(t, 5), 1001, time.Unix(1_600_000_000, 0).UTC())
+	require.NoError(t, err)
+	signerRotationRequest := rotation.Envelope
+	messages["signer_rotation_request"] = Message{Type: TypeSignerRotationRequest, SignerRotationRequest: &signerRotationRequest}
+	rotation, err = responderChannel.ConfirmSignerRotation(rotation.Envelope)
+	require.NoError(t, err)
+	signerRotationResponse := rotation.Envelope.ConfirmerSignatures
+	messages["signer_rotation_response"] = Message{Type: TypeSignerRotationResponse, SignerRotationResponse: &signerRotationResponse}
+	for _, c := range []*state.Channel{initiatorChannel, responderChannel} {
+		require.True(t, c.ExpireSignerRotation(time.Unix(1_600_000_001, 0)))
+	}
+
+	ca, err = initiatorChannel.ProposeClose()
+	require.NoError(t, err)
+	closeRequest := ca.Envelope
+	messages["close_request"] = Message{Type: TypeCloseRequest, CloseRequest: &closeRequest}
+	ca, err = responderChannel.ConfirmClose(ca.Envelope)
+	require.NoError(t, err)
+	closeResponse := ca.Envelope.ConfirmerSignatures
+	messages["close_response"] = Message{Type: TypeCloseResponse, CloseResponse: &closeResponse}
+
+	return messages
+}
+
+// TestVectors checks the canonical encoding of each message of a channel
+// built from fixed keys and parameters against the test vectors in
+// testdata/vectors, so that any change to the messages exchanged is noticed.
+// The vectors also document the messages for other implementations. The
+// keys are those with raw seeds of 32 bytes of 1 (initiator signer), 2
+// (responder signer), 3 (initiator channel account), 4 (responder channel
+// account) and 5 (the initiator's new signer in the signer rotation), and the
+// network is the test network.
+//
+// If a change to the messages is intended, regenerate the vectors with:
+//
+//	go test ./agent/msg -run TestVectors -update
+func TestVectors(t *testing.T) {
+	dir := filepath.Join("testdata", "vectors")
+	for name, m := range vectorMessages(t) {
+		name, m := name, m
+		t.Run(name, func(t *testing.T) {
+			b, err := MarshalCanonical(m)
+			require.NoError(t, err)
+
+			path := filepath.Join(dir, name+".json")
+			if *updateVectors {
+				require.NoError(t, os.MkdirAll(dir, 0o755))
+				require.NoError(t, os.WriteFile(path, b, 0o644))
+			}
+
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(b), "encoding of %s differs from %s", name, path)
+
+			// The vector decodes to the message it was generated from.
+			decoded, err := UnmarshalCanonical(want)
+			require.NoError(t, err)
+			assert.Equal(t, m, decoded)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// ErrOpenTokenMismatch indicates that OpenWithToken was called while a
+// channel exists that was not opened with the same token.
+var ErrOpenTokenMismatch = errors.New("channel exists and was not opened with the token")
+
+// ErrOpenExpired indicates that an open cannot be resumed because its open
+// agreement has expired without the open transaction being seen. Call
+// CancelOpen to discard it.
+var ErrOpenExpired = errors.New("open expired")
+
+// ErrOpenNotCancelable indicates that an open cannot be canceled, because
+// there is no open proposed by the agent, the open transaction has been
+// executed, or the open agreement has not expired.
+var ErrOpenNotCancelable = errors.New("open cannot be canceled")
+
+// OpenWithToken kicks off the open process in the same way as Open, and
+// records the token with the open so that a retry can resume it. The token
+// should be unique to each channel the application opens, and is saved in the
+// agent's snapshots.
+//
+// If the agent already has a channel that was opened with the same token, the
+// existing attempt is resumed instead of erroring or starting a new one:
+//
+// - An open that has not been confirmed by the other participant is sent to
+// them again.
+// - An open that has been confirmed but not seen on the network is submitted
+// again.
+// - An open that has been seen on the network returns no error.
+//
+// The token's lifetime is that of the open attempt it started. It is valid
+// until the open agreement expires, after which retries return
+// ErrOpenExpired, and it is forgotten when CancelOpen discards the attempt.
+// Calling OpenWithToken with a different token while a channel exists returns
+// ErrOpenTokenMismatch.
+func (a *Agent) OpenWithToken(asset state.Asset, token string) error {
+	if token == "" {
+		return fmt.Errorf("token is required")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return a.open(asset, token, true)
+	}
+	if a.openToken != token {
+		return ErrOpenTokenMismatch
+	}
+	return a.resumeOpen(asset)
+}
+
+// resumeOpen continues the open of the channel from where it was
+// interrupted. It must be called with the mutex locked.
+func (a *Agent) resumeOpen(asset state.Asset) error {
+	if a.shuttingDown {
+		return ErrShuttingDown
+	}
+	if a.paused {
+		return ErrPaused
+	}
+	open := a.channel.OpenAgreement()
+	openAsset := open.Envelope.Details.Asset
+	if openAsset != asset && !(openAsset.IsNative() && asset.IsNative()) {
+		return fmt.Errorf("open in progress is in asset %s, not %s", openAsset.StringCanonical(), asset.StringCanonical())
+	}
+
+	s, err := a.channel.State()
+	if err != nil {
+		return fmt.Errorf("getting channel state: %w", err)
+	}
+	switch s {
+	case state.StateNone:
+	case state.StateError:
+		return fmt.Errorf("open transaction executed with unexpected results")
+	default:
+		// The open transaction has been seen and the open is complete.
+		return nil
+	}
+	if !time.Now().Before(open.Envelope.Details.ExpiresAt) {
+		return ErrOpenExpired
+	}
+
+	if pending, ok := a.channel.PendingOpen(); ok {
+		if a.conn == nil {
+			return fmt.Errorf("not connected")
+		}
+		fmt.Fprintf(a.logWriter, "resuming open: sending open again\n")
+		err = a.sendRequest(msg.Message{
+			Type:            msg.TypeOpenRequest,
+			OpenRequest:     &pending.Envelope,
+			OpenByResponder: !a.channel.IsInitiator(),
+		})
+		if err != nil {
+			return fmt.Errorf("sending open: %w", err)
+		}
+		a.watchOpenResponse(pending)
+		return nil
+	}
+
+	fmt.Fprintf(a.logWriter, "resuming open: submitting open tx again\n")
+	openTx, err := a.channel.OpenTx()
+	if err != nil {
+		return fmt.Errorf("building open tx: %w", err)
+	}
+	err = a.submitter.SubmitTx(openTx)
+	if err != nil {
+		return fmt.Errorf("submitting open tx: %w", err)
+	}
+	a.watchOpen()
+	return nil
+}
+
+// handleRepeatedOpenRequest handles an open request received when the agent
+// already has a channel. If the request is for the open the agent has already
+// confirmed, such as when the other participant is resuming an open whose
+// response was lost, the confirmation is sent again. Otherwise the request is
+// rejected. It must be called with the mutex locked.
+func (a *Agent) handleRepeatedOpenRequest(openIn state.OpenEnvelope) error {
+	open := a.channel.OpenAgreement()
+	if a.channel.IsOpenProposer() || !open.Envelope.Details.Equal(openIn.Details) || !open.Envelope.HasAllSignatures() {
+		return a.reject(msg.TypeOpenRequest, 0, fmt.Errorf("channel already exists"))
+	}
+	fmt.Fprintf(a.logWriter, "open requested again, sending confirmation again\n")
+	err := a.send(msg.Message{
+		Type:         msg.TypeOpenResponse,
+		OpenResponse: &open.Envelope.ConfirmerSignatures,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding open to send back: %w", err)
+	}
+	return nil
+}
+
+// CancelOpen discards an open proposed by the agent so that a new channel can
+// be opened, and forgets the token it was started with.
+//
+// The other participant holds this participant's signatures for the open
+// transaction once it has been proposed, and can submit it until the open
+// agreement expires. So that the agent does not stop watching a channel that
+// can still open, only an open that has expired without the open transaction
+// being seen, or whose open transaction failed, can be canceled. Otherwise
+// ErrOpenNotCancelable is returned.
+func (a *Agent) CancelOpen() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil || !a.channel.IsOpenProposer() {
+		return fmt.Errorf("%w: no open proposed by the agent", ErrOpenNotCancelable)
+	}
+	s, err := a.channel.State()
+	if err != nil {
+		return fmt.Errorf("getting channel state: %w", err)
+	}
+	open := a.channel.OpenAgreement()
+	switch s {
+	case state.StateNone:
+		expiresAt := open.Envelope.Details.ExpiresAt
+		if time.Now().Before(expiresAt) {
+			return fmt.Errorf("%w: open agreement expires at %v", ErrOpenNotCancelable, expiresAt)
+		}
+	case state.StateError:
+	default:
+		return fmt.Errorf("%w: open transaction executed", ErrOpenNotCancelable)
+	}
+
+	fmt.Fprintf(a.logWriter, "open canceled\n")
+	a.streamerCancel()
+	a.streamerTransactions = nil
+	a.channel = nil
+	a.openToken = ""
+	a.takeSnapshot()
+	if a.events != nil {
+		a.events <- OpenCanceledEvent{OpenAgreement: open}
+	}
+	return nil
+}
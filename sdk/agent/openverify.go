@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/stellar/go/keypair"
+)
+
+// verifyOpen checks with the balance collector that both channel accounts
+// hold at least their contributions in the open agreement, and writes an
+// OpenVerifiedEvent if they do, or an OpenMismatchEvent for the first that
+// does not. Only the participant that proposed the open, and so submitted the
+// open transaction, verifies the open. It must be called with the mutex
+// locked.
+func (a *Agent) verifyOpen() {
+	if !a.verifyOpenBalances || a.events == nil || !a.channel.IsOpenProposer() {
+		return
+	}
+
+	open := a.channel.OpenAgreement()
+	details := open.Envelope.Details
+	localContribution, remoteContribution := details.InitiatorContribution, details.ResponderContribution
+	if !a.channel.IsInitiator() {
+		localContribution, remoteContribution = remoteContribution, localContribution
+	}
+	accounts := []struct {
+		account      *keypair.FromAddress
+		contribution int64
+	}{
+		{a.channelAccountKey, localContribution},
+		{a.otherChannelAccount, remoteContribution},
+	}
+	for _, c := range accounts {
+		balance, err := a.collectBalance(c.account, details.Asset)
+		if err != nil {
+			a.events <- ErrorEvent{Err: fmt.Errorf("verifying open: getting balance of %s: %w", c.account.Address(), err)}
+			return
+		}
+		if balance < c.contribution {
+			fmt.Fprintf(a.logWriter, "open mismatch: %s has %d, less than the contribution %d\n", c.account.Address(), balance, c.contribution)
+			a.events <- OpenMismatchEvent{
+				OpenAgreement:  open,
+				ChannelAccount: c.account,
+				Contribution:   c.contribution,
+				Balance:        balance,
+			}
+			return
+		}
+	}
+	a.events <- OpenVerifiedEvent{OpenAgreement: open}
+}
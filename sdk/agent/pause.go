@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPaused indicates that the agent is paused and is not accepting new
+// opens or payments. Requests rejected with it are sent the
+// msg.RejectCodeTryLater code, and can be proposed again once the agent
+// resumes.
+var ErrPaused = errors.New("agent is paused")
+
+// Pause stops the agent accepting new opens and payments until Resume is
+// called, such as during maintenance. Opens and payments proposed by the other
+// participant while paused are rejected with the msg.RejectCodeTryLater code,
+// and Open returns ErrPaused. The connection and channel are unaffected, and
+// agreements already in flight, payments made by the agent, and closes
+// continue as usual. A PausedEvent is written if the agent was not already
+// paused.
+func (a *Agent) Pause() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.paused {
+		return
+	}
+	a.paused = true
+	fmt.Fprintf(a.logWriter, "paused\n")
+	if a.events != nil {
+		a.events <- PausedEvent{}
+	}
+}
+
+// Resume has a paused agent accept opens and payments again. A ResumedEvent
+// is written if the agent was paused.
+func (a *Agent) Resume() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.paused {
+		return
+	}
+	a.paused = false
+	fmt.Fprintf(a.logWriter, "resumed\n")
+	if a.events != nil {
+		a.events <- ResumedEvent{}
+	}
+}
+
+// Paused returns true if the agent is paused.
+func (a *Agent) Paused() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.paused
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+)
+
+// watchPayment abandons the payment with the iteration number if it is still
+// pending when the payment timeout passes. It must be called with the mutex
+// locked.
+func (a *Agent) watchPayment(iterationNumber int64) {
+	timeout := a.effectivePaymentTimeout()
+	if timeout <= 0 {
+		return
+	}
+	time.AfterFunc(timeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.expirePayment(iterationNumber)
+	})
+}
+
+// effectivePaymentTimeout returns the payment timeout, or the response timeout
+// if no payment timeout is configured.
+func (a *Agent) effectivePaymentTimeout() time.Duration {
+	if a.paymentTimeout > 0 {
+		return a.paymentTimeout
+	}
+	return a.responseTimeout
+}
+
+// expirePayment abandons the payment proposed by this participant with the
+// iteration number if it is still pending.
+//
+// The payment is discarded and its iteration number is never reused, in the
+// same way as a rejected payment, so that the next agreement supersedes it.
+// The other participant is sent a rejection with the RejectCodeExpired code
+// to tell it the payment was withdrawn. A confirmation of the payment that
+// arrives later no longer matches a pending payment and is rejected by
+// handlePaymentResponse.
+//
+// If the other participant had already confirmed the payment, it holds an
+// agreement this participant has abandoned, and the participants disagree
+// on the latest agreement. See handlePaymentWithdrawn.
+//
+// It must be called with the mutex locked.
+func (a *Agent) expirePayment(iterationNumber int64) {
+	if a.channel == nil {
+		return
+	}
+	payment, ok := a.channel.PendingPayment()
+	if !ok || payment.Envelope.Details.IterationNumber != iterationNumber ||
+		!payment.Envelope.Details.ProposingSigner.Equal(a.signer().FromAddress()) {
+		return
+	}
+	err := a.channel.RejectPayment(iterationNumber)
+	if err != nil {
+		fmt.Fprintf(a.logWriter, "abandoning payment %d: %v\n", iterationNumber, err)
+		return
+	}
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "payment %d timed out after %v\n", iterationNumber, a.effectivePaymentTimeout())
+
+	err = a.send(msg.Message{
+		Type: msg.TypeReject,
+		Reject: &msg.Reject{
+			Type:            msg.TypePaymentRequest,
+			IterationNumber: iterationNumber,
+			Code:            msg.RejectCodeExpired,
+			Reason:          fmt.Sprintf("payment timed out after %v", a.effectivePaymentTimeout()),
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(a.logWriter, "sending payment withdrawal: %v\n", err)
+	}
+	if a.events != nil {
+		a.events <- PaymentTimeoutEvent{CloseAgreement: payment}
+	}
+}
+
+// handlePaymentWithdrawn handles the other participant withdrawing a payment
+// it proposed. Requests are handled in the order they are received, so the
+// payment has already been handled. If it was rejected there is nothing to do.
+// If it was confirmed, the other participant has abandoned an agreement this
+// participant holds, and so an error is returned because the participants
+// disagree on the latest agreement and further payments will fail. This
+// participant can still close the channel with the agreement it holds. It
+// must be called with the mutex locked.
+func (a *Agent) handlePaymentWithdrawn(iterationNumber int64) error {
+	latest := a.channel.LatestCloseAgreement()
+	if latest.Envelope.Details.IterationNumber == iterationNumber {
+		return fmt.Errorf("payment %d was withdrawn by the other participant after it was confirmed", iterationNumber)
+	}
+	fmt.Fprintf(a.logWriter, "payment %d withdrawn by remote\n", iterationNumber)
+	return nil
+}
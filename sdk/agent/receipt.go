@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// PaymentDirection is the direction of a payment relative to the agent.
+type PaymentDirection string
+
+const (
+	PaymentDirectionSent     PaymentDirection = "sent"
+	PaymentDirectionReceived PaymentDirection = "received"
+)
+
+// defaultReceiptBufferSize is the number of receipts queued for the
+// ReceiptWriter if no ReceiptBufferSize is configured.
+const defaultReceiptBufferSize = 256
+
+// Receipt is the record of a settled payment written to the ReceiptWriter.
+// Each receipt is encoded as a single line of JSON.
+type Receipt struct {
+	// IterationNumber is the iteration number of the payment's agreement.
+	IterationNumber int64
+	Direction       PaymentDirection
+	Amount          int64
+	// Balance is the amount owing from the initiator to the responder after
+	// the payment, if positive, or from the responder to the initiator, if
+	// negative.
+	Balance  int64
+	MemoType state.MemoType
+	Memo     []byte
+	// Time is when the payment was settled.
+	Time time.Time
+}
+
+// writeReceipt queues a receipt of the settled payment for the
+// ReceiptWriter, if one is configured. It blocks only if the queue is full. It
+// must be called with the mutex locked.
+func (a *Agent) writeReceipt(direction PaymentDirection, payment state.CloseAgreement) {
+	if a.receipts == nil {
+		return
+	}
+	d := payment.Envelope.Details
+	a.receipts <- Receipt{
+		IterationNumber: d.IterationNumber,
+		Direction:       direction,
+		Amount:          d.PaymentAmount,
+		Balance:         d.Balance,
+		MemoType:        d.MemoType,
+		Memo:            d.Memo,
+		Time:            time.Now().UTC(),
+	}
+}
+
+// receiptLoop writes the receipts queued by writeReceipt to the
+// ReceiptWriter, each with a single write so that a writer appending to a
+// file appends whole records. Errors writing are written as ErrorEvents and
+// the receipt is not retried.
+func (a *Agent) receiptLoop(receipts <-chan Receipt) {
+	for r := range receipts {
+		b := bytes.Buffer{}
+		err := json.NewEncoder(&b).Encode(r)
+		if err == nil {
+			_, err = a.receiptWriter.Write(b.Bytes())
+		}
+		if err != nil {
+			err = fmt.Errorf("writing receipt of payment %d: %w", r.IterationNumber, err)
+			fmt.Fprintf(a.logWriter, "%v\n", err)
+			if a.events != nil {
+				a.events <- ErrorEvent{Err: err}
+			}
+		}
+	}
+}
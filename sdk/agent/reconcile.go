@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// Reconcile compares the latest authorized agreement of the channel with the
+// other participant's, so that a participant that is behind, such as one
+// that never received the confirmation of a payment it proposed, catches up
+// without closing the channel. The agent sends its latest authorized
+// agreement, the other participant catches up to it if it is newer and
+// replies with its own, and the agent catches up to that if it is newer. A
+// ReconciledEvent is written when the reply is received.
+//
+// A participant only catches up to an agreement that is signed by both
+// participants, and so only to an agreement that it signed itself. See
+// state.Channel.CatchUp.
+func (a *Agent) Reconcile() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	if a.channel == nil {
+		return fmt.Errorf("no channel")
+	}
+
+	latest := a.channel.LatestCloseAgreement()
+	err := a.send(msg.Message{
+		Type:             msg.TypeReconcileRequest,
+		ReconcileRequest: &latest.Envelope,
+	})
+	if err != nil {
+		return fmt.Errorf("sending reconcile request: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) handleReconcileRequest(m msg.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if m.ReconcileRequest == nil {
+		return a.reject(msg.TypeReconcileRequest, 0, fmt.Errorf("%w: reconcile request missing", ErrMalformedMessage))
+	}
+
+	remote := *m.ReconcileRequest
+	if a.channel == nil {
+		return a.reject(msg.TypeReconcileRequest, remote.Details.IterationNumber, fmt.Errorf("no channel"))
+	}
+
+	caughtUp, err := a.catchUp(remote)
+	if err != nil {
+		return a.reject(msg.TypeReconcileRequest, remote.Details.IterationNumber, err)
+	}
+	latest := a.channel.LatestCloseAgreement()
+	if caughtUp && a.events != nil {
+		a.events <- ReconciledEvent{CloseAgreement: latest, CaughtUp: true}
+	}
+
+	err = a.send(msg.Message{
+		Type:              msg.TypeReconcileResponse,
+		ReconcileResponse: &latest.Envelope,
+	})
+	if err != nil {
+		return fmt.Errorf("sending reconcile response: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) handleReconcileResponse(m msg.Message) error {
+	if m.ReconcileResponse == nil {
+		return fmt.Errorf("%w: reconcile response missing", ErrMalformedMessage)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return fmt.Errorf("no channel")
+	}
+
+	caughtUp, err := a.catchUp(*m.ReconcileResponse)
+	if err != nil {
+		return err
+	}
+	if a.events != nil {
+		a.events <- ReconciledEvent{CloseAgreement: a.channel.LatestCloseAgreement(), CaughtUp: caughtUp}
+	}
+	return nil
+}
+
+// catchUp catches up to the agreement of the other participant if it is newer
+// than the latest authorized agreement, and returns true if it caught up. It
+// must be called with the mutex locked.
+func (a *Agent) catchUp(remote state.CloseEnvelope) (bool, error) {
+	latest := a.channel.LatestCloseAgreement()
+	if remote.Details.IterationNumber <= latest.Envelope.Details.IterationNumber {
+		return false, nil
+	}
+	_, err := a.channel.CatchUp(remote)
+	if err != nil {
+		return false, fmt.Errorf("catching up to iteration %d: %w", remote.Details.IterationNumber, err)
+	}
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "caught up from iteration %d to %d\n", latest.Envelope.Details.IterationNumber, remote.Details.IterationNumber)
+	return true, nil
+}
@@ -0,0 +1,278 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/stellar/go/keypair"
+)
+
+const (
+	// defaultReconnectMinBackoff is how long a ReconnectingAgent waits before
+	// its first attempt to reconnect if no minimum backoff is configured.
+	defaultReconnectMinBackoff = time.Second
+	// defaultReconnectMaxBackoff is the longest a ReconnectingAgent waits
+	// between attempts to reconnect if no maximum backoff is configured.
+	defaultReconnectMaxBackoff = time.Minute
+)
+
+// ConnState is the state of the connection of a ReconnectingAgent.
+type ConnState int
+
+const (
+	// ConnStateStopped is the state before Run is called and after it
+	// returns.
+	ConnStateStopped ConnState = iota
+	// ConnStateConnecting is the state while dialing the other participant.
+	ConnStateConnecting
+	// ConnStateConnected is the state while connected to the other
+	// participant.
+	ConnStateConnected
+	// ConnStateWaiting is the state while waiting to reconnect after a
+	// connection ended or an attempt to connect failed.
+	ConnStateWaiting
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnStateStopped:
+		return "stopped"
+	case ConnStateConnecting:
+		return "connecting"
+	case ConnStateConnected:
+		return "connected"
+	case ConnStateWaiting:
+		return "waiting"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// ReconnectingConfig contains the information that can be supplied to
+// configure a ReconnectingAgent at construction.
+type ReconnectingConfig struct {
+	// Agent is the config of the agent. The events of the agent are written
+	// to its Events channel. If Store is set and the config has no
+	// Snapshotter, snapshots are saved to the Store.
+	Agent Config
+
+	// Dial returns a new connection to the other participant. It is called
+	// each time the agent connects, and may dial out or wait for an incoming
+	// connection. See Agent.ServeConn for the connections supported.
+	Dial func(ctx context.Context) (io.ReadWriter, error)
+
+	// Store, if set, is where snapshots of the channel are saved, and where
+	// the channel with RemoteChannelAccount is restored from when the
+	// ReconnectingAgent is constructed.
+	Store Store
+	// RemoteChannelAccount is the channel account of the other participant,
+	// used to find the channel's snapshot in the Store. If nil, no snapshot
+	// is restored.
+	RemoteChannelAccount *keypair.FromAddress
+
+	// MinBackoff is how long to wait before the first attempt to reconnect
+	// after the connection ends. Each failed attempt doubles the wait, up to
+	// MaxBackoff. They default to one second and one minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ReconnectingAgent keeps an Agent connected to the other participant,
+// reconnecting with backoff whenever the connection ends. The channel and its
+// agreements are kept by the same Agent across connections, and the Agent's
+// events from every connection are written to the configured Events channel,
+// including a DisconnectedEvent each time a connection ends.
+//
+// Messages in flight when a connection ends are lost. Configure
+// Config.ReplayOnReconnect so that an unanswered open or payment is sent again
+// when reconnected, and Config.PaymentTimeout or Config.ResponseTimeout so
+// that a payment or close that is never answered is abandoned.
+type ReconnectingAgent struct {
+	agent      *Agent
+	dial       func(ctx context.Context) (io.ReadWriter, error)
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	events     chan<- interface{}
+
+	disconnects chan struct{}
+
+	mu      sync.Mutex
+	state   ConnState
+	lastErr error
+}
+
+// NewReconnectingAgent constructs a ReconnectingAgent. If a Store and
+// RemoteChannelAccount are configured and the Store holds a snapshot of the
+// channel, the agent is restored from the snapshot.
+func NewReconnectingAgent(c ReconnectingConfig) (*ReconnectingAgent, error) {
+	if c.Dial == nil {
+		return nil, fmt.Errorf("dial function is required")
+	}
+	r := &ReconnectingAgent{
+		dial:        c.Dial,
+		minBackoff:  c.MinBackoff,
+		maxBackoff:  c.MaxBackoff,
+		events:      c.Agent.Events,
+		disconnects: make(chan struct{}, 1),
+	}
+	if r.minBackoff <= 0 {
+		r.minBackoff = defaultReconnectMinBackoff
+	}
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = defaultReconnectMaxBackoff
+	}
+	if r.maxBackoff < r.minBackoff {
+		r.maxBackoff = r.minBackoff
+	}
+
+	// The agent's events are passed through a channel owned by the
+	// ReconnectingAgent so that it can see when connections end.
+	events := make(chan interface{})
+	agentConfig := c.Agent
+	agentConfig.Events = events
+	if c.Store != nil && agentConfig.Snapshotter == nil {
+		agentConfig.Snapshotter = StoreSnapshotter{Store: c.Store}
+	}
+
+	var snapshot *Snapshot
+	if c.Store != nil && c.RemoteChannelAccount != nil {
+		id := ChannelID{
+			LocalChannelAccount:  c.Agent.ChannelAccountKey.Address(),
+			RemoteChannelAccount: c.RemoteChannelAccount.Address(),
+		}
+		s, err := c.Store.Load(id)
+		if err == nil {
+			snapshot = &s
+		} else if !errors.Is(err, ErrChannelNotFound) {
+			return nil, fmt.Errorf("loading snapshot: %w", err)
+		}
+	}
+	if snapshot != nil {
+		r.agent = NewAgentFromSnapshot(agentConfig, *snapshot)
+	} else {
+		r.agent = NewAgent(agentConfig)
+	}
+
+	go r.forwardEvents(events)
+	return r, nil
+}
+
+// Agent returns the agent that is kept connected. Its methods can be called
+// to open, pay, and close the channel while it is connected.
+func (r *ReconnectingAgent) Agent() *Agent {
+	return r.agent
+}
+
+// ConnState returns the current state of the connection, and the error that
+// ended the last connection or attempt to connect, if any.
+func (r *ReconnectingAgent) ConnState() (ConnState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state, r.lastErr
+}
+
+func (r *ReconnectingAgent) setConnState(s ConnState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = s
+	if err != nil {
+		r.lastErr = err
+	}
+}
+
+// Run connects the agent and reconnects it each time the connection ends,
+// until the context is done. When the context is done, the agent is
+// disconnected and the context's error is returned. The channel is not closed
+// and Run can be called again to reconnect.
+func (r *ReconnectingAgent) Run(ctx context.Context) error {
+	defer r.setConnState(ConnStateStopped, nil)
+	defer r.agent.disconnect()
+
+	backoff := r.minBackoff
+	for {
+		r.setConnState(ConnStateConnecting, nil)
+		err := r.connect(ctx)
+		if err == nil {
+			r.setConnState(ConnStateConnected, nil)
+			backoff = r.minBackoff
+			select {
+			case <-r.disconnects:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			r.setConnState(ConnStateWaiting, nil)
+		} else {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(r.agent.logWriter, "reconnecting in %v: %v\n", backoff, err)
+			r.setConnState(ConnStateWaiting, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// connect dials the other participant and starts the agent on the new
+// connection.
+func (r *ReconnectingAgent) connect(ctx context.Context) error {
+	// Discard any disconnect of a previous connection that was not waited
+	// for.
+	select {
+	case <-r.disconnects:
+	default:
+	}
+	if !r.agent.disconnected() {
+		return fmt.Errorf("already connected")
+	}
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dialing: %w", err)
+	}
+	err = r.agent.ServeConn(conn)
+	if err != nil {
+		r.agent.disconnect()
+		if c, ok := conn.(io.Closer); ok {
+			c.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+// forwardEvents writes the agent's events to the configured events channel,
+// and signals Run when a connection ends.
+func (r *ReconnectingAgent) forwardEvents(events <-chan interface{}) {
+	for e := range events {
+		ended, err := false, error(nil)
+		switch e := e.(type) {
+		case DisconnectedEvent:
+			ended, err = true, e.Err
+		case ConnectFailedEvent:
+			ended, err = true, e.Err
+		}
+		if ended {
+			r.mu.Lock()
+			r.lastErr = err
+			r.mu.Unlock()
+			select {
+			case r.disconnects <- struct{}{}:
+			default:
+			}
+		}
+		if r.events != nil {
+			r.events <- e
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go/xdr"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild"
+)
+
+// ErrChannelAccountNotRecoverable indicates that the channel account cannot
+// be recovered, because a channel may have been formed with it.
+var ErrChannelAccountNotRecoverable = errors.New("channel account cannot be recovered")
+
+// RecoverUnopenedAccount returns the funds held by the agent's channel account
+// to its creator, which is the agent's channel account signer, by merging the
+// channel account into it. It is intended for recovering funds after an open
+// never completed, such as when the other participant vanished after an open
+// was proposed and before it was signed or submitted.
+//
+// It only runs when no channel can have formed: the agent has no channel, or
+// its open agreement has expired without the open transaction being seen and
+// the initiator's channel account has not reached the sequence number of the
+// open transaction on the network. Otherwise ErrChannelAccountNotRecoverable
+// is returned. If the agent has no channel, the channel account is expected to
+// hold only the native asset.
+//
+// Once recovered the channel account no longer exists, the agent forgets its
+// channel, and a ChannelAccountRecoveredEvent is written.
+func (a *Agent) RecoverUnopenedAccount() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	asset := state.NativeAsset
+	if a.channel != nil {
+		s, err := a.channel.State()
+		if err != nil {
+			return fmt.Errorf("getting channel state: %w", err)
+		}
+		if s != state.StateNone {
+			return fmt.Errorf("%w: open transaction executed", ErrChannelAccountNotRecoverable)
+		}
+		open := a.channel.OpenAgreement()
+		if !open.Envelope.Empty() {
+			d := open.Envelope.Details
+			if time.Now().Before(d.ExpiresAt) {
+				return fmt.Errorf("%w: open agreement expires at %v", ErrChannelAccountNotRecoverable, d.ExpiresAt)
+			}
+			initiatorChannelAccount := a.channelAccountKey
+			if !a.channel.IsInitiator() {
+				initiatorChannelAccount = a.otherChannelAccount
+			}
+			seqNum, err := a.sequenceNumberCollector.GetSequenceNumber(initiatorChannelAccount)
+			if err != nil {
+				return fmt.Errorf("getting sequence number of initiator channel account: %w", err)
+			}
+			if seqNum >= d.StartingSequence {
+				return fmt.Errorf("%w: initiator channel account sequence number %d reached open transaction sequence number %d",
+					ErrChannelAccountNotRecoverable, seqNum, d.StartingSequence)
+			}
+			asset = d.Asset
+		}
+	}
+
+	seqNum, err := a.sequenceNumberCollector.GetSequenceNumber(a.channelAccountKey)
+	if err != nil {
+		return fmt.Errorf("getting sequence number of channel account: %w", err)
+	}
+	var assetBalance int64
+	if !asset.IsNative() {
+		assetBalance, err = a.balanceCollector.GetBalance(a.channelAccountKey, asset)
+		if err != nil {
+			return fmt.Errorf("getting balance of channel account: %w", err)
+		}
+	}
+	creator := a.channelAccountSigner.FromAddress()
+	tx, err := txbuild.RecoverChannelAccount(txbuild.RecoverChannelAccountParams{
+		ChannelAccount: a.channelAccountKey,
+		Creator:        creator,
+		SequenceNumber: seqNum + 1,
+		Asset:          asset.Asset(),
+		AssetBalance:   assetBalance,
+	})
+	if err != nil {
+		return fmt.Errorf("building recover tx: %w", err)
+	}
+	hash, err := tx.Hash(a.networkPassphrase)
+	if err != nil {
+		return fmt.Errorf("hashing recover tx: %w", err)
+	}
+	sig, err := a.channelAccountSigner.Sign(hash[:])
+	if err != nil {
+		return fmt.Errorf("signing recover tx: %w", err)
+	}
+	tx, err = tx.AddSignatureDecorated(xdr.NewDecoratedSignature(sig, creator.Hint()))
+	if err != nil {
+		return fmt.Errorf("adding signature to recover tx: %w", err)
+	}
+	err = a.submitter.SubmitTx(tx)
+	if err != nil {
+		err = fmt.Errorf("submitting recover tx: %w", err)
+		a.reportFeeAccountUnderfunded(err)
+		return err
+	}
+
+	fmt.Fprintf(a.logWriter, "channel account %s recovered to %s\n", a.channelAccountKey.Address(), creator.Address())
+	if a.channel != nil {
+		a.streamerCancel()
+		a.streamerTransactions = nil
+		a.channel = nil
+		a.openToken = ""
+		a.takeSnapshot()
+	}
+	if a.events != nil {
+		a.events <- ChannelAccountRecoveredEvent{ChannelAccount: a.channelAccountKey, Creator: creator}
+	}
+	return nil
+}
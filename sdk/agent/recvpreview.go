@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+)
+
+// recvPreviewSize is the number of the most recently read bytes of a
+// connection that are included in the error when a message cannot be decoded.
+const recvPreviewSize = 128
+
+// tailReader is a reader that remembers the last bytes read from the
+// underlying reader, so that they can be shown when a message read from it
+// cannot be decoded. The decoder reads ahead, and so the bytes are those most
+// recently read from the connection, which end with or include the bytes that
+// could not be decoded. The last error returned by the underlying reader is
+// kept so that a failing connection can be told apart from malformed bytes.
+type tailReader struct {
+	r    io.Reader
+	tail []byte
+	err  error
+}
+
+func (r *tailReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.err = err
+	r.tail = append(r.tail, p[:n]...)
+	if len(r.tail) > recvPreviewSize {
+		r.tail = append(r.tail[:0], r.tail[len(r.tail)-recvPreviewSize:]...)
+	}
+	return n, err
+}
+
+// preview returns a description of the last bytes read, as hex and as a
+// quoted string.
+func (r *tailReader) preview() string {
+	return fmt.Sprintf("last %d bytes read: %x %q", len(r.tail), r.tail, r.tail)
+}
+
+// reportReadError writes an ErrorEvent with the error that a message read from
+// the connection with the counters could not be decoded, if it is still
+// attached, so that the bytes that could not be decoded are seen by whatever
+// monitors the events.
+func (a *Agent) reportReadError(counters *connCounters, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == nil || a.connCounters != counters || a.events == nil {
+		return
+	}
+	a.events <- ErrorEvent{Err: err}
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// sendRequest sends the open or payment request and remembers it as the last
+// request sent, so that it can be replayed on a new connection if it is not
+// answered. It must be called with the mutex locked.
+func (a *Agent) sendRequest(m msg.Message) error {
+	a.lastRequest = &m
+	return a.send(m)
+}
+
+// replayLastRequest sends the last request sent again if the agent is
+// configured to replay on reconnect and the request is still unanswered. The
+// request is forgotten once it has been answered. It must be called with the
+// mutex locked.
+func (a *Agent) replayLastRequest() {
+	m := a.lastRequest
+	if !a.replayOnReconnect || m == nil {
+		return
+	}
+	if !a.isUnanswered(*m) {
+		a.lastRequest = nil
+		return
+	}
+	fmt.Fprintf(a.logWriter, "replaying unanswered request of type %d\n", m.Type)
+	err := a.send(*m)
+	if err != nil {
+		fmt.Fprintf(a.logWriter, "replaying request: %v\n", err)
+	}
+}
+
+// isUnanswered returns true if the request is the open or payment proposed by
+// the agent that the channel holds as pending. It must be called with the
+// mutex locked.
+func (a *Agent) isUnanswered(m msg.Message) bool {
+	if a.channel == nil {
+		return false
+	}
+	switch m.Type {
+	case msg.TypeOpenRequest:
+		open, ok := a.channel.PendingOpen()
+		return ok && a.channel.IsOpenProposer() && open.Envelope.Details.Equal(m.OpenRequest.Details)
+	case msg.TypePaymentRequest:
+		payment, ok := a.channel.PendingPayment()
+		return ok && payment.Envelope.Details.Equal(m.PaymentRequest.Details) &&
+			payment.Envelope.Details.ProposingSigner.Equal(a.signer().FromAddress())
+	}
+	return false
+}
+
+// isRepeatedPaymentRequest returns true if the payment is the latest
+// authorized agreement and was proposed by the other participant, such as when
+// the other participant replays a payment whose confirmation was lost. It
+// must be called with the mutex locked.
+func (a *Agent) isRepeatedPaymentRequest(paymentIn state.CloseEnvelope) bool {
+	if a.channel == nil {
+		return false
+	}
+	latest := a.channel.LatestCloseAgreement()
+	return latest.Envelope.Details.IterationNumber > 0 &&
+		latest.Envelope.Details.Equal(paymentIn.Details) &&
+		latest.Envelope.Details.ConfirmingSigner.Equal(a.signer().FromAddress())
+}
+
+// handleRepeatedPaymentRequest sends again the confirmation of a payment that
+// the agent has already confirmed. The payment is not received again. It must
+// be called with the mutex locked.
+func (a *Agent) handleRepeatedPaymentRequest(paymentIn state.CloseEnvelope) error {
+	latest := a.channel.LatestCloseAgreement()
+	fmt.Fprintf(a.logWriter, "payment %d requested again, sending confirmation again\n", paymentIn.Details.IterationNumber)
+	err := a.send(msg.Message{
+		Type:            msg.TypePaymentResponse,
+		PaymentResponse: &latest.Envelope.ConfirmerSignatures,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding payment to send back: %w", err)
+	}
+	return nil
+}
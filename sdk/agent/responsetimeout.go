@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// watchOpenResponse reports the open proposed by this participant as timed
+// out if it is still not authorized when the response timeout passes. It must
+// be called with the mutex locked.
+func (a *Agent) watchOpenResponse(open state.OpenAgreement) {
+	if a.responseTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(a.responseTimeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.expireOpenResponse(open)
+	})
+}
+
+// expireOpenResponse emits an OpenResponseTimeoutEvent if the open agreement
+// is still the channel's open agreement and is not authorized.
+//
+// The open agreement is not discarded, for the same reason a rejected open is
+// not, because the other participant holds this participant's signatures for
+// it. It expires at its ExpiresAt if it is never authorized.
+//
+// It must be called with the mutex locked.
+func (a *Agent) expireOpenResponse(open state.OpenAgreement) {
+	if a.channel == nil {
+		return
+	}
+	current, pending := a.channel.PendingOpen()
+	if !pending || !current.Envelope.Equal(open.Envelope) {
+		return
+	}
+	fmt.Fprintf(a.logWriter, "open response timed out after %v\n", a.responseTimeout)
+	if a.events != nil {
+		a.events <- OpenResponseTimeoutEvent{OpenAgreement: current}
+	}
+}
+
+// watchCloseResponse abandons the coordinated close proposed by this
+// participant with the iteration number if it is still pending when the
+// response timeout passes. It must be called with the mutex locked.
+func (a *Agent) watchCloseResponse(iterationNumber int64) {
+	if a.responseTimeout <= 0 {
+		return
+	}
+	time.AfterFunc(a.responseTimeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.expireCloseResponse(iterationNumber)
+	})
+}
+
+// expireCloseResponse abandons the coordinated close proposed by this
+// participant with the iteration number if it is still pending.
+//
+// The close is discarded in the same way as a rejected close. The declaration
+// has already been submitted, and so the channel can still be closed by
+// calling Close once the observation period has passed. A confirmation of
+// the close that arrives later no longer matches a pending close and
+// handleCloseResponse returns an error.
+//
+// It must be called with the mutex locked.
+func (a *Agent) expireCloseResponse(iterationNumber int64) {
+	if a.channel == nil {
+		return
+	}
+	close, ok := a.channel.LatestUnauthorizedCloseAgreement()
+	if !ok {
+		return
+	}
+	d := close.Envelope.Details
+	if d.IterationNumber != iterationNumber ||
+		d.ObservationPeriodTime != 0 || d.ObservationPeriodLedgerGap != 0 ||
+		!d.ProposingSigner.Equal(a.signer().FromAddress()) {
+		return
+	}
+	err := a.channel.RejectClose()
+	if err != nil {
+		fmt.Fprintf(a.logWriter, "abandoning close: %v\n", err)
+		return
+	}
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "close response timed out after %v\n", a.responseTimeout)
+	if a.events != nil {
+		a.events <- CloseResponseTimeoutEvent{CloseAgreement: close}
+	}
+}
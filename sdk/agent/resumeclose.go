@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// ErrCloseNotDeclared indicates that the agent has not seen a declaration of
+// the channel's close on the network.
+var ErrCloseNotDeclared = errors.New("close not declared")
+
+// ResumeClose completes the close of a channel whose close has been declared
+// on the network, without coordinating with the other participant. It
+// recovers a close when the messages that would have completed it were lost,
+// such as when either participant restarted after the close was declared.
+//
+// If the declaration seen is of an outdated close agreement, the declaration
+// of the latest close agreement is submitted first. The close transaction is
+// then submitted once the observation period has passed, or immediately if
+// the latest close agreement is a coordinated close. Waiting and submitting
+// happen in the background after ResumeClose returns, and a failed submission
+// is written as an ErrorEvent and retried after a ledger, until the close is
+// submitted or the agent shuts down.
+//
+// If the declaration was seen before the agent was restored from a snapshot,
+// the time it was declared is unknown and the observation period is waited
+// for from the time ResumeClose is called.
+//
+// An error wrapping ErrCloseNotDeclared is returned if the agent has not seen
+// a declaration, in which case ResumeClose can be called again once a
+// ClosingEvent or ClosingWithOutdatedStateEvent occurs.
+func (a *Agent) ResumeClose() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return fmt.Errorf("no channel")
+	}
+	s, err := a.channel.State()
+	if err != nil {
+		return fmt.Errorf("getting channel state: %w", err)
+	}
+	switch s {
+	case state.StateClosing:
+	case state.StateClosingWithOutdatedState:
+		declTx, _, err := a.closeTxs()
+		if err != nil {
+			return fmt.Errorf("building declaration tx: %w", err)
+		}
+		declHash, err := declTx.HashHex(a.networkPassphrase)
+		if err != nil {
+			return fmt.Errorf("hashing decl tx: %w", err)
+		}
+		fmt.Fprintln(a.logWriter, "submitting declaration of latest close agreement:", declHash)
+		err = a.submitter.SubmitTx(declTx)
+		if err != nil {
+			err = fmt.Errorf("submitting declaration tx %s: %w", declHash, err)
+			a.reportFeeAccountUnderfunded(err)
+			return err
+		}
+	case state.StateClosed, state.StateClosedWithOutdatedState:
+		return fmt.Errorf("channel already closed")
+	default:
+		return fmt.Errorf("%w: channel state %v", ErrCloseNotDeclared, s)
+	}
+
+	if a.closeDeclaredAt.IsZero() {
+		a.closeDeclaredAt = time.Now()
+	}
+	if a.closeResuming {
+		return nil
+	}
+	a.closeResuming = true
+	a.resumeCloseAfter(0)
+	return nil
+}
+
+// resumeCloseAfter submits the close transaction after d if it can be
+// submitted by then, otherwise it waits again until it can be.
+func (a *Agent) resumeCloseAfter(d time.Duration) {
+	time.AfterFunc(d, func() {
+		a.mu.Lock()
+		wait, done := a.resumeCloseWait(time.Now())
+		retry := a.averageLedgerDuration()
+		a.mu.Unlock()
+		if done {
+			return
+		}
+		if wait > 0 {
+			a.resumeCloseAfter(wait)
+			return
+		}
+		err := a.Close()
+		if err != nil {
+			if a.events != nil {
+				a.events <- ErrorEvent{Err: fmt.Errorf("resuming close: %w", err)}
+			}
+			a.resumeCloseAfter(retry)
+			return
+		}
+		a.mu.Lock()
+		a.closeResuming = false
+		a.mu.Unlock()
+	})
+}
+
+// resumeCloseWait returns how long until the close transaction can be
+// submitted, and true if the close should no longer be resumed. It must be
+// called with the mutex locked.
+func (a *Agent) resumeCloseWait(now time.Time) (wait time.Duration, done bool) {
+	if a.shuttingDown {
+		a.closeResuming = false
+		return 0, true
+	}
+	s, err := a.channel.State()
+	if err != nil {
+		a.closeResuming = false
+		return 0, true
+	}
+	switch s {
+	case state.StateClosing:
+		if isCoordinatedClose(a.channel.LatestCloseAgreement().Envelope.Details) {
+			return 0, false
+		}
+		return a.closeAvailableAt().Sub(now), false
+	case state.StateClosingWithOutdatedState:
+		// Wait for the declaration of the latest close agreement to be seen.
+		return a.averageLedgerDuration(), false
+	default:
+		a.closeResuming = false
+		return 0, true
+	}
+}
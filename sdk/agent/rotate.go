@@ -0,0 +1,330 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// RotateSigner replaces the signer of the agent's channel account with the
+// new signer, such as when the signer's key may have been compromised. A
+// *keypair.Full can be used as the new signer, or any other state.Signer.
+//
+// The agent proposes the rotation to the other participant with a close
+// agreement that keeps the balance of the latest agreement and is signed by
+// the new signer. Once the other participant confirms it, the agent submits a
+// transaction that replaces the signer on both channel accounts. The process
+// is asynchronous and the function returns once the rotation is sent to the
+// other participant. The account of the current signer must exist on the
+// network, because it is the source of the rotation transaction.
+//
+// The latest agreement signed by the old signer remains valid until the
+// rotation transaction is seen by the Streamer, at which point the agreement
+// signed for the new signer becomes the latest agreement and a
+// SignerRotatedEvent is written. If the rotation transaction fails, or a
+// ledger closes after it expires, the rotation is discarded and a
+// SignerRotationFailedEvent is written. An expired rotation is only noticed
+// from the ledgers of ingested transactions, or the status of a Streamer that
+// implements StreamStatusReporter. No payments or closes can be agreed while
+// the rotation is pending, and the rotation expires by the same time as an
+// open proposed by the agent, see Config.OpenExpiryMargin.
+//
+// Calling RotateSigner again with the same new signer while the rotation is
+// pending sends the rotation again, or submits the rotation transaction again
+// if it was confirmed, such as after reconnecting or after the agent was
+// restored from a snapshot.
+func (a *Agent) RotateSigner(newSigner state.Signer) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.shuttingDown {
+		return ErrShuttingDown
+	}
+	if a.paused {
+		return ErrPaused
+	}
+	if a.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	if a.channel == nil {
+		return fmt.Errorf("no channel")
+	}
+	if newSigner == nil {
+		return fmt.Errorf("no new signer")
+	}
+
+	if r, ok := a.channel.PendingSignerRotation(); ok && r.Envelope.Details.NewSigner.Equal(newSigner.FromAddress()) {
+		return a.resumeSignerRotation(newSigner)
+	}
+
+	// The rotation transaction has the old signer's account as its source, so
+	// that it does not consume the sequence numbers of the channel accounts.
+	oldSigner := a.signer().FromAddress()
+	seqNum, err := a.sequenceNumberCollector.GetSequenceNumber(oldSigner)
+	if err != nil {
+		return fmt.Errorf("getting sequence number of signer account: %w", err)
+	}
+	expiry, err := a.openExpiry()
+	if err != nil {
+		return err
+	}
+
+	r, err := a.channel.ProposeSignerRotation(newSigner, seqNum+1, time.Now().Add(expiry))
+	if err != nil {
+		return fmt.Errorf("proposing signer rotation: %w", err)
+	}
+	a.rotationSigner = newSigner
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "proposing signer rotation from %s to %s\n", oldSigner.Address(), newSigner.Address())
+
+	err = a.send(msg.Message{
+		Type:                  msg.TypeSignerRotationRequest,
+		SignerRotationRequest: &r.Envelope,
+	})
+	if err != nil {
+		return fmt.Errorf("sending signer rotation: %w", err)
+	}
+	a.watchSignerRotation(r)
+	return nil
+}
+
+// resumeSignerRotation gives the channel the new signer of the pending signer
+// rotation proposed by the agent, then sends the rotation again if it has not
+// been confirmed, or submits the rotation transaction again if it has. It
+// must be called with the mutex locked.
+func (a *Agent) resumeSignerRotation(newSigner state.Signer) error {
+	r, err := a.channel.ResumeSignerRotation(newSigner)
+	if err != nil {
+		return fmt.Errorf("resuming signer rotation: %w", err)
+	}
+	a.rotationSigner = newSigner
+	a.watchSignerRotation(r)
+
+	if len(r.Envelope.ProposerSignatures.Rotation) != 0 {
+		return a.submitSignerRotation(r)
+	}
+	fmt.Fprintf(a.logWriter, "sending signer rotation to %s again\n", newSigner.Address())
+	err = a.send(msg.Message{
+		Type:                  msg.TypeSignerRotationRequest,
+		SignerRotationRequest: &r.Envelope,
+	})
+	if err != nil {
+		return fmt.Errorf("sending signer rotation: %w", err)
+	}
+	return nil
+}
+
+// submitSignerRotation submits the rotation transaction of the signer
+// rotation signed by both participants. It must be called with the mutex
+// locked.
+func (a *Agent) submitSignerRotation(r state.SignerRotation) error {
+	tx, err := r.SignedTransaction()
+	if err != nil {
+		return fmt.Errorf("building signer rotation tx: %w", err)
+	}
+	hash, err := tx.HashHex(a.networkPassphrase)
+	if err != nil {
+		return fmt.Errorf("hashing signer rotation tx: %w", err)
+	}
+	fmt.Fprintln(a.logWriter, "submitting signer rotation", hash)
+	err = a.submitter.SubmitTx(tx)
+	if err != nil {
+		return fmt.Errorf("submitting signer rotation tx %s: %w", hash, err)
+	}
+	return nil
+}
+
+func (a *Agent) handleSignerRotationRequest(m msg.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if m.SignerRotationRequest == nil {
+		return a.reject(msg.TypeSignerRotationRequest, 0, fmt.Errorf("%w: signer rotation request missing", ErrMalformedMessage))
+	}
+
+	rotationIn := *m.SignerRotationRequest
+	iterationNumber := rotationIn.Details.Close.IterationNumber
+	if a.channel == nil {
+		return a.reject(msg.TypeSignerRotationRequest, iterationNumber, fmt.Errorf("no channel"))
+	}
+
+	// The other participant sends the rotation again if it did not receive
+	// the confirmation, in which case the confirmation is sent again.
+	if r, ok := a.channel.PendingSignerRotation(); ok &&
+		r.Envelope.Details.Equal(rotationIn.Details) &&
+		r.Envelope.Details.OldSigner.Equal(a.otherChannelAccountSigner) {
+		return a.sendSignerRotationResponse(r)
+	}
+	if a.paused {
+		return a.reject(msg.TypeSignerRotationRequest, iterationNumber, ErrPaused)
+	}
+
+	r, err := a.channel.ConfirmSignerRotation(rotationIn)
+	if err != nil {
+		return a.reject(msg.TypeSignerRotationRequest, iterationNumber, fmt.Errorf("confirming signer rotation: %w", err))
+	}
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "signer rotation confirmed from %s to %s\n", r.Envelope.Details.OldSigner.Address(), r.Envelope.Details.NewSigner.Address())
+
+	err = a.sendSignerRotationResponse(r)
+	if a.events != nil {
+		a.events <- SignerRotationConfirmedEvent{Rotation: r}
+	}
+	a.watchSignerRotation(r)
+	return err
+}
+
+// sendSignerRotationResponse sends the other participant the confirmation of
+// the signer rotation it proposed. It must be called with the mutex locked.
+func (a *Agent) sendSignerRotationResponse(r state.SignerRotation) error {
+	err := a.send(msg.Message{
+		Type:                   msg.TypeSignerRotationResponse,
+		SignerRotationResponse: &r.Envelope.ConfirmerSignatures,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding signer rotation to send back: %w", err)
+	}
+	return nil
+}
+
+func (a *Agent) handleSignerRotationResponse(m msg.Message) error {
+	if m.SignerRotationResponse == nil {
+		return fmt.Errorf("%w: signer rotation response missing", ErrMalformedMessage)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.channel == nil {
+		return fmt.Errorf("no channel")
+	}
+
+	r, err := a.channel.FinalizeSignerRotation(*m.SignerRotationResponse)
+	if err != nil {
+		return fmt.Errorf("finalizing signer rotation: %w", err)
+	}
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "signer rotation authorized\n")
+
+	if a.events != nil {
+		a.events <- SignerRotationConfirmedEvent{Rotation: r}
+	}
+	return a.submitSignerRotation(r)
+}
+
+// handleSignerRotationRejected discards the signer rotation proposed by the
+// agent that the other participant rejected. It must be called with the
+// mutex locked.
+func (a *Agent) handleSignerRotationRejected(reject *msg.Reject) error {
+	r, ok := a.channel.PendingSignerRotation()
+	if !ok || r.Envelope.Details.Close.IterationNumber != reject.IterationNumber {
+		return fmt.Errorf("rejected signer rotation %d is not pending", reject.IterationNumber)
+	}
+	err := a.channel.RejectSignerRotation()
+	if err != nil {
+		return fmt.Errorf("rejecting signer rotation: %w", err)
+	}
+	a.rotationSigner = nil
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "signer rotation rejected by remote: %s\n", reject.Reason)
+	if a.events != nil {
+		a.events <- SignerRotationRejectedEvent{Rotation: r, Code: reject.Code, Reason: reject.Reason}
+	}
+	return nil
+}
+
+// signerRotationIngested updates the signers of the agent if ingesting a
+// transaction completed the signer rotation r that was pending before it was
+// ingested, and writes a SignerRotatedEvent, or a SignerRotationFailedEvent if
+// the rotation transaction failed. It must be called with the mutex locked.
+func (a *Agent) signerRotationIngested(r state.SignerRotation) {
+	if current, ok := a.channel.PendingSignerRotation(); ok && current.TransactionHash == r.TransactionHash {
+		return
+	}
+	d := r.Envelope.Details
+	latest := a.channel.LatestCloseAgreement()
+	if !latest.Envelope.Equal(r.CloseAgreement().Envelope) {
+		a.rotationSigner = nil
+		fmt.Fprintf(a.logWriter, "signer rotation transaction failed\n")
+		if a.events != nil {
+			a.events <- SignerRotationFailedEvent{Rotation: r, Err: fmt.Errorf("signer rotation transaction failed")}
+		}
+		return
+	}
+
+	if d.OldSigner.Equal(a.signer().FromAddress()) {
+		if a.rotationSigner == nil {
+			fmt.Fprintf(a.logWriter, "signer rotated to %s which the agent does not hold, restore the agent with it\n", d.NewSigner.Address())
+		} else {
+			a.rotatedSigner = a.rotationSigner
+		}
+		a.rotationSigner = nil
+	} else {
+		a.otherChannelAccountSigner = d.NewSigner
+	}
+	fmt.Fprintf(a.logWriter, "signer rotated from %s to %s\n", d.OldSigner.Address(), d.NewSigner.Address())
+	if a.events != nil {
+		a.events <- SignerRotatedEvent{OldSigner: d.OldSigner, NewSigner: d.NewSigner, CloseAgreement: latest}
+	}
+}
+
+// expireSignerRotation discards the pending signer rotation if the ledger
+// close time is after it expires, and writes a SignerRotationFailedEvent. The
+// close time must be of a ledger that every transaction up to has been
+// ingested. It must be called with the mutex locked.
+func (a *Agent) expireSignerRotation(ledgerCloseTime time.Time) {
+	r, ok := a.channel.PendingSignerRotation()
+	if !ok || !a.channel.ExpireSignerRotation(ledgerCloseTime) {
+		return
+	}
+	a.rotationSigner = nil
+	a.takeSnapshot()
+	fmt.Fprintf(a.logWriter, "signer rotation expired at %v\n", r.Envelope.Details.ExpiresAt)
+	if a.events != nil {
+		a.events <- SignerRotationFailedEvent{Rotation: r, Err: fmt.Errorf("signer rotation expired at %v", r.Envelope.Details.ExpiresAt)}
+	}
+}
+
+// watchSignerRotation discards the signer rotation once the stream has
+// caught up to a ledger that closed after the rotation expires, as reported
+// by a Streamer that implements StreamStatusReporter, if it is still pending.
+// It must be called with the mutex locked.
+func (a *Agent) watchSignerRotation(r state.SignerRotation) {
+	if _, reported := a.streamer.(StreamStatusReporter); !reported {
+		return
+	}
+	channel := a.channel
+	go func() {
+		time.Sleep(time.Until(r.Envelope.Details.ExpiresAt))
+		ticker := time.NewTicker(streamCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			a.mu.Lock()
+			more := false
+			if a.channel == channel {
+				current, pending := a.channel.PendingSignerRotation()
+				more = pending && current.TransactionHash == r.TransactionHash
+			}
+			if more {
+				status, _ := a.streamStatus()
+				a.expireSignerRotation(status.LatestLedgerCloseTime)
+			}
+			a.mu.Unlock()
+			if !more {
+				break
+			}
+		}
+	}()
+}
+
+// signer returns the signer of the channel account, which is the configured
+// signer until it is rotated with RotateSigner. It must be called with the
+// mutex locked.
+func (a *Agent) signer() state.Signer {
+	if a.rotatedSigner != nil {
+		return a.rotatedSigner
+	}
+	return a.channelAccountSigner
+}
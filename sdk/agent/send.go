@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/stellar/starlight/sdk/agent/msg"
+)
+
+// sendRequest is a message queued to be written to the connection, along with
+// a channel that will receive the result of writing the message.
+type sendRequest struct {
+	Message msg.Message
+	Err     chan<- error
+}
+
+// attachConn attaches the connection to the agent, creates the decoder that
+// will be used to read all messages from the connection, and starts the send
+// loop that owns all writes to the connection.
+func (a *Agent) attachConn(conn io.ReadWriter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	counters := &connCounters{}
+	r := countingReader{r: conn, count: &counters.bytesReceived}
+	w := countingWriter{w: conn, count: &counters.bytesSent}
+	// The decoder buffers bytes read beyond the message being decoded, and so
+	// the same decoder must be used for every message on the connection.
+	tail := &tailReader{r: r}
+	a.attach(conn, counters, tail,
+		msg.NewDecoder(io.TeeReader(tail, a.logWriter)),
+		msg.NewEncoder(io.MultiWriter(w, a.logWriter)))
+}
+
+// messageDecoder decodes messages received from a connection.
+type messageDecoder interface {
+	Decode(e interface{}) error
+}
+
+// messageEncoder encodes messages to send on a connection.
+type messageEncoder interface {
+	Encode(e interface{}) error
+}
+
+// attach attaches the connection, which is an io.ReadWriter or a
+// MessageConn, with the decoder and encoder that read and write its messages,
+// and starts the send loop. The tail holds the bytes most recently read by the
+// decoder. It must be called with the mutex locked.
+func (a *Agent) attach(conn interface{}, counters *connCounters, tail *tailReader, dec messageDecoder, enc messageEncoder) {
+	a.conn = conn
+	a.connCounters = counters
+	a.helloReceived = false
+	a.recvTail = tail
+	a.recv = dec
+	a.sendQueue = make(chan sendRequest)
+	go a.sendLoop(enc, counters, a.sendQueue)
+}
+
+// ErrSendTimeout indicates that a message could not be written to the
+// connection within the configured send timeout, and so the connection was
+// closed.
+var ErrSendTimeout = errors.New("send timed out")
+
+// send queues the message to be written to the connection and waits for it to
+// be written. Messages are written to the connection one at a time in the
+// order they are queued so that concurrent senders never interleave bytes on
+// the connection. If a send timeout is configured and the message is not
+// written within it, the connection is closed, because a message partially
+// written cannot be withdrawn, and ErrSendTimeout is returned. It must be
+// called with the mutex locked.
+func (a *Agent) send(m msg.Message) error {
+	if a.sendQueue == nil {
+		return fmt.Errorf("not connected")
+	}
+	var timeout <-chan time.Time
+	if a.sendTimeout > 0 {
+		timer := time.NewTimer(a.sendTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	errCh := make(chan error, 1)
+	select {
+	case a.sendQueue <- sendRequest{Message: m, Err: errCh}:
+	case <-timeout:
+		return a.sendTimedOut(m)
+	}
+	select {
+	case err := <-errCh:
+		return err
+	case <-timeout:
+		return a.sendTimedOut(m)
+	}
+}
+
+// sendTimedOut closes the connection after the message could not be written
+// to it in time, writes a DisconnectedEvent, and returns the error. It must be
+// called with the mutex locked.
+func (a *Agent) sendTimedOut(m msg.Message) error {
+	err := fmt.Errorf("sending message %d: %w after %v", m.Type, ErrSendTimeout, a.sendTimeout)
+	fmt.Fprintf(a.logWriter, "%v, disconnecting\n", err)
+	closeErr := a.closeConn()
+	if closeErr != nil {
+		fmt.Fprintf(a.logWriter, "closing connection: %v\n", closeErr)
+	}
+	if a.events != nil {
+		a.events <- DisconnectedEvent{Err: err}
+	}
+	return err
+}
+
+// MessageDirection is the direction a message travelled between the agent and
+// the other participant.
+type MessageDirection int
+
+const (
+	// MessageSent is a message sent by the agent to the other participant.
+	MessageSent MessageDirection = iota
+	// MessageReceived is a message received by the agent from the other
+	// participant.
+	MessageReceived
+)
+
+func (d MessageDirection) String() string {
+	switch d {
+	case MessageSent:
+		return "sent"
+	case MessageReceived:
+		return "received"
+	}
+	return fmt.Sprintf("MessageDirection(%d)", int(d))
+}
+
+// sendLoop writes each message queued to the connection using the encoder.
+// It is the only writer to the connection, and the encoder is reused for the
+// life of the connection.
+func (a *Agent) sendLoop(enc messageEncoder, counters *connCounters, queue <-chan sendRequest) {
+	for req := range queue {
+		err := enc.Encode(req.Message)
+		if err == nil {
+			atomic.AddInt64(&counters.messagesSent, 1)
+			if a.messageObserver != nil {
+				a.messageObserver(MessageSent, req.Message)
+			}
+		}
+		req.Err <- err
+	}
+}
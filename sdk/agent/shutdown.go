@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrShuttingDown indicates that the agent is shutting down and is not
+// accepting new opens or payments.
+var ErrShuttingDown = errors.New("agent is shutting down")
+
+// shutdownPollInterval is how often Shutdown checks if in-flight agreements
+// have settled.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown stops the agent accepting new opens and payments, waits for any
+// in-flight open, payment, close, or signer rotation proposed by either
+// participant to be authorized, then disconnects from the remote participant.
+// If the context is done before in-flight agreements settle, the agent
+// disconnects anyway and the context's error is returned.
+//
+// DeclareClose can still be called during shutdown, and Shutdown will wait for
+// the coordinated close it proposes in the same way as any other in-flight
+// agreement. If the remote participant does not respond before the agent
+// disconnects, the channel can still be closed by calling Close once the
+// observation period has passed.
+func (a *Agent) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.shuttingDown = true
+	a.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		a.mu.Lock()
+		inFlight := a.inFlight()
+		a.mu.Unlock()
+		if !inFlight {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err := a.disconnect()
+			if err != nil {
+				return fmt.Errorf("disconnecting: %w", err)
+			}
+			return fmt.Errorf("waiting for in-flight agreements: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	err := a.disconnect()
+	if err != nil {
+		return fmt.Errorf("disconnecting: %w", err)
+	}
+	return nil
+}
+
+// inFlight returns true if the channel has an open or close agreement, or a
+// signer rotation, that has been proposed but is yet to be authorized by both
+// participants.
+func (a *Agent) inFlight() bool {
+	if a.channel == nil {
+		return false
+	}
+	if _, pending := a.channel.PendingOpen(); pending {
+		return true
+	}
+	if r, pending := a.channel.PendingSignerRotation(); pending && r.Envelope.ConfirmerSignatures.Empty() {
+		return true
+	}
+	_, unauthorized := a.channel.LatestUnauthorizedCloseAgreement()
+	return unauthorized
+}
+
+// disconnect gives the Snapshotter any changes that have not been
+// snapshotted, stops the send loop, and closes the connection if it can be
+// closed.
+func (a *Agent) disconnect() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeConn()
+}
+
+// dropConn disconnects from the connection with the counters if it is still
+// attached, after it could no longer be read, and writes a DisconnectedEvent
+// with the error that ended it.
+func (a *Agent) dropConn(counters *connCounters, reason error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conn == nil || a.connCounters != counters {
+		return
+	}
+	err := a.closeConn()
+	if err != nil {
+		fmt.Fprintf(a.logWriter, "closing connection: %v\n", err)
+	}
+	if a.events != nil {
+		a.events <- DisconnectedEvent{Err: reason}
+	}
+}
+
+// closeConn is the implementation of disconnect. It must be called with the
+// mutex locked.
+func (a *Agent) closeConn() error {
+	a.flushSnapshot()
+
+	if a.conn == nil {
+		return nil
+	}
+	conn := a.conn
+	close(a.sendQueue)
+	a.conn = nil
+	a.sendQueue = nil
+	if c, ok := conn.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
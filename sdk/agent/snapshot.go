@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// SnapshotMode is a mode of a SnapshotPolicy.
+type SnapshotMode int
+
+const (
+	// SnapshotEveryChange gives the Snapshotter a snapshot after every change
+	// to the agent's meaningful state.
+	SnapshotEveryChange SnapshotMode = iota
+	// SnapshotOpenClose gives the Snapshotter a snapshot only after changes
+	// to the connection, open, or close of the channel. Payments and ingested
+	// transactions that do not change the channel's state are not
+	// snapshotted.
+	SnapshotOpenClose
+	// SnapshotPeriodic gives the Snapshotter a snapshot after the same
+	// changes as SnapshotOpenClose, and otherwise after a number of changes
+	// or once a duration has passed since the last snapshot.
+	SnapshotPeriodic
+)
+
+// SnapshotPolicy controls how often the Snapshotter is given a snapshot.
+//
+// Policies other than SnapshotEveryChange trade durability for throughput.
+// Changes that are not snapshotted, such as the latest payments, are lost if
+// the process stops without shutting down the agent, and an agent restored
+// from an older snapshot may not hold the latest close agreement. Shutdown
+// always gives the Snapshotter a snapshot if there are changes that have not
+// been snapshotted.
+type SnapshotPolicy struct {
+	Mode SnapshotMode
+
+	// Changes and Interval apply to SnapshotPeriodic. A snapshot is taken
+	// when Changes changes have occurred since the last snapshot, or when a
+	// change occurs Interval or longer after the last snapshot. A zero value
+	// disables that trigger.
+	Changes  int
+	Interval time.Duration
+}
+
+// snapshotMilestone is the part of the agent's state that is always
+// snapshotted when it changes, regardless of the snapshot policy.
+type snapshotMilestone struct {
+	connected        bool
+	hasChannel       bool
+	state            state.State
+	openProposed     bool
+	openAuthorized   bool
+	closeProposed    bool
+	coordinatedClose bool
+	closeDeclared    bool
+}
+
+func (a *Agent) snapshotMilestone() snapshotMilestone {
+	m := snapshotMilestone{
+		connected:     a.otherChannelAccount != nil,
+		hasChannel:    a.channel != nil,
+		closeDeclared: !a.closeDeclaredAt.IsZero(),
+	}
+	if a.channel == nil {
+		return m
+	}
+	m.state, _ = a.channel.State()
+	open := a.channel.OpenAgreement()
+	m.openProposed = !open.Envelope.Empty()
+	m.openAuthorized = m.openProposed && open.Envelope.HasAllSignatures()
+	unauthorized, ok := a.channel.LatestUnauthorizedCloseAgreement()
+	m.closeProposed = ok && isCoordinatedClose(unauthorized.Envelope.Details)
+	m.coordinatedClose = m.openAuthorized && isCoordinatedClose(a.channel.LatestCloseAgreement().Envelope.Details)
+	return m
+}
+
+// isCoordinatedClose returns true if the close agreement details have no
+// observation period, which is only the case for a coordinated close.
+func isCoordinatedClose(d state.CloseDetails) bool {
+	return d.ObservationPeriodTime == 0 && d.ObservationPeriodLedgerGap == 0
+}
+
+// takeSnapshot records a change to the agent's meaningful state, and gives the
+// Snapshotter a snapshot if the snapshot policy requires one. It must be
+// called with the lock held.
+func (a *Agent) takeSnapshot() {
+	if a.snapshotter == nil {
+		return
+	}
+	a.unsnapshottedChanges++
+	milestone := a.snapshotMilestone()
+	due := milestone != a.lastSnapshotMilestone
+	switch a.snapshotPolicy.Mode {
+	case SnapshotEveryChange:
+		due = true
+	case SnapshotPeriodic:
+		p := a.snapshotPolicy
+		if p.Changes > 0 && a.unsnapshottedChanges >= p.Changes {
+			due = true
+		}
+		if p.Interval > 0 && time.Since(a.lastSnapshotAt) >= p.Interval {
+			due = true
+		}
+	}
+	if !due {
+		return
+	}
+	a.lastSnapshotMilestone = milestone
+	a.snapshot()
+}
+
+// flushSnapshot gives the Snapshotter a snapshot if there are changes that
+// have not been snapshotted. It must be called with the lock held.
+func (a *Agent) flushSnapshot() {
+	if a.snapshotter == nil || a.unsnapshottedChanges == 0 {
+		return
+	}
+	a.lastSnapshotMilestone = a.snapshotMilestone()
+	a.snapshot()
+}
+
+func (a *Agent) snapshot() {
+	snapshot := a.buildSnapshot()
+	a.snapshotter.Snapshot(a, snapshot)
+	a.unsnapshottedChanges = 0
+	a.lastSnapshotAt = time.Now()
+}
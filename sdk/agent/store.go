@@ -0,0 +1,68 @@
+package agent
+
+import "errors"
+
+// ErrChannelNotFound indicates that a Store has no snapshot for a channel.
+var ErrChannelNotFound = errors.New("channel not found")
+
+// ChannelID identifies a channel managed by an agent by the pair of channel
+// accounts that participate in it: the agent's own channel account, which is
+// the ChannelAccountKey of its Config, and the channel account of the other
+// participant. Addresses are the strkey encoded G... addresses of the
+// accounts.
+//
+// The pair is always given from the perspective of the agent, and so the two
+// participants of a channel identify it with the accounts in opposite order.
+type ChannelID struct {
+	LocalChannelAccount  string
+	RemoteChannelAccount string
+}
+
+// String returns the local and remote channel account addresses separated by
+// an underscore.
+func (id ChannelID) String() string {
+	return id.LocalChannelAccount + "_" + id.RemoteChannelAccount
+}
+
+// Store persists snapshots of the channels of multiple agents so that a
+// process managing many channels can enumerate them and restore each with
+// NewAgentFromSnapshot on startup. StoreSnapshotter saves snapshots of an
+// agent to a Store.
+type Store interface {
+	// List returns the identifiers of all channels that have snapshots.
+	List() ([]ChannelID, error)
+	// Load returns the latest snapshot saved for the channel, or an error
+	// wrapping ErrChannelNotFound if there is none.
+	Load(id ChannelID) (Snapshot, error)
+	// Save saves the snapshot as the latest snapshot of the channel.
+	Save(id ChannelID, s Snapshot) error
+}
+
+// StoreSnapshotter is a Snapshotter that saves the snapshots of agents to a
+// Store, keyed by the ChannelID of each agent's channel. The same
+// StoreSnapshotter can be used by multiple agents.
+//
+// Snapshots taken before the agent is connected to the other participant are
+// not saved because the channel cannot be identified, and because the agent
+// has no channel to restore.
+type StoreSnapshotter struct {
+	Store Store
+
+	// ErrorHandler, if not nil, is called with any error saving a snapshot.
+	ErrorHandler func(id ChannelID, err error)
+}
+
+// Snapshot saves the snapshot to the Store.
+func (s StoreSnapshotter) Snapshot(a *Agent, snapshot Snapshot) {
+	if snapshot.OtherChannelAccount == nil {
+		return
+	}
+	id := ChannelID{
+		LocalChannelAccount:  a.Config().ChannelAccountKey.Address(),
+		RemoteChannelAccount: snapshot.OtherChannelAccount.Address(),
+	}
+	err := s.Store.Save(id, snapshot)
+	if err != nil && s.ErrorHandler != nil {
+		s.ErrorHandler(id, err)
+	}
+}
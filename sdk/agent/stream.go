@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/starlight/sdk/state"
+)
+
+// streamCheckInterval is how often the agent checks the health of the stream
+// when a StreamLagThreshold is configured.
+const streamCheckInterval = time.Second
+
+// streamLoop periodically checks the health of the stream until the channel
+// reaches a state where the stream is no longer needed, or is discarded.
+func (a *Agent) streamLoop(channel *state.Channel) {
+	ticker := time.NewTicker(streamCheckInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		a.mu.Lock()
+		more := a.channel == channel && a.checkStream(now)
+		a.mu.Unlock()
+		if !more {
+			break
+		}
+	}
+}
+
+// streamStatus returns the status of the stream as reported by the Streamer,
+// and false if the Streamer does not implement StreamStatusReporter. The
+// ledgers of the transactions the agent has ingested are not used, because
+// they only show the stream is flowing while the channel accounts are
+// active. It must be called with the mutex locked.
+func (a *Agent) streamStatus() (StreamStatus, bool) {
+	r, ok := a.streamer.(StreamStatusReporter)
+	if !ok {
+		return StreamStatus{Connected: true}, false
+	}
+	return r.StreamStatus(), true
+}
+
+// streamLag returns how far the stream with the status is behind now. Until
+// the stream has seen a ledger, its lag is measured from when it started so
+// that a stream that never delivers is noticed. The lag of a stream whose
+// Streamer does not report its status cannot be measured, and is zero. It
+// must be called with the mutex locked.
+func (a *Agent) streamLag(now time.Time, status StreamStatus, reported bool) time.Duration {
+	if !reported {
+		return 0
+	}
+	since := status.LatestLedgerCloseTime
+	if since.IsZero() {
+		since = a.streamStartedAt
+	}
+	return now.Sub(since)
+}
+
+// checkStream writes a StreamErrorEvent for a new error reported by the
+// stream, and a StreamLagEvent if the stream has fallen behind by more than
+// the stream lag threshold. It returns false if the channel is closed and the
+// stream is no longer needed, else true. It must be called with the mutex
+// locked.
+func (a *Agent) checkStream(now time.Time) bool {
+	if a.channel == nil {
+		return true
+	}
+	s, err := a.channel.State()
+	if err == nil && (s == state.StateClosed || s == state.StateClosedWithOutdatedState) {
+		return false
+	}
+
+	status, reported := a.streamStatus()
+
+	if status.Err == nil {
+		a.streamErr = nil
+	} else if a.streamErr == nil || a.streamErr.Error() != status.Err.Error() {
+		a.streamErr = status.Err
+		fmt.Fprintf(a.logWriter, "stream error: %v\n", status.Err)
+		if a.events != nil {
+			a.events <- StreamErrorEvent{Err: status.Err}
+		}
+	}
+
+	lag := a.streamLag(now, status, reported)
+	if lag <= a.streamLagThreshold {
+		a.streamLagging = false
+		return true
+	}
+	if a.streamLagging {
+		return true
+	}
+	a.streamLagging = true
+	fmt.Fprintf(a.logWriter, "stream lagging by %v\n", lag)
+	if a.events != nil {
+		a.events <- StreamLagEvent{Lag: lag, LatestLedgerCloseTime: status.LatestLedgerCloseTime}
+	}
+	return true
+}
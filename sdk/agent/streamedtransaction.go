@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
+)
+
+// Transaction returns the transaction decoded from TransactionXDR. The
+// decoded transaction is cached so that subsequent calls do not decode it
+// again. It is not safe to call concurrently.
+func (t *StreamedTransaction) Transaction() (*txnbuild.GenericTransaction, error) {
+	if t.tx == nil {
+		tx, err := txnbuild.TransactionFromXDR(t.TransactionXDR)
+		if err != nil {
+			return nil, fmt.Errorf("parsing transaction xdr: %w", err)
+		}
+		t.tx = tx
+	}
+	return t.tx, nil
+}
+
+// Result returns the transaction result decoded from ResultXDR. The decoded
+// result is cached so that subsequent calls do not decode it again. It is not
+// safe to call concurrently.
+func (t *StreamedTransaction) Result() (xdr.TransactionResult, error) {
+	if t.result == nil {
+		result := xdr.TransactionResult{}
+		err := xdr.SafeUnmarshalBase64(t.ResultXDR, &result)
+		if err != nil {
+			return xdr.TransactionResult{}, fmt.Errorf("parsing result xdr: %w", err)
+		}
+		t.result = &result
+	}
+	return *t.result, nil
+}
+
+// ResultMeta returns the transaction result meta decoded from ResultMetaXDR.
+// The decoded result meta is cached so that subsequent calls do not decode it
+// again. It is not safe to call concurrently.
+func (t *StreamedTransaction) ResultMeta() (xdr.TransactionMeta, error) {
+	if t.resultMeta == nil {
+		resultMeta := xdr.TransactionMeta{}
+		err := xdr.SafeUnmarshalBase64(t.ResultMetaXDR, &resultMeta)
+		if err != nil {
+			return xdr.TransactionMeta{}, fmt.Errorf("parsing result meta xdr: %w", err)
+		}
+		t.resultMeta = &resultMeta
+	}
+	return *t.resultMeta, nil
+}
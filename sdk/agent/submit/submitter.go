@@ -9,11 +9,13 @@
 package submit
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/stellar/go/clients/horizonclient"
 	"github.com/stellar/go/keypair"
 	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/agent"
 )
 
 // SubmitTxer is an implementation of submitting transaction XDR to the network.
@@ -21,6 +23,14 @@ type SubmitTxer interface {
 	SubmitTx(xdr string) error
 }
 
+// BaseFeeProvider provides the base fee currently recommended for
+// transactions, such as from the fee stats of the network, so that
+// transactions submitted while the network is congested are not stuck with a
+// fee that is too low.
+type BaseFeeProvider interface {
+	BaseFee() (int64, error)
+}
+
 // Submitter submits transactions to the network via Horizon. If a transaction
 // has a base fee below the submitters base fee, the transaction is wrapped in a
 // fee bump transaction. This means fee-less transactions are wrapped in fee
@@ -28,10 +38,15 @@ type SubmitTxer interface {
 //
 // The BaseFee is the base fee that will be used for any submission where the
 // transaction has a lower base fee.
+//
+// The BaseFeeProvider, if set, is consulted for each submission, and the
+// greater of the base fee it provides and BaseFee is used. If it errors,
+// BaseFee is used.
 type Submitter struct {
 	SubmitTxer        SubmitTxer
 	NetworkPassphrase string
 	BaseFee           int64
+	BaseFeeProvider   BaseFeeProvider
 	FeeAccount        *keypair.FromAddress
 	FeeAccountSigners []*keypair.Full
 }
@@ -40,12 +55,26 @@ type Submitter struct {
 // lower than the submitters base fee it is wrapped in a fee bump transaction
 // with the Submitter's FeeAccount as the fee account.
 func (s *Submitter) SubmitTx(tx *txnbuild.Transaction) error {
-	if tx.BaseFee() < s.BaseFee {
-		return s.submitTxWithFeeBump(tx)
+	baseFee := s.SubmitBaseFee()
+	if tx.BaseFee() < baseFee {
+		return s.submitTxWithFeeBump(tx, baseFee)
 	}
 	return s.submitTx(tx)
 }
 
+// SubmitBaseFee returns the base fee the Submitter pays for the transactions
+// it submits, and implements agent.FeeReporter.
+func (s *Submitter) SubmitBaseFee() int64 {
+	if s.BaseFeeProvider == nil {
+		return s.BaseFee
+	}
+	baseFee, err := s.BaseFeeProvider.BaseFee()
+	if err != nil || baseFee < s.BaseFee {
+		return s.BaseFee
+	}
+	return baseFee
+}
+
 func (s *Submitter) submitTx(tx *txnbuild.Transaction) error {
 	txeBase64, err := tx.Base64()
 	if err != nil {
@@ -58,10 +87,10 @@ func (s *Submitter) submitTx(tx *txnbuild.Transaction) error {
 	return nil
 }
 
-func (s *Submitter) submitTxWithFeeBump(tx *txnbuild.Transaction) error {
+func (s *Submitter) submitTxWithFeeBump(tx *txnbuild.Transaction, baseFee int64) error {
 	feeBumpTx, err := txnbuild.NewFeeBumpTransaction(txnbuild.FeeBumpTransactionParams{
 		Inner:      tx,
-		BaseFee:    s.BaseFee,
+		BaseFee:    baseFee,
 		FeeAccount: s.FeeAccount.Address(),
 	})
 	if err != nil {
@@ -77,12 +106,34 @@ func (s *Submitter) submitTxWithFeeBump(tx *txnbuild.Transaction) error {
 	}
 	err = s.SubmitTxer.SubmitTx(txeBase64)
 	if err != nil {
+		if feeAccountUnderfunded(err) {
+			return fmt.Errorf("submitting fee bump tx with fee account %s: %w: %v", s.FeeAccount.Address(), agent.ErrFeeAccountUnderfunded, buildErr(err))
+		}
 		return fmt.Errorf("submitting fee bump tx: %w", buildErr(err))
 	}
 
 	return nil
 }
 
+// txInsufficientBalance is the result code Horizon reports for a fee bump
+// transaction whose fee account has insufficient balance to pay the fee.
+const txInsufficientBalance = "tx_insufficient_balance"
+
+// feeAccountUnderfunded returns true if the error is from Horizon rejecting a
+// fee bump transaction because its fee account has insufficient balance to pay
+// the fee.
+func feeAccountUnderfunded(err error) bool {
+	var hErr *horizonclient.Error
+	if !errors.As(err, &hErr) {
+		return false
+	}
+	resultCodes, err := hErr.ResultCodes()
+	if err != nil {
+		return false
+	}
+	return resultCodes.TransactionCode == txInsufficientBalance
+}
+
 func buildErr(err error) error {
 	if hErr := horizonclient.GetError(err); hErr != nil {
 		resultString, rErr := hErr.ResultString()
@@ -1,51 +1,162 @@
 package agent
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
+	"time"
 )
 
+// defaultTCPKeepAlivePeriod is the period of TCP keepalive probes if
+// Config.TCPKeepAlivePeriod is zero.
+const defaultTCPKeepAlivePeriod = 15 * time.Second
+
 // ServeTCP listens on the given address for a single incoming connection to
 // start a payment channel.
 func (a *Agent) ServeTCP(addr string) error {
-	if a.conn != nil {
+	return a.ServeContext(context.Background(), addr)
+}
+
+// ServeContext listens on the given address for a single incoming connection
+// to start a payment channel, in the same way as ServeTCP. If the context is
+// done before a connection is accepted, the listener is closed and the
+// context's error is returned.
+func (a *Agent) ServeContext(ctx context.Context, addr string) error {
+	if !a.disconnected() {
 		return fmt.Errorf("already connected")
 	}
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("listening on %s: %w", addr, err)
 	}
+	defer ln.Close()
+
+	// Close the listener if the context is done while waiting, which
+	// unblocks Accept.
+	accepted := make(chan struct{})
+	defer close(accepted)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ln.Close()
+		case <-accepted:
+		}
+	}()
+
 	conn, err := ln.Accept()
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("accepting incoming connection: %w", err)
 	}
 	fmt.Fprintf(a.logWriter, "accepted connection from %v\n", conn.RemoteAddr())
-	a.conn = conn
-	err = a.hello()
-	if err != nil {
-		return fmt.Errorf("sending hello: %w", err)
-	}
-	go a.receiveLoop()
-	return nil
+	a.setKeepAlive(conn)
+	a.attachConn(conn)
+	return a.start()
 }
 
 // ConnectTCP connects to the given address for establishing a single payment
-// channel.
+// channel. If connecting or sending the hello fails, it is retried up to the
+// configured number of connect retries. If the last attempt fails to send
+// the hello, a ConnectFailedEvent is written.
 func (a *Agent) ConnectTCP(addr string) error {
-	if a.conn != nil {
+	if !a.disconnected() {
+		return fmt.Errorf("already connected")
+	}
+	handshakeFailed, err := a.dialAndHandshake(addr)
+	if handshakeFailed {
+		a.connectFailed(err)
+	}
+	return err
+}
+
+// ServeConn uses an established connection to the other participant for
+// establishing a single payment channel. It allows the agent to be used over
+// transports other than TCP, such as a stream of a QUIC connection or a
+// WebSocket, that provide an ordered and reliable stream of bytes. If conn
+// implements io.Closer it is closed when the agent is shut down. If conn is a
+// *tls.Conn, its connection state is included in the ConnectedEvent. For
+// transports that preserve message boundaries use ServeMessageConn.
+//
+// For example, a stream of a QUIC connection from the quic-go package is
+// served by wrapping it so that closing it also stops reading, because
+// closing a quic.Stream only closes its write direction and the agent would
+// otherwise keep waiting to receive from it. The wrapper can also give the
+// connection's remote address to the ConnectedEvent:
+//
+//	type quicStream struct {
+//		quic.Stream
+//		conn quic.Connection
+//	}
+//
+//	func (s quicStream) Close() error {
+//		s.Stream.CancelRead(0)
+//		return s.Stream.Close()
+//	}
+//
+//	func (s quicStream) RemoteAddr() net.Addr {
+//		return s.conn.RemoteAddr()
+//	}
+//
+//	stream, err := conn.AcceptStream(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	return a.ServeConn(quicStream{Stream: stream, conn: conn})
+func (a *Agent) ServeConn(conn io.ReadWriter) error {
+	if !a.disconnected() {
 		return fmt.Errorf("already connected")
 	}
-	var err error
-	conn, err := net.Dial("tcp", addr)
+	a.attachConn(conn)
+	return a.start()
+}
+
+// start sends a hello on the attached connection and starts receiving
+// messages from it. If the hello cannot be sent, the connection is closed and
+// a ConnectFailedEvent is written.
+func (a *Agent) start() error {
+	err := a.handshake()
 	if err != nil {
-		return fmt.Errorf("connecting to %s: %w", addr, err)
+		a.connectFailed(err)
+	}
+	return err
+}
+
+// connInfo returns the remote address of the connection and its TLS state, if
+// the connection provides them.
+func connInfo(conn interface{}) (net.Addr, *tls.ConnectionState) {
+	var remoteAddr net.Addr
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		remoteAddr = c.RemoteAddr()
+	}
+	var tlsState *tls.ConnectionState
+	if c, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		s := c.ConnectionState()
+		tlsState = &s
+	}
+	return remoteAddr, tlsState
+}
+
+// setKeepAlive sets the configured TCP keepalive on the connection, if it is
+// a TCP connection. Failing to set it is logged, and the connection is used
+// without it.
+func (a *Agent) setKeepAlive(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	period := a.tcpKeepAlivePeriod
+	if period == 0 {
+		period = defaultTCPKeepAlivePeriod
+	}
+	err := tcpConn.SetKeepAlive(period > 0)
+	if err == nil && period > 0 {
+		err = tcpConn.SetKeepAlivePeriod(period)
 	}
-	fmt.Fprintf(a.logWriter, "connected to %v\n", conn.RemoteAddr())
-	a.conn = conn
-	err = a.hello()
 	if err != nil {
-		return fmt.Errorf("sending hello: %w", err)
+		fmt.Fprintf(a.logWriter, "setting tcp keepalive: %v\n", err)
 	}
-	go a.receiveLoop()
-	return nil
 }
@@ -0,0 +1,38 @@
+package state
+
+import "github.com/stellar/starlight/sdk/txbuild"
+
+// MinimumBalances returns the minimum balance of the channel's asset that the
+// local and remote channel accounts must each hold for the close transaction
+// of the latest authorized close agreement in the snapshot to succeed. A
+// channel account that holds less is under-collateralized and the other
+// participant cannot be paid what the agreement owes them.
+//
+// The snapshot does not record which participant is local, and so initiator
+// must be true if the local participant is the initiator, as given in the
+// channel's Config.
+//
+// If the channel's asset is native and baseReserve is non-zero, the minimum
+// balance that a channel account must hold to cover the reserves of the
+// account and its subentries is included, because that amount cannot be paid
+// out. baseReserve should be zero if the reserves of the channel accounts are
+// sponsored. Reserves are not included for other assets because they are paid
+// in the native asset.
+func MinimumBalances(s Snapshot, initiator bool, baseReserve int64) (local, remote int64) {
+	balance := s.LatestAuthorizedCloseAgreement.Envelope.Details.Balance
+	initiatorOwes := amountToResponder(balance)
+	responderOwes := amountToInitiator(balance)
+	if initiator {
+		local, remote = initiatorOwes, responderOwes
+	} else {
+		local, remote = responderOwes, initiatorOwes
+	}
+
+	asset := s.OpenAgreement.Envelope.Details.Asset
+	if asset.IsNative() && baseReserve > 0 {
+		reserve := txbuild.MinimumBalance(baseReserve, txbuild.ChannelAccountSubentries(asset.Asset()))
+		local += reserve
+		remote += reserve
+	}
+	return local, remote
+}
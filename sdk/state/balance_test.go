@@ -0,0 +1,41 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinimumBalances(t *testing.T) {
+	snapshot := func(asset Asset, balance int64) Snapshot {
+		s := Snapshot{}
+		s.OpenAgreement.Envelope.Details.Asset = asset
+		s.LatestAuthorizedCloseAgreement.Envelope.Details.Balance = balance
+		return s
+	}
+	credit := Asset("ABCD:GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36")
+
+	testCases := []struct {
+		name        string
+		snapshot    Snapshot
+		initiator   bool
+		baseReserve int64
+		wantLocal   int64
+		wantRemote  int64
+	}{
+		{"zero balance", snapshot(NativeAsset, 0), true, 0, 0, 0},
+		{"initiator owes as initiator", snapshot(NativeAsset, 100), true, 0, 100, 0},
+		{"initiator owes as responder", snapshot(NativeAsset, 100), false, 0, 0, 100},
+		{"responder owes as initiator", snapshot(NativeAsset, -100), true, 0, 0, 100},
+		{"responder owes as responder", snapshot(NativeAsset, -100), false, 0, 100, 0},
+		{"native with reserves", snapshot(NativeAsset, 100), true, 5_000000, 100 + 4*5_000000, 4 * 5_000000},
+		{"credit ignores reserves", snapshot(credit, -100), true, 5_000000, 0, 100},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			local, remote := MinimumBalances(tc.snapshot, tc.initiator, tc.baseReserve)
+			assert.Equal(t, tc.wantLocal, local)
+			assert.Equal(t, tc.wantRemote, remote)
+		})
+	}
+}
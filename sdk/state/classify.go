@@ -0,0 +1,90 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/txbuild"
+)
+
+// TxKind is the kind of a transaction of the channel.
+type TxKind string
+
+const (
+	// TxKindUnrelated is a transaction that is not one of the channel's
+	// transactions.
+	TxKindUnrelated TxKind = "unrelated"
+	// TxKindOpen is the channel's open transaction.
+	TxKindOpen TxKind = "open"
+	// TxKindDeclaration is a declaration transaction of an iteration of the
+	// channel.
+	TxKindDeclaration TxKind = "declaration"
+	// TxKindClose is a close transaction of an iteration of the channel.
+	TxKindClose TxKind = "close"
+)
+
+// ClassifyTransaction returns whether the transaction, given as XDR, is the
+// channel's open transaction, a declaration or close transaction of one of its
+// iterations, or unrelated to the channel, and the iteration number of a
+// declaration or close. A transaction wrapped in a fee bump transaction is
+// classified by the transaction it wraps.
+//
+// Declaration and close transactions are recognized by their source account
+// being the initiator's channel account and by their sequence number, and so
+// are classified for any iteration, including iterations that are older or
+// newer than the latest agreement known to the channel, such as a declaration
+// of an outdated agreement. An open must have been proposed or confirmed.
+func (c *Channel) ClassifyTransaction(xdr string) (kind TxKind, iterationNumber int64, err error) {
+	open := c.openAgreement.Envelope.Details
+	if c.openAgreement.Envelope.Empty() {
+		return "", 0, fmt.Errorf("no open agreement")
+	}
+
+	gtx, err := txnbuild.TransactionFromXDR(xdr)
+	if err != nil {
+		return "", 0, fmt.Errorf("parsing transaction xdr: %w", err)
+	}
+	var tx *txnbuild.Transaction
+	if feeBump, ok := gtx.FeeBump(); ok {
+		tx = feeBump.InnerTransaction()
+	}
+	if transaction, ok := gtx.Transaction(); ok {
+		tx = transaction
+	}
+	if tx == nil {
+		return "", 0, fmt.Errorf("transaction unrecognized")
+	}
+
+	txHash, err := tx.Hash(c.networkPassphrase)
+	if err != nil {
+		return "", 0, fmt.Errorf("hashing tx: %w", err)
+	}
+	if txHash == c.openAgreement.Transactions.OpenHash {
+		return TxKindOpen, 0, nil
+	}
+
+	if tx.SourceAccount().AccountID != c.initiatorChannelAccount().Address.Address() {
+		return TxKindUnrelated, 0, nil
+	}
+	seqNum := tx.SourceAccount().Sequence
+	if seqNum <= open.StartingSequence {
+		return TxKindUnrelated, 0, nil
+	}
+	ops := tx.Operations()
+	iterationNumber = (seqNum - open.StartingSequence) / 2
+	switch txbuild.SequenceNumberToTransactionType(open.StartingSequence, seqNum) {
+	case txbuild.TransactionTypeDeclaration:
+		if len(ops) == 1 {
+			if _, ok := ops[0].(*txnbuild.BumpSequence); ok {
+				return TxKindDeclaration, iterationNumber, nil
+			}
+		}
+	case txbuild.TransactionTypeClose:
+		if len(ops) >= 2 {
+			if _, ok := ops[0].(*txnbuild.SetOptions); ok {
+				return TxKindClose, iterationNumber, nil
+			}
+		}
+	}
+	return TxKindUnrelated, 0, nil
+}
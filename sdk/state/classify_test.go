@@ -0,0 +1,138 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannel_ClassifyTransaction(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Without an open the channel's transactions are unknown.
+	_, _, err := initiatorChannel.ClassifyTransaction("")
+	assert.EqualError(t, err, "no open agreement")
+
+	open, err := initiatorChannel.ProposeOpen(OpenParams{
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(5 * time.Minute),
+		StartingSequence:           101,
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+	})
+	require.NoError(t, err)
+	open, err = responderChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	openTx, err := initiatorChannel.OpenTx()
+	require.NoError(t, err)
+	openXDR, err := openTx.Base64()
+	require.NoError(t, err)
+	successResultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         localSigner.Address(),
+		ResponderSigner:         remoteSigner.Address(),
+		InitiatorChannelAccount: localChannelAccount.Address(),
+		ResponderChannelAccount: remoteChannelAccount.Address(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, initiatorChannel.IngestTx(1, openXDR, successResultXDR, resultMetaXDR))
+	require.NoError(t, responderChannel.IngestTx(1, openXDR, successResultXDR, resultMetaXDR))
+	initiatorChannel.UpdateLocalChannelAccountBalance(200)
+	responderChannel.UpdateRemoteChannelAccountBalance(200)
+
+	ca, err := initiatorChannel.ProposePayment(10)
+	require.NoError(t, err)
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+
+	base64 := func(tx *txnbuild.Transaction) string {
+		xdr, err := tx.Base64()
+		require.NoError(t, err)
+		return xdr
+	}
+	declTx, closeTx, err := initiatorChannel.CloseTxs()
+	require.NoError(t, err)
+	feeBumpCloseTx, err := txnbuild.NewFeeBumpTransaction(txnbuild.FeeBumpTransactionParams{
+		Inner:      closeTx,
+		BaseFee:    txnbuild.MinBaseFee,
+		FeeAccount: keypair.MustRandom().Address(),
+	})
+	require.NoError(t, err)
+	feeBumpCloseXDR, err := feeBumpCloseTx.Base64()
+	require.NoError(t, err)
+	otherTx := func(source *keypair.FromAddress, seqNum int64) string {
+		tx, err := txnbuild.NewTransaction(txnbuild.TransactionParams{
+			SourceAccount: &txnbuild.SimpleAccount{AccountID: source.Address(), Sequence: seqNum},
+			BaseFee:       txnbuild.MinBaseFee,
+			Timebounds:    txnbuild.NewInfiniteTimeout(),
+			Operations:    []txnbuild.Operation{&txnbuild.ManageData{Name: "name", Value: []byte("value")}},
+		})
+		require.NoError(t, err)
+		return base64(tx)
+	}
+
+	testCases := []struct {
+		name      string
+		xdr       string
+		kind      TxKind
+		iteration int64
+	}{
+		{"open", openXDR, TxKindOpen, 0},
+		{"declaration", base64(declTx), TxKindDeclaration, 2},
+		{"close", base64(closeTx), TxKindClose, 2},
+		{"outdatedDeclaration", base64(open.CloseTransactions.Declaration), TxKindDeclaration, 1},
+		{"outdatedClose", base64(open.CloseTransactions.Close), TxKindClose, 1},
+		{"feeBumpedClose", feeBumpCloseXDR, TxKindClose, 2},
+		{"otherAccount", otherTx(remoteChannelAccount, 105), TxKindUnrelated, 0},
+		{"beforeOpen", otherTx(localChannelAccount, 100), TxKindUnrelated, 0},
+		{"notDeclarationOrClose", otherTx(localChannelAccount, 105), TxKindUnrelated, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, c := range []*Channel{initiatorChannel, responderChannel} {
+				kind, iteration, err := c.ClassifyTransaction(tc.xdr)
+				require.NoError(t, err)
+				assert.Equal(t, tc.kind, kind)
+				assert.Equal(t, tc.iteration, iteration)
+			}
+		})
+	}
+
+	_, _, err = initiatorChannel.ClassifyTransaction("AAAA")
+	assert.Error(t, err)
+}
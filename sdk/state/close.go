@@ -1,6 +1,7 @@
 package state
 
 import (
+	"bytes"
 	"fmt"
 
 	"github.com/stellar/go/txnbuild"
@@ -22,28 +23,41 @@ func (c *Channel) closeTxs(oad OpenDetails, d CloseDetails) (txs CloseTransactio
 			return c.latestUnauthorizedCloseAgreement.Transactions, nil
 		}
 	}
+	return buildCloseTxs(c.networkPassphrase, c.txParticipants(), oad, d)
+}
+
+// buildCloseTxs builds the transactions that can be submitted to close a
+// channel between the participants with the state defined in the
+// CloseAgreementDetails, and that was opened with the given
+// OpenAgreementDetails.
+func buildCloseTxs(networkPassphrase string, p txParticipants, oad OpenDetails, d CloseDetails) (txs CloseTransactions, err error) {
+	memo, err := transactionMemo(d.MemoType, d.Memo)
+	if err != nil {
+		return CloseTransactions{}, err
+	}
 	txClose, err := txbuild.Close(txbuild.CloseParams{
 		ObservationPeriodTime:      d.ObservationPeriodTime,
 		ObservationPeriodLedgerGap: d.ObservationPeriodLedgerGap,
-		InitiatorSigner:            c.initiatorSigner(),
-		ResponderSigner:            c.responderSigner(),
-		InitiatorChannelAccount:    c.initiatorChannelAccount().Address,
-		ResponderChannelAccount:    c.responderChannelAccount().Address,
+		InitiatorSigner:            p.InitiatorSigner,
+		ResponderSigner:            p.ResponderSigner,
+		InitiatorChannelAccount:    p.InitiatorChannelAccount,
+		ResponderChannelAccount:    p.ResponderChannelAccount,
 		StartSequence:              oad.StartingSequence,
 		IterationNumber:            d.IterationNumber,
 		AmountToInitiator:          amountToInitiator(d.Balance),
 		AmountToResponder:          amountToResponder(d.Balance),
 		Asset:                      oad.Asset.Asset(),
+		Memo:                       memo,
 	})
 	if err != nil {
 		return CloseTransactions{}, err
 	}
-	txCloseHash, err := txClose.Hash(c.networkPassphrase)
+	txCloseHash, err := txClose.Hash(networkPassphrase)
 	if err != nil {
 		return CloseTransactions{}, err
 	}
 	txDecl, err := txbuild.Declaration(txbuild.DeclarationParams{
-		InitiatorChannelAccount: c.initiatorChannelAccount().Address,
+		InitiatorChannelAccount: p.InitiatorChannelAccount,
 		StartSequence:           oad.StartingSequence,
 		IterationNumber:         d.IterationNumber,
 		IterationNumberExecuted: 0,
@@ -53,7 +67,7 @@ func (c *Channel) closeTxs(oad OpenDetails, d CloseDetails) (txs CloseTransactio
 	if err != nil {
 		return CloseTransactions{}, err
 	}
-	txDeclHash, err := txDecl.Hash(c.networkPassphrase)
+	txDeclHash, err := txDecl.Hash(networkPassphrase)
 	if err != nil {
 		return CloseTransactions{}, err
 	}
@@ -66,15 +80,91 @@ func (c *Channel) closeTxs(oad OpenDetails, d CloseDetails) (txs CloseTransactio
 	return txs, nil
 }
 
-// CloseTxs builds the declaration and close transactions used for closing the
+// CloseTxs returns the declaration and close transactions used for closing the
 // channel using the latest close agreement. The transactions are signed and
-// ready to submit.
+// ready to submit. They are signed when the agreement is authorized, and so
+// are returned without being rebuilt or signed again.
 func (c *Channel) CloseTxs() (declTx *txnbuild.Transaction, closeTx *txnbuild.Transaction, err error) {
-	cae := c.latestAuthorizedCloseAgreement
-	txs := cae.SignedTransactions()
+	txs := c.latestAuthorizedCloseTxs
+	if txs.Declaration == nil || txs.Close == nil {
+		return nil, nil, fmt.Errorf("no authorized close agreement")
+	}
 	return txs.Declaration, txs.Close, nil
 }
 
+// ErrCloseTxsMismatch indicates that the declaration and close transactions
+// that would be submitted to close the channel do not match the latest
+// authorized close agreement.
+var ErrCloseTxsMismatch = fmt.Errorf("close transactions do not match the latest authorized close agreement")
+
+// ValidateCloseTxs checks that the transactions returned by CloseTxs are those
+// of the latest authorized close agreement, by building the transactions again
+// from the agreement's details and comparing their hashes, which commit to the
+// agreement's iteration number, balance, and memo, by checking the memo of the
+// close transaction, and by verifying both participants' signatures of them. It returns an error wrapping
+// ErrCloseTxsMismatch if they do not match, in which case the transactions
+// must not be submitted.
+func (c *Channel) ValidateCloseTxs() error {
+	txs := c.latestAuthorizedCloseTxs
+	if txs.Declaration == nil || txs.Close == nil {
+		return fmt.Errorf("no authorized close agreement")
+	}
+	ca := c.latestAuthorizedCloseAgreement
+	built, err := buildCloseTxs(c.networkPassphrase, c.txParticipants(), c.openAgreement.Envelope.Details, ca.Envelope.Details)
+	if err != nil {
+		return fmt.Errorf("building close txs: %w", err)
+	}
+
+	declHash, err := txs.Declaration.Hash(c.networkPassphrase)
+	if err != nil {
+		return fmt.Errorf("hashing declaration tx: %w", err)
+	}
+	closeHash, err := txs.Close.Hash(c.networkPassphrase)
+	if err != nil {
+		return fmt.Errorf("hashing close tx: %w", err)
+	}
+	if declHash != built.DeclarationHash || ca.Transactions.DeclarationHash != built.DeclarationHash {
+		return fmt.Errorf("%w: declaration tx of iteration %d", ErrCloseTxsMismatch, ca.Envelope.Details.IterationNumber)
+	}
+	if closeHash != built.CloseHash || ca.Transactions.CloseHash != built.CloseHash {
+		return fmt.Errorf("%w: close tx of iteration %d", ErrCloseTxsMismatch, ca.Envelope.Details.IterationNumber)
+	}
+	closeMemo, err := txs.Close.ToXDR().Memo().MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("encoding close tx memo: %w", err)
+	}
+	builtMemo, err := built.Close.ToXDR().Memo().MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("encoding close tx memo: %w", err)
+	}
+	if !bytes.Equal(closeMemo, builtMemo) {
+		return fmt.Errorf("%w: close tx memo of iteration %d", ErrCloseTxsMismatch, ca.Envelope.Details.IterationNumber)
+	}
+
+	e := ca.Envelope
+	err = verifySignatures([]signatureVerificationInput{
+		{TransactionHash: built.DeclarationHash, Signature: e.ProposerSignatures.Declaration, Signer: e.Details.ProposingSigner},
+		{TransactionHash: built.CloseHash, Signature: e.ProposerSignatures.Close, Signer: e.Details.ProposingSigner},
+		{TransactionHash: built.DeclarationHash, Signature: e.ConfirmerSignatures.Declaration, Signer: e.Details.ConfirmingSigner},
+		{TransactionHash: built.CloseHash, Signature: e.ConfirmerSignatures.Close, Signer: e.Details.ConfirmingSigner},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: invalid signature: %v", ErrCloseTxsMismatch, err)
+	}
+	return nil
+}
+
+// setLatestAuthorizedCloseAgreement stores the close agreement as the latest
+// authorized close agreement, and attaches the signatures of the agreement to
+// its transactions so that CloseTxs can return them without delay.
+func (c *Channel) setLatestAuthorizedCloseAgreement(ca CloseAgreement) {
+	c.latestAuthorizedCloseAgreement = ca
+	c.latestAuthorizedCloseTxs = CloseTransactions{}
+	if ca.Transactions.Declaration != nil && ca.Transactions.Close != nil {
+		c.latestAuthorizedCloseTxs = ca.SignedTransactions()
+	}
+}
+
 // ProposeClose proposes that the latest authorized close agreement be submitted
 // without waiting the observation period. This should be used when participants
 // are in agreement on the final close state, but would like to submit earlier
@@ -90,6 +180,11 @@ func (c *Channel) ProposeClose() (CloseAgreement, error) {
 		return CloseAgreement{}, fmt.Errorf("cannot propose a coordinated close before channel is opened")
 	}
 
+	// If a signer rotation is pending, error.
+	if err := c.checkNoSignerRotationPending(); err != nil {
+		return CloseAgreement{}, fmt.Errorf("cannot propose a coordinated close: %w", err)
+	}
+
 	d := c.latestAuthorizedCloseAgreement.Envelope.Details
 	d.ObservationPeriodTime = 0
 	d.ObservationPeriodLedgerGap = 0
@@ -116,17 +211,41 @@ func (c *Channel) ProposeClose() (CloseAgreement, error) {
 	return c.latestUnauthorizedCloseAgreement, nil
 }
 
+// RejectClose discards the coordinated close proposed with ProposeClose after
+// the other participant rejects it. The channel can still be closed by
+// submitting the declaration and close transactions of the latest authorized
+// close agreement, and a new coordinated close can be proposed.
+func (c *Channel) RejectClose() error {
+	ca := c.latestUnauthorizedCloseAgreement
+	if ca.Envelope.Empty() {
+		return fmt.Errorf("no coordinated close is pending")
+	}
+	d := ca.Envelope.Details
+	if d.ObservationPeriodTime != 0 || d.ObservationPeriodLedgerGap != 0 {
+		return fmt.Errorf("cannot reject a payment as a coordinated close")
+	}
+	c.latestUnauthorizedCloseAgreement = CloseAgreement{}
+	return nil
+}
+
 func (c *Channel) validateClose(ca CloseEnvelope) error {
 	// If the channel is not open yet, error.
 	if c.latestAuthorizedCloseAgreement.Envelope.Empty() || !c.openExecutedAndValidated {
 		return fmt.Errorf("cannot confirm a coordinated close before channel is opened")
 	}
+	if err := c.checkNoSignerRotationPending(); err != nil {
+		return fmt.Errorf("cannot confirm a coordinated close: %w", err)
+	}
 	if ca.Details.IterationNumber != c.latestAuthorizedCloseAgreement.Envelope.Details.IterationNumber {
 		return fmt.Errorf("close agreement iteration number does not match saved latest authorized close agreement")
 	}
 	if ca.Details.Balance != c.latestAuthorizedCloseAgreement.Envelope.Details.Balance {
 		return fmt.Errorf("close agreement balance does not match saved latest authorized close agreement")
 	}
+	if ca.Details.MemoType != c.latestAuthorizedCloseAgreement.Envelope.Details.MemoType ||
+		!bytes.Equal(ca.Details.Memo, c.latestAuthorizedCloseAgreement.Envelope.Details.Memo) {
+		return fmt.Errorf("close agreement memo does not match saved latest authorized close agreement")
+	}
 	if ca.Details.ObservationPeriodTime != 0 {
 		return fmt.Errorf("close agreement observation period time is not zero")
 	}
@@ -195,10 +314,10 @@ func (c *Channel) ConfirmClose(ce CloseEnvelope) (closeAgreement CloseAgreement,
 	}
 
 	// The new close agreement is valid and authorized, store and promote it.
-	c.latestAuthorizedCloseAgreement = CloseAgreement{
+	c.setLatestAuthorizedCloseAgreement(CloseAgreement{
 		Envelope:     ce,
 		Transactions: txs,
-	}
+	})
 	c.latestUnauthorizedCloseAgreement = CloseAgreement{}
 	return c.latestAuthorizedCloseAgreement, nil
 }
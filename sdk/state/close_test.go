@@ -57,7 +57,7 @@ func TestChannel_CloseTx(t *testing.T) {
 	txs, err := channel.closeTxs(oe.Details, ce.Details)
 	require.NoError(t, err)
 	channel.openAgreement = OpenAgreement{Envelope: oe}
-	channel.latestAuthorizedCloseAgreement = CloseAgreement{Envelope: ce, Transactions: txs}
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{Envelope: ce, Transactions: txs})
 	closeTxHash := txs.CloseHash
 
 	// TODO: Compare the non-signature parts of the txs with the result of
@@ -77,6 +77,12 @@ func TestChannel_CloseTx(t *testing.T) {
 		{Hint: remoteSigner.Hint(), Signature: []byte{3}},
 	}, closeTx.Signatures())
 
+	// Check signed txs are cached by checking the same txs are returned again.
+	declTx2, closeTx2, err := channel.CloseTxs()
+	require.NoError(t, err)
+	assert.Same(t, declTx, declTx2)
+	assert.Same(t, closeTx, closeTx2)
+
 	// Check stored txs are used by replacing the stored tx with an identifiable
 	// tx and checking that's what is used for the authorized closing transactions.
 	testTx, err := txnbuild.NewTransaction(txnbuild.TransactionParams{
@@ -86,10 +92,13 @@ func TestChannel_CloseTx(t *testing.T) {
 		Operations:    []txnbuild.Operation{&txnbuild.BumpSequence{}},
 	})
 	require.NoError(t, err)
-	channel.latestAuthorizedCloseAgreement.Transactions = CloseTransactions{
-		Declaration: testTx,
-		Close:       testTx,
-	}
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{
+		Envelope: ce,
+		Transactions: CloseTransactions{
+			Declaration: testTx,
+			Close:       testTx,
+		},
+	})
 	declTx, closeTx, err = channel.CloseTxs()
 	require.NoError(t, err)
 	assert.Equal(t, int64(123456789), declTx.SequenceNumber())
@@ -115,6 +124,92 @@ func TestChannel_CloseTx(t *testing.T) {
 	assert.Equal(t, int64(987654321), txs.Close.SequenceNumber())
 }
 
+func TestChannel_ValidateCloseTxs(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	channel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+	})
+	err := channel.ValidateCloseTxs()
+	assert.EqualError(t, err, "no authorized close agreement")
+
+	oe := OpenEnvelope{
+		Details: OpenDetails{
+			ObservationPeriodTime:      1,
+			ObservationPeriodLedgerGap: 1,
+			StartingSequence:           1,
+			Asset:                      NativeAsset,
+			ExpiresAt:                  time.Now(),
+		},
+	}
+	channel.openAgreement = OpenAgreement{Envelope: oe}
+	ce := CloseEnvelope{
+		Details: CloseDetails{
+			ObservationPeriodTime:      1,
+			ObservationPeriodLedgerGap: 1,
+			IterationNumber:            3,
+			Balance:                    4,
+			ProposingSigner:            localSigner.FromAddress(),
+			ConfirmingSigner:           remoteSigner.FromAddress(),
+		},
+	}
+	txs, err := channel.closeTxs(oe.Details, ce.Details)
+	require.NoError(t, err)
+	ce.ProposerSignatures, err = signCloseAgreementTxs(txs, localSigner)
+	require.NoError(t, err)
+	ce.ConfirmerSignatures, err = signCloseAgreementTxs(txs, remoteSigner)
+	require.NoError(t, err)
+
+	// The transactions of the agreement match.
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{Envelope: ce, Transactions: txs})
+	require.NoError(t, channel.ValidateCloseTxs())
+
+	// Transactions of another iteration do not match.
+	otherDetails := ce.Details
+	otherDetails.IterationNumber = 2
+	otherTxs, err := channel.closeTxs(oe.Details, otherDetails)
+	require.NoError(t, err)
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{Envelope: ce, Transactions: otherTxs})
+	err = channel.ValidateCloseTxs()
+	assert.ErrorIs(t, err, ErrCloseTxsMismatch)
+	assert.EqualError(t, err, "close transactions do not match the latest authorized close agreement: declaration tx of iteration 3")
+
+	// Transactions of another balance do not match.
+	otherDetails = ce.Details
+	otherDetails.Balance = 5
+	otherTxs, err = channel.closeTxs(oe.Details, otherDetails)
+	require.NoError(t, err)
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{Envelope: ce, Transactions: otherTxs})
+	err = channel.ValidateCloseTxs()
+	assert.ErrorIs(t, err, ErrCloseTxsMismatch)
+
+	// Transactions with another memo do not match.
+	otherDetails = ce.Details
+	otherDetails.MemoType = MemoTypeText
+	otherDetails.Memo = []byte("memo")
+	otherTxs, err = channel.closeTxs(oe.Details, otherDetails)
+	require.NoError(t, err)
+	assert.Equal(t, txnbuild.MemoText("memo"), otherTxs.Close.Memo())
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{Envelope: ce, Transactions: otherTxs})
+	err = channel.ValidateCloseTxs()
+	assert.ErrorIs(t, err, ErrCloseTxsMismatch)
+
+	// Signatures that are not of the transactions do not match.
+	badSigs := ce
+	badSigs.ConfirmerSignatures.Close = badSigs.ConfirmerSignatures.Declaration
+	channel.setLatestAuthorizedCloseAgreement(CloseAgreement{Envelope: badSigs, Transactions: txs})
+	err = channel.ValidateCloseTxs()
+	assert.ErrorIs(t, err, ErrCloseTxsMismatch)
+}
+
 func TestChannel_ProposeClose(t *testing.T) {
 	localSigner := keypair.MustRandom()
 	remoteSigner := keypair.MustRandom()
@@ -509,3 +604,97 @@ func TestChannel_ConfirmClose_signatureChecks(t *testing.T) {
 	_, err = initiatorChannel.ConfirmClose(ca.Envelope)
 	require.NoError(t, err)
 }
+
+func TestChannel_RejectClose(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	localChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	remoteChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Put channel into the Open state.
+	{
+		open1, err := localChannel.ProposeOpen(OpenParams{
+			ObservationPeriodTime:      1,
+			ObservationPeriodLedgerGap: 1,
+			ExpiresAt:                  time.Now().Add(time.Hour),
+			StartingSequence:           101,
+		})
+		require.NoError(t, err)
+		open2, err := remoteChannel.ConfirmOpen(open1.Envelope)
+		require.NoError(t, err)
+		_, err = localChannel.ConfirmOpen(open2.Envelope)
+		require.NoError(t, err)
+
+		ftx, err := localChannel.OpenTx()
+		require.NoError(t, err)
+		ftxXDR, err := ftx.Base64()
+		require.NoError(t, err)
+
+		successResultXDR, err := txbuildtest.BuildResultXDR(true)
+		require.NoError(t, err)
+		resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+			InitiatorSigner:         localSigner.Address(),
+			ResponderSigner:         remoteSigner.Address(),
+			InitiatorChannelAccount: localChannelAccount.Address(),
+			ResponderChannelAccount: remoteChannelAccount.Address(),
+			StartSequence:           101,
+			Asset:                   txnbuild.NativeAsset{},
+		})
+		require.NoError(t, err)
+
+		err = localChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+		err = remoteChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+
+		cs, err := localChannel.State()
+		require.NoError(t, err)
+		assert.Equal(t, StateOpen, cs)
+
+		cs, err = remoteChannel.State()
+		require.NoError(t, err)
+		assert.Equal(t, StateOpen, cs)
+	}
+
+	// Rejecting when no close is pending errors.
+	err := localChannel.RejectClose()
+	require.EqualError(t, err, "no coordinated close is pending")
+
+	// A payment cannot be rejected as a close.
+	localChannel.UpdateLocalChannelAccountBalance(10)
+	_, err = localChannel.ProposePayment(1)
+	require.NoError(t, err)
+	err = localChannel.RejectClose()
+	require.EqualError(t, err, "cannot reject a payment as a coordinated close")
+	err = localChannel.RejectPayment(2)
+	require.NoError(t, err)
+
+	// A proposed close can be rejected, and proposed again.
+	_, err = localChannel.ProposeClose()
+	require.NoError(t, err)
+	err = localChannel.RejectClose()
+	require.NoError(t, err)
+	_, ok := localChannel.LatestUnauthorizedCloseAgreement()
+	assert.False(t, ok)
+	_, err = localChannel.ProposeClose()
+	require.NoError(t, err)
+}
@@ -24,16 +24,9 @@ import (
 // The function maybe called with duplicate transactions and duplicates will not
 // change the state of the channel.
 func (c *Channel) IngestTx(txOrderID int64, txXDR, resultXDR, resultMetaXDR string) error {
-	// If channel has not been opened or has been closed, return.
-	if c.OpenAgreement().Envelope.Empty() {
-		return fmt.Errorf("channel has not been opened")
-	}
-	cs, err := c.State()
+	err := c.checkIngestable()
 	if err != nil {
-		return fmt.Errorf("getting channel state: %w", err)
-	}
-	if cs == StateClosed || cs == StateClosedWithOutdatedState {
-		return fmt.Errorf("channel has been closed")
+		return err
 	}
 
 	// Get transaction object from the transaction XDR.
@@ -41,6 +34,29 @@ func (c *Channel) IngestTx(txOrderID int64, txXDR, resultXDR, resultMetaXDR stri
 	if err != nil {
 		return fmt.Errorf("parsing transaction xdr")
 	}
+	var txResult xdr.TransactionResult
+	err = xdr.SafeUnmarshalBase64(resultXDR, &txResult)
+	if err != nil {
+		return fmt.Errorf("parsing the result xdr: %w", err)
+	}
+	var txMeta xdr.TransactionMeta
+	err = xdr.SafeUnmarshalBase64(resultMetaXDR, &txMeta)
+	if err != nil {
+		return fmt.Errorf("parsing the result meta xdr: %w", err)
+	}
+
+	return c.IngestDecodedTx(txOrderID, gtx, txResult, txMeta)
+}
+
+// IngestDecodedTx is IngestTx for a transaction, result, and result meta that
+// have already been decoded from their XDR, so that callers that decode them
+// for their own use do not require them to be decoded again.
+func (c *Channel) IngestDecodedTx(txOrderID int64, gtx *txnbuild.GenericTransaction, txResult xdr.TransactionResult, txMeta xdr.TransactionMeta) error {
+	err := c.checkIngestable()
+	if err != nil {
+		return err
+	}
+
 	var tx *txnbuild.Transaction
 	if feeBump, ok := gtx.FeeBump(); ok {
 		tx = feeBump.InnerTransaction()
@@ -60,12 +76,17 @@ func (c *Channel) IngestTx(txOrderID int64, txXDR, resultXDR, resultMetaXDR stri
 		return err
 	}
 
-	err = c.ingestTxMetaToUpdateBalances(txOrderID, resultMetaXDR)
+	err = c.ingestSignerRotationTx(tx, txResult)
+	if err != nil {
+		return err
+	}
+
+	err = c.ingestTxMetaToUpdateBalances(txOrderID, txMeta)
 	if err != nil {
 		return err
 	}
 
-	err = c.ingestOpenTx(tx, resultXDR, resultMetaXDR)
+	err = c.ingestOpenTx(tx, txResult, txMeta)
 	if err != nil {
 		return err
 	}
@@ -73,6 +94,23 @@ func (c *Channel) IngestTx(txOrderID int64, txXDR, resultXDR, resultMetaXDR stri
 	return nil
 }
 
+// checkIngestable returns an error if the channel is not in a state where
+// transactions can be ingested.
+func (c *Channel) checkIngestable() error {
+	// If channel has not been opened or has been closed, return.
+	if c.OpenAgreement().Envelope.Empty() {
+		return fmt.Errorf("channel has not been opened")
+	}
+	cs, err := c.State()
+	if err != nil {
+		return fmt.Errorf("getting channel state: %w", err)
+	}
+	if cs == StateClosed || cs == StateClosedWithOutdatedState {
+		return fmt.Errorf("channel has been closed")
+	}
+	return nil
+}
+
 func (c *Channel) ingestTxToUpdateInitiatorChannelAccountSequence(tx *txnbuild.Transaction) {
 	// If the transaction's source account is not the initiator's channel
 	// account, return.
@@ -154,14 +192,7 @@ func (c *Channel) ingestTxToUpdateUnauthorizedCloseAgreement(tx *txnbuild.Transa
 // ingestTxMetaToUpdateBalances uses the transaction result meta data
 // from a transaction response to update local and remote channel account
 // balances.
-func (c *Channel) ingestTxMetaToUpdateBalances(txOrderID int64, resultMetaXDR string) error {
-	// If not a valid resultMetaXDR string, return.
-	var txMeta xdr.TransactionMeta
-	err := xdr.SafeUnmarshalBase64(resultMetaXDR, &txMeta)
-	if err != nil {
-		return fmt.Errorf("parsing the result meta xdr: %w", err)
-	}
-
+func (c *Channel) ingestTxMetaToUpdateBalances(txOrderID int64, txMeta xdr.TransactionMeta) error {
 	channelAsset := c.openAgreement.Envelope.Details.Asset
 
 	// Find ledger changes for the channel accounts' balances,
@@ -219,14 +250,14 @@ func (c *Channel) ingestTxMetaToUpdateBalances(txOrderID int64, resultMetaXDR st
 	return nil
 }
 
-// ingestOpenTx accepts a transaction, resultXDR, and resultMetaXDR. The
-// method returns with no error if either 1. the resultXDR shows the transaction
+// ingestOpenTx accepts a transaction, result, and result meta. The
+// method returns with no error if either 1. the result shows the transaction
 // was unsuccessful, or 2. the transaction is not the open transaction this
 // channel is expecting, the method returns with no error. Lastly, this method
 // will validate that the resulting account and trustlines after the open
 // transaction was submitted are in this channel's expected states to mark the
 // channel as open.
-func (c *Channel) ingestOpenTx(tx *txnbuild.Transaction, resultXDR string, resultMetaXDR string) (err error) {
+func (c *Channel) ingestOpenTx(tx *txnbuild.Transaction, txResult xdr.TransactionResult, txMeta xdr.TransactionMeta) (err error) {
 	// If the transaction is not the open transaction, ignore.
 	openTx, err := c.OpenTx()
 	if err != nil {
@@ -245,25 +276,14 @@ func (c *Channel) ingestOpenTx(tx *txnbuild.Transaction, resultXDR string, resul
 	}
 
 	// If the transaction was not successful, return.
-	var txResult xdr.TransactionResult
-	err = xdr.SafeUnmarshalBase64(resultXDR, &txResult)
-	if err != nil {
-		return fmt.Errorf("parsing the result xdr: %w", err)
-	}
 	if !txResult.Successful() {
 		return nil
 	}
 
-	const requiredSignerWeight = 1
-	const requiredNumOfSigners = 2
-	const requiredThresholds = requiredNumOfSigners * requiredSignerWeight
+	scheme := c.openAgreement.Envelope.Details.SignerScheme.OrDefault()
+	requiredThresholds := xdr.Thresholds{scheme.MasterWeight, scheme.Threshold, scheme.Threshold, scheme.Threshold}
+	requiredSignerWeight := xdr.Uint32(scheme.SignerWeight)
 
-	// If not a valid resultMetaXDR string, return error.
-	var txMeta xdr.TransactionMeta
-	err = xdr.SafeUnmarshalBase64(resultMetaXDR, &txMeta)
-	if err != nil {
-		return fmt.Errorf("parsing the result meta xdr: %w", err)
-	}
 	txMetaV2, ok := txMeta.GetV2()
 	if !ok {
 		return fmt.Errorf("result meta version unrecognized")
@@ -320,10 +340,10 @@ func (c *Channel) ingestOpenTx(tx *txnbuild.Transaction, resultXDR string, resul
 
 	channelAccounts := [2]*xdr.AccountEntry{initiatorChannelAccountEntry, responderChannelAccountEntry}
 	for _, ea := range channelAccounts {
-		// Validate the channel account thresholds are equal to the number of
-		// signers so that all signers are required to sign all transactions.
+		// Validate the channel account thresholds are those of the signer
+		// scheme so that all signers are required to sign all transactions.
 		// Thresholds are: Master Key, Low, Medium, High.
-		if ea.Thresholds != (xdr.Thresholds{0, requiredThresholds, requiredThresholds, requiredThresholds}) {
+		if ea.Thresholds != requiredThresholds {
 			c.openExecutedWithError = fmt.Errorf("incorrect initiator channel account thresholds found")
 			return nil
 		}
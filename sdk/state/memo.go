@@ -0,0 +1,97 @@
+package state
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/stellar/go/txnbuild"
+)
+
+// MemoType describes how the memo attached to a payment is encoded. The types
+// mirror the memo types of the Stellar network so that memos can be carried
+// over to Stellar transactions without ambiguity. A memo of any type other
+// than MemoTypeNone is the memo of the close transaction of the payment's
+// agreement, so that the memo is seen on the network when the channel closes
+// with it.
+type MemoType int
+
+const (
+	// MemoTypeNone indicates the memo is opaque bytes of any length.
+	MemoTypeNone MemoType = iota
+	// MemoTypeText indicates the memo is text of at most 28 bytes.
+	MemoTypeText
+	// MemoTypeID indicates the memo is an unsigned 64-bit integer encoded as
+	// 8 big-endian bytes.
+	MemoTypeID
+	// MemoTypeHash indicates the memo is a 32 byte hash.
+	MemoTypeHash
+	// MemoTypeReturn indicates the memo is a 32 byte hash of a transaction
+	// being refunded.
+	MemoTypeReturn
+)
+
+// String returns a string representation of the memo type.
+func (t MemoType) String() string {
+	switch t {
+	case MemoTypeNone:
+		return "none"
+	case MemoTypeText:
+		return "text"
+	case MemoTypeID:
+		return "id"
+	case MemoTypeHash:
+		return "hash"
+	case MemoTypeReturn:
+		return "return"
+	}
+	return fmt.Sprintf("unknown(%d)", int(t))
+}
+
+// validateMemo checks that the memo is the size required by the memo type.
+func validateMemo(t MemoType, memo []byte) error {
+	switch t {
+	case MemoTypeNone:
+		return nil
+	case MemoTypeText:
+		if len(memo) > 28 {
+			return fmt.Errorf("memo of type %s must be at most 28 bytes, got %d bytes", t, len(memo))
+		}
+		return nil
+	case MemoTypeID:
+		if len(memo) != 8 {
+			return fmt.Errorf("memo of type %s must be 8 bytes, got %d bytes", t, len(memo))
+		}
+		return nil
+	case MemoTypeHash, MemoTypeReturn:
+		if len(memo) != 32 {
+			return fmt.Errorf("memo of type %s must be 32 bytes, got %d bytes", t, len(memo))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown memo type %d", int(t))
+}
+
+// transactionMemo returns the memo of a Stellar transaction for a memo of the
+// type, or nil for MemoTypeNone because opaque bytes cannot be carried by a
+// transaction.
+func transactionMemo(t MemoType, memo []byte) (txnbuild.Memo, error) {
+	err := validateMemo(t, memo)
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case MemoTypeText:
+		return txnbuild.MemoText(memo), nil
+	case MemoTypeID:
+		return txnbuild.MemoID(binary.BigEndian.Uint64(memo)), nil
+	case MemoTypeHash:
+		var h txnbuild.MemoHash
+		copy(h[:], memo)
+		return h, nil
+	case MemoTypeReturn:
+		var h txnbuild.MemoReturn
+		copy(h[:], memo)
+		return h, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateMemo(t *testing.T) {
+	testCases := []struct {
+		memoType MemoType
+		memo     []byte
+		wantErr  string
+	}{
+		{MemoTypeNone, nil, ""},
+		{MemoTypeNone, []byte(strings.Repeat("a", 1000)), ""},
+		{MemoTypeText, nil, ""},
+		{MemoTypeText, []byte(strings.Repeat("a", 28)), ""},
+		{MemoTypeText, []byte(strings.Repeat("a", 29)), "memo of type text must be at most 28 bytes, got 29 bytes"},
+		{MemoTypeID, make([]byte, 8), ""},
+		{MemoTypeID, make([]byte, 7), "memo of type id must be 8 bytes, got 7 bytes"},
+		{MemoTypeHash, make([]byte, 32), ""},
+		{MemoTypeHash, nil, "memo of type hash must be 32 bytes, got 0 bytes"},
+		{MemoTypeReturn, make([]byte, 32), ""},
+		{MemoTypeReturn, make([]byte, 33), "memo of type return must be 32 bytes, got 33 bytes"},
+		{MemoType(9), nil, "unknown memo type 9"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.memoType.String(), func(t *testing.T) {
+			err := validateMemo(tc.memoType, tc.memo)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransactionMemo(t *testing.T) {
+	hash := [32]byte{1, 2, 3}
+	testCases := []struct {
+		memoType MemoType
+		memo     []byte
+		want     txnbuild.Memo
+	}{
+		{MemoTypeNone, []byte("opaque"), nil},
+		{MemoTypeText, []byte("text"), txnbuild.MemoText("text")},
+		{MemoTypeID, []byte{0, 0, 0, 0, 0, 0, 1, 2}, txnbuild.MemoID(258)},
+		{MemoTypeHash, hash[:], txnbuild.MemoHash(hash)},
+		{MemoTypeReturn, hash[:], txnbuild.MemoReturn(hash)},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.memoType.String(), func(t *testing.T) {
+			memo, err := transactionMemo(tc.memoType, tc.memo)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, memo)
+		})
+	}
+
+	_, err := transactionMemo(MemoTypeID, make([]byte, 7))
+	assert.EqualError(t, err, "memo of type id must be 8 bytes, got 7 bytes")
+}
@@ -12,12 +12,34 @@ import (
 )
 
 // OpenDetails contain the details participants agree on for opening a channel.
+//
+// InitiatorContribution and ResponderContribution are the amounts of the asset
+// that each participant commits to the channel by holding them in their
+// channel account. The open transaction does not move funds, and the first
+// close agreement has a Balance of zero, so if the channel closes without any
+// payments each channel account keeps its contribution. Payments then move
+// value between the contributions, a positive Balance being owed by the
+// initiator to the responder, and a negative Balance by the responder to the
+// initiator. Contributions may differ, and a contribution of zero means a
+// participant is only able to receive until they are paid.
+//
+// SignerScheme is the weights and thresholds the open transaction sets on the
+// channel accounts. The zero value is txbuild.DefaultSignerScheme.
+//
+// InitiatorTrustLimit and ResponderTrustLimit are the limits the open
+// transaction sets on the trustlines of each participant's channel account
+// when the asset is not native. A limit of zero is the maximum limit.
 type OpenDetails struct {
 	ObservationPeriodTime      time.Duration
 	ObservationPeriodLedgerGap int64
 	Asset                      Asset
 	ExpiresAt                  time.Time
 	StartingSequence           int64
+	InitiatorContribution      int64
+	ResponderContribution      int64
+	SignerScheme               txbuild.SignerScheme
+	InitiatorTrustLimit        int64
+	ResponderTrustLimit        int64
 	ProposingSigner            *keypair.FromAddress
 	ConfirmingSigner           *keypair.FromAddress
 }
@@ -29,6 +51,11 @@ func (d OpenDetails) Equal(d2 OpenDetails) bool {
 		d.Asset == d2.Asset &&
 		d.ExpiresAt.Equal(d2.ExpiresAt) &&
 		d.StartingSequence == d2.StartingSequence &&
+		d.InitiatorContribution == d2.InitiatorContribution &&
+		d.ResponderContribution == d2.ResponderContribution &&
+		d.SignerScheme.OrDefault() == d2.SignerScheme.OrDefault() &&
+		d.InitiatorTrustLimit == d2.InitiatorTrustLimit &&
+		d.ResponderTrustLimit == d2.ResponderTrustLimit &&
 		d.ProposingSigner.Equal(d2.ProposingSigner) &&
 		d.ConfirmingSigner.Equal(d2.ConfirmingSigner)
 }
@@ -58,7 +85,7 @@ func (oas OpenSignatures) Equal(oas2 OpenSignatures) bool {
 		bytes.Equal(oas.Close, oas2.Close)
 }
 
-func signOpenAgreementTxs(txs OpenTransactions, closeTxs CloseTransactions, signer *keypair.Full) (s OpenSignatures, err error) {
+func signOpenAgreementTxs(txs OpenTransactions, closeTxs CloseTransactions, signer Signer) (s OpenSignatures, err error) {
 	s.Declaration, err = signer.Sign(closeTxs.DeclarationHash[:])
 	if err != nil {
 		return OpenSignatures{}, fmt.Errorf("signing declaration: %w", err)
@@ -207,6 +234,11 @@ type OpenParams struct {
 	Asset                      Asset
 	ExpiresAt                  time.Time
 	StartingSequence           int64
+	InitiatorContribution      int64
+	ResponderContribution      int64
+	SignerScheme               txbuild.SignerScheme
+	InitiatorTrustLimit        int64
+	ResponderTrustLimit        int64
 }
 
 // openTxs builds the transactions that embody the open agreement that can be
@@ -219,6 +251,12 @@ func (c *Channel) openTxs(d OpenDetails) (txs OpenTransactions, closeTxs CloseTr
 	if c.openAgreement.Envelope.Details.Equal(d) {
 		return c.openAgreement.Transactions, c.openAgreement.CloseTransactions, nil
 	}
+	return buildOpenTxs(c.networkPassphrase, c.txParticipants(), d)
+}
+
+// buildOpenTxs builds the transactions that embody the open agreement between
+// the participants, and includes the first close agreement transactions.
+func buildOpenTxs(networkPassphrase string, p txParticipants, d OpenDetails) (txs OpenTransactions, closeTxs CloseTransactions, err error) {
 	cad := CloseDetails{
 		ObservationPeriodTime:      d.ObservationPeriodTime,
 		ObservationPeriodLedgerGap: d.ObservationPeriodLedgerGap,
@@ -227,29 +265,32 @@ func (c *Channel) openTxs(d OpenDetails) (txs OpenTransactions, closeTxs CloseTr
 		ConfirmingSigner:           d.ConfirmingSigner,
 	}
 
-	closeTxs, err = c.closeTxs(d, cad)
+	closeTxs, err = buildCloseTxs(networkPassphrase, p, d, cad)
 	if err != nil {
 		err = fmt.Errorf("building close txs for open: %w", err)
 		return
 	}
 
 	open, err := txbuild.Open(txbuild.OpenParams{
-		InitiatorSigner:         c.initiatorSigner(),
-		ResponderSigner:         c.responderSigner(),
-		InitiatorChannelAccount: c.initiatorChannelAccount().Address,
-		ResponderChannelAccount: c.responderChannelAccount().Address,
+		InitiatorSigner:         p.InitiatorSigner,
+		ResponderSigner:         p.ResponderSigner,
+		InitiatorChannelAccount: p.InitiatorChannelAccount,
+		ResponderChannelAccount: p.ResponderChannelAccount,
 		StartSequence:           d.StartingSequence,
 		Asset:                   d.Asset.Asset(),
 		ExpiresAt:               d.ExpiresAt,
 		DeclarationTxHash:       closeTxs.DeclarationHash,
 		CloseTxHash:             closeTxs.CloseHash,
 		ConfirmingSigner:        d.ConfirmingSigner,
+		SignerScheme:            d.SignerScheme,
+		InitiatorTrustLimit:     d.InitiatorTrustLimit,
+		ResponderTrustLimit:     d.ResponderTrustLimit,
 	})
 	if err != nil {
 		err = fmt.Errorf("building open tx for open: %w", err)
 		return
 	}
-	openHash, err := open.Hash(c.networkPassphrase)
+	openHash, err := open.Hash(networkPassphrase)
 	if err != nil {
 		err = fmt.Errorf("hashing open tx: %w", err)
 		return
@@ -272,12 +313,19 @@ func (c *Channel) OpenTx() (openTx *txnbuild.Transaction, err error) {
 }
 
 // ProposeOpen proposes the open of the channel, it is called by the participant
-// initiating the channel.
+// proposing the open, which is usually the initiator of the channel but may be
+// the responder.
 func (c *Channel) ProposeOpen(p OpenParams) (OpenAgreement, error) {
 	// if the channel is already opening, error.
 	if !c.openAgreement.Envelope.Empty() {
 		return OpenAgreement{}, fmt.Errorf("cannot propose a new open if channel is already opening or already open")
 	}
+	if p.InitiatorContribution < 0 || p.ResponderContribution < 0 {
+		return OpenAgreement{}, fmt.Errorf("contributions must not be less than 0")
+	}
+	if p.InitiatorTrustLimit < 0 || p.ResponderTrustLimit < 0 {
+		return OpenAgreement{}, fmt.Errorf("trust limits must not be less than 0")
+	}
 
 	d := OpenDetails{
 		ObservationPeriodTime:      p.ObservationPeriodTime,
@@ -285,6 +333,11 @@ func (c *Channel) ProposeOpen(p OpenParams) (OpenAgreement, error) {
 		Asset:                      p.Asset,
 		ExpiresAt:                  p.ExpiresAt,
 		StartingSequence:           p.StartingSequence,
+		InitiatorContribution:      p.InitiatorContribution,
+		ResponderContribution:      p.ResponderContribution,
+		SignerScheme:               p.SignerScheme,
+		InitiatorTrustLimit:        p.InitiatorTrustLimit,
+		ResponderTrustLimit:        p.ResponderTrustLimit,
 		ProposingSigner:            c.localSigner.FromAddress(),
 		ConfirmingSigner:           c.remoteSigner,
 	}
@@ -309,6 +362,47 @@ func (c *Channel) ProposeOpen(p OpenParams) (OpenAgreement, error) {
 	return open, nil
 }
 
+// ErrContributionMismatch indicates that an open agreement states a
+// contribution for a participant that differs from the contribution expected.
+var ErrContributionMismatch = fmt.Errorf("open agreement contribution does not match expected contribution")
+
+// ContributionMismatchError is returned when an open agreement states a
+// contribution for a participant's channel account that differs from the
+// contribution expected for it. It matches ErrContributionMismatch when
+// compared with errors.Is.
+type ContributionMismatchError struct {
+	ChannelAccount *keypair.FromAddress
+	Proposed       int64
+	Expected       int64
+}
+
+func (e ContributionMismatchError) Error() string {
+	return fmt.Sprintf("%v: channel account %s proposed %d, expected %d",
+		ErrContributionMismatch, e.ChannelAccount.Address(), e.Proposed, e.Expected)
+}
+
+// Unwrap returns ErrContributionMismatch.
+func (e ContributionMismatchError) Unwrap() error {
+	return ErrContributionMismatch
+}
+
+// ErrObservationPeriodMismatch indicates that an open agreement states an
+// observation period that differs from the observation period expected. The
+// error it is wrapped in names the field that differs.
+var ErrObservationPeriodMismatch = fmt.Errorf("open agreement observation period does not match expected observation period")
+
+// ErrSignerSchemeMismatch indicates that an open agreement states a signer
+// scheme that differs from the signer scheme expected.
+var ErrSignerSchemeMismatch = fmt.Errorf("open agreement signer scheme does not match expected signer scheme")
+
+// ErrTrustLimitMismatch indicates that an open agreement states a trust limit
+// for a channel account that differs from the trust limit expected for it.
+var ErrTrustLimitMismatch = fmt.Errorf("open agreement trust limit does not match expected trust limit")
+
+// ErrOpenExpiryTooFar indicates that an open agreement expires further into
+// the future than the channel's max open expiry permits.
+var ErrOpenExpiryTooFar = fmt.Errorf("open agreement expires too far into the future")
+
 func (c *Channel) validateOpen(m OpenEnvelope) error {
 	// if the channel is already open, error.
 	if c.openAgreement.Envelope.HasAllSignatures() {
@@ -321,8 +415,64 @@ func (c *Channel) validateOpen(m OpenEnvelope) error {
 	}
 
 	// If the expiry of the agreement is past the max expiry the channel will accept, error.
-	if m.Details.ExpiresAt.After(time.Now().Add(c.maxOpenExpiry)) {
-		return fmt.Errorf("input open agreement expire too far into the future")
+	if maxExpiresAt := time.Now().Add(c.maxOpenExpiry); m.Details.ExpiresAt.After(maxExpiresAt) {
+		return fmt.Errorf("%w: expires at %v, max open expiry %v permits up to %v",
+			ErrOpenExpiryTooFar, m.Details.ExpiresAt, c.maxOpenExpiry, maxExpiresAt.Round(time.Second))
+	}
+
+	// If the observation period is not what this participant expects, error.
+	if c.observationPeriodTime != 0 && m.Details.ObservationPeriodTime != c.observationPeriodTime {
+		return fmt.Errorf("%w: ObservationPeriodTime proposed %v, expected %v",
+			ErrObservationPeriodMismatch, m.Details.ObservationPeriodTime, c.observationPeriodTime)
+	}
+	if c.observationPeriodLedgerGap != 0 && m.Details.ObservationPeriodLedgerGap != c.observationPeriodLedgerGap {
+		return fmt.Errorf("%w: ObservationPeriodLedgerGap proposed %d, expected %d",
+			ErrObservationPeriodMismatch, m.Details.ObservationPeriodLedgerGap, c.observationPeriodLedgerGap)
+	}
+
+	// If the signer scheme is not what this participant expects, error.
+	if proposed, expected := m.Details.SignerScheme.OrDefault(), c.signerScheme.OrDefault(); proposed != expected {
+		return fmt.Errorf("%w: proposed %+v, expected %+v", ErrSignerSchemeMismatch, proposed, expected)
+	}
+
+	// If the contributions are not what this participant expects, error.
+	if m.Details.InitiatorContribution < 0 || m.Details.ResponderContribution < 0 {
+		return fmt.Errorf("input open agreement contributions must not be less than 0")
+	}
+	localContribution, remoteContribution := m.Details.InitiatorContribution, m.Details.ResponderContribution
+	if !c.initiator {
+		localContribution, remoteContribution = remoteContribution, localContribution
+	}
+	if c.localContribution != 0 && localContribution != c.localContribution {
+		return ContributionMismatchError{
+			ChannelAccount: c.localChannelAccount.Address,
+			Proposed:       localContribution,
+			Expected:       c.localContribution,
+		}
+	}
+	if c.remoteContribution != 0 && remoteContribution != c.remoteContribution {
+		return ContributionMismatchError{
+			ChannelAccount: c.remoteChannelAccount.Address,
+			Proposed:       remoteContribution,
+			Expected:       c.remoteContribution,
+		}
+	}
+
+	// If the trust limits are not what this participant expects, error.
+	if m.Details.InitiatorTrustLimit < 0 || m.Details.ResponderTrustLimit < 0 {
+		return fmt.Errorf("input open agreement trust limits must not be less than 0")
+	}
+	localTrustLimit, remoteTrustLimit := m.Details.InitiatorTrustLimit, m.Details.ResponderTrustLimit
+	if !c.initiator {
+		localTrustLimit, remoteTrustLimit = remoteTrustLimit, localTrustLimit
+	}
+	if c.localTrustLimit != 0 && localTrustLimit != c.localTrustLimit {
+		return fmt.Errorf("%w: channel account %s proposed %d, expected %d",
+			ErrTrustLimitMismatch, c.localChannelAccount.Address.Address(), localTrustLimit, c.localTrustLimit)
+	}
+	if c.remoteTrustLimit != 0 && remoteTrustLimit != c.remoteTrustLimit {
+		return fmt.Errorf("%w: channel account %s proposed %d, expected %d",
+			ErrTrustLimitMismatch, c.remoteChannelAccount.Address.Address(), remoteTrustLimit, c.remoteTrustLimit)
 	}
 
 	return nil
@@ -378,6 +528,6 @@ func (c *Channel) ConfirmOpen(m OpenEnvelope) (open OpenAgreement, err error) {
 		Transactions:      txs,
 		CloseTransactions: closeTxs,
 	}
-	c.latestAuthorizedCloseAgreement = c.openAgreement.CloseAgreement()
+	c.setLatestAuthorizedCloseAgreement(c.openAgreement.CloseAgreement())
 	return c.openAgreement, nil
 }
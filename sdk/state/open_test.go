@@ -11,6 +11,7 @@ import (
 	"github.com/stellar/go/network"
 	"github.com/stellar/go/txnbuild"
 	"github.com/stellar/go/xdr"
+	"github.com/stellar/starlight/sdk/txbuild"
 	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -217,7 +218,291 @@ func TestConfirmOpen_rejectsOpenAgreementsWithLongOpen(t *testing.T) {
 		Asset:                      NativeAsset,
 		ExpiresAt:                  time.Now().Add(100 * time.Second),
 	}})
-	require.EqualError(t, err, "validating open agreement: input open agreement expire too far into the future")
+	require.ErrorIs(t, err, ErrOpenExpiryTooFar)
+	assert.Contains(t, err.Error(), "validating open agreement: open agreement expires too far into the future: expires at ")
+	assert.Contains(t, err.Error(), "max open expiry 10s permits up to ")
+}
+
+func TestChannel_ConfirmOpen_observationPeriod(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	newChannel := func(observationPeriodTime time.Duration, observationPeriodLedgerGap int64) *Channel {
+		return NewChannel(Config{
+			NetworkPassphrase:          network.TestNetworkPassphrase,
+			MaxOpenExpiry:              2 * time.Hour,
+			LocalSigner:                localSigner,
+			RemoteSigner:               remoteSigner.FromAddress(),
+			LocalChannelAccount:        localChannelAccount,
+			RemoteChannelAccount:       remoteChannelAccount,
+			ObservationPeriodTime:      observationPeriodTime,
+			ObservationPeriodLedgerGap: observationPeriodLedgerGap,
+		})
+	}
+	details := OpenDetails{
+		ObservationPeriodTime:      time.Minute,
+		ObservationPeriodLedgerGap: 10,
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(time.Hour),
+	}
+
+	t.Run("timeMismatch", func(t *testing.T) {
+		_, err := newChannel(2*time.Minute, 10).ConfirmOpen(OpenEnvelope{Details: details})
+		require.ErrorIs(t, err, ErrObservationPeriodMismatch)
+		assert.EqualError(t, err, "validating open agreement: open agreement observation period does not match expected observation period: ObservationPeriodTime proposed 1m0s, expected 2m0s")
+	})
+
+	t.Run("ledgerGapMismatch", func(t *testing.T) {
+		_, err := newChannel(time.Minute, 20).ConfirmOpen(OpenEnvelope{Details: details})
+		require.ErrorIs(t, err, ErrObservationPeriodMismatch)
+		assert.EqualError(t, err, "validating open agreement: open agreement observation period does not match expected observation period: ObservationPeriodLedgerGap proposed 10, expected 20")
+	})
+
+	t.Run("match", func(t *testing.T) {
+		// Validation passes and confirming fails later only because the
+		// agreement is not signed.
+		_, err := newChannel(time.Minute, 10).ConfirmOpen(OpenEnvelope{Details: details})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrObservationPeriodMismatch)
+		assert.NotContains(t, err.Error(), "validating open agreement")
+	})
+
+	t.Run("anyAccepted", func(t *testing.T) {
+		_, err := newChannel(0, 0).ConfirmOpen(OpenEnvelope{Details: details})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "validating open agreement")
+	})
+}
+
+func TestChannel_ConfirmOpen_signerScheme(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	newChannel := func(scheme txbuild.SignerScheme) *Channel {
+		return NewChannel(Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			MaxOpenExpiry:        2 * time.Hour,
+			LocalSigner:          localSigner,
+			RemoteSigner:         remoteSigner.FromAddress(),
+			LocalChannelAccount:  localChannelAccount,
+			RemoteChannelAccount: remoteChannelAccount,
+			SignerScheme:         scheme,
+		})
+	}
+	custom := txbuild.SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4}
+	details := OpenDetails{
+		ObservationPeriodTime:      time.Minute,
+		ObservationPeriodLedgerGap: 10,
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(time.Hour),
+		SignerScheme:               custom,
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		_, err := newChannel(txbuild.SignerScheme{}).ConfirmOpen(OpenEnvelope{Details: details})
+		require.ErrorIs(t, err, ErrSignerSchemeMismatch)
+		assert.EqualError(t, err, "validating open agreement: open agreement signer scheme does not match expected signer scheme: "+
+			"proposed {MasterWeight:1 SignerWeight:2 Threshold:4}, expected {MasterWeight:0 SignerWeight:1 Threshold:2}")
+	})
+
+	t.Run("match", func(t *testing.T) {
+		// Validation passes and confirming fails later only because the
+		// agreement is not signed.
+		_, err := newChannel(custom).ConfirmOpen(OpenEnvelope{Details: details})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "validating open agreement")
+	})
+
+	t.Run("defaultMatchesZero", func(t *testing.T) {
+		d := details
+		d.SignerScheme = txbuild.DefaultSignerScheme
+		_, err := newChannel(txbuild.SignerScheme{}).ConfirmOpen(OpenEnvelope{Details: d})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "validating open agreement")
+	})
+}
+
+func TestChannel_ConfirmOpen_contributions(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	newChannels := func(responderConfig Config) (initiatorChannel, responderChannel *Channel) {
+		initiatorChannel = NewChannel(Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			Initiator:            true,
+			LocalSigner:          localSigner,
+			RemoteSigner:         remoteSigner.FromAddress(),
+			LocalChannelAccount:  localChannelAccount,
+			RemoteChannelAccount: remoteChannelAccount,
+			MaxOpenExpiry:        2 * time.Hour,
+		})
+		responderConfig.NetworkPassphrase = network.TestNetworkPassphrase
+		responderConfig.LocalSigner = remoteSigner
+		responderConfig.RemoteSigner = localSigner.FromAddress()
+		responderConfig.LocalChannelAccount = remoteChannelAccount
+		responderConfig.RemoteChannelAccount = localChannelAccount
+		responderConfig.MaxOpenExpiry = 2 * time.Hour
+		responderChannel = NewChannel(responderConfig)
+		return
+	}
+	params := OpenParams{
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(5 * time.Second),
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+		StartingSequence:           101,
+		InitiatorContribution:      80,
+		ResponderContribution:      20,
+	}
+
+	t.Run("asymmetricAgreed", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{LocalContribution: 20, RemoteContribution: 80})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		m, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		m, err = initiatorChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		assert.Equal(t, int64(80), m.Envelope.Details.InitiatorContribution)
+		assert.Equal(t, int64(20), m.Envelope.Details.ResponderContribution)
+	})
+
+	t.Run("noExpectations", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+	})
+
+	t.Run("localMismatch", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{LocalContribution: 50})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmOpen(m.Envelope)
+		assert.EqualError(t, err, "validating open agreement: open agreement contribution does not match expected contribution: channel account "+remoteChannelAccount.Address()+" proposed 20, expected 50")
+		assert.ErrorIs(t, err, ErrContributionMismatch)
+		mismatchErr := ContributionMismatchError{}
+		require.ErrorAs(t, err, &mismatchErr)
+		assert.Equal(t, ContributionMismatchError{ChannelAccount: remoteChannelAccount, Proposed: 20, Expected: 50}, mismatchErr)
+	})
+
+	t.Run("remoteMismatch", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{LocalContribution: 20, RemoteContribution: 100})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmOpen(m.Envelope)
+		mismatchErr := ContributionMismatchError{}
+		require.ErrorAs(t, err, &mismatchErr)
+		assert.Equal(t, ContributionMismatchError{ChannelAccount: localChannelAccount, Proposed: 80, Expected: 100}, mismatchErr)
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		initiatorChannel, _ := newChannels(Config{})
+		p := params
+		p.ResponderContribution = -1
+		_, err := initiatorChannel.ProposeOpen(p)
+		assert.EqualError(t, err, "contributions must not be less than 0")
+	})
+}
+
+func TestChannel_ConfirmOpen_trustLimits(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	newChannels := func(responderConfig Config) (initiatorChannel, responderChannel *Channel) {
+		initiatorChannel = NewChannel(Config{
+			NetworkPassphrase:    network.TestNetworkPassphrase,
+			Initiator:            true,
+			LocalSigner:          localSigner,
+			RemoteSigner:         remoteSigner.FromAddress(),
+			LocalChannelAccount:  localChannelAccount,
+			RemoteChannelAccount: remoteChannelAccount,
+			MaxOpenExpiry:        2 * time.Hour,
+		})
+		responderConfig.NetworkPassphrase = network.TestNetworkPassphrase
+		responderConfig.LocalSigner = remoteSigner
+		responderConfig.RemoteSigner = localSigner.FromAddress()
+		responderConfig.LocalChannelAccount = remoteChannelAccount
+		responderConfig.RemoteChannelAccount = localChannelAccount
+		responderConfig.MaxOpenExpiry = 2 * time.Hour
+		responderChannel = NewChannel(responderConfig)
+		return
+	}
+	params := OpenParams{
+		Asset:                      Asset("ABC:GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36"),
+		ExpiresAt:                  time.Now().Add(5 * time.Second),
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+		StartingSequence:           101,
+		InitiatorTrustLimit:        1000,
+		ResponderTrustLimit:        500,
+	}
+	changeTrustLimits := func(open OpenAgreement) []string {
+		limits := []string{}
+		for _, op := range open.Transactions.Open.Operations() {
+			if ct, ok := op.(*txnbuild.ChangeTrust); ok {
+				limits = append(limits, ct.SourceAccount+" "+ct.Limit)
+			}
+		}
+		return limits
+	}
+
+	t.Run("agreed", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{LocalTrustLimit: 500, RemoteTrustLimit: 1000})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		m, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		m, err = initiatorChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			localChannelAccount.Address() + " 0.0001000",
+			remoteChannelAccount.Address() + " 0.0000500",
+		}, changeTrustLimits(m))
+	})
+
+	t.Run("noExpectations", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+	})
+
+	t.Run("localMismatch", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{LocalTrustLimit: 600})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmOpen(m.Envelope)
+		assert.ErrorIs(t, err, ErrTrustLimitMismatch)
+		assert.EqualError(t, err, "validating open agreement: open agreement trust limit does not match expected trust limit: channel account "+remoteChannelAccount.Address()+" proposed 500, expected 600")
+	})
+
+	t.Run("remoteMismatch", func(t *testing.T) {
+		initiatorChannel, responderChannel := newChannels(Config{RemoteTrustLimit: 2000})
+		m, err := initiatorChannel.ProposeOpen(params)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmOpen(m.Envelope)
+		assert.ErrorIs(t, err, ErrTrustLimitMismatch)
+		assert.EqualError(t, err, "validating open agreement: open agreement trust limit does not match expected trust limit: channel account "+localChannelAccount.Address()+" proposed 1000, expected 2000")
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		initiatorChannel, _ := newChannels(Config{})
+		p := params
+		p.InitiatorTrustLimit = -1
+		_, err := initiatorChannel.ProposeOpen(p)
+		assert.EqualError(t, err, "trust limits must not be less than 0")
+	})
 }
 
 func TestChannel_ConfirmOpen_signatureChecks(t *testing.T) {
@@ -641,6 +926,7 @@ func TestChannel_OpenAndPayment_sequenceOverflow(t *testing.T) {
 		assert.Equal(t, StateOpen, cs)
 	}
 	initiatorChannel.UpdateLocalChannelAccountBalance(200)
+	initiatorChannel.UpdateRemoteChannelAccountBalance(200)
 
 	// Proposing Payment that pushes the sequence number over max int64 should error.
 	_, err = initiatorChannel.ProposePayment(10)
@@ -653,7 +939,8 @@ func TestChannel_OpenAndPayment_sequenceOverflow(t *testing.T) {
 			IterationNumber:            2,
 			ObservationPeriodTime:      10,
 			ObservationPeriodLedgerGap: 10,
-			Balance:                    0,
+			Balance:                    -10,
+			PaymentAmount:              10,
 			ConfirmingSigner:           localSigner.FromAddress(),
 			ProposingSigner:            remoteSigner.FromAddress(),
 		},
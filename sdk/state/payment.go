@@ -24,6 +24,7 @@ type CloseDetails struct {
 	// signers because the information is not embedded into the agreement's
 	// transactions.
 	PaymentAmount int64
+	MemoType      MemoType
 	Memo          []byte
 }
 
@@ -36,6 +37,7 @@ func (d CloseDetails) Equal(d2 CloseDetails) bool {
 		d.ProposingSigner.Equal(d2.ProposingSigner) &&
 		d.ConfirmingSigner.Equal(d2.ConfirmingSigner) &&
 		d.PaymentAmount == d2.PaymentAmount &&
+		d.MemoType == d2.MemoType &&
 		bytes.Equal(d.Memo, d2.Memo)
 }
 
@@ -62,7 +64,7 @@ func (cas CloseSignatures) Equal(cas2 CloseSignatures) bool {
 		bytes.Equal(cas.Close, cas2.Close)
 }
 
-func signCloseAgreementTxs(txs CloseTransactions, signer *keypair.Full) (s CloseSignatures, err error) {
+func signCloseAgreementTxs(txs CloseTransactions, signer Signer) (s CloseSignatures, err error) {
 	g := errgroup.Group{}
 	g.Go(func() error {
 		var err error
@@ -125,6 +127,23 @@ type CloseAgreement struct {
 	Transactions CloseTransactions
 }
 
+// Equal returns true if two CloseAgreement have equal envelopes and
+// transaction hashes, else false. Agreements with the same details but
+// different signatures are not equal.
+func (ca CloseAgreement) Equal(ca2 CloseAgreement) bool {
+	return ca.Envelope.Equal(ca2.Envelope) &&
+		ca.Transactions.DeclarationHash == ca2.Transactions.DeclarationHash &&
+		ca.Transactions.CloseHash == ca2.Transactions.CloseHash
+}
+
+// IsNewerThan returns true if the CloseAgreement has a greater iteration
+// number than the other agreement, else false. Agreements with the same
+// iteration number are neither newer nor older than one another, even if they
+// are not equal.
+func (ca CloseAgreement) IsNewerThan(ca2 CloseAgreement) bool {
+	return ca.Envelope.Details.IterationNumber > ca2.Envelope.Details.IterationNumber
+}
+
 // SignedTransactions adds signatures from the CloseAgreement's Envelope to its
 // Transactions.
 func (ca CloseAgreement) SignedTransactions() CloseTransactions {
@@ -167,8 +186,20 @@ func (c *Channel) ProposePayment(amount int64) (CloseAgreement, error) {
 // information about the payment. See the ProposePayment function for more
 // information.
 func (c *Channel) ProposePaymentWithMemo(amount int64, memo []byte) (CloseAgreement, error) {
-	if amount < 0 {
-		return CloseAgreement{}, fmt.Errorf("payment amount must not be less than 0")
+	return c.ProposePaymentWithTypedMemo(amount, MemoTypeNone, memo)
+}
+
+// ProposePaymentWithTypedMemo proposes a new payment that has a memo of the
+// given memo type attached to it. The memo must be the size required by the
+// memo type. See the ProposePayment function for more information.
+func (c *Channel) ProposePaymentWithTypedMemo(amount int64, memoType MemoType, memo []byte) (CloseAgreement, error) {
+	if amount <= 0 {
+		return CloseAgreement{}, fmt.Errorf("%w: %d", ErrInvalidAmount, amount)
+	}
+
+	err := validateMemo(memoType, memo)
+	if err != nil {
+		return CloseAgreement{}, fmt.Errorf("invalid memo: %w", err)
 	}
 
 	// If the channel is not open yet, error.
@@ -182,6 +213,11 @@ func (c *Channel) ProposePaymentWithMemo(amount int64, memo []byte) (CloseAgreem
 		return CloseAgreement{}, fmt.Errorf("cannot propose payment after an accepted coordinated close")
 	}
 
+	// If a signer rotation is pending, error.
+	if err := c.checkNoSignerRotationPending(); err != nil {
+		return CloseAgreement{}, fmt.Errorf("cannot propose payment: %w", err)
+	}
+
 	// If a coordinated close has been proposed by this channel already, error.
 	if !c.latestUnauthorizedCloseAgreement.Envelope.Empty() && c.latestUnauthorizedCloseAgreement.Envelope.Details.ObservationPeriodTime == 0 &&
 		c.latestUnauthorizedCloseAgreement.Envelope.Details.ObservationPeriodLedgerGap == 0 {
@@ -200,8 +236,8 @@ func (c *Channel) ProposePaymentWithMemo(amount int64, memo []byte) (CloseAgreem
 		newBalance = c.Balance() - amount
 	}
 
-	if c.amountToRemote(newBalance) > c.localChannelAccount.Balance {
-		return CloseAgreement{}, fmt.Errorf("amount over commits: %w", ErrUnderfunded)
+	if err := c.checkFunded(c.amountToRemote(newBalance), c.localChannelAccount.Balance); err != nil {
+		return CloseAgreement{}, fmt.Errorf("amount over commits: %w", err)
 	}
 
 	d := CloseDetails{
@@ -212,6 +248,7 @@ func (c *Channel) ProposePaymentWithMemo(amount int64, memo []byte) (CloseAgreem
 		ProposingSigner:            c.localSigner.FromAddress(),
 		ConfirmingSigner:           c.remoteSigner,
 		PaymentAmount:              amount,
+		MemoType:                   memoType,
 		Memo:                       memo,
 	}
 	txs, err := c.closeTxs(c.openAgreement.Envelope.Details, d)
@@ -233,10 +270,53 @@ func (c *Channel) ProposePaymentWithMemo(amount int64, memo []byte) (CloseAgreem
 	return c.latestUnauthorizedCloseAgreement, nil
 }
 
+// ErrInvalidAmount indicates that a payment amount is zero or negative. A
+// payment of zero would use an iteration without changing the balance, and
+// payments can only push funds to the other participant.
+var ErrInvalidAmount = fmt.Errorf("payment amount must be greater than 0")
+
 // ErrUnderfunded indicates that the account has insufficient funds to make a
 // specific payment amount.
 var ErrUnderfunded = fmt.Errorf("account is underfunded to make payment")
 
+// UnderfundedError is returned when a channel account has insufficient funds
+// to make a specific payment amount. It contains the amount the channel
+// account would be required to pay out, the reserve amount it must hold in
+// addition, and the balance known to be available, so that callers can
+// determine the shortfall. It matches ErrUnderfunded when compared with
+// errors.Is.
+type UnderfundedError struct {
+	Asset     Asset
+	Required  int64
+	Reserve   int64
+	Available int64
+}
+
+// Shortfall returns the amount the channel account needs to be topped up by to
+// make the payment.
+func (e UnderfundedError) Shortfall() int64 {
+	return e.Required + e.Reserve - e.Available
+}
+
+func (e UnderfundedError) Error() string {
+	if e.Reserve != 0 {
+		return fmt.Sprintf("%v: required %d, reserve %d, available %d, shortfall %d of %s",
+			ErrUnderfunded, e.Required, e.Reserve, e.Available, e.Shortfall(), e.Asset.StringCanonical())
+	}
+	return fmt.Sprintf("%v: required %d, available %d, shortfall %d of %s",
+		ErrUnderfunded, e.Required, e.Available, e.Shortfall(), e.Asset.StringCanonical())
+}
+
+// Unwrap returns ErrUnderfunded.
+func (e UnderfundedError) Unwrap() error {
+	return ErrUnderfunded
+}
+
+// ErrIterationGap indicates that a payment does not advance the iteration
+// number of the channel by exactly one, either because it skips ahead of the
+// next iteration number or repeats a previous one.
+var ErrIterationGap = fmt.Errorf("payment iteration number does not follow the latest authorized iteration number")
+
 // validatePayment validates the close agreement given to the ConfirmPayment method. Note that
 // there are additional verifications ConfirmPayment performs that are based
 // on the state of the close agreement signatures.
@@ -258,9 +338,14 @@ func (c *Channel) validatePayment(ce CloseEnvelope) (err error) {
 		return fmt.Errorf("cannot confirm payment after an accepted coordinated close")
 	}
 
+	// If a signer rotation is pending, error.
+	if err := c.checkNoSignerRotationPending(); err != nil {
+		return fmt.Errorf("cannot confirm payment: %w", err)
+	}
+
 	// If the new close agreement details are incorrect, error.
 	if ce.Details.IterationNumber != c.nextIterationNumber() {
-		return fmt.Errorf("invalid payment iteration number, got: %d want: %d", ce.Details.IterationNumber, c.nextIterationNumber())
+		return fmt.Errorf("invalid payment iteration number, got: %d want: %d: %w", ce.Details.IterationNumber, c.nextIterationNumber(), ErrIterationGap)
 	}
 	if ce.Details.ObservationPeriodTime != c.latestAuthorizedCloseAgreement.Envelope.Details.ObservationPeriodTime ||
 		ce.Details.ObservationPeriodLedgerGap != c.latestAuthorizedCloseAgreement.Envelope.Details.ObservationPeriodLedgerGap {
@@ -272,11 +357,18 @@ func (c *Channel) validatePayment(ce CloseEnvelope) (err error) {
 	if !ce.Details.ConfirmingSigner.Equal(c.localSigner.FromAddress()) && !ce.Details.ConfirmingSigner.Equal(c.remoteSigner) {
 		return fmt.Errorf("close agreement confirmer does not match a local or remote signer, got: %s", ce.Details.ConfirmingSigner.Address())
 	}
+	err = validateMemo(ce.Details.MemoType, ce.Details.Memo)
+	if err != nil {
+		return fmt.Errorf("invalid payment memo: %w", err)
+	}
 	if !ce.Details.ProposingSigner.Equal(c.localSigner.FromAddress()) && !ce.Details.ProposingSigner.Equal(c.remoteSigner) {
 		return fmt.Errorf("close agreement proposer does not match a local or remote signer, got: %s", ce.Details.ProposingSigner.Address())
 	}
 
 	// If the close agreement payment amount is incorrect, error.
+	if ce.Details.PaymentAmount <= 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidAmount, ce.Details.PaymentAmount)
+	}
 	pa := ce.Details.PaymentAmount
 	proposerIsResponder := ce.Details.ProposingSigner.Equal(c.responderSigner())
 	if proposerIsResponder {
@@ -344,8 +436,8 @@ func (c *Channel) ConfirmPayment(ce CloseEnvelope) (closeAgreement CloseAgreemen
 			return CloseAgreement{}, fmt.Errorf("close agreement is a payment to the proposer")
 		}
 		// If the payment over extends the proposers ability to pay, error.
-		if c.amountToLocal(ce.Details.Balance) > c.remoteChannelAccount.Balance {
-			return CloseAgreement{}, fmt.Errorf("close agreement over commits: %w", ErrUnderfunded)
+		if err := c.checkFunded(c.amountToLocal(ce.Details.Balance), c.remoteChannelAccount.Balance); err != nil {
+			return CloseAgreement{}, fmt.Errorf("close agreement over commits: %w", err)
 		}
 		ce.ConfirmerSignatures, err = signCloseAgreementTxs(txs, c.localSigner)
 		if err != nil {
@@ -355,10 +447,10 @@ func (c *Channel) ConfirmPayment(ce CloseEnvelope) (closeAgreement CloseAgreemen
 
 	// All signatures are present that would be required to submit all
 	// transactions in the payment.
-	c.latestAuthorizedCloseAgreement = CloseAgreement{
+	c.setLatestAuthorizedCloseAgreement(CloseAgreement{
 		Envelope:     ce,
 		Transactions: txs,
-	}
+	})
 	c.latestUnauthorizedCloseAgreement = CloseAgreement{}
 
 	return c.latestAuthorizedCloseAgreement, nil
@@ -389,8 +481,97 @@ func (c *Channel) FinalizePayment(cs CloseSignatures) (closeAgreement CloseAgree
 	// All signatures are present that would be required to submit all
 	// transactions in the payment.
 	c.latestUnauthorizedCloseAgreement.Envelope.ConfirmerSignatures = cs
-	c.latestAuthorizedCloseAgreement = c.latestUnauthorizedCloseAgreement
+	c.setLatestAuthorizedCloseAgreement(c.latestUnauthorizedCloseAgreement)
 	c.latestUnauthorizedCloseAgreement = CloseAgreement{}
 
 	return c.latestAuthorizedCloseAgreement, nil
 }
+
+// RejectPayment records that the payment with the given iteration number was
+// rejected by the confirmer. Both participants call it, the confirmer when it
+// rejects a payment it was asked to confirm, and the proposer when it learns
+// that its payment was rejected. The iteration number must be that of the
+// next payment, and the proposer's unauthorized payment, if any, is discarded.
+//
+// The iteration number of a rejected payment is never reused, so that the
+// next agreement supersedes the rejected payment. Until the next agreement is
+// authorized the confirmer holds the proposer's signatures for the rejected
+// payment and could still authorize it.
+func (c *Channel) RejectPayment(iterationNumber int64) error {
+	if c.latestAuthorizedCloseAgreement.Envelope.Empty() || !c.openExecutedAndValidated {
+		return fmt.Errorf("cannot reject a payment before channel is opened")
+	}
+	if iterationNumber != c.nextIterationNumber() {
+		return fmt.Errorf("invalid payment iteration number, got: %d want: %d: %w", iterationNumber, c.nextIterationNumber(), ErrIterationGap)
+	}
+	unauthorized := c.latestUnauthorizedCloseAgreement.Envelope.Details
+	if !c.latestUnauthorizedCloseAgreement.Envelope.Empty() &&
+		unauthorized.ObservationPeriodTime == 0 && unauthorized.ObservationPeriodLedgerGap == 0 {
+		return fmt.Errorf("cannot reject a coordinated close as a payment")
+	}
+	c.latestUnauthorizedCloseAgreement = CloseAgreement{}
+	c.rejectedIterationNumber = iterationNumber
+	return nil
+}
+
+// CatchUp authorizes an agreement that the other participant holds and this
+// participant does not, such as when the confirmation of a payment this
+// participant proposed was lost or arrived after the payment timed out, or
+// when this participant's channel was restored from an older snapshot.
+//
+// The agreement must be newer than the latest authorized agreement, must have
+// the same observation period, and must be signed by both participants for
+// the transactions built from its details. A participant can therefore only
+// catch up to an agreement it signed itself, never to one the other
+// participant made up. Any iterations between the latest authorized agreement
+// and the agreement are skipped, because the agreement supersedes them. An
+// unauthorized agreement that the agreement supersedes is discarded.
+func (c *Channel) CatchUp(ce CloseEnvelope) (closeAgreement CloseAgreement, err error) {
+	if c.latestAuthorizedCloseAgreement.Envelope.Empty() || !c.openExecutedAndValidated {
+		return CloseAgreement{}, fmt.Errorf("cannot catch up before channel is opened")
+	}
+	if err := c.checkNoSignerRotationPending(); err != nil {
+		return CloseAgreement{}, fmt.Errorf("cannot catch up: %w", err)
+	}
+	latest := c.latestAuthorizedCloseAgreement.Envelope.Details
+	if ce.Details.IterationNumber <= latest.IterationNumber {
+		return CloseAgreement{}, fmt.Errorf("agreement iteration number %d is not newer than the latest authorized iteration number %d", ce.Details.IterationNumber, latest.IterationNumber)
+	}
+	if ce.Details.ObservationPeriodTime != latest.ObservationPeriodTime ||
+		ce.Details.ObservationPeriodLedgerGap != latest.ObservationPeriodLedgerGap {
+		return CloseAgreement{}, fmt.Errorf("invalid agreement observation period: different than channel state")
+	}
+
+	txs, err := c.closeTxs(c.openAgreement.Envelope.Details, ce.Details)
+	if err != nil {
+		return CloseAgreement{}, err
+	}
+
+	remoteSigs := ce.SignaturesFor(c.remoteSigner)
+	if remoteSigs == nil {
+		return CloseAgreement{}, fmt.Errorf("remote is not a signer")
+	}
+	localSigs := ce.SignaturesFor(c.localSigner.FromAddress())
+	if localSigs == nil {
+		return CloseAgreement{}, fmt.Errorf("local is not a signer")
+	}
+	err = verifySignatures([]signatureVerificationInput{
+		{TransactionHash: txs.DeclarationHash, Signature: remoteSigs.Declaration, Signer: c.remoteSigner},
+		{TransactionHash: txs.CloseHash, Signature: remoteSigs.Close, Signer: c.remoteSigner},
+		{TransactionHash: txs.DeclarationHash, Signature: localSigs.Declaration, Signer: c.localSigner.FromAddress()},
+		{TransactionHash: txs.CloseHash, Signature: localSigs.Close, Signer: c.localSigner.FromAddress()},
+	})
+	if err != nil {
+		return CloseAgreement{}, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	c.setLatestAuthorizedCloseAgreement(CloseAgreement{
+		Envelope:     ce,
+		Transactions: txs,
+	})
+	if c.latestUnauthorizedCloseAgreement.Envelope.Details.IterationNumber <= ce.Details.IterationNumber {
+		c.latestUnauthorizedCloseAgreement = CloseAgreement{}
+	}
+
+	return c.latestAuthorizedCloseAgreement, nil
+}
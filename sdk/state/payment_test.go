@@ -109,6 +109,46 @@ func TestCloseEnvelope_Equal(t *testing.T) {
 	}
 }
 
+func TestCloseAgreement_Equal(t *testing.T) {
+	assert.True(t, CloseAgreement{}.Equal(CloseAgreement{}))
+
+	f := fuzz.New().NilChance(0)
+	ce := CloseEnvelope{}
+	f.Fuzz(&ce)
+	t.Log("ce:", ce)
+	txs := CloseTransactions{}
+	f.Fuzz(&txs.DeclarationHash)
+	f.Fuzz(&txs.CloseHash)
+	a := CloseAgreement{Envelope: ce, Transactions: txs}
+	assert.True(t, a.Equal(a))
+	assert.False(t, a.IsNewerThan(a))
+
+	// Agreements for the same iteration with different signatures are not
+	// equal, and neither is newer than the other.
+	b := a
+	b.Envelope.ConfirmerSignatures = CloseSignatures{}
+	f.Fuzz(&b.Envelope.ConfirmerSignatures)
+	t.Log("b:", b)
+	assert.False(t, a.Equal(b))
+	assert.False(t, b.Equal(a))
+	assert.False(t, a.IsNewerThan(b))
+	assert.False(t, b.IsNewerThan(a))
+
+	// Agreements with different transaction hashes are not equal.
+	c := a
+	c.Transactions.CloseHash = TransactionHash{}
+	assert.False(t, a.Equal(c))
+	assert.False(t, c.Equal(a))
+}
+
+func TestCloseAgreement_IsNewerThan(t *testing.T) {
+	a := CloseAgreement{Envelope: CloseEnvelope{Details: CloseDetails{IterationNumber: 2}}}
+	b := CloseAgreement{Envelope: CloseEnvelope{Details: CloseDetails{IterationNumber: 3}}}
+	assert.True(t, b.IsNewerThan(a))
+	assert.False(t, a.IsNewerThan(b))
+	assert.False(t, a.Equal(b))
+}
+
 func TestChannel_ConfirmPayment_acceptsSameObservationPeriod(t *testing.T) {
 	localSigner := keypair.MustRandom()
 	remoteSigner := keypair.MustRandom()
@@ -173,6 +213,8 @@ func TestChannel_ConfirmPayment_acceptsSameObservationPeriod(t *testing.T) {
 		assert.Equal(t, StateOpen, cs)
 	}
 
+	initiatorChannel.UpdateRemoteChannelAccountBalance(1)
+
 	// A close agreement from the remote participant should be accepted if the
 	// observation period matches the channels observation period.
 	{
@@ -190,6 +232,8 @@ func TestChannel_ConfirmPayment_acceptsSameObservationPeriod(t *testing.T) {
 			IterationNumber:            1,
 			ObservationPeriodTime:      1,
 			ObservationPeriodLedgerGap: 1,
+			Balance:                    -1,
+			PaymentAmount:              1,
 			ProposingSigner:            remoteSigner.FromAddress(),
 			ConfirmingSigner:           localSigner.FromAddress(),
 		})
@@ -205,6 +249,8 @@ func TestChannel_ConfirmPayment_acceptsSameObservationPeriod(t *testing.T) {
 				IterationNumber:            1,
 				ObservationPeriodTime:      1,
 				ObservationPeriodLedgerGap: 1,
+				Balance:                    -1,
+				PaymentAmount:              1,
 				ProposingSigner:            remoteSigner.FromAddress(),
 				ConfirmingSigner:           localSigner.FromAddress(),
 			},
@@ -424,7 +470,7 @@ func TestChannel_ConfirmPayment_localWhoIsInitiatorRejectsPaymentToRemoteWhoIsRe
 			Close:       txClose.Signatures()[0].Signature,
 		},
 	})
-	require.EqualError(t, err, "close agreement is a payment to the proposer")
+	require.ErrorIs(t, err, ErrInvalidAmount)
 }
 
 func TestChannel_ConfirmPayment_localWhoIsResponderRejectsPaymentToRemoteWhoIsInitiator(t *testing.T) {
@@ -529,7 +575,7 @@ func TestChannel_ConfirmPayment_localWhoIsResponderRejectsPaymentToRemoteWhoIsIn
 			Close:       txClose.Signatures()[0].Signature,
 		},
 	})
-	require.EqualError(t, err, "close agreement is a payment to the proposer")
+	require.ErrorIs(t, err, ErrInvalidAmount)
 }
 
 func TestChannel_ConfirmPayment_initiatorRejectsPaymentThatIsUnderfunded(t *testing.T) {
@@ -634,8 +680,12 @@ func TestChannel_ConfirmPayment_initiatorRejectsPaymentThatIsUnderfunded(t *test
 			Close:       txClose.Signatures()[0].Signature,
 		},
 	})
-	assert.EqualError(t, err, "close agreement over commits: account is underfunded to make payment")
+	assert.EqualError(t, err, "close agreement over commits: account is underfunded to make payment: required 110, available 0, shortfall 110 of native")
 	assert.ErrorIs(t, err, ErrUnderfunded)
+	underfundedErr := UnderfundedError{}
+	require.ErrorAs(t, err, &underfundedErr)
+	assert.Equal(t, UnderfundedError{Asset: NativeAsset, Required: 110, Available: 0}, underfundedErr)
+	assert.Equal(t, int64(110), underfundedErr.Shortfall())
 
 	// The same close payment should pass if the balance has been updated.
 	initiatorChannel.UpdateRemoteChannelAccountBalance(200)
@@ -751,8 +801,12 @@ func TestChannel_ConfirmPayment_responderRejectsPaymentThatIsUnderfunded(t *test
 			Close:       txClose.Signatures()[0].Signature,
 		},
 	})
-	assert.EqualError(t, err, "close agreement over commits: account is underfunded to make payment")
+	assert.EqualError(t, err, "close agreement over commits: account is underfunded to make payment: required 110, available 0, shortfall 110 of native")
 	assert.ErrorIs(t, err, ErrUnderfunded)
+	underfundedErr := UnderfundedError{}
+	require.ErrorAs(t, err, &underfundedErr)
+	assert.Equal(t, UnderfundedError{Asset: NativeAsset, Required: 110, Available: 0}, underfundedErr)
+	assert.Equal(t, int64(110), underfundedErr.Shortfall())
 
 	// The same close payment should pass if the balance has been updated.
 	responderChannel.UpdateRemoteChannelAccountBalance(200)
@@ -845,8 +899,12 @@ func TestChannel_ConfirmPayment_initiatorCannotProposePaymentThatIsUnderfunded(t
 	}
 
 	_, err := initiatorChannel.ProposePayment(110)
-	assert.EqualError(t, err, "amount over commits: account is underfunded to make payment")
+	assert.EqualError(t, err, "amount over commits: account is underfunded to make payment: required 170, available 0, shortfall 170 of native")
 	assert.ErrorIs(t, err, ErrUnderfunded)
+	underfundedErr := UnderfundedError{}
+	require.ErrorAs(t, err, &underfundedErr)
+	assert.Equal(t, UnderfundedError{Asset: NativeAsset, Required: 170, Available: 0}, underfundedErr)
+	assert.Equal(t, int64(170), underfundedErr.Shortfall())
 
 	// The same close payment should pass if the balance has been updated.
 	initiatorChannel.UpdateLocalChannelAccountBalance(200)
@@ -933,8 +991,12 @@ func TestChannel_ConfirmPayment_responderCannotProposePaymentThatIsUnderfunded(t
 	}
 
 	_, err := responderChannel.ProposePayment(110)
-	assert.EqualError(t, err, "amount over commits: account is underfunded to make payment")
+	assert.EqualError(t, err, "amount over commits: account is underfunded to make payment: required 170, available 0, shortfall 170 of native")
 	assert.ErrorIs(t, err, ErrUnderfunded)
+	underfundedErr := UnderfundedError{}
+	require.ErrorAs(t, err, &underfundedErr)
+	assert.Equal(t, UnderfundedError{Asset: NativeAsset, Required: 170, Available: 0}, underfundedErr)
+	assert.Equal(t, int64(170), underfundedErr.Shortfall())
 
 	// The same close payment should pass if the balance has been updated.
 	responderChannel.UpdateLocalChannelAccountBalance(200)
@@ -1015,10 +1077,13 @@ func TestChannel_ProposeAndConfirmPayment_rejectNegativeAmountPayment(t *testing
 	}
 
 	_, err := initiatorChannel.ProposePayment(-1)
-	assert.EqualError(t, err, "payment amount must not be less than 0")
+	assert.EqualError(t, err, "payment amount must be greater than 0: -1")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
 
-	// Propose a payment and modify it to be a valid payment with amount zero.
-	ca, err := initiatorChannel.ProposePayment(0)
+	// Propose a payment and modify it to be a signed payment with a negative
+	// amount.
+	initiatorChannel.UpdateLocalChannelAccountBalance(1)
+	ca, err := initiatorChannel.ProposePayment(1)
 	require.NoError(t, err)
 	ca.Envelope.Details.PaymentAmount = -1
 	ca.Envelope.Details.Balance = -1
@@ -1028,10 +1093,11 @@ func TestChannel_ProposeAndConfirmPayment_rejectNegativeAmountPayment(t *testing
 	require.NoError(t, err)
 	ca.Envelope.ProposerSignatures = sigs
 	_, err = responderChannel.ConfirmPayment(ca.Envelope)
-	assert.EqualError(t, err, "close agreement is a payment to the proposer")
+	assert.EqualError(t, err, "validating payment: payment amount must be greater than 0: -1")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
 }
 
-func TestChannel_ProposeAndConfirmPayment_allowZeroAmountPayment(t *testing.T) {
+func TestChannel_ProposeAndConfirmPayment_rejectZeroAmountPayment(t *testing.T) {
 	localSigner := keypair.MustRandom()
 	remoteSigner := keypair.MustRandom()
 	localChannelAccount := keypair.MustRandom().FromAddress()
@@ -1103,11 +1169,25 @@ func TestChannel_ProposeAndConfirmPayment_allowZeroAmountPayment(t *testing.T) {
 		assert.Equal(t, StateOpen, cs)
 	}
 
-	ca, err := initiatorChannel.ProposePayment(0)
-	require.NoError(t, err)
+	_, err := initiatorChannel.ProposePayment(0)
+	assert.EqualError(t, err, "payment amount must be greater than 0: 0")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
 
-	_, err = responderChannel.ConfirmPayment(ca.Envelope)
+	// Propose a payment and modify it to be a signed payment with amount
+	// zero.
+	initiatorChannel.UpdateLocalChannelAccountBalance(1)
+	ca, err := initiatorChannel.ProposePayment(1)
+	require.NoError(t, err)
+	ca.Envelope.Details.PaymentAmount = 0
+	ca.Envelope.Details.Balance = 0
+	txs, err := initiatorChannel.closeTxs(initiatorChannel.openAgreement.Envelope.Details, ca.Envelope.Details)
 	require.NoError(t, err)
+	sigs, err := signCloseAgreementTxs(txs, initiatorChannel.localSigner)
+	require.NoError(t, err)
+	ca.Envelope.ProposerSignatures = sigs
+	_, err = responderChannel.ConfirmPayment(ca.Envelope)
+	assert.EqualError(t, err, "validating payment: payment amount must be greater than 0: 0")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
 }
 
 func TestChannel_ProposeAndConfirmPayment_withMemo(t *testing.T) {
@@ -1193,6 +1273,46 @@ func TestChannel_ProposeAndConfirmPayment_withMemo(t *testing.T) {
 	assert.Equal(t, []byte("id1"), caResponse.Envelope.Details.Memo)
 	_, err = initiatorChannel.ConfirmPayment(caResponse.Envelope)
 	require.NoError(t, err)
+
+	// A memo that is not the size required by its type is rejected.
+	_, err = initiatorChannel.ProposePaymentWithTypedMemo(1, MemoTypeHash, []byte("id2"))
+	require.EqualError(t, err, "invalid memo: memo of type hash must be 32 bytes, got 3 bytes")
+
+	ca, err = initiatorChannel.ProposePaymentWithTypedMemo(1, MemoTypeID, []byte{0, 0, 0, 0, 0, 0, 0, 2})
+	require.NoError(t, err)
+	assert.Equal(t, MemoTypeID, ca.Envelope.Details.MemoType)
+
+	// A received memo that is not the size required by its type is rejected.
+	invalidEnvelope := ca.Envelope
+	invalidEnvelope.Details.MemoType = MemoTypeHash
+	_, err = responderChannel.ConfirmPayment(invalidEnvelope)
+	require.EqualError(t, err, "validating payment: invalid payment memo: memo of type hash must be 32 bytes, got 8 bytes")
+
+	caResponse, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	assert.Equal(t, MemoTypeID, caResponse.Envelope.Details.MemoType)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 2}, caResponse.Envelope.Details.Memo)
+	_, err = initiatorChannel.ConfirmPayment(caResponse.Envelope)
+	require.NoError(t, err)
+
+	// The close transaction of the agreement carries the typed memo, whereas
+	// an opaque memo is not carried by any transaction.
+	_, closeTx, err := initiatorChannel.CloseTxs()
+	require.NoError(t, err)
+	assert.Equal(t, txnbuild.MemoID(2), closeTx.Memo())
+	require.NoError(t, initiatorChannel.ValidateCloseTxs())
+	_, closeTx, err = responderChannel.CloseTxs()
+	require.NoError(t, err)
+	assert.Equal(t, txnbuild.MemoID(2), closeTx.Memo())
+	require.NoError(t, responderChannel.ValidateCloseTxs())
+
+	// A coordinated close must keep the memo of the agreement it closes with.
+	close, err := initiatorChannel.ProposeClose()
+	require.NoError(t, err)
+	otherMemo := close.Envelope
+	otherMemo.Details.Memo = []byte{0, 0, 0, 0, 0, 0, 0, 3}
+	_, err = responderChannel.ConfirmClose(otherMemo)
+	require.EqualError(t, err, "validating close agreement: close agreement memo does not match saved latest authorized close agreement")
 }
 
 func TestChannel_ConfirmPayment_signatureChecks(t *testing.T) {
@@ -2027,3 +2147,434 @@ func TestChannel_ConfirmPayment_validatePaymentAmount(t *testing.T) {
 	ca, err = initiatorChannel.ConfirmPayment(ca.Envelope)
 	require.NoError(t, err)
 }
+
+func TestChannel_ConfirmPayment_rejectsIterationGap(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Put channel into the Open state.
+	{
+		m, err := initiatorChannel.ProposeOpen(OpenParams{
+			Asset:                      NativeAsset,
+			ExpiresAt:                  time.Now().Add(5 * time.Minute),
+			StartingSequence:           101,
+			ObservationPeriodTime:      10,
+			ObservationPeriodLedgerGap: 10,
+		})
+		require.NoError(t, err)
+		m, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		_, err = initiatorChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+
+		ftx, err := initiatorChannel.OpenTx()
+		require.NoError(t, err)
+		ftxXDR, err := ftx.Base64()
+		require.NoError(t, err)
+
+		successResultXDR, err := txbuildtest.BuildResultXDR(true)
+		require.NoError(t, err)
+		resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+			InitiatorSigner:         localSigner.Address(),
+			ResponderSigner:         remoteSigner.Address(),
+			InitiatorChannelAccount: localChannelAccount.Address(),
+			ResponderChannelAccount: remoteChannelAccount.Address(),
+			StartSequence:           101,
+			Asset:                   txnbuild.NativeAsset{},
+		})
+		require.NoError(t, err)
+
+		err = initiatorChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+		err = responderChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+	}
+	initiatorChannel.UpdateLocalChannelAccountBalance(200)
+	initiatorChannel.UpdateRemoteChannelAccountBalance(200)
+	responderChannel.UpdateLocalChannelAccountBalance(200)
+	responderChannel.UpdateRemoteChannelAccountBalance(200)
+
+	// Make a first payment so that there is a previous iteration to repeat.
+	ca, err := initiatorChannel.ProposePayment(10)
+	require.NoError(t, err)
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	previous := ca
+	require.Equal(t, int64(2), previous.Envelope.Details.IterationNumber)
+
+	// A payment that skips an iteration is rejected.
+	{
+		d := CloseDetails{
+			ObservationPeriodTime:      10,
+			ObservationPeriodLedgerGap: 10,
+			IterationNumber:            4,
+			Balance:                    20,
+			PaymentAmount:              10,
+			ProposingSigner:            localSigner.FromAddress(),
+			ConfirmingSigner:           remoteSigner.FromAddress(),
+		}
+		txs, err := initiatorChannel.closeTxs(initiatorChannel.openAgreement.Envelope.Details, d)
+		require.NoError(t, err)
+		sigs, err := signCloseAgreementTxs(txs, localSigner)
+		require.NoError(t, err)
+		_, err = responderChannel.ConfirmPayment(CloseEnvelope{Details: d, ProposerSignatures: sigs})
+		assert.EqualError(t, err, "validating payment: invalid payment iteration number, got: 4 want: 3: "+
+			"payment iteration number does not follow the latest authorized iteration number")
+		assert.ErrorIs(t, err, ErrIterationGap)
+	}
+
+	// A payment that repeats the previous iteration is rejected.
+	{
+		_, err = responderChannel.ConfirmPayment(previous.Envelope)
+		assert.EqualError(t, err, "validating payment: invalid payment iteration number, got: 2 want: 3: "+
+			"payment iteration number does not follow the latest authorized iteration number")
+		assert.ErrorIs(t, err, ErrIterationGap)
+	}
+
+	// A payment that advances the iteration by one is accepted.
+	ca, err = initiatorChannel.ProposePayment(10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), ca.Envelope.Details.IterationNumber)
+	_, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+}
+
+func TestChannel_RejectPayment(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Put channel into the Open state.
+	{
+		m, err := initiatorChannel.ProposeOpen(OpenParams{
+			Asset:                      NativeAsset,
+			ExpiresAt:                  time.Now().Add(5 * time.Minute),
+			StartingSequence:           101,
+			ObservationPeriodTime:      10,
+			ObservationPeriodLedgerGap: 10,
+		})
+		require.NoError(t, err)
+		m, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		_, err = initiatorChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+
+		ftx, err := initiatorChannel.OpenTx()
+		require.NoError(t, err)
+		ftxXDR, err := ftx.Base64()
+		require.NoError(t, err)
+
+		successResultXDR, err := txbuildtest.BuildResultXDR(true)
+		require.NoError(t, err)
+		resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+			InitiatorSigner:         localSigner.Address(),
+			ResponderSigner:         remoteSigner.Address(),
+			InitiatorChannelAccount: localChannelAccount.Address(),
+			ResponderChannelAccount: remoteChannelAccount.Address(),
+			StartSequence:           101,
+			Asset:                   txnbuild.NativeAsset{},
+		})
+		require.NoError(t, err)
+
+		err = initiatorChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+		err = responderChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+	}
+	initiatorChannel.UpdateLocalChannelAccountBalance(200)
+	initiatorChannel.UpdateRemoteChannelAccountBalance(200)
+	responderChannel.UpdateLocalChannelAccountBalance(200)
+	responderChannel.UpdateRemoteChannelAccountBalance(200)
+
+	// Reject a payment before it is confirmed.
+	ca, err := initiatorChannel.ProposePayment(10)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), ca.Envelope.Details.IterationNumber)
+	err = responderChannel.RejectPayment(3)
+	assert.ErrorIs(t, err, ErrIterationGap)
+	require.NoError(t, responderChannel.RejectPayment(2))
+	require.NoError(t, initiatorChannel.RejectPayment(2))
+	_, unauthorized := initiatorChannel.LatestUnauthorizedCloseAgreement()
+	assert.False(t, unauthorized)
+
+	// The rejected payment can no longer be confirmed.
+	_, err = responderChannel.ConfirmPayment(ca.Envelope)
+	assert.ErrorIs(t, err, ErrIterationGap)
+
+	// The rejected iteration is skipped and survives a snapshot.
+	initiatorChannel = NewChannelFromSnapshot(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	}, initiatorChannel.Snapshot())
+	ca, err = initiatorChannel.ProposePayment(20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), ca.Envelope.Details.IterationNumber)
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), initiatorChannel.Balance())
+	assert.Equal(t, int64(20), responderChannel.Balance())
+}
+
+func TestChannel_CatchUp(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Put channel into the Open state.
+	{
+		m, err := initiatorChannel.ProposeOpen(OpenParams{
+			Asset:                      NativeAsset,
+			ExpiresAt:                  time.Now().Add(5 * time.Minute),
+			StartingSequence:           101,
+			ObservationPeriodTime:      10,
+			ObservationPeriodLedgerGap: 10,
+		})
+		require.NoError(t, err)
+		m, err = responderChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+		_, err = initiatorChannel.ConfirmOpen(m.Envelope)
+		require.NoError(t, err)
+
+		ftx, err := initiatorChannel.OpenTx()
+		require.NoError(t, err)
+		ftxXDR, err := ftx.Base64()
+		require.NoError(t, err)
+
+		successResultXDR, err := txbuildtest.BuildResultXDR(true)
+		require.NoError(t, err)
+		resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+			InitiatorSigner:         localSigner.Address(),
+			ResponderSigner:         remoteSigner.Address(),
+			InitiatorChannelAccount: localChannelAccount.Address(),
+			ResponderChannelAccount: remoteChannelAccount.Address(),
+			StartSequence:           101,
+			Asset:                   txnbuild.NativeAsset{},
+		})
+		require.NoError(t, err)
+
+		err = initiatorChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+		err = responderChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+	}
+	initiatorChannel.UpdateLocalChannelAccountBalance(200)
+	initiatorChannel.UpdateRemoteChannelAccountBalance(200)
+	responderChannel.UpdateLocalChannelAccountBalance(200)
+	responderChannel.UpdateRemoteChannelAccountBalance(200)
+
+	// The responder confirms a payment, but the initiator never receives the
+	// confirmation and abandons the payment.
+	ca, err := initiatorChannel.ProposePayment(10)
+	require.NoError(t, err)
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	require.NoError(t, initiatorChannel.RejectPayment(2))
+	assert.Equal(t, int64(0), initiatorChannel.Balance())
+	assert.Equal(t, int64(10), responderChannel.Balance())
+
+	// An agreement not signed by the initiator cannot be caught up to.
+	unsigned := ca.Envelope
+	unsigned.ProposerSignatures = CloseSignatures{}
+	_, err = initiatorChannel.CatchUp(unsigned)
+	assert.EqualError(t, err, "invalid signature: signature verification failed")
+
+	// An agreement with details that differ from those signed cannot be
+	// caught up to.
+	altered := ca.Envelope
+	altered.Details.Balance = 100
+	_, err = initiatorChannel.CatchUp(altered)
+	assert.EqualError(t, err, "invalid signature: signature verification failed")
+
+	// The agreement signed by both participants can be caught up to.
+	caughtUp, err := initiatorChannel.CatchUp(ca.Envelope)
+	require.NoError(t, err)
+	assert.Equal(t, ca.Envelope, caughtUp.Envelope)
+	assert.Equal(t, int64(10), initiatorChannel.Balance())
+
+	// The agreement is no longer newer.
+	_, err = initiatorChannel.CatchUp(ca.Envelope)
+	assert.EqualError(t, err, "agreement iteration number 2 is not newer than the latest authorized iteration number 2")
+
+	// Payments continue from the agreement.
+	ca, err = initiatorChannel.ProposePayment(20)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), ca.Envelope.Details.IterationNumber)
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	assert.Equal(t, int64(30), initiatorChannel.Balance())
+	assert.Equal(t, int64(30), responderChannel.Balance())
+}
+
+func TestChannel_ReserveAmount(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	localChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+		ReserveAmount:        10,
+	})
+	remoteChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+		ReserveAmount:        10,
+	})
+
+	// Put channel into the Open state.
+	{
+		open1, err := localChannel.ProposeOpen(OpenParams{
+			ObservationPeriodTime:      1,
+			ObservationPeriodLedgerGap: 1,
+			Asset:                      NativeAsset,
+			ExpiresAt:                  time.Now().Add(time.Hour),
+			StartingSequence:           101,
+		})
+		require.NoError(t, err)
+		open2, err := remoteChannel.ConfirmOpen(open1.Envelope)
+		require.NoError(t, err)
+		_, err = localChannel.ConfirmOpen(open2.Envelope)
+		require.NoError(t, err)
+
+		ftx, err := localChannel.OpenTx()
+		require.NoError(t, err)
+		ftxXDR, err := ftx.Base64()
+		require.NoError(t, err)
+
+		successResultXDR, err := txbuildtest.BuildResultXDR(true)
+		require.NoError(t, err)
+		resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+			InitiatorSigner:         localSigner.Address(),
+			ResponderSigner:         remoteSigner.Address(),
+			InitiatorChannelAccount: localChannelAccount.Address(),
+			ResponderChannelAccount: remoteChannelAccount.Address(),
+			StartSequence:           101,
+			Asset:                   txnbuild.NativeAsset{},
+		})
+		require.NoError(t, err)
+
+		err = localChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+		err = remoteChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR)
+		require.NoError(t, err)
+
+		cs, err := localChannel.State()
+		require.NoError(t, err)
+		assert.Equal(t, StateOpen, cs)
+
+		cs, err = remoteChannel.State()
+		require.NoError(t, err)
+		assert.Equal(t, StateOpen, cs)
+	}
+
+	localChannel.UpdateLocalChannelAccountBalance(100)
+	remoteChannel.UpdateRemoteChannelAccountBalance(100)
+
+	// A payment that would leave less than the reserve is rejected.
+	_, err := localChannel.ProposePayment(91)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrUnderfunded)
+	underfundedErr := UnderfundedError{}
+	require.ErrorAs(t, err, &underfundedErr)
+	assert.Equal(t, UnderfundedError{Asset: NativeAsset, Required: 91, Reserve: 10, Available: 100}, underfundedErr)
+	assert.Equal(t, int64(1), underfundedErr.Shortfall())
+	assert.EqualError(t, err, "amount over commits: account is underfunded to make payment: required 91, reserve 10, available 100, shortfall 1 of native")
+
+	// A payment that leaves exactly the reserve is accepted.
+	ca, err := localChannel.ProposePayment(90)
+	require.NoError(t, err)
+	ca, err = remoteChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = localChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+
+	// The confirmer applies the reserve to the proposer's channel account.
+	localChannel.UpdateLocalChannelAccountBalance(200)
+	ca, err = localChannel.ProposePayment(1)
+	require.NoError(t, err)
+	_, err = remoteChannel.ConfirmPayment(ca.Envelope)
+	assert.EqualError(t, err, "close agreement over commits: account is underfunded to make payment: required 91, reserve 10, available 100, shortfall 1 of native")
+}
@@ -0,0 +1,520 @@
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/go/xdr"
+	"github.com/stellar/starlight/sdk/txbuild"
+)
+
+// ErrSignerRotationPending indicates that an agreement cannot be proposed or
+// confirmed because a signer rotation is pending. Until the rotation
+// transaction executes or expires it is unknown which of the latest
+// authorized agreement and the agreement of the rotation will be valid on the
+// network, and so no other agreement is made.
+var ErrSignerRotationPending = fmt.Errorf("signer rotation pending")
+
+// ErrSignerUnavailable indicates that the local signer of the channel was
+// rotated to a signer the channel does not hold, such as when the channel was
+// restored with the old signer while the rotation was pending. The channel
+// must be restored with the new signer to make further agreements.
+var ErrSignerUnavailable = fmt.Errorf("signer unavailable")
+
+// SignerRotationDetails contains the details of a signer rotation that the
+// participants agree on. The rotation replaces the OldSigner of a participant
+// with the NewSigner on both channel accounts with the transaction built by
+// txbuild.RotateSigner, with the OldSigner's account as its source at the
+// SequenceNumber and a max time of ExpiresAt.
+//
+// Close is the agreement that supersedes the latest authorized agreement
+// when the rotation executes. It has the same balance and observation period
+// as the latest authorized agreement, the next iteration number, and its
+// transactions are built for the NewSigner. It is proposed by the NewSigner.
+type SignerRotationDetails struct {
+	OldSigner      *keypair.FromAddress
+	NewSigner      *keypair.FromAddress
+	SequenceNumber int64
+	ExpiresAt      time.Time
+	Close          CloseDetails
+}
+
+// Equal returns true if two SignerRotationDetails are equal, else false.
+func (d SignerRotationDetails) Equal(d2 SignerRotationDetails) bool {
+	return d.OldSigner.Equal(d2.OldSigner) &&
+		d.NewSigner.Equal(d2.NewSigner) &&
+		d.SequenceNumber == d2.SequenceNumber &&
+		d.ExpiresAt.Equal(d2.ExpiresAt) &&
+		d.Close.Equal(d2.Close)
+}
+
+// SignerRotationSignatures holds the signatures of a participant for a signer
+// rotation, for the transactions of the rotation's close agreement and for the
+// rotation transaction.
+type SignerRotationSignatures struct {
+	Close    CloseSignatures
+	Rotation xdr.Signature
+}
+
+// Empty returns true if there are not any signatures present, else false.
+func (s SignerRotationSignatures) Empty() bool {
+	return s.Close.Empty() && len(s.Rotation) == 0
+}
+
+// Equal returns true if two SignerRotationSignatures are equal, else false.
+func (s SignerRotationSignatures) Equal(s2 SignerRotationSignatures) bool {
+	return s.Close.Equal(s2.Close) && bytes.Equal(s.Rotation, s2.Rotation)
+}
+
+// SignerRotationEnvelope contains the details of a signer rotation and the
+// signatures of the participants.
+//
+// The proposer's close signatures are by the NewSigner, and its rotation
+// signature is by the OldSigner. The proposer signs the rotation transaction
+// only once it holds the confirmer's signatures of the close agreement, and
+// so the rotation cannot execute before both participants hold the agreement
+// that supersedes the agreements signed by the OldSigner.
+type SignerRotationEnvelope struct {
+	Details             SignerRotationDetails
+	ProposerSignatures  SignerRotationSignatures
+	ConfirmerSignatures SignerRotationSignatures
+}
+
+// Empty returns true if the SignerRotationEnvelope has no data, else false.
+func (e SignerRotationEnvelope) Empty() bool {
+	return e.Equal(SignerRotationEnvelope{})
+}
+
+// Equal returns true if two SignerRotationEnvelope are equal, else false.
+func (e SignerRotationEnvelope) Equal(e2 SignerRotationEnvelope) bool {
+	return e.Details.Equal(e2.Details) &&
+		e.ProposerSignatures.Equal(e2.ProposerSignatures) &&
+		e.ConfirmerSignatures.Equal(e2.ConfirmerSignatures)
+}
+
+// CloseEnvelope returns the envelope of the rotation's close agreement.
+func (e SignerRotationEnvelope) CloseEnvelope() CloseEnvelope {
+	return CloseEnvelope{
+		Details:             e.Details.Close,
+		ProposerSignatures:  e.ProposerSignatures.Close,
+		ConfirmerSignatures: e.ConfirmerSignatures.Close,
+	}
+}
+
+// SignerRotation contains all the information known for a signer rotation
+// proposed or confirmed by the channel.
+type SignerRotation struct {
+	Envelope          SignerRotationEnvelope
+	TransactionHash   TransactionHash
+	Transaction       *txnbuild.Transaction
+	CloseTransactions CloseTransactions
+}
+
+// CloseAgreement returns the close agreement that supersedes the latest
+// authorized agreement when the rotation executes.
+func (r SignerRotation) CloseAgreement() CloseAgreement {
+	return CloseAgreement{
+		Envelope:     r.Envelope.CloseEnvelope(),
+		Transactions: r.CloseTransactions,
+	}
+}
+
+// SignedTransaction returns the rotation transaction with the signatures of
+// the old signer and of the other participant's signer attached, ready to
+// submit. An error is returned if either signature is missing.
+func (r SignerRotation) SignedTransaction() (*txnbuild.Transaction, error) {
+	e := r.Envelope
+	if len(e.ProposerSignatures.Rotation) == 0 || len(e.ConfirmerSignatures.Rotation) == 0 {
+		return nil, fmt.Errorf("rotation transaction not signed by both participants")
+	}
+	tx, err := r.Transaction.AddSignatureDecorated(
+		xdr.NewDecoratedSignature(e.ProposerSignatures.Rotation, e.Details.OldSigner.Hint()),
+		xdr.NewDecoratedSignature(e.ConfirmerSignatures.Rotation, e.Details.Close.ConfirmingSigner.Hint()),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("adding signatures: %w", err)
+	}
+	return tx, nil
+}
+
+// buildSignerRotation builds the rotation transaction and the transactions of
+// the rotation's close agreement for the envelope.
+func (c *Channel) buildSignerRotation(e SignerRotationEnvelope) (SignerRotation, error) {
+	d := e.Details
+	p := c.txParticipants()
+	switch {
+	case d.OldSigner.Equal(p.InitiatorSigner):
+		p.InitiatorSigner = d.NewSigner
+	case d.OldSigner.Equal(p.ResponderSigner):
+		p.ResponderSigner = d.NewSigner
+	default:
+		return SignerRotation{}, fmt.Errorf("old signer %s is not a signer of the channel", d.OldSigner.Address())
+	}
+
+	tx, err := txbuild.RotateSigner(txbuild.RotateSignerParams{
+		InitiatorChannelAccount: p.InitiatorChannelAccount,
+		ResponderChannelAccount: p.ResponderChannelAccount,
+		OldSigner:               d.OldSigner,
+		NewSigner:               d.NewSigner,
+		SequenceNumber:          d.SequenceNumber,
+		ExpiresAt:               d.ExpiresAt,
+		SignerScheme:            c.openAgreement.Envelope.Details.SignerScheme,
+	})
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("building rotation tx: %w", err)
+	}
+	txHash, err := tx.Hash(c.networkPassphrase)
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("hashing rotation tx: %w", err)
+	}
+	closeTxs, err := buildCloseTxs(c.networkPassphrase, p, c.openAgreement.Envelope.Details, d.Close)
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("building close txs: %w", err)
+	}
+	return SignerRotation{
+		Envelope:          e,
+		TransactionHash:   txHash,
+		Transaction:       tx,
+		CloseTransactions: closeTxs,
+	}, nil
+}
+
+// checkSignerRotatable returns an error if the channel is not in a state
+// where a signer rotation can be proposed or confirmed.
+func (c *Channel) checkSignerRotatable() error {
+	if c.latestAuthorizedCloseAgreement.Envelope.Empty() || !c.openExecutedAndValidated {
+		return fmt.Errorf("cannot rotate signer before channel is opened")
+	}
+	cs, err := c.State()
+	if err != nil {
+		return fmt.Errorf("getting channel state: %w", err)
+	}
+	if cs != StateOpen {
+		return fmt.Errorf("cannot rotate signer of a channel that is closing or closed")
+	}
+	latest := c.latestAuthorizedCloseAgreement.Envelope.Details
+	if latest.ObservationPeriodTime == 0 && latest.ObservationPeriodLedgerGap == 0 {
+		return fmt.Errorf("cannot rotate signer after an accepted coordinated close")
+	}
+	if !c.latestUnauthorizedCloseAgreement.Envelope.Empty() {
+		return fmt.Errorf("cannot rotate signer while an unfinished agreement exists")
+	}
+	if !c.pendingSignerRotation.Envelope.Empty() {
+		return ErrSignerRotationPending
+	}
+	return nil
+}
+
+// checkNoSignerRotationPending returns ErrSignerRotationPending if a signer
+// rotation is pending.
+func (c *Channel) checkNoSignerRotationPending() error {
+	if !c.pendingSignerRotation.Envelope.Empty() {
+		return ErrSignerRotationPending
+	}
+	return nil
+}
+
+// ProposeSignerRotation proposes that the local signer be replaced with the
+// new signer, by a rotation transaction with the local signer's account as its
+// source at the sequence number and expiring at expiresAt, and by a close
+// agreement at the next iteration number that keeps the balance of the latest
+// authorized agreement and is signed by the new signer.
+//
+// Agreements signed by the old signer remain valid until the rotation
+// transaction executes, at which point the latest authorized agreement can no
+// longer be executed, because its transactions require the old signer's
+// signature, and the rotation's close agreement becomes the latest
+// authorized agreement. Which of the two is valid on the network is unknown
+// until the rotation executes or expires, and so no other agreement can be
+// proposed or confirmed while the rotation is pending, and any that is
+// returns an error wrapping ErrSignerRotationPending.
+//
+// The rotation executes once the other participant confirms it with
+// ConfirmSignerRotation, its signatures are given to FinalizeSignerRotation,
+// and the transaction returned by SignedTransaction is submitted. The
+// rotation is completed when the channel ingests the rotation transaction,
+// and is discarded if the transaction fails or expires, see
+// ExpireSignerRotation.
+func (c *Channel) ProposeSignerRotation(newSigner Signer, sequenceNumber int64, expiresAt time.Time) (SignerRotation, error) {
+	if newSigner == nil {
+		return SignerRotation{}, fmt.Errorf("no new signer")
+	}
+	if newSigner.FromAddress().Equal(c.localSigner.FromAddress()) || newSigner.FromAddress().Equal(c.remoteSigner) {
+		return SignerRotation{}, fmt.Errorf("new signer is already a signer of the channel")
+	}
+	err := c.checkSignerRotatable()
+	if err != nil {
+		return SignerRotation{}, err
+	}
+	if maxExpiresAt := time.Now().Add(c.maxOpenExpiry); expiresAt.After(maxExpiresAt) {
+		return SignerRotation{}, fmt.Errorf("rotation expires at %v, after the max expiry %v", expiresAt, maxExpiresAt.Round(time.Second))
+	}
+
+	latest := c.latestAuthorizedCloseAgreement.Envelope.Details
+	r, err := c.buildSignerRotation(SignerRotationEnvelope{
+		Details: SignerRotationDetails{
+			OldSigner:      c.localSigner.FromAddress(),
+			NewSigner:      newSigner.FromAddress(),
+			SequenceNumber: sequenceNumber,
+			ExpiresAt:      expiresAt,
+			Close: CloseDetails{
+				ObservationPeriodTime:      latest.ObservationPeriodTime,
+				ObservationPeriodLedgerGap: latest.ObservationPeriodLedgerGap,
+				IterationNumber:            c.nextIterationNumber(),
+				Balance:                    latest.Balance,
+				ProposingSigner:            newSigner.FromAddress(),
+				ConfirmingSigner:           c.remoteSigner,
+			},
+		},
+	})
+	if err != nil {
+		return SignerRotation{}, err
+	}
+	r.Envelope.ProposerSignatures.Close, err = signCloseAgreementTxs(r.CloseTransactions, newSigner)
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("signing close agreement with new signer: %w", err)
+	}
+
+	c.pendingSignerRotation = r
+	c.pendingSigner = newSigner
+	return r, nil
+}
+
+// ResumeSignerRotation gives the channel the new signer of the pending signer
+// rotation that the local participant proposed, when the channel was restored
+// with the old signer while the rotation was pending, so that the channel
+// signs with the new signer once the rotation executes. It returns the
+// pending rotation.
+func (c *Channel) ResumeSignerRotation(newSigner Signer) (SignerRotation, error) {
+	r := c.pendingSignerRotation
+	if r.Envelope.Empty() {
+		return SignerRotation{}, fmt.Errorf("no signer rotation is pending")
+	}
+	if !r.Envelope.Details.OldSigner.Equal(c.localSigner.FromAddress()) {
+		return SignerRotation{}, fmt.Errorf("pending signer rotation was not proposed by local")
+	}
+	if newSigner == nil || !newSigner.FromAddress().Equal(r.Envelope.Details.NewSigner) {
+		return SignerRotation{}, fmt.Errorf("signer is not the new signer of the pending signer rotation")
+	}
+	c.pendingSigner = newSigner
+	return r, nil
+}
+
+// validateSignerRotation validates the signer rotation given to the
+// ConfirmSignerRotation method.
+func (c *Channel) validateSignerRotation(e SignerRotationEnvelope) error {
+	d := e.Details
+	if !d.OldSigner.Equal(c.remoteSigner) {
+		return fmt.Errorf("signer rotation old signer is not the remote signer, got: %s", d.OldSigner.Address())
+	}
+	if d.NewSigner == nil || d.NewSigner.Equal(c.localSigner.FromAddress()) || d.NewSigner.Equal(c.remoteSigner) {
+		return fmt.Errorf("signer rotation new signer is missing or already a signer of the channel")
+	}
+	if maxExpiresAt := time.Now().Add(c.maxOpenExpiry); d.ExpiresAt.After(maxExpiresAt) {
+		return fmt.Errorf("signer rotation expires at %v, after the max expiry %v", d.ExpiresAt, maxExpiresAt.Round(time.Second))
+	}
+
+	latest := c.latestAuthorizedCloseAgreement.Envelope.Details
+	if d.Close.IterationNumber != c.nextIterationNumber() {
+		return fmt.Errorf("invalid signer rotation iteration number, got: %d want: %d: %w", d.Close.IterationNumber, c.nextIterationNumber(), ErrIterationGap)
+	}
+	if d.Close.Balance != latest.Balance {
+		return fmt.Errorf("signer rotation balance does not match the latest authorized close agreement")
+	}
+	if d.Close.ObservationPeriodTime != latest.ObservationPeriodTime ||
+		d.Close.ObservationPeriodLedgerGap != latest.ObservationPeriodLedgerGap {
+		return fmt.Errorf("invalid signer rotation observation period: different than channel state")
+	}
+	if !d.Close.ProposingSigner.Equal(d.NewSigner) {
+		return fmt.Errorf("signer rotation close agreement proposer is not the new signer")
+	}
+	if !d.Close.ConfirmingSigner.Equal(c.localSigner.FromAddress()) {
+		return fmt.Errorf("signer rotation close agreement confirmer is not the local signer")
+	}
+	if d.Close.PaymentAmount != 0 || d.Close.MemoType != MemoTypeNone || len(d.Close.Memo) != 0 {
+		return fmt.Errorf("signer rotation close agreement has a payment")
+	}
+	return nil
+}
+
+// ConfirmSignerRotation confirms a signer rotation proposed by the other
+// participant with ProposeSignerRotation. It checks that the rotation's close
+// agreement keeps the balance of the latest authorized agreement and is
+// signed by the new signer, then signs the close agreement and the rotation
+// transaction. The returned rotation's confirmer signatures are to be given
+// to the other participant's FinalizeSignerRotation.
+//
+// The local participant holds the rotation's close agreement signed by both
+// participants before the rotation transaction can execute, and so whether
+// the rotation executes or not it can close the channel with an agreement
+// that is valid on the network. See ProposeSignerRotation for the agreements
+// that can be made while the rotation is pending.
+func (c *Channel) ConfirmSignerRotation(e SignerRotationEnvelope) (SignerRotation, error) {
+	err := c.checkSignerRotatable()
+	if err != nil {
+		return SignerRotation{}, err
+	}
+	err = c.validateSignerRotation(e)
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("validating signer rotation: %w", err)
+	}
+
+	e.ConfirmerSignatures = SignerRotationSignatures{}
+	e.ProposerSignatures.Rotation = nil
+	r, err := c.buildSignerRotation(e)
+	if err != nil {
+		return SignerRotation{}, err
+	}
+	err = verifySignatures([]signatureVerificationInput{
+		{TransactionHash: r.CloseTransactions.DeclarationHash, Signature: e.ProposerSignatures.Close.Declaration, Signer: e.Details.NewSigner},
+		{TransactionHash: r.CloseTransactions.CloseHash, Signature: e.ProposerSignatures.Close.Close, Signer: e.Details.NewSigner},
+	})
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	r.Envelope.ConfirmerSignatures.Close, err = signCloseAgreementTxs(r.CloseTransactions, c.localSigner)
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("local signing close agreement: %w", err)
+	}
+	r.Envelope.ConfirmerSignatures.Rotation, err = c.localSigner.Sign(r.TransactionHash[:])
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("local signing rotation tx: %w", err)
+	}
+
+	c.pendingSignerRotation = r
+	return r, nil
+}
+
+// FinalizeSignerRotation finalizes a signer rotation proposed by the local
+// participant by attaching the other participant's signatures, then signs the
+// rotation transaction with the old signer. The rotation transaction returned
+// by SignedTransaction of the returned rotation is ready to submit.
+func (c *Channel) FinalizeSignerRotation(s SignerRotationSignatures) (SignerRotation, error) {
+	r := c.pendingSignerRotation
+	if r.Envelope.Empty() || !r.Envelope.Details.OldSigner.Equal(c.localSigner.FromAddress()) {
+		return SignerRotation{}, fmt.Errorf("no signer rotation proposed by local to finalize")
+	}
+	err := verifySignatures([]signatureVerificationInput{
+		{TransactionHash: r.CloseTransactions.DeclarationHash, Signature: s.Close.Declaration, Signer: c.remoteSigner},
+		{TransactionHash: r.CloseTransactions.CloseHash, Signature: s.Close.Close, Signer: c.remoteSigner},
+		{TransactionHash: r.TransactionHash, Signature: s.Rotation, Signer: c.remoteSigner},
+	})
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	// The rotation transaction is signed only now that the local participant
+	// holds the confirmer's signatures of the rotation's close agreement.
+	rotationSig, err := c.localSigner.Sign(r.TransactionHash[:])
+	if err != nil {
+		return SignerRotation{}, fmt.Errorf("local signing rotation tx: %w", err)
+	}
+	r.Envelope.ConfirmerSignatures = s
+	r.Envelope.ProposerSignatures.Rotation = rotationSig
+	c.pendingSignerRotation = r
+	return r, nil
+}
+
+// RejectSignerRotation discards the pending signer rotation that the local
+// participant proposed after the other participant rejects it, before it is
+// finalized. A rotation that has been finalized cannot be discarded, because
+// the rotation transaction has been signed and may execute, and it remains
+// pending until it executes or expires.
+func (c *Channel) RejectSignerRotation() error {
+	r := c.pendingSignerRotation
+	if r.Envelope.Empty() || !r.Envelope.Details.OldSigner.Equal(c.localSigner.FromAddress()) {
+		return fmt.Errorf("no signer rotation proposed by local is pending")
+	}
+	if len(r.Envelope.ProposerSignatures.Rotation) != 0 {
+		return fmt.Errorf("cannot reject a signer rotation that has been finalized")
+	}
+	c.pendingSignerRotation = SignerRotation{}
+	c.pendingSigner = nil
+	return nil
+}
+
+// PendingSignerRotation returns the signer rotation that has been proposed or
+// confirmed and that is yet to execute or expire.
+func (c *Channel) PendingSignerRotation() (SignerRotation, bool) {
+	r := c.pendingSignerRotation
+	return r, !r.Envelope.Empty()
+}
+
+// ExpireSignerRotation discards the pending signer rotation if the ledger
+// close time is after the rotation transaction's max time, because the
+// transaction can no longer execute, and returns true if it was discarded.
+// The close time should be that of a ledger that the channel has ingested
+// every transaction up to, so that a rotation that executed is never
+// discarded.
+func (c *Channel) ExpireSignerRotation(ledgerCloseTime time.Time) bool {
+	r := c.pendingSignerRotation
+	if r.Envelope.Empty() || !ledgerCloseTime.After(r.Envelope.Details.ExpiresAt) {
+		return false
+	}
+	c.pendingSignerRotation = SignerRotation{}
+	c.pendingSigner = nil
+	return true
+}
+
+// ingestSignerRotationTx completes the pending signer rotation if the
+// transaction is its rotation transaction and was successful, making the
+// rotation's close agreement the latest authorized agreement and replacing
+// the rotated signer, and discards it if the transaction failed.
+func (c *Channel) ingestSignerRotationTx(tx *txnbuild.Transaction, txResult xdr.TransactionResult) error {
+	r := c.pendingSignerRotation
+	if r.Envelope.Empty() {
+		return nil
+	}
+	txHash, err := tx.Hash(c.networkPassphrase)
+	if err != nil {
+		return fmt.Errorf("hashing tx: %w", err)
+	}
+	if txHash != r.TransactionHash {
+		return nil
+	}
+
+	c.pendingSignerRotation = SignerRotation{}
+	pendingSigner := c.pendingSigner
+	c.pendingSigner = nil
+	if !txResult.Successful() {
+		return nil
+	}
+
+	ca := r.CloseAgreement()
+	if !ca.Envelope.ConfirmerSignatures.HasAllSignatures() {
+		return fmt.Errorf("signer rotation executed without the confirmer's signatures of its close agreement")
+	}
+	c.setLatestAuthorizedCloseAgreement(ca)
+	d := r.Envelope.Details
+	if d.OldSigner.Equal(c.localSigner.FromAddress()) {
+		if pendingSigner == nil {
+			pendingSigner = unavailableSigner{address: d.NewSigner}
+		}
+		c.localSigner = pendingSigner
+	} else {
+		c.remoteSigner = d.NewSigner
+	}
+	return nil
+}
+
+// unavailableSigner is the local signer of a channel whose signer was rotated
+// to a signer the channel does not hold. It cannot sign.
+type unavailableSigner struct {
+	address *keypair.FromAddress
+}
+
+func (s unavailableSigner) Address() string {
+	return s.address.Address()
+}
+
+func (s unavailableSigner) FromAddress() *keypair.FromAddress {
+	return s.address
+}
+
+func (s unavailableSigner) Sign(input []byte) ([]byte, error) {
+	return nil, fmt.Errorf("%w: %s", ErrSignerUnavailable, s.address.Address())
+}
@@ -0,0 +1,304 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rotationTestChannels returns the configs and channels of an open channel in
+// which the initiator has paid the responder 100.
+func rotationTestChannels(t *testing.T) (initiatorConfig, responderConfig Config, initiatorChannel, responderChannel *Channel) {
+	initiatorSigner := keypair.MustRandom()
+	responderSigner := keypair.MustRandom()
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	responderChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorConfig = Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          initiatorSigner,
+		RemoteSigner:         responderSigner.FromAddress(),
+		LocalChannelAccount:  initiatorChannelAccount,
+		RemoteChannelAccount: responderChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	}
+	responderConfig = Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          responderSigner,
+		RemoteSigner:         initiatorSigner.FromAddress(),
+		LocalChannelAccount:  responderChannelAccount,
+		RemoteChannelAccount: initiatorChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	}
+	initiatorChannel = NewChannel(initiatorConfig)
+	responderChannel = NewChannel(responderConfig)
+
+	open, err := initiatorChannel.ProposeOpen(OpenParams{
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(5 * time.Minute),
+		StartingSequence:           101,
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+	})
+	require.NoError(t, err)
+	open, err = responderChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	openTx, err := initiatorChannel.OpenTx()
+	require.NoError(t, err)
+	openXDR, err := openTx.Base64()
+	require.NoError(t, err)
+	resultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         initiatorSigner.Address(),
+		ResponderSigner:         responderSigner.Address(),
+		InitiatorChannelAccount: initiatorChannelAccount.Address(),
+		ResponderChannelAccount: responderChannelAccount.Address(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	for _, c := range []*Channel{initiatorChannel, responderChannel} {
+		require.NoError(t, c.IngestTx(1, openXDR, resultXDR, resultMetaXDR))
+		c.UpdateLocalChannelAccountBalance(1000)
+		c.UpdateRemoteChannelAccountBalance(1000)
+	}
+
+	ca, err := initiatorChannel.ProposePayment(100)
+	require.NoError(t, err)
+	ca, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	return initiatorConfig, responderConfig, initiatorChannel, responderChannel
+}
+
+// ingestRotationTx ingests the rotation transaction into the channels with
+// the result given.
+func ingestRotationTx(t *testing.T, r SignerRotation, success bool, channels ...*Channel) {
+	t.Helper()
+	tx, err := r.SignedTransaction()
+	require.NoError(t, err)
+	txXDR, err := tx.Base64()
+	require.NoError(t, err)
+	resultXDR, err := txbuildtest.BuildResultXDR(success)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildResultMetaXDR(nil)
+	require.NoError(t, err)
+	for _, c := range channels {
+		require.NoError(t, c.IngestTx(2, txXDR, resultXDR, resultMetaXDR))
+	}
+}
+
+func TestChannel_SignerRotation(t *testing.T) {
+	_, _, initiatorChannel, responderChannel := rotationTestChannels(t)
+	oldSigner := responderChannel.localSigner.FromAddress()
+	newSigner := keypair.MustRandom()
+	before := responderChannel.LatestCloseAgreement()
+
+	r, err := responderChannel.ProposeSignerRotation(newSigner, 1001, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, oldSigner, r.Envelope.Details.OldSigner)
+	assert.Equal(t, newSigner.FromAddress(), r.Envelope.Details.NewSigner)
+	assert.Equal(t, before.Envelope.Details.IterationNumber+1, r.Envelope.Details.Close.IterationNumber)
+	assert.Equal(t, before.Envelope.Details.Balance, r.Envelope.Details.Close.Balance)
+	assert.Equal(t, int64(1001), r.Transaction.SequenceNumber())
+	assert.Empty(t, r.Envelope.ProposerSignatures.Rotation, "rotation tx is not signed before the close agreement is confirmed")
+
+	r, err = initiatorChannel.ConfirmSignerRotation(r.Envelope)
+	require.NoError(t, err)
+	assert.True(t, r.Envelope.ConfirmerSignatures.Close.HasAllSignatures())
+	assert.NotEmpty(t, r.Envelope.ConfirmerSignatures.Rotation)
+
+	// Until the rotation executes or expires no other agreement can be made.
+	_, err = initiatorChannel.ProposePayment(10)
+	assert.ErrorIs(t, err, ErrSignerRotationPending)
+	_, err = responderChannel.ProposePayment(10)
+	assert.ErrorIs(t, err, ErrSignerRotationPending)
+	_, err = initiatorChannel.ProposeClose()
+	assert.ErrorIs(t, err, ErrSignerRotationPending)
+	_, err = responderChannel.ProposeSignerRotation(keypair.MustRandom(), 1002, time.Now().Add(time.Minute))
+	assert.ErrorIs(t, err, ErrSignerRotationPending)
+
+	r, err = responderChannel.FinalizeSignerRotation(r.Envelope.ConfirmerSignatures)
+	require.NoError(t, err)
+	tx, err := r.SignedTransaction()
+	require.NoError(t, err)
+	require.Len(t, tx.Signatures(), 2)
+	assert.NoError(t, oldSigner.Verify(r.TransactionHash[:], tx.Signatures()[0].Signature))
+	assert.NoError(t, initiatorChannel.localSigner.FromAddress().Verify(r.TransactionHash[:], tx.Signatures()[1].Signature))
+
+	// The agreement signed by the old signer remains the latest authorized
+	// agreement until the rotation executes.
+	assert.Equal(t, before, responderChannel.LatestCloseAgreement())
+	assert.Equal(t, before, initiatorChannel.LatestCloseAgreement())
+
+	ingestRotationTx(t, r, true, initiatorChannel, responderChannel)
+
+	// The rotation's close agreement is the latest authorized agreement of
+	// both participants, and its transactions are signed by the new signer.
+	_, pending := responderChannel.PendingSignerRotation()
+	assert.False(t, pending)
+	_, pending = initiatorChannel.PendingSignerRotation()
+	assert.False(t, pending)
+	latest := responderChannel.LatestCloseAgreement()
+	assert.Equal(t, r.Envelope.Details.Close.IterationNumber, latest.Envelope.Details.IterationNumber)
+	assert.Equal(t, latest.Envelope, initiatorChannel.LatestCloseAgreement().Envelope)
+	assert.Equal(t, int64(100), latest.Envelope.Details.Balance)
+	require.NoError(t, responderChannel.ValidateCloseTxs())
+	require.NoError(t, initiatorChannel.ValidateCloseTxs())
+	assert.Equal(t, newSigner.FromAddress(), initiatorChannel.remoteSigner)
+
+	// Payments continue with the new signer.
+	ca, err := responderChannel.ProposePayment(10)
+	require.NoError(t, err)
+	assert.Equal(t, newSigner.FromAddress(), ca.Envelope.Details.ProposingSigner)
+	ca, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	_, err = responderChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+	assert.Equal(t, int64(90), responderChannel.Balance())
+	require.NoError(t, responderChannel.ValidateCloseTxs())
+}
+
+func TestChannel_SignerRotation_failed(t *testing.T) {
+	_, _, initiatorChannel, responderChannel := rotationTestChannels(t)
+	before := initiatorChannel.LatestCloseAgreement()
+
+	r, err := initiatorChannel.ProposeSignerRotation(keypair.MustRandom(), 1001, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	r, err = responderChannel.ConfirmSignerRotation(r.Envelope)
+	require.NoError(t, err)
+	r, err = initiatorChannel.FinalizeSignerRotation(r.Envelope.ConfirmerSignatures)
+	require.NoError(t, err)
+
+	// A rotation transaction that fails is discarded, and the channel
+	// continues with the old signer.
+	ingestRotationTx(t, r, false, initiatorChannel, responderChannel)
+	_, pending := initiatorChannel.PendingSignerRotation()
+	assert.False(t, pending)
+	_, pending = responderChannel.PendingSignerRotation()
+	assert.False(t, pending)
+	assert.Equal(t, before, initiatorChannel.LatestCloseAgreement())
+	_, err = initiatorChannel.ProposePayment(10)
+	require.NoError(t, err)
+}
+
+func TestChannel_ExpireSignerRotation(t *testing.T) {
+	_, _, initiatorChannel, responderChannel := rotationTestChannels(t)
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+
+	r, err := initiatorChannel.ProposeSignerRotation(keypair.MustRandom(), 1001, expiresAt)
+	require.NoError(t, err)
+	_, err = responderChannel.ConfirmSignerRotation(r.Envelope)
+	require.NoError(t, err)
+
+	// The rotation is pending until a ledger closes after it expires.
+	assert.False(t, responderChannel.ExpireSignerRotation(expiresAt))
+	_, pending := responderChannel.PendingSignerRotation()
+	assert.True(t, pending)
+	assert.True(t, responderChannel.ExpireSignerRotation(expiresAt.Add(time.Second)))
+	_, pending = responderChannel.PendingSignerRotation()
+	assert.False(t, pending)
+	_, err = responderChannel.ProposePayment(10)
+	require.NoError(t, err)
+}
+
+func TestChannel_RejectSignerRotation(t *testing.T) {
+	_, _, initiatorChannel, responderChannel := rotationTestChannels(t)
+
+	r, err := initiatorChannel.ProposeSignerRotation(keypair.MustRandom(), 1001, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, initiatorChannel.RejectSignerRotation())
+	_, pending := initiatorChannel.PendingSignerRotation()
+	assert.False(t, pending)
+
+	// Once finalized the rotation transaction is signed and may execute, and
+	// so the rotation cannot be discarded.
+	r, err = initiatorChannel.ProposeSignerRotation(keypair.MustRandom(), 1001, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	r, err = responderChannel.ConfirmSignerRotation(r.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.FinalizeSignerRotation(r.Envelope.ConfirmerSignatures)
+	require.NoError(t, err)
+	assert.Error(t, initiatorChannel.RejectSignerRotation())
+}
+
+func TestChannel_ConfirmSignerRotation_invalid(t *testing.T) {
+	_, _, initiatorChannel, responderChannel := rotationTestChannels(t)
+
+	r, err := initiatorChannel.ProposeSignerRotation(keypair.MustRandom(), 1001, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+
+	// A rotation that changes the balance is rejected.
+	e := r.Envelope
+	e.Details.Close.Balance = 0
+	_, err = responderChannel.ConfirmSignerRotation(e)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "balance does not match")
+
+	// A rotation that is not signed by the new signer is rejected.
+	e = r.Envelope
+	e.Details.NewSigner = keypair.MustRandom().FromAddress()
+	e.Details.Close.ProposingSigner = e.Details.NewSigner
+	_, err = responderChannel.ConfirmSignerRotation(e)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid signature")
+
+	// A rotation that expires too far in the future is rejected, so that it
+	// cannot block payments indefinitely.
+	_, err = initiatorChannel.ProposeSignerRotation(keypair.MustRandom(), 1001, time.Now().Add(3*time.Hour))
+	require.Error(t, err)
+
+	_, pending := responderChannel.PendingSignerRotation()
+	assert.False(t, pending)
+}
+
+func TestChannel_SignerRotation_snapshot(t *testing.T) {
+	_, responderConfig, initiatorChannel, responderChannel := rotationTestChannels(t)
+	newSigner := keypair.MustRandom()
+
+	r, err := responderChannel.ProposeSignerRotation(newSigner, 1001, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	r, err = initiatorChannel.ConfirmSignerRotation(r.Envelope)
+	require.NoError(t, err)
+	r, err = responderChannel.FinalizeSignerRotation(r.Envelope.ConfirmerSignatures)
+	require.NoError(t, err)
+
+	// A channel restored with the old signer while the rotation is pending
+	// still has the rotation pending.
+	restored := NewChannelFromSnapshot(responderConfig, responderChannel.Snapshot())
+	restoredRotation, pending := restored.PendingSignerRotation()
+	require.True(t, pending)
+	assert.Equal(t, r, restoredRotation)
+
+	// Without the new signer, once the rotation executes the channel can be
+	// closed with the rotation's close agreement but cannot make agreements.
+	ingestRotationTx(t, r, true, restored)
+	require.NoError(t, restored.ValidateCloseTxs())
+	_, err = restored.ProposePayment(10)
+	assert.ErrorIs(t, err, ErrSignerUnavailable)
+
+	// With the new signer given, the channel signs with it once the rotation
+	// executes.
+	restored = NewChannelFromSnapshot(responderConfig, responderChannel.Snapshot())
+	_, err = restored.ResumeSignerRotation(keypair.MustRandom())
+	assert.Error(t, err)
+	_, err = restored.ResumeSignerRotation(newSigner)
+	require.NoError(t, err)
+	ingestRotationTx(t, r, true, restored, initiatorChannel)
+	ca, err := restored.ProposePayment(10)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmPayment(ca.Envelope)
+	require.NoError(t, err)
+}
@@ -0,0 +1,22 @@
+package state
+
+import "github.com/stellar/go/keypair"
+
+var _ Signer = (*keypair.Full)(nil)
+
+// Signer signs the declaration, close, and open transactions of agreements on
+// behalf of the local participant. A *keypair.Full is a Signer. Other
+// implementations can keep the key outside the process, such as in a hardware
+// security module or a remote signing service.
+//
+// The channel accounts of an open channel have exactly two signers, one for
+// each participant, and so a Signer must produce a single ed25519 signature
+// that verifies against the address it reports. A participant that requires
+// multiple parties to approve its signatures must do so within its Signer,
+// for example with a threshold signing scheme that assembles the partial
+// signatures of its parties into one signature for the Signer's address.
+type Signer interface {
+	Address() string
+	FromAddress() *keypair.FromAddress
+	Sign(input []byte) ([]byte, error)
+}
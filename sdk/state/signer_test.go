@@ -0,0 +1,70 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSigner is a Signer that is not a *keypair.Full, and that counts the
+// signatures it makes.
+type countingSigner struct {
+	kp    *keypair.Full
+	count int
+}
+
+func (s *countingSigner) Address() string                   { return s.kp.Address() }
+func (s *countingSigner) FromAddress() *keypair.FromAddress { return s.kp.FromAddress() }
+func (s *countingSigner) Sign(input []byte) ([]byte, error) {
+	s.count++
+	return s.kp.Sign(input)
+}
+
+func TestChannel_customSigner(t *testing.T) {
+	localSigner := &countingSigner{kp: keypair.MustRandom()}
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	localChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	remoteChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	open1, err := localChannel.ProposeOpen(OpenParams{
+		ObservationPeriodTime:      1,
+		ObservationPeriodLedgerGap: 1,
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(time.Hour),
+		StartingSequence:           101,
+	})
+	require.NoError(t, err)
+	open2, err := remoteChannel.ConfirmOpen(open1.Envelope)
+	require.NoError(t, err)
+	_, err = localChannel.ConfirmOpen(open2.Envelope)
+	require.NoError(t, err)
+
+	// The signer signed the declaration, close, and open transactions, and
+	// the remote verified them.
+	assert.Equal(t, 3, localSigner.count)
+	assert.Equal(t, localSigner.FromAddress(), open2.Envelope.Details.ProposingSigner)
+	assert.True(t, open2.Envelope.HasAllSignatures())
+}
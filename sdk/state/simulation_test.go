@@ -0,0 +1,206 @@
+package state
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestChannel_simulation runs randomized sequences of payments between two
+// channels without a network, using fixed seeds so that any failure can be
+// reproduced. After every step it checks that both channels agree on the
+// latest authorized close agreement, that the balance matches a model of the
+// payments that succeeded, that iteration numbers only increase, and that
+// the close transactions of the agreement are valid. Each sequence ends with
+// a coordinated close whose transactions must conserve the funds of the
+// channel accounts.
+func TestChannel_simulation(t *testing.T) {
+	seeds := []int64{1, 2, 3, 42, 1000, 65536}
+	for _, seed := range seeds {
+		seed := seed
+		t.Run(fmt.Sprint(seed), func(t *testing.T) {
+			simulateChannel(t, seed, 200)
+		})
+	}
+}
+
+func simulateChannel(t *testing.T, seed int64, steps int) {
+	r := rand.New(rand.NewSource(seed))
+
+	initiatorSigner := keypair.MustRandom()
+	responderSigner := keypair.MustRandom()
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	responderChannelAccount := keypair.MustRandom().FromAddress()
+
+	initiatorChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          initiatorSigner,
+		RemoteSigner:         responderSigner.FromAddress(),
+		LocalChannelAccount:  initiatorChannelAccount,
+		RemoteChannelAccount: responderChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          responderSigner,
+		RemoteSigner:         initiatorSigner.FromAddress(),
+		LocalChannelAccount:  responderChannelAccount,
+		RemoteChannelAccount: initiatorChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Open.
+	open, err := initiatorChannel.ProposeOpen(OpenParams{
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(5 * time.Minute),
+		StartingSequence:           101,
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+	})
+	require.NoError(t, err)
+	open, err = responderChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	openTx, err := initiatorChannel.OpenTx()
+	require.NoError(t, err)
+	openXDR, err := openTx.Base64()
+	require.NoError(t, err)
+	successResultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         initiatorSigner.Address(),
+		ResponderSigner:         responderSigner.Address(),
+		InitiatorChannelAccount: initiatorChannelAccount.Address(),
+		ResponderChannelAccount: responderChannelAccount.Address(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, initiatorChannel.IngestTx(1, openXDR, successResultXDR, resultMetaXDR))
+	require.NoError(t, responderChannel.IngestTx(1, openXDR, successResultXDR, resultMetaXDR))
+
+	// Fund the channel accounts with random amounts.
+	initiatorFunds := 1 + r.Int63n(1_000)
+	responderFunds := 1 + r.Int63n(1_000)
+	initiatorChannel.UpdateLocalChannelAccountBalance(initiatorFunds)
+	initiatorChannel.UpdateRemoteChannelAccountBalance(responderFunds)
+	responderChannel.UpdateLocalChannelAccountBalance(responderFunds)
+	responderChannel.UpdateRemoteChannelAccountBalance(initiatorFunds)
+
+	// The model of the channel's balance, positive when the initiator owes
+	// the responder, negative when the responder owes the initiator.
+	balance := int64(0)
+	iterationNumber := initiatorChannel.LatestCloseAgreement().Envelope.Details.IterationNumber
+
+	check := func(step int) {
+		ica := initiatorChannel.LatestCloseAgreement()
+		rca := responderChannel.LatestCloseAgreement()
+		require.Equal(t, ica.Envelope, rca.Envelope, "step %d: channels disagree on the latest close agreement", step)
+		require.Equal(t, balance, initiatorChannel.Balance(), "step %d: initiator balance", step)
+		require.Equal(t, balance, responderChannel.Balance(), "step %d: responder balance", step)
+		require.LessOrEqual(t, amountToResponder(balance), initiatorFunds, "step %d: initiator over committed", step)
+		require.LessOrEqual(t, amountToInitiator(balance), responderFunds, "step %d: responder over committed", step)
+		require.GreaterOrEqual(t, ica.Envelope.Details.IterationNumber, iterationNumber, "step %d: iteration number decreased", step)
+		iterationNumber = ica.Envelope.Details.IterationNumber
+		require.NoError(t, initiatorChannel.ValidateCloseTxs(), "step %d: initiator close txs", step)
+		require.NoError(t, responderChannel.ValidateCloseTxs(), "step %d: responder close txs", step)
+	}
+	check(0)
+
+	for step := 1; step <= steps; step++ {
+		// Pick a payer, and an amount that is sometimes more than the payer
+		// can afford.
+		payer, payee := initiatorChannel, responderChannel
+		payerFunds, payerOwes := initiatorFunds, amountToResponder(balance)
+		sign := int64(1)
+		if r.Intn(2) == 0 {
+			payer, payee = responderChannel, initiatorChannel
+			payerFunds, payerOwes = responderFunds, amountToInitiator(balance)
+			sign = -1
+		}
+		amt := 1 + r.Int63n(payerFunds/4+1)
+		newBalance := balance + sign*amt
+		underfunded := sign*newBalance > payerFunds
+
+		ca, err := payer.ProposePayment(amt)
+		if underfunded {
+			require.ErrorIs(t, err, ErrUnderfunded, "step %d: payment of %d owing %d of %d", step, amt, payerOwes, payerFunds)
+			check(step)
+			continue
+		}
+		require.NoError(t, err, "step %d: payment of %d owing %d of %d", step, amt, payerOwes, payerFunds)
+		proposed := ca.Envelope.Details.IterationNumber
+		require.Greater(t, proposed, iterationNumber, "step %d: proposed iteration number", step)
+
+		// Sometimes the payee rejects the payment, which must leave both
+		// channels at the latest authorized agreement.
+		if r.Intn(5) == 0 {
+			require.NoError(t, payee.RejectPayment(proposed), "step %d: payee reject", step)
+			require.NoError(t, payer.RejectPayment(proposed), "step %d: payer reject", step)
+			check(step)
+			continue
+		}
+
+		ca, err = payee.ConfirmPayment(ca.Envelope)
+		require.NoError(t, err, "step %d: payee confirm", step)
+		if r.Intn(2) == 0 {
+			_, err = payer.ConfirmPayment(ca.Envelope)
+		} else {
+			_, err = payer.FinalizePayment(ca.Envelope.ConfirmerSignatures)
+		}
+		require.NoError(t, err, "step %d: payer confirm", step)
+		balance = newBalance
+
+		check(step)
+		require.Equal(t, proposed, iterationNumber, "step %d: authorized iteration number", step)
+	}
+
+	// Close.
+	ca, err := initiatorChannel.ProposeClose()
+	require.NoError(t, err)
+	ca, err = responderChannel.ConfirmClose(ca.Envelope)
+	require.NoError(t, err)
+	_, err = initiatorChannel.ConfirmClose(ca.Envelope)
+	require.NoError(t, err)
+	check(steps + 1)
+	assert.Zero(t, initiatorChannel.LatestCloseAgreement().Envelope.Details.ObservationPeriodTime)
+	assert.Zero(t, initiatorChannel.LatestCloseAgreement().Envelope.Details.ObservationPeriodLedgerGap)
+
+	// The close transaction moves funds only between the channel accounts,
+	// and leaves each with the funds the model expects.
+	_, closeTx, err := initiatorChannel.CloseTxs()
+	require.NoError(t, err)
+	funds := map[string]int64{
+		initiatorChannelAccount.Address(): initiatorFunds,
+		responderChannelAccount.Address(): responderFunds,
+	}
+	for _, op := range closeTx.Operations() {
+		p, ok := op.(*txnbuild.Payment)
+		if !ok {
+			continue
+		}
+		amt, err := amount.ParseInt64(p.Amount)
+		require.NoError(t, err)
+		require.Contains(t, funds, p.SourceAccount)
+		require.Contains(t, funds, p.Destination)
+		funds[p.SourceAccount] -= amt
+		funds[p.Destination] += amt
+	}
+	assert.Equal(t, initiatorFunds+responderFunds, funds[initiatorChannelAccount.Address()]+funds[responderChannelAccount.Address()])
+	assert.Equal(t, initiatorFunds-balance, funds[initiatorChannelAccount.Address()])
+	assert.Equal(t, responderFunds+balance, funds[responderChannelAccount.Address()])
+	assert.GreaterOrEqual(t, funds[initiatorChannelAccount.Address()], int64(0))
+	assert.GreaterOrEqual(t, funds[responderChannelAccount.Address()], int64(0))
+}
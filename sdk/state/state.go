@@ -18,8 +18,42 @@ type Config struct {
 	LocalChannelAccount  *keypair.FromAddress
 	RemoteChannelAccount *keypair.FromAddress
 
-	LocalSigner  *keypair.Full
+	LocalSigner  Signer
 	RemoteSigner *keypair.FromAddress
+
+	// LocalContribution and RemoteContribution are the contributions that
+	// this participant expects an open agreement it confirms to state for
+	// the local and remote participants. See OpenDetails for how
+	// contributions relate to balances. If zero, any contribution is
+	// accepted for that participant.
+	LocalContribution  int64
+	RemoteContribution int64
+
+	// LocalTrustLimit and RemoteTrustLimit are the trust limits that this
+	// participant expects an open agreement it confirms to state for the
+	// trustlines of the local and remote channel accounts. If zero, any
+	// trust limit is accepted for that participant.
+	LocalTrustLimit  int64
+	RemoteTrustLimit int64
+
+	// ObservationPeriodTime and ObservationPeriodLedgerGap are the
+	// observation period that this participant expects an open agreement it
+	// confirms to state. If zero, any observation period is accepted.
+	ObservationPeriodTime      time.Duration
+	ObservationPeriodLedgerGap int64
+
+	// SignerScheme is the signer scheme that this participant expects an
+	// open agreement it confirms to state. The zero value is
+	// txbuild.DefaultSignerScheme.
+	SignerScheme txbuild.SignerScheme
+
+	// ReserveAmount is the amount of the channel's asset that a channel
+	// account must still hold after paying out what a payment agreement owes
+	// from it. Payments proposed or confirmed that would leave the paying
+	// channel account with less are rejected with an UnderfundedError. It is
+	// not applied to a channel account that owes nothing. If zero, a channel
+	// account can be committed to paying out its entire balance.
+	ReserveAmount int64
 }
 
 // NewChannel constructs a new channel with the given config.
@@ -32,6 +66,15 @@ func NewChannel(c Config) *Channel {
 		remoteChannelAccount: &ChannelAccount{Address: c.RemoteChannelAccount},
 		localSigner:          c.LocalSigner,
 		remoteSigner:         c.RemoteSigner,
+		localContribution:    c.LocalContribution,
+		remoteContribution:   c.RemoteContribution,
+		localTrustLimit:      c.LocalTrustLimit,
+		remoteTrustLimit:     c.RemoteTrustLimit,
+		reserveAmount:        c.ReserveAmount,
+
+		observationPeriodTime:      c.ObservationPeriodTime,
+		observationPeriodLedgerGap: c.ObservationPeriodLedgerGap,
+		signerScheme:               c.SignerScheme,
 	}
 	return channel
 }
@@ -53,12 +96,20 @@ type Snapshot struct {
 
 	LatestAuthorizedCloseAgreement   CloseAgreement
 	LatestUnauthorizedCloseAgreement CloseAgreement
+
+	RejectedIterationNumber int64
+
+	PendingSignerRotation SignerRotationEnvelope
 }
 
 // NewChannelFromSnapshot creates the channel with the given config, and
 // restores the internal state of the channel using the snapshot. To restore the
 // channel to its identical state the same config should be provided that was in
-// use when the snapshot was created.
+// use when the snapshot was created. If a signer rotation has executed since
+// the config was created, the config must give the signers that the rotation
+// replaced the rotated signer with. A channel restored with a pending signer
+// rotation that it proposed is given the new signer with
+// ResumeSignerRotation.
 func NewChannelFromSnapshot(c Config, s Snapshot) *Channel {
 	channel := NewChannel(c)
 
@@ -75,9 +126,21 @@ func NewChannelFromSnapshot(c Config, s Snapshot) *Channel {
 		channel.openExecutedWithError = fmt.Errorf("open executed with error")
 	}
 
-	channel.latestAuthorizedCloseAgreement = s.LatestAuthorizedCloseAgreement
+	channel.setLatestAuthorizedCloseAgreement(s.LatestAuthorizedCloseAgreement)
 	channel.latestUnauthorizedCloseAgreement = s.LatestUnauthorizedCloseAgreement
 
+	channel.rejectedIterationNumber = s.RejectedIterationNumber
+
+	if !s.PendingSignerRotation.Empty() {
+		// A rotation that cannot be built again is kept so that no agreement
+		// is made until it expires, because it may still execute.
+		r, err := channel.buildSignerRotation(s.PendingSignerRotation)
+		if err != nil {
+			r = SignerRotation{Envelope: s.PendingSignerRotation}
+		}
+		channel.pendingSignerRotation = r
+	}
+
 	return channel
 }
 
@@ -103,15 +166,41 @@ type Channel struct {
 	localChannelAccount  *ChannelAccount
 	remoteChannelAccount *ChannelAccount
 
-	localSigner  *keypair.Full
+	localSigner  Signer
 	remoteSigner *keypair.FromAddress
 
+	localContribution  int64
+	remoteContribution int64
+	localTrustLimit    int64
+	remoteTrustLimit   int64
+	reserveAmount      int64
+
+	observationPeriodTime      time.Duration
+	observationPeriodLedgerGap int64
+	signerScheme               txbuild.SignerScheme
+
 	openAgreement            OpenAgreement
 	openExecutedAndValidated bool
 	openExecutedWithError    error
 
 	latestAuthorizedCloseAgreement   CloseAgreement
 	latestUnauthorizedCloseAgreement CloseAgreement
+
+	// latestAuthorizedCloseTxs are the transactions of the latest authorized
+	// close agreement with all signatures attached, so that they are ready to
+	// submit without delay. They are updated by
+	// setLatestAuthorizedCloseAgreement.
+	latestAuthorizedCloseTxs CloseTransactions
+
+	// rejectedIterationNumber is the iteration number of the latest payment
+	// that was rejected. It is never reused by a later agreement.
+	rejectedIterationNumber int64
+
+	// pendingSignerRotation is the signer rotation proposed or confirmed that
+	// is yet to execute or expire, and pendingSigner is its new signer if it
+	// was proposed by the local participant.
+	pendingSignerRotation SignerRotation
+	pendingSigner         Signer
 }
 
 // Snapshot returns a snapshot of the channel's internal state that if combined
@@ -132,6 +221,10 @@ func (c *Channel) Snapshot() Snapshot {
 
 		LatestAuthorizedCloseAgreement:   c.latestAuthorizedCloseAgreement,
 		LatestUnauthorizedCloseAgreement: c.latestUnauthorizedCloseAgreement,
+
+		RejectedIterationNumber: c.rejectedIterationNumber,
+
+		PendingSignerRotation: c.pendingSignerRotation.Envelope,
 	}
 }
 
@@ -188,24 +281,79 @@ func (c *Channel) State() (State, error) {
 	return StateError, fmt.Errorf("initiator channel account sequence has unexpected value")
 }
 
+// ErrStartingSequenceMismatch indicates that the sequence number of the
+// initiator's channel account on the network cannot have resulted from the
+// channel's starting sequence, and so the declaration and close transactions
+// built with it would be invalid.
+var ErrStartingSequenceMismatch = fmt.Errorf("starting sequence does not match channel account sequence")
+
+// ValidateStartingSequence checks that the sequence number of the initiator's
+// channel account, as found on the network, is consistent with the starting
+// sequence of the open agreement, from which the sequence numbers of the
+// declaration and close transactions are derived. It should be used after a
+// channel is restored with NewChannelFromSnapshot, because a wrong starting
+// sequence makes every declaration and close transaction invalid.
+//
+// Once the open transaction has executed the channel account's sequence
+// number must be at least the starting sequence, and must not be the
+// sequence number after it, which no transaction of the channel uses, else
+// an error wrapping ErrStartingSequenceMismatch is returned. Later sequence
+// numbers are consistent, because the declaration and close transactions of
+// any iteration may have executed, or the channel account may have been used
+// after the channel closed. The check therefore detects a starting sequence
+// that is after the channel account's sequence, but not every wrong starting
+// sequence. Before the open transaction has executed any sequence number is
+// consistent, because the open may have executed without having been
+// ingested yet.
+func (c *Channel) ValidateStartingSequence(seqNum int64) error {
+	if c.openAgreement.Envelope.Empty() {
+		return fmt.Errorf("no open agreement")
+	}
+	if !c.openExecutedAndValidated {
+		return nil
+	}
+	s := c.openAgreement.Envelope.Details.StartingSequence
+	if seqNum < s {
+		return fmt.Errorf("%w: channel account sequence %d is before starting sequence %d", ErrStartingSequenceMismatch, seqNum, s)
+	}
+	if txbuild.SequenceNumberToTransactionType(s, seqNum) == txbuild.TransactionTypeUnrecognized {
+		return fmt.Errorf("%w: channel account sequence %d is not of a transaction of the channel with starting sequence %d", ErrStartingSequenceMismatch, seqNum, s)
+	}
+	return nil
+}
+
 func (c *Channel) setInitiatorChannelAccountSequence(seqNum int64) {
 	c.initiatorChannelAccount().SequenceNumber = seqNum
 }
 
-// IsInitiator returns true if this channel initiated the process of opening a
-// channel, else false.
+// IsInitiator returns true if this channel is the initiator of the channel,
+// whose channel account provides the channel's starting sequence, else false.
+// The initiator usually proposes the open, but the responder may propose it
+// instead. See IsOpenProposer.
 func (c *Channel) IsInitiator() bool {
 	return c.initiator
 }
 
+// IsOpenProposer returns true if an open has been proposed and this channel
+// proposed it, else false.
+func (c *Channel) IsOpenProposer() bool {
+	proposer := c.openAgreement.Envelope.Details.ProposingSigner
+	return proposer != nil && proposer.Equal(c.localSigner.FromAddress())
+}
+
 // nextIterationNumber returns the next iteration number for the channel. If
 // there is a pending unauthorized close agreement, then that agreement
-// iteration is used, else the latest authorized agreeement is used.
+// iteration is used, else the latest authorized agreeement is used. Iteration
+// numbers of rejected payments are skipped.
 func (c *Channel) nextIterationNumber() int64 {
 	if !c.latestUnauthorizedCloseAgreement.Envelope.Empty() {
 		return c.latestUnauthorizedCloseAgreement.Envelope.Details.IterationNumber
 	}
-	return c.latestAuthorizedCloseAgreement.Envelope.Details.IterationNumber + 1
+	next := c.latestAuthorizedCloseAgreement.Envelope.Details.IterationNumber + 1
+	if c.rejectedIterationNumber >= next {
+		next = c.rejectedIterationNumber + 1
+	}
+	return next
 }
 
 // Balance returns the amount owing from the initiator to the responder, if positive, or
@@ -231,6 +379,32 @@ func (c *Channel) LatestUnauthorizedCloseAgreement() (CloseAgreement, bool) {
 	return c.latestUnauthorizedCloseAgreement, !c.latestUnauthorizedCloseAgreement.Envelope.Empty()
 }
 
+// PendingOpen returns the open agreement if it has been proposed or confirmed
+// but is yet to be signed by both participants.
+func (c *Channel) PendingOpen() (OpenAgreement, bool) {
+	open := c.openAgreement
+	if open.Envelope.Empty() || open.Envelope.HasAllSignatures() {
+		return OpenAgreement{}, false
+	}
+	return open, true
+}
+
+// PendingPayment returns the latest unauthorized close agreement if it is a
+// payment yet to be signed by both participants. A coordinated close that is
+// yet to be signed by both participants is not a payment, and is returned by
+// LatestUnauthorizedCloseAgreement.
+func (c *Channel) PendingPayment() (CloseAgreement, bool) {
+	ca := c.latestUnauthorizedCloseAgreement
+	if ca.Envelope.Empty() {
+		return CloseAgreement{}, false
+	}
+	d := ca.Envelope.Details
+	if d.ObservationPeriodTime == 0 && d.ObservationPeriodLedgerGap == 0 {
+		return CloseAgreement{}, false
+	}
+	return ca, true
+}
+
 // UpdateLocalChannelAccountBalance updates the local channel account balance.
 func (c *Channel) UpdateLocalChannelAccountBalance(balance int64) {
 	c.localChannelAccount.Balance = balance
@@ -267,6 +441,24 @@ func (c *Channel) responderChannelAccount() *ChannelAccount {
 	}
 }
 
+// txParticipants are the signers and channel accounts of the participants of
+// a channel, which the channel's transactions are built for.
+type txParticipants struct {
+	InitiatorSigner         *keypair.FromAddress
+	ResponderSigner         *keypair.FromAddress
+	InitiatorChannelAccount *keypair.FromAddress
+	ResponderChannelAccount *keypair.FromAddress
+}
+
+func (c *Channel) txParticipants() txParticipants {
+	return txParticipants{
+		InitiatorSigner:         c.initiatorSigner(),
+		ResponderSigner:         c.responderSigner(),
+		InitiatorChannelAccount: c.initiatorChannelAccount().Address,
+		ResponderChannelAccount: c.responderChannelAccount().Address,
+	}
+}
+
 func (c *Channel) initiatorSigner() *keypair.FromAddress {
 	if c.initiator {
 		return c.localSigner.FromAddress()
@@ -297,6 +489,25 @@ func (c *Channel) amountToRemote(balance int64) int64 {
 	return amountToInitiator(balance)
 }
 
+// checkFunded returns an UnderfundedError if a channel account with the given
+// balance cannot pay out the required amount and still hold the reserve
+// amount.
+func (c *Channel) checkFunded(required, balance int64) error {
+	reserve := int64(0)
+	if required > 0 {
+		reserve = c.reserveAmount
+	}
+	if required+reserve > balance {
+		return UnderfundedError{
+			Asset:     c.openAgreement.Envelope.Details.Asset,
+			Required:  required,
+			Reserve:   reserve,
+			Available: balance,
+		}
+	}
+	return nil
+}
+
 func amountToInitiator(balance int64) int64 {
 	if balance < 0 {
 		return balance * -1
@@ -2,6 +2,7 @@ package state
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
@@ -250,3 +251,205 @@ func TestNewChannelWithSnapshot(t *testing.T) {
 	assertChannelSnapshotsAndRestores(t, localConfig, localChannel)
 	assertChannelSnapshotsAndRestores(t, remoteConfig, remoteChannel)
 }
+
+func TestChannel_PendingOpenAndPayment(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	localChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	remoteChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	// Nothing is pending before an open is proposed.
+	_, pending := localChannel.PendingOpen()
+	assert.False(t, pending)
+	_, pending = localChannel.PendingPayment()
+	assert.False(t, pending)
+
+	// The open is pending until it is signed by both participants.
+	open1, err := localChannel.ProposeOpen(OpenParams{
+		ObservationPeriodTime:      1,
+		ObservationPeriodLedgerGap: 1,
+		ExpiresAt:                  time.Now().Add(time.Hour),
+		StartingSequence:           101,
+	})
+	require.NoError(t, err)
+	pendingOpen, pending := localChannel.PendingOpen()
+	assert.True(t, pending)
+	assert.Equal(t, open1, pendingOpen)
+	open2, err := remoteChannel.ConfirmOpen(open1.Envelope)
+	require.NoError(t, err)
+	_, pending = remoteChannel.PendingOpen()
+	assert.False(t, pending)
+	_, err = localChannel.ConfirmOpen(open2.Envelope)
+	require.NoError(t, err)
+	_, pending = localChannel.PendingOpen()
+	assert.False(t, pending)
+
+	// Put the channel into an open state by ingesting the open tx.
+	ftx, err := localChannel.OpenTx()
+	require.NoError(t, err)
+	ftxXDR, err := ftx.Base64()
+	require.NoError(t, err)
+	successResultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         localSigner.Address(),
+		ResponderSigner:         remoteSigner.Address(),
+		InitiatorChannelAccount: localChannelAccount.Address(),
+		ResponderChannelAccount: remoteChannelAccount.Address(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, localChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR))
+	require.NoError(t, remoteChannel.IngestTx(1, ftxXDR, successResultXDR, resultMetaXDR))
+	localChannel.UpdateLocalChannelAccountBalance(100)
+	remoteChannel.UpdateRemoteChannelAccountBalance(100)
+
+	// The payment is pending until it is signed by both participants.
+	payment, err := localChannel.ProposePayment(10)
+	require.NoError(t, err)
+	pendingPayment, pending := localChannel.PendingPayment()
+	assert.True(t, pending)
+	assert.Equal(t, payment, pendingPayment)
+	confirmed, err := remoteChannel.ConfirmPayment(payment.Envelope)
+	require.NoError(t, err)
+	_, pending = remoteChannel.PendingPayment()
+	assert.False(t, pending)
+	_, err = localChannel.FinalizePayment(confirmed.Envelope.ConfirmerSignatures)
+	require.NoError(t, err)
+	_, pending = localChannel.PendingPayment()
+	assert.False(t, pending)
+
+	// A pending coordinated close is not a pending payment.
+	_, err = localChannel.ProposeClose()
+	require.NoError(t, err)
+	_, pending = localChannel.PendingPayment()
+	assert.False(t, pending)
+	_, pending = localChannel.LatestUnauthorizedCloseAgreement()
+	assert.True(t, pending)
+}
+
+func TestChannel_ValidateStartingSequence(t *testing.T) {
+	localSigner := keypair.MustRandom()
+	remoteSigner := keypair.MustRandom()
+	localChannelAccount := keypair.MustRandom().FromAddress()
+	remoteChannelAccount := keypair.MustRandom().FromAddress()
+
+	// The starting sequence of an account created in ledger 12345.
+	startingSequence := int64(12345) << 32
+
+	localConfig := Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          localSigner,
+		RemoteSigner:         remoteSigner.FromAddress(),
+		LocalChannelAccount:  localChannelAccount,
+		RemoteChannelAccount: remoteChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	}
+	localChannel := NewChannel(localConfig)
+	remoteChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            false,
+		LocalSigner:          remoteSigner,
+		RemoteSigner:         localSigner.FromAddress(),
+		LocalChannelAccount:  remoteChannelAccount,
+		RemoteChannelAccount: localChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+
+	assert.EqualError(t, localChannel.ValidateStartingSequence(startingSequence), "no open agreement")
+
+	open, err := localChannel.ProposeOpen(OpenParams{
+		ObservationPeriodTime:      1,
+		ObservationPeriodLedgerGap: 1,
+		ExpiresAt:                  time.Now().Add(time.Hour),
+		StartingSequence:           startingSequence,
+	})
+	require.NoError(t, err)
+	open, err = remoteChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	_, err = localChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+
+	// Before the open executes any sequence is consistent.
+	assert.NoError(t, localChannel.ValidateStartingSequence(startingSequence-1))
+	assert.NoError(t, localChannel.ValidateStartingSequence(startingSequence+1))
+
+	openTx, err := localChannel.OpenTx()
+	require.NoError(t, err)
+	openXDR, err := openTx.Base64()
+	require.NoError(t, err)
+	successResultXDR, err := txbuildtest.BuildResultXDR(true)
+	require.NoError(t, err)
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         localSigner.Address(),
+		ResponderSigner:         remoteSigner.Address(),
+		InitiatorChannelAccount: localChannelAccount.Address(),
+		ResponderChannelAccount: remoteChannelAccount.Address(),
+		StartSequence:           startingSequence,
+		Asset:                   txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	require.NoError(t, localChannel.IngestTx(1, openXDR, successResultXDR, resultMetaXDR))
+
+	// The starting sequence is restored from a snapshot, and the close
+	// transactions built with it are those of the channel before the
+	// snapshot.
+	_, closeTx, err := localChannel.CloseTxs()
+	require.NoError(t, err)
+	snapshotJSON, err := json.Marshal(localChannel.Snapshot())
+	require.NoError(t, err)
+	snapshot := Snapshot{}
+	require.NoError(t, json.Unmarshal(snapshotJSON, &snapshot))
+	restored := NewChannelFromSnapshot(localConfig, snapshot)
+	assert.Equal(t, startingSequence, restored.OpenAgreement().Envelope.Details.StartingSequence)
+	_, restoredCloseTx, err := restored.CloseTxs()
+	require.NoError(t, err)
+	assert.Equal(t, closeTx.SequenceNumber(), restoredCloseTx.SequenceNumber())
+	assert.NoError(t, restored.ValidateCloseTxs())
+
+	// The sequence of the channel account after the open, a declaration, or
+	// a close is consistent.
+	assert.NoError(t, restored.ValidateStartingSequence(startingSequence))
+	assert.NoError(t, restored.ValidateStartingSequence(startingSequence+2))
+	assert.NoError(t, restored.ValidateStartingSequence(startingSequence+3))
+	assert.NoError(t, restored.ValidateStartingSequence(restoredCloseTx.SequenceNumber()))
+
+	// So is any later sequence, because the channel account may be used
+	// after the channel closes.
+	assert.NoError(t, restored.ValidateStartingSequence(restoredCloseTx.SequenceNumber()+100))
+
+	// A sequence before the starting sequence, or the one after it that no
+	// transaction of the channel uses, is not.
+	err = restored.ValidateStartingSequence(startingSequence - 1)
+	assert.ErrorIs(t, err, ErrStartingSequenceMismatch)
+	assert.EqualError(t, err, fmt.Sprintf("starting sequence does not match channel account sequence: channel account sequence %d is before starting sequence %d", startingSequence-1, startingSequence))
+	err = restored.ValidateStartingSequence(startingSequence + 1)
+	assert.ErrorIs(t, err, ErrStartingSequenceMismatch)
+
+	// A snapshot with the wrong starting sequence is detected by the
+	// sequence of the channel account.
+	snapshot.OpenAgreement.Envelope.Details.StartingSequence = int64(12346) << 32
+	wrong := NewChannelFromSnapshot(localConfig, snapshot)
+	assert.ErrorIs(t, wrong.ValidateStartingSequence(startingSequence), ErrStartingSequenceMismatch)
+}
@@ -0,0 +1,256 @@
+// Package statetest contains helpers for testing the lifecycle of channels
+// locally, by exchanging agreements between a pair of state.Channels in
+// memory, without a network.
+package statetest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stellar/starlight/sdk/txbuild/txbuildtest"
+)
+
+// Participant identifies one of the participants of a Pair.
+type Participant int
+
+const (
+	Initiator Participant = iota
+	Responder
+)
+
+// String returns the name of the participant.
+func (p Participant) String() string {
+	switch p {
+	case Initiator:
+		return "initiator"
+	case Responder:
+		return "responder"
+	}
+	return fmt.Sprintf("Participant(%d)", int(p))
+}
+
+// PairConfig configures the channels of a Pair. Zero values are replaced with
+// the defaults documented on each field.
+type PairConfig struct {
+	// NetworkPassphrase defaults to the test network passphrase.
+	NetworkPassphrase string
+	// MaxOpenExpiry defaults to two hours.
+	MaxOpenExpiry time.Duration
+	// ReserveAmount is given to both channels, see
+	// state.Config.ReserveAmount.
+	ReserveAmount int64
+
+	// InitiatorBalance and ResponderBalance are the balances of the channel
+	// accounts that both channels are given once the open is ingested.
+	InitiatorBalance int64
+	ResponderBalance int64
+}
+
+// Pair is the channels of both participants of a channel, with random keys.
+// The methods of a Pair exchange agreements between the channels the same
+// way that two agents do over a connection, so that a scripted sequence of
+// opens, payments, and closes can be tested without a network.
+type Pair struct {
+	Initiator *state.Channel
+	Responder *state.Channel
+
+	InitiatorSigner         *keypair.Full
+	ResponderSigner         *keypair.Full
+	InitiatorChannelAccount *keypair.FromAddress
+	ResponderChannelAccount *keypair.FromAddress
+
+	config PairConfig
+}
+
+// NewChannelPair returns a pair of new channels that have not been opened.
+func NewChannelPair(c PairConfig) *Pair {
+	if c.NetworkPassphrase == "" {
+		c.NetworkPassphrase = network.TestNetworkPassphrase
+	}
+	if c.MaxOpenExpiry == 0 {
+		c.MaxOpenExpiry = 2 * time.Hour
+	}
+	p := &Pair{
+		InitiatorSigner:         keypair.MustRandom(),
+		ResponderSigner:         keypair.MustRandom(),
+		InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		config:                  c,
+	}
+	p.Initiator = state.NewChannel(state.Config{
+		NetworkPassphrase:    c.NetworkPassphrase,
+		MaxOpenExpiry:        c.MaxOpenExpiry,
+		Initiator:            true,
+		LocalChannelAccount:  p.InitiatorChannelAccount,
+		RemoteChannelAccount: p.ResponderChannelAccount,
+		LocalSigner:          p.InitiatorSigner,
+		RemoteSigner:         p.ResponderSigner.FromAddress(),
+		ReserveAmount:        c.ReserveAmount,
+	})
+	p.Responder = state.NewChannel(state.Config{
+		NetworkPassphrase:    c.NetworkPassphrase,
+		MaxOpenExpiry:        c.MaxOpenExpiry,
+		LocalChannelAccount:  p.ResponderChannelAccount,
+		RemoteChannelAccount: p.InitiatorChannelAccount,
+		LocalSigner:          p.ResponderSigner,
+		RemoteSigner:         p.InitiatorSigner.FromAddress(),
+		ReserveAmount:        c.ReserveAmount,
+	})
+	return p
+}
+
+// Channel returns the channel of the participant.
+func (p *Pair) Channel(participant Participant) *state.Channel {
+	if participant == Responder {
+		return p.Responder
+	}
+	return p.Initiator
+}
+
+// Open has the initiator propose the open and the responder confirm it, then
+// ingests the open transaction into both channels as if it executed
+// successfully, and sets the balances of the channel accounts to those in the
+// PairConfig. If the params have no asset, expiry, starting sequence, or
+// observation period, the native asset, an expiry of half the max open expiry
+// from now, a starting sequence of 101, and an observation period of one
+// minute and one ledger are used.
+func (p *Pair) Open(params state.OpenParams) (state.OpenAgreement, error) {
+	if params.Asset == "" {
+		params.Asset = state.NativeAsset
+	}
+	if params.ExpiresAt.IsZero() {
+		params.ExpiresAt = time.Now().Add(p.config.MaxOpenExpiry / 2)
+	}
+	if params.StartingSequence == 0 {
+		params.StartingSequence = 101
+	}
+	if params.ObservationPeriodTime == 0 && params.ObservationPeriodLedgerGap == 0 {
+		params.ObservationPeriodTime = time.Minute
+		params.ObservationPeriodLedgerGap = 1
+	}
+
+	open, err := p.Initiator.ProposeOpen(params)
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("proposing open: %w", err)
+	}
+	open, err = p.Responder.ConfirmOpen(open.Envelope)
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("confirming open: %w", err)
+	}
+	open, err = p.Initiator.ConfirmOpen(open.Envelope)
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("finalizing open: %w", err)
+	}
+
+	openTx, err := p.Initiator.OpenTx()
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("building open tx: %w", err)
+	}
+	openTxXDR, err := openTx.Base64()
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("encoding open tx: %w", err)
+	}
+	resultXDR, err := txbuildtest.BuildResultXDR(true)
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("building open tx result: %w", err)
+	}
+	resultMetaXDR, err := txbuildtest.BuildOpenResultMetaXDR(txbuildtest.OpenResultMetaParams{
+		InitiatorSigner:         p.InitiatorSigner.Address(),
+		ResponderSigner:         p.ResponderSigner.Address(),
+		InitiatorChannelAccount: p.InitiatorChannelAccount.Address(),
+		ResponderChannelAccount: p.ResponderChannelAccount.Address(),
+		StartSequence:           params.StartingSequence,
+		Asset:                   params.Asset.Asset(),
+	})
+	if err != nil {
+		return state.OpenAgreement{}, fmt.Errorf("building open tx result meta: %w", err)
+	}
+	for _, participant := range []Participant{Initiator, Responder} {
+		err = p.Channel(participant).IngestTx(1, openTxXDR, resultXDR, resultMetaXDR)
+		if err != nil {
+			return state.OpenAgreement{}, fmt.Errorf("ingesting open tx into %s channel: %w", participant, err)
+		}
+	}
+	p.Initiator.UpdateLocalChannelAccountBalance(p.config.InitiatorBalance)
+	p.Initiator.UpdateRemoteChannelAccountBalance(p.config.ResponderBalance)
+	p.Responder.UpdateLocalChannelAccountBalance(p.config.ResponderBalance)
+	p.Responder.UpdateRemoteChannelAccountBalance(p.config.InitiatorBalance)
+	return open, nil
+}
+
+// Pay has the participant propose a payment of the amount to the other
+// participant, who confirms it, and then finalizes the payment with the
+// other participant's signatures. It returns the close agreement authorized
+// by both participants. If the other participant cannot confirm the payment,
+// both participants reject it, the same way agents do, and the error is
+// returned.
+func (p *Pair) Pay(from Participant, amount int64, memo []byte) (state.CloseAgreement, error) {
+	proposer := p.Channel(from)
+	confirmer := p.Channel(p.other(from))
+
+	ca, err := proposer.ProposePaymentWithMemo(amount, memo)
+	if err != nil {
+		return state.CloseAgreement{}, fmt.Errorf("%s proposing payment: %w", from, err)
+	}
+	confirmed, err := confirmer.ConfirmPayment(ca.Envelope)
+	if err != nil {
+		iteration := ca.Envelope.Details.IterationNumber
+		for _, c := range []*state.Channel{confirmer, proposer} {
+			rejectErr := c.RejectPayment(iteration)
+			if rejectErr != nil {
+				return state.CloseAgreement{}, fmt.Errorf("rejecting payment: %v: %w", rejectErr, err)
+			}
+		}
+		return state.CloseAgreement{}, fmt.Errorf("%s confirming payment: %w", p.other(from), err)
+	}
+	ca, err = proposer.FinalizePayment(confirmed.Envelope.ConfirmerSignatures)
+	if err != nil {
+		return state.CloseAgreement{}, fmt.Errorf("%s finalizing payment: %w", from, err)
+	}
+	return ca, p.checkAgreed()
+}
+
+// Close has the participant propose a coordinated close and the other
+// participant confirm it, and returns the close agreement authorized by both
+// participants.
+func (p *Pair) Close(from Participant) (state.CloseAgreement, error) {
+	proposer := p.Channel(from)
+	confirmer := p.Channel(p.other(from))
+
+	ca, err := proposer.ProposeClose()
+	if err != nil {
+		return state.CloseAgreement{}, fmt.Errorf("%s proposing close: %w", from, err)
+	}
+	confirmed, err := confirmer.ConfirmClose(ca.Envelope)
+	if err != nil {
+		return state.CloseAgreement{}, fmt.Errorf("%s confirming close: %w", p.other(from), err)
+	}
+	ca, err = proposer.ConfirmClose(confirmed.Envelope)
+	if err != nil {
+		return state.CloseAgreement{}, fmt.Errorf("%s finalizing close: %w", from, err)
+	}
+	return ca, p.checkAgreed()
+}
+
+func (p *Pair) other(participant Participant) Participant {
+	if participant == Initiator {
+		return Responder
+	}
+	return Initiator
+}
+
+// checkAgreed returns an error if the channels do not have the same latest
+// authorized close agreement.
+func (p *Pair) checkAgreed() error {
+	i := p.Initiator.LatestCloseAgreement()
+	r := p.Responder.LatestCloseAgreement()
+	if !i.Envelope.Equal(r.Envelope) {
+		return fmt.Errorf("channels disagree: initiator has iteration %d balance %d, responder has iteration %d balance %d",
+			i.Envelope.Details.IterationNumber, i.Envelope.Details.Balance,
+			r.Envelope.Details.IterationNumber, r.Envelope.Details.Balance)
+	}
+	return nil
+}
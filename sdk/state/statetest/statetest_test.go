@@ -0,0 +1,79 @@
+package statetest
+
+import (
+	"testing"
+
+	"github.com/stellar/starlight/sdk/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPair(t *testing.T) {
+	type payment struct {
+		From        Participant
+		Amount      int64
+		WantErr     error
+		WantBalance int64
+	}
+	testCases := []struct {
+		name     string
+		payments []payment
+	}{
+		{
+			name: "both directions",
+			payments: []payment{
+				{From: Initiator, Amount: 100, WantBalance: 100},
+				{From: Responder, Amount: 40, WantBalance: 60},
+				{From: Responder, Amount: 100, WantBalance: -40},
+			},
+		},
+		{
+			name: "zero amount",
+			payments: []payment{
+				{From: Initiator, Amount: 0, WantErr: state.ErrInvalidAmount, WantBalance: 0},
+				{From: Responder, Amount: 0, WantErr: state.ErrInvalidAmount, WantBalance: 0},
+				{From: Initiator, Amount: 1, WantBalance: 1},
+			},
+		},
+		{
+			name: "max out",
+			payments: []payment{
+				{From: Initiator, Amount: 1000, WantBalance: 1000},
+				{From: Initiator, Amount: 1, WantErr: state.ErrUnderfunded, WantBalance: 1000},
+				{From: Responder, Amount: 2000, WantBalance: -1000},
+				{From: Responder, Amount: 1, WantErr: state.ErrUnderfunded, WantBalance: -1000},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewChannelPair(PairConfig{InitiatorBalance: 1000, ResponderBalance: 1000})
+			_, err := p.Open(state.OpenParams{})
+			require.NoError(t, err)
+			for _, c := range []*state.Channel{p.Initiator, p.Responder} {
+				s, err := c.State()
+				require.NoError(t, err)
+				assert.Equal(t, state.StateOpen, s)
+			}
+
+			for i, pay := range tc.payments {
+				ca, err := p.Pay(pay.From, pay.Amount, nil)
+				if pay.WantErr != nil {
+					assert.ErrorIs(t, err, pay.WantErr, "payment %d", i)
+				} else {
+					require.NoError(t, err, "payment %d", i)
+					assert.True(t, ca.Envelope.ProposerSignatures.HasAllSignatures(), "payment %d", i)
+					assert.True(t, ca.Envelope.ConfirmerSignatures.HasAllSignatures(), "payment %d", i)
+				}
+				assert.Equal(t, pay.WantBalance, p.Initiator.Balance(), "payment %d", i)
+				assert.Equal(t, pay.WantBalance, p.Responder.Balance(), "payment %d", i)
+			}
+
+			ca, err := p.Close(Responder)
+			require.NoError(t, err)
+			assert.Zero(t, ca.Envelope.Details.ObservationPeriodTime)
+			assert.Zero(t, ca.Envelope.Details.ObservationPeriodLedgerGap)
+			assert.True(t, ca.Envelope.ConfirmerSignatures.HasAllSignatures())
+		})
+	}
+}
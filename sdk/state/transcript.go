@@ -0,0 +1,266 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/stellar/go/keypair"
+)
+
+// Participant identifies a participant of a channel.
+type Participant struct {
+	ChannelAccount *keypair.FromAddress
+	Signer         *keypair.FromAddress
+}
+
+// TranscriptConfig contains the information for verifying the agreements of a
+// channel between an initiator and responder.
+type TranscriptConfig struct {
+	NetworkPassphrase string
+	Initiator         Participant
+	Responder         Participant
+}
+
+// TranscriptResult is the outcome of the agreements verified by a
+// TranscriptVerifier.
+type TranscriptResult struct {
+	OpenAgreement        OpenAgreement
+	LatestCloseAgreement CloseAgreement
+
+	// Payments is the number of payments verified.
+	Payments int
+
+	// CoordinatedClose is true if the participants agreed to a coordinated
+	// close, else false.
+	CoordinatedClose bool
+
+	// Balance is the balance of the latest close agreement. See
+	// Channel.Balance.
+	Balance int64
+
+	// InitiatorBalance and ResponderBalance are the amounts each participant
+	// holds after the payments, relative to the contributions stated in the
+	// open agreement. If the open agreement states no contributions, they
+	// are the net amounts received by each participant.
+	InitiatorBalance int64
+	ResponderBalance int64
+}
+
+// TranscriptVerifier verifies the open agreement, payments, and coordinated
+// close of a channel as seen by a third party that holds no keys of the
+// participants. It rebuilds the transactions of each agreement from its
+// details and checks that both participants have signed them, and that each
+// agreement follows validly from the one before it.
+//
+// The verifier does not have the balances of the channel accounts on the
+// network, and so it does not check that participants were funded enough to
+// make each payment, or that the open executed successfully.
+type TranscriptVerifier struct {
+	networkPassphrase string
+	initiator         Participant
+	responder         Participant
+
+	open     OpenAgreement
+	latest   CloseAgreement
+	rejected int64
+	payments int
+	closed   bool
+}
+
+// NewTranscriptVerifier constructs a new TranscriptVerifier with the given
+// config.
+func NewTranscriptVerifier(c TranscriptConfig) *TranscriptVerifier {
+	return &TranscriptVerifier{
+		networkPassphrase: c.NetworkPassphrase,
+		initiator:         c.Initiator,
+		responder:         c.Responder,
+	}
+}
+
+func (v *TranscriptVerifier) txParticipants() txParticipants {
+	return txParticipants{
+		InitiatorSigner:         v.initiator.Signer,
+		ResponderSigner:         v.responder.Signer,
+		InitiatorChannelAccount: v.initiator.ChannelAccount,
+		ResponderChannelAccount: v.responder.ChannelAccount,
+	}
+}
+
+// VerifyOpen verifies an open agreement proposed by the initiator and
+// confirmed by the responder, holding the signatures of both.
+func (v *TranscriptVerifier) VerifyOpen(e OpenEnvelope) error {
+	if !v.open.Envelope.Empty() {
+		return fmt.Errorf("open agreement already verified")
+	}
+	if !e.Details.ProposingSigner.Equal(v.initiator.Signer) {
+		return fmt.Errorf("open agreement proposer is not the initiator")
+	}
+	if !e.Details.ConfirmingSigner.Equal(v.responder.Signer) {
+		return fmt.Errorf("open agreement confirmer is not the responder")
+	}
+	if e.Details.InitiatorContribution < 0 || e.Details.ResponderContribution < 0 {
+		return fmt.Errorf("open agreement contributions must not be less than 0")
+	}
+	txs, closeTxs, err := buildOpenTxs(v.networkPassphrase, v.txParticipants(), e.Details)
+	if err != nil {
+		return fmt.Errorf("building open agreement txs: %w", err)
+	}
+	err = e.ProposerSignatures.Verify(txs, closeTxs, e.Details.ProposingSigner)
+	if err != nil {
+		return fmt.Errorf("invalid open agreement signatures by proposer: %w", err)
+	}
+	err = e.ConfirmerSignatures.Verify(txs, closeTxs, e.Details.ConfirmingSigner)
+	if err != nil {
+		return fmt.Errorf("invalid open agreement signatures by confirmer: %w", err)
+	}
+	v.open = OpenAgreement{
+		Envelope:          e,
+		Transactions:      txs,
+		CloseTransactions: closeTxs,
+	}
+	v.latest = v.open.CloseAgreement()
+	return nil
+}
+
+// VerifyPayment verifies a payment agreement holding the signatures of both
+// participants. The payment must follow the latest agreement verified.
+func (v *TranscriptVerifier) VerifyPayment(e CloseEnvelope) error {
+	err := v.validateNext()
+	if err != nil {
+		return err
+	}
+	latest := v.latest.Envelope.Details
+	d := e.Details
+	if d.IterationNumber != v.nextIterationNumber() {
+		return fmt.Errorf("invalid payment iteration number, got: %d want: %d: %w", d.IterationNumber, v.nextIterationNumber(), ErrIterationGap)
+	}
+	if d.ObservationPeriodTime != latest.ObservationPeriodTime ||
+		d.ObservationPeriodLedgerGap != latest.ObservationPeriodLedgerGap {
+		return fmt.Errorf("invalid payment observation period: different than channel state")
+	}
+	if d.PaymentAmount < 0 {
+		return fmt.Errorf("payment amount must not be less than 0")
+	}
+	err = validateMemo(d.MemoType, d.Memo)
+	if err != nil {
+		return fmt.Errorf("invalid payment memo: %w", err)
+	}
+	wantBalance := latest.Balance + d.PaymentAmount
+	if d.ProposingSigner.Equal(v.responder.Signer) {
+		wantBalance = latest.Balance - d.PaymentAmount
+	}
+	if d.Balance != wantBalance {
+		return fmt.Errorf("payment amount %d does not move the balance from %d to %d", d.PaymentAmount, latest.Balance, d.Balance)
+	}
+	ca, err := v.verifyCloseEnvelope(e)
+	if err != nil {
+		return err
+	}
+	v.latest = ca
+	v.payments++
+	return nil
+}
+
+// VerifyRejectedPayment verifies that a payment proposal that was rejected by
+// the confirmer was for the next iteration, so that the iteration is skipped
+// by the next payment. See Channel.RejectPayment.
+func (v *TranscriptVerifier) VerifyRejectedPayment(e CloseEnvelope) error {
+	err := v.validateNext()
+	if err != nil {
+		return err
+	}
+	if e.Details.IterationNumber != v.nextIterationNumber() {
+		return fmt.Errorf("invalid rejected payment iteration number, got: %d want: %d: %w", e.Details.IterationNumber, v.nextIterationNumber(), ErrIterationGap)
+	}
+	v.rejected = e.Details.IterationNumber
+	return nil
+}
+
+// nextIterationNumber returns the iteration number of the next payment, which
+// follows the latest agreement and any rejected payment.
+func (v *TranscriptVerifier) nextIterationNumber() int64 {
+	next := v.latest.Envelope.Details.IterationNumber + 1
+	if v.rejected >= next {
+		next = v.rejected + 1
+	}
+	return next
+}
+
+// VerifyClose verifies a coordinated close agreement holding the signatures
+// of both participants. The close must agree to the same iteration and balance
+// as the latest agreement verified, without an observation period.
+func (v *TranscriptVerifier) VerifyClose(e CloseEnvelope) error {
+	err := v.validateNext()
+	if err != nil {
+		return err
+	}
+	latest := v.latest.Envelope.Details
+	d := e.Details
+	if d.IterationNumber != latest.IterationNumber {
+		return fmt.Errorf("close agreement iteration number does not match latest agreement")
+	}
+	if d.Balance != latest.Balance {
+		return fmt.Errorf("close agreement balance does not match latest agreement")
+	}
+	if d.ObservationPeriodTime != 0 || d.ObservationPeriodLedgerGap != 0 {
+		return fmt.Errorf("close agreement observation period is not zero")
+	}
+	ca, err := v.verifyCloseEnvelope(e)
+	if err != nil {
+		return err
+	}
+	v.latest = ca
+	v.closed = true
+	return nil
+}
+
+// validateNext checks that the channel is able to accept another agreement.
+func (v *TranscriptVerifier) validateNext() error {
+	if v.open.Envelope.Empty() {
+		return fmt.Errorf("open agreement not verified")
+	}
+	if v.closed {
+		return fmt.Errorf("agreement after coordinated close")
+	}
+	return nil
+}
+
+// verifyCloseEnvelope checks that the envelope is proposed and confirmed by
+// different participants of the channel, and that both have signed the
+// transactions built from the envelope's details.
+func (v *TranscriptVerifier) verifyCloseEnvelope(e CloseEnvelope) (CloseAgreement, error) {
+	d := e.Details
+	proposerIsParticipant := d.ProposingSigner.Equal(v.initiator.Signer) || d.ProposingSigner.Equal(v.responder.Signer)
+	confirmerIsParticipant := d.ConfirmingSigner.Equal(v.initiator.Signer) || d.ConfirmingSigner.Equal(v.responder.Signer)
+	if !proposerIsParticipant || !confirmerIsParticipant || d.ProposingSigner.Equal(d.ConfirmingSigner) {
+		return CloseAgreement{}, fmt.Errorf("close agreement must be proposed and confirmed by different participants")
+	}
+	txs, err := buildCloseTxs(v.networkPassphrase, v.txParticipants(), v.open.Envelope.Details, d)
+	if err != nil {
+		return CloseAgreement{}, fmt.Errorf("building close agreement txs: %w", err)
+	}
+	ca := CloseAgreement{Envelope: e, Transactions: txs}
+	err = VerifyCloseAgreement(ca, d.ProposingSigner)
+	if err != nil {
+		return CloseAgreement{}, err
+	}
+	err = VerifyCloseAgreement(ca, d.ConfirmingSigner)
+	if err != nil {
+		return CloseAgreement{}, err
+	}
+	return ca, nil
+}
+
+// Result returns the outcome of the agreements verified so far.
+func (v *TranscriptVerifier) Result() TranscriptResult {
+	od := v.open.Envelope.Details
+	balance := v.latest.Envelope.Details.Balance
+	return TranscriptResult{
+		OpenAgreement:        v.open,
+		LatestCloseAgreement: v.latest,
+		Payments:             v.payments,
+		CoordinatedClose:     v.closed,
+		Balance:              balance,
+		InitiatorBalance:     od.InitiatorContribution - balance,
+		ResponderBalance:     od.ResponderContribution + balance,
+	}
+}
@@ -0,0 +1,174 @@
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscriptVerifier(t *testing.T) {
+	initiatorSigner := keypair.MustRandom()
+	responderSigner := keypair.MustRandom()
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	responderChannelAccount := keypair.MustRandom().FromAddress()
+	config := TranscriptConfig{
+		NetworkPassphrase: network.TestNetworkPassphrase,
+		Initiator:         Participant{ChannelAccount: initiatorChannelAccount, Signer: initiatorSigner.FromAddress()},
+		Responder:         Participant{ChannelAccount: responderChannelAccount, Signer: responderSigner.FromAddress()},
+	}
+
+	// Build the open agreement signed by both participants.
+	initiatorChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		Initiator:            true,
+		LocalSigner:          initiatorSigner,
+		RemoteSigner:         responderSigner.FromAddress(),
+		LocalChannelAccount:  initiatorChannelAccount,
+		RemoteChannelAccount: responderChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	responderChannel := NewChannel(Config{
+		NetworkPassphrase:    network.TestNetworkPassphrase,
+		LocalSigner:          responderSigner,
+		RemoteSigner:         initiatorSigner.FromAddress(),
+		LocalChannelAccount:  responderChannelAccount,
+		RemoteChannelAccount: initiatorChannelAccount,
+		MaxOpenExpiry:        2 * time.Hour,
+	})
+	open, err := initiatorChannel.ProposeOpen(OpenParams{
+		Asset:                      NativeAsset,
+		ExpiresAt:                  time.Now().Add(5 * time.Minute),
+		StartingSequence:           101,
+		ObservationPeriodTime:      10,
+		ObservationPeriodLedgerGap: 10,
+		InitiatorContribution:      100,
+		ResponderContribution:      50,
+	})
+	require.NoError(t, err)
+	open, err = responderChannel.ConfirmOpen(open.Envelope)
+	require.NoError(t, err)
+	openDetails := open.Envelope.Details
+
+	// agree builds a close envelope signed by both participants.
+	agree := func(d CloseDetails, proposer, confirmer *keypair.Full) CloseEnvelope {
+		d.ProposingSigner = proposer.FromAddress()
+		d.ConfirmingSigner = confirmer.FromAddress()
+		txs, err := buildCloseTxs(network.TestNetworkPassphrase, initiatorChannel.txParticipants(), openDetails, d)
+		require.NoError(t, err)
+		proposerSigs, err := signCloseAgreementTxs(txs, proposer)
+		require.NoError(t, err)
+		confirmerSigs, err := signCloseAgreementTxs(txs, confirmer)
+		require.NoError(t, err)
+		return CloseEnvelope{Details: d, ProposerSignatures: proposerSigs, ConfirmerSignatures: confirmerSigs}
+	}
+	payment := func(iteration, balance, amount int64, proposer, confirmer *keypair.Full) CloseEnvelope {
+		return agree(CloseDetails{
+			ObservationPeriodTime:      10,
+			ObservationPeriodLedgerGap: 10,
+			IterationNumber:            iteration,
+			Balance:                    balance,
+			PaymentAmount:              amount,
+		}, proposer, confirmer)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		require.NoError(t, v.VerifyPayment(payment(2, 30, 30, initiatorSigner, responderSigner)))
+		require.NoError(t, v.VerifyPayment(payment(3, 20, 10, responderSigner, initiatorSigner)))
+		require.NoError(t, v.VerifyClose(agree(CloseDetails{IterationNumber: 3, Balance: 20}, initiatorSigner, responderSigner)))
+
+		r := v.Result()
+		assert.True(t, r.OpenAgreement.Envelope.Equal(open.Envelope))
+		assert.Equal(t, 2, r.Payments)
+		assert.True(t, r.CoordinatedClose)
+		assert.Equal(t, int64(20), r.Balance)
+		assert.Equal(t, int64(80), r.InitiatorBalance)
+		assert.Equal(t, int64(70), r.ResponderBalance)
+
+		// Nothing is accepted after a coordinated close.
+		err := v.VerifyPayment(payment(4, 30, 10, initiatorSigner, responderSigner))
+		assert.EqualError(t, err, "agreement after coordinated close")
+	})
+
+	t.Run("openMissingConfirmerSignatures", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		e := open.Envelope
+		e.ConfirmerSignatures = OpenSignatures{}
+		err := v.VerifyOpen(e)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid open agreement signatures by confirmer")
+	})
+
+	t.Run("openProposedByResponder", func(t *testing.T) {
+		v := NewTranscriptVerifier(TranscriptConfig{
+			NetworkPassphrase: network.TestNetworkPassphrase,
+			Initiator:         config.Responder,
+			Responder:         config.Initiator,
+		})
+		err := v.VerifyOpen(open.Envelope)
+		assert.EqualError(t, err, "open agreement proposer is not the initiator")
+	})
+
+	t.Run("paymentBeforeOpen", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		err := v.VerifyPayment(payment(2, 30, 30, initiatorSigner, responderSigner))
+		assert.EqualError(t, err, "open agreement not verified")
+	})
+
+	t.Run("paymentIterationGap", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		err := v.VerifyPayment(payment(3, 30, 30, initiatorSigner, responderSigner))
+		assert.ErrorIs(t, err, ErrIterationGap)
+	})
+
+	t.Run("paymentToProposer", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		err := v.VerifyPayment(payment(2, -30, 30, initiatorSigner, responderSigner))
+		assert.EqualError(t, err, "payment amount 30 does not move the balance from 0 to -30")
+	})
+
+	t.Run("paymentSignedByOneParticipant", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		e := payment(2, 30, 30, initiatorSigner, responderSigner)
+		e.ConfirmerSignatures = CloseSignatures{}
+		err := v.VerifyPayment(e)
+		assert.EqualError(t, err, "close agreement is missing signatures by "+responderSigner.Address())
+	})
+
+	t.Run("paymentSignaturesForDifferentDetails", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		e := payment(2, 30, 30, initiatorSigner, responderSigner)
+		e.Details.Balance = 40
+		e.Details.PaymentAmount = 40
+		err := v.VerifyPayment(e)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid declaration signature by "+initiatorSigner.Address())
+	})
+
+	t.Run("rejectedPaymentSkipsIteration", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		require.NoError(t, v.VerifyRejectedPayment(payment(2, 30, 30, initiatorSigner, responderSigner)))
+		err := v.VerifyPayment(payment(2, 30, 30, initiatorSigner, responderSigner))
+		assert.ErrorIs(t, err, ErrIterationGap)
+		require.NoError(t, v.VerifyPayment(payment(3, 10, 10, initiatorSigner, responderSigner)))
+		assert.Equal(t, 1, v.Result().Payments)
+		assert.Equal(t, int64(10), v.Result().Balance)
+	})
+
+	t.Run("closeWithDifferentBalance", func(t *testing.T) {
+		v := NewTranscriptVerifier(config)
+		require.NoError(t, v.VerifyOpen(open.Envelope))
+		err := v.VerifyClose(agree(CloseDetails{IterationNumber: 1, Balance: 10}, initiatorSigner, responderSigner))
+		assert.EqualError(t, err, "close agreement balance does not match latest agreement")
+	})
+}
@@ -1,6 +1,8 @@
 package state
 
 import (
+	"fmt"
+
 	"github.com/stellar/go/keypair"
 	"github.com/stellar/go/xdr"
 	"golang.org/x/sync/errgroup"
@@ -22,3 +24,31 @@ func verifySignatures(inputs []signatureVerificationInput) error {
 	}
 	return g.Wait()
 }
+
+// VerifyCloseAgreement checks that the agreement holds valid signatures by the
+// signer for the agreement's declaration and close transaction hashes. The
+// signer must be the proposing or confirming signer of the agreement.
+//
+// The transaction hashes are taken from the agreement as is and are not
+// rebuilt from the agreement's details, so callers receiving agreements from
+// an untrusted source should also check the hashes match the transactions
+// they expect.
+func VerifyCloseAgreement(agreement CloseAgreement, signer *keypair.FromAddress) error {
+	sigs := agreement.Envelope.SignaturesFor(signer)
+	if sigs == nil {
+		return fmt.Errorf("%s is not a signer of the close agreement", signer.Address())
+	}
+	if !sigs.HasAllSignatures() {
+		return fmt.Errorf("close agreement is missing signatures by %s", signer.Address())
+	}
+	txs := agreement.Transactions
+	err := signer.Verify(txs.DeclarationHash[:], []byte(sigs.Declaration))
+	if err != nil {
+		return fmt.Errorf("invalid declaration signature by %s: %w", signer.Address(), err)
+	}
+	err = signer.Verify(txs.CloseHash[:], []byte(sigs.Close))
+	if err != nil {
+		return fmt.Errorf("invalid close signature by %s: %w", signer.Address(), err)
+	}
+	return nil
+}
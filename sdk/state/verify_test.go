@@ -0,0 +1,70 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCloseAgreement(t *testing.T) {
+	proposer := keypair.MustRandom()
+	confirmer := keypair.MustRandom()
+	other := keypair.MustRandom()
+
+	txs := CloseTransactions{
+		DeclarationHash: TransactionHash{1},
+		CloseHash:       TransactionHash{2},
+	}
+	proposerSigs, err := signCloseAgreementTxs(txs, proposer)
+	require.NoError(t, err)
+	confirmerSigs, err := signCloseAgreementTxs(txs, confirmer)
+	require.NoError(t, err)
+
+	agreement := CloseAgreement{
+		Envelope: CloseEnvelope{
+			Details: CloseDetails{
+				ProposingSigner:  proposer.FromAddress(),
+				ConfirmingSigner: confirmer.FromAddress(),
+			},
+			ProposerSignatures:  proposerSigs,
+			ConfirmerSignatures: confirmerSigs,
+		},
+		Transactions: txs,
+	}
+
+	// Valid signatures by both signers are accepted.
+	assert.NoError(t, VerifyCloseAgreement(agreement, proposer.FromAddress()))
+	assert.NoError(t, VerifyCloseAgreement(agreement, confirmer.FromAddress()))
+
+	// Verifying a key that is not a signer errors.
+	err = VerifyCloseAgreement(agreement, other.FromAddress())
+	assert.EqualError(t, err, other.Address()+" is not a signer of the close agreement")
+
+	// Missing signatures error.
+	{
+		ca := agreement
+		ca.Envelope.ConfirmerSignatures = CloseSignatures{Declaration: confirmerSigs.Declaration}
+		err = VerifyCloseAgreement(ca, confirmer.FromAddress())
+		assert.EqualError(t, err, "close agreement is missing signatures by "+confirmer.Address())
+	}
+
+	// Signatures by another key error.
+	{
+		otherSigs, err := signCloseAgreementTxs(txs, other)
+		require.NoError(t, err)
+		ca := agreement
+		ca.Envelope.ConfirmerSignatures = CloseSignatures{Declaration: otherSigs.Declaration, Close: confirmerSigs.Close}
+		err = VerifyCloseAgreement(ca, confirmer.FromAddress())
+		assert.EqualError(t, err, "invalid declaration signature by "+confirmer.Address()+": signature verification failed")
+	}
+
+	// Signatures for other transaction hashes error.
+	{
+		ca := agreement
+		ca.Transactions.CloseHash = TransactionHash{3}
+		err = VerifyCloseAgreement(ca, proposer.FromAddress())
+		assert.EqualError(t, err, "invalid close signature by "+proposer.Address()+": signature verification failed")
+	}
+}
@@ -21,6 +21,9 @@ type CloseParams struct {
 	AmountToInitiator          int64
 	AmountToResponder          int64
 	Asset                      txnbuild.Asset
+	// Memo is the memo of the close transaction, such as the memo of the
+	// payment that the closing agreement was made with. It is optional.
+	Memo txnbuild.Memo
 }
 
 func Close(p CloseParams) (*txnbuild.Transaction, error) {
@@ -40,6 +43,7 @@ func Close(p CloseParams) (*txnbuild.Transaction, error) {
 			Sequence:  seq,
 		},
 		BaseFee:              0,
+		Memo:                 p.Memo,
 		Timebounds:           txnbuild.NewInfiniteTimeout(),
 		MinSequenceAge:       int64(p.ObservationPeriodTime.Seconds()),
 		MinSequenceLedgerGap: p.ObservationPeriodLedgerGap,
@@ -4,7 +4,10 @@ import (
 	"math"
 	"testing"
 
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestClose_iterationNumber_checkNonNegative(t *testing.T) {
@@ -27,3 +30,24 @@ func TestClose_startSequenceOfIteration_checkNonNegative(t *testing.T) {
 	})
 	assert.EqualError(t, err, "invalid sequence number: cannot be negative")
 }
+
+func TestClose_memo(t *testing.T) {
+	p := CloseParams{
+		InitiatorSigner:         keypair.MustRandom().FromAddress(),
+		ResponderSigner:         keypair.MustRandom().FromAddress(),
+		InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		StartSequence:           101,
+		IterationNumber:         2,
+		AmountToResponder:       10,
+		Asset:                   txnbuild.NativeAsset{},
+	}
+	tx, err := Close(p)
+	require.NoError(t, err)
+	assert.Nil(t, tx.Memo())
+
+	p.Memo = txnbuild.MemoID(7)
+	tx, err = Close(p)
+	require.NoError(t, err)
+	assert.Equal(t, txnbuild.MemoID(7), tx.Memo())
+}
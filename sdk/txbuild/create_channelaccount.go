@@ -1,6 +1,7 @@
 package txbuild
 
 import (
+	"fmt"
 	"math"
 
 	"github.com/stellar/go/amount"
@@ -13,18 +14,52 @@ type CreateChannelAccountParams struct {
 	ChannelAccount *keypair.FromAddress
 	SequenceNumber int64
 	Asset          txnbuild.BasicAsset
+
+	// TrustLimit is the limit of the trustline established for the Asset when
+	// the Asset is not native. If zero, the trustline has the maximum limit.
+	TrustLimit int64
+
+	// BaseReserve is the base reserve of the network. If zero, the reserves of
+	// the channel account are sponsored by the Creator. If non-zero, the
+	// channel account is not sponsored and is instead funded with the minimum
+	// balance required to cover the reserves of the account and the subentries
+	// it holds once a channel is open.
+	BaseReserve int64
 }
 
 func CreateChannelAccount(p CreateChannelAccountParams) (*txnbuild.Transaction, error) {
-	ops := []txnbuild.Operation{
-		&txnbuild.BeginSponsoringFutureReserves{
-			SponsoredID: p.ChannelAccount.Address(),
-		},
-		&txnbuild.CreateAccount{
+	if p.TrustLimit < 0 {
+		return nil, fmt.Errorf("invalid trust limit: cannot be negative")
+	}
+	if p.BaseReserve < 0 {
+		return nil, fmt.Errorf("invalid base reserve: cannot be negative")
+	}
+	trustLimit := p.TrustLimit
+	if trustLimit == 0 {
+		trustLimit = math.MaxInt64
+	}
+	sponsored := p.BaseReserve == 0
+
+	ops := []txnbuild.Operation{}
+	if sponsored {
+		ops = append(ops,
+			&txnbuild.BeginSponsoringFutureReserves{
+				SponsoredID: p.ChannelAccount.Address(),
+			},
+			&txnbuild.CreateAccount{
+				Destination: p.ChannelAccount.Address(),
+				// base reserves sponsored by p.Creator
+				Amount: "0",
+			},
+		)
+	} else {
+		minBalance := MinimumBalance(p.BaseReserve, ChannelAccountSubentries(p.Asset))
+		ops = append(ops, &txnbuild.CreateAccount{
 			Destination: p.ChannelAccount.Address(),
-			// base reserves sponsored by p.Creator
-			Amount: "0",
-		},
+			Amount:      amount.StringFromInt64(minBalance),
+		})
+	}
+	ops = append(ops,
 		&txnbuild.SetOptions{
 			SourceAccount:   p.ChannelAccount.Address(),
 			MasterWeight:    txnbuild.NewThreshold(0),
@@ -33,17 +68,19 @@ func CreateChannelAccount(p CreateChannelAccountParams) (*txnbuild.Transaction,
 			HighThreshold:   txnbuild.NewThreshold(1),
 			Signer:          &txnbuild.Signer{Address: p.Creator.Address(), Weight: 1},
 		},
-	}
+	)
 	if !p.Asset.IsNative() {
 		ops = append(ops, &txnbuild.ChangeTrust{
 			Line:          p.Asset.MustToChangeTrustAsset(),
-			Limit:         amount.StringFromInt64(math.MaxInt64),
+			Limit:         amount.StringFromInt64(trustLimit),
+			SourceAccount: p.ChannelAccount.Address(),
+		})
+	}
+	if sponsored {
+		ops = append(ops, &txnbuild.EndSponsoringFutureReserves{
 			SourceAccount: p.ChannelAccount.Address(),
 		})
 	}
-	ops = append(ops, &txnbuild.EndSponsoringFutureReserves{
-		SourceAccount: p.ChannelAccount.Address(),
-	})
 
 	tx, err := txnbuild.NewTransaction(
 		txnbuild.TransactionParams{
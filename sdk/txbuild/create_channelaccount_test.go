@@ -0,0 +1,121 @@
+package txbuild
+
+import (
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateChannelAccount_native(t *testing.T) {
+	creator := keypair.MustRandom().FromAddress()
+	channelAccount := keypair.MustRandom().FromAddress()
+
+	tx, err := CreateChannelAccount(CreateChannelAccountParams{
+		Creator:        creator,
+		ChannelAccount: channelAccount,
+		SequenceNumber: 101,
+		Asset:          txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+
+	ops := tx.Operations()
+	require.Len(t, ops, 4)
+	assert.IsType(t, &txnbuild.BeginSponsoringFutureReserves{}, ops[0])
+	assert.IsType(t, &txnbuild.CreateAccount{}, ops[1])
+	assert.IsType(t, &txnbuild.SetOptions{}, ops[2])
+	assert.IsType(t, &txnbuild.EndSponsoringFutureReserves{}, ops[3])
+}
+
+func TestCreateChannelAccount_credit(t *testing.T) {
+	creator := keypair.MustRandom().FromAddress()
+	channelAccount := keypair.MustRandom().FromAddress()
+	asset := txnbuild.CreditAsset{Code: "ABDC", Issuer: keypair.MustRandom().Address()}
+
+	testCases := []struct {
+		trustLimit int64
+		wantLimit  string
+	}{
+		{0, "922337203685.4775807"},
+		{1000_0000000, "1000.0000000"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.wantLimit, func(t *testing.T) {
+			tx, err := CreateChannelAccount(CreateChannelAccountParams{
+				Creator:        creator,
+				ChannelAccount: channelAccount,
+				SequenceNumber: 101,
+				Asset:          asset,
+				TrustLimit:     tc.trustLimit,
+			})
+			require.NoError(t, err)
+
+			ops := tx.Operations()
+			require.Len(t, ops, 5)
+			assert.IsType(t, &txnbuild.BeginSponsoringFutureReserves{}, ops[0])
+			assert.IsType(t, &txnbuild.CreateAccount{}, ops[1])
+			assert.IsType(t, &txnbuild.SetOptions{}, ops[2])
+			require.IsType(t, &txnbuild.ChangeTrust{}, ops[3])
+			assert.IsType(t, &txnbuild.EndSponsoringFutureReserves{}, ops[4])
+
+			changeTrust := ops[3].(*txnbuild.ChangeTrust)
+			assert.Equal(t, channelAccount.Address(), changeTrust.SourceAccount)
+			assert.Equal(t, asset.MustToChangeTrustAsset(), changeTrust.Line)
+			assert.Equal(t, tc.wantLimit, changeTrust.Limit)
+		})
+	}
+}
+
+func TestCreateChannelAccount_trustLimit_checkNonNegative(t *testing.T) {
+	_, err := CreateChannelAccount(CreateChannelAccountParams{
+		Creator:        keypair.MustRandom().FromAddress(),
+		ChannelAccount: keypair.MustRandom().FromAddress(),
+		SequenceNumber: 101,
+		Asset:          txnbuild.NativeAsset{},
+		TrustLimit:     -1,
+	})
+	assert.EqualError(t, err, "invalid trust limit: cannot be negative")
+}
+
+func TestCreateChannelAccount_baseReserve(t *testing.T) {
+	creator := keypair.MustRandom().FromAddress()
+	channelAccount := keypair.MustRandom().FromAddress()
+
+	testCases := []struct {
+		asset      txnbuild.BasicAsset
+		wantOps    int
+		wantAmount string
+	}{
+		{txnbuild.NativeAsset{}, 2, "2.0000000"},
+		{txnbuild.CreditAsset{Code: "ABDC", Issuer: keypair.MustRandom().Address()}, 3, "2.5000000"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.wantAmount, func(t *testing.T) {
+			tx, err := CreateChannelAccount(CreateChannelAccountParams{
+				Creator:        creator,
+				ChannelAccount: channelAccount,
+				SequenceNumber: 101,
+				Asset:          tc.asset,
+				BaseReserve:    5000000,
+			})
+			require.NoError(t, err)
+
+			// The channel account is funded with its minimum balance instead of
+			// being sponsored.
+			ops := tx.Operations()
+			require.Len(t, ops, tc.wantOps)
+			require.IsType(t, &txnbuild.CreateAccount{}, ops[0])
+			createAccount := ops[0].(*txnbuild.CreateAccount)
+			assert.Equal(t, channelAccount.Address(), createAccount.Destination)
+			assert.Equal(t, tc.wantAmount, createAccount.Amount)
+			for _, op := range ops {
+				assert.NotEqual(t, &txnbuild.BeginSponsoringFutureReserves{}, op)
+				assert.NotEqual(t, &txnbuild.EndSponsoringFutureReserves{}, op)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package txbuild
 
 import (
+	"fmt"
 	"math"
 	"time"
 
@@ -21,9 +22,43 @@ type OpenParams struct {
 	DeclarationTxHash       [32]byte
 	CloseTxHash             [32]byte
 	ConfirmingSigner        *keypair.FromAddress
+	SignerScheme            SignerScheme
+
+	// InitiatorTrustLimit and ResponderTrustLimit are the limits of the
+	// trustlines the open establishes for the Asset on the initiator's and
+	// responder's channel accounts when the Asset is not native. If zero,
+	// the trustline has the maximum limit.
+	InitiatorTrustLimit int64
+	ResponderTrustLimit int64
 }
 
 func Open(p OpenParams) (*txnbuild.Transaction, error) {
+	// The open transaction must expire at the same time the open agreement
+	// expires, so that the open cannot be submitted after either participant
+	// considers the open expired. A zero or pre-epoch expiry would result in
+	// a max time that never expires.
+	if p.ExpiresAt.Unix() <= 0 {
+		return nil, fmt.Errorf("invalid expires at: must be after unix epoch")
+	}
+
+	scheme := p.SignerScheme.OrDefault()
+	err := scheme.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.InitiatorTrustLimit < 0 || p.ResponderTrustLimit < 0 {
+		return nil, fmt.Errorf("invalid trust limit: cannot be negative")
+	}
+	initiatorTrustLimit := p.InitiatorTrustLimit
+	if initiatorTrustLimit == 0 {
+		initiatorTrustLimit = math.MaxInt64
+	}
+	responderTrustLimit := p.ResponderTrustLimit
+	if responderTrustLimit == 0 {
+		responderTrustLimit = math.MaxInt64
+	}
+
 	// Build the list of extra signatures required for signing the open
 	// transaction that will be required in addition to the signers for the
 	// account signers. The extra signers will be signatures by the confirming
@@ -32,7 +67,7 @@ func Open(p OpenParams) (*txnbuild.Transaction, error) {
 	// open transaction. This prevents the confirming signer from
 	// withholding signatures for the declaration and closing transactions.
 	extraSignerKeys := [2]xdr.SignerKey{}
-	err := extraSignerKeys[0].SetSignedPayload(p.ConfirmingSigner.Address(), p.DeclarationTxHash[:])
+	err = extraSignerKeys[0].SetSignedPayload(p.ConfirmingSigner.Address(), p.DeclarationTxHash[:])
 	if err != nil {
 		return nil, err
 	}
@@ -64,16 +99,16 @@ func Open(p OpenParams) (*txnbuild.Transaction, error) {
 	tp.Operations = append(tp.Operations, &txnbuild.BeginSponsoringFutureReserves{SourceAccount: p.InitiatorSigner.Address(), SponsoredID: p.InitiatorChannelAccount.Address()})
 	tp.Operations = append(tp.Operations, &txnbuild.SetOptions{
 		SourceAccount:   p.InitiatorChannelAccount.Address(),
-		MasterWeight:    txnbuild.NewThreshold(0),
-		LowThreshold:    txnbuild.NewThreshold(2),
-		MediumThreshold: txnbuild.NewThreshold(2),
-		HighThreshold:   txnbuild.NewThreshold(2),
-		Signer:          &txnbuild.Signer{Address: p.InitiatorSigner.Address(), Weight: 1},
+		MasterWeight:    txnbuild.NewThreshold(txnbuild.Threshold(scheme.MasterWeight)),
+		LowThreshold:    txnbuild.NewThreshold(txnbuild.Threshold(scheme.Threshold)),
+		MediumThreshold: txnbuild.NewThreshold(txnbuild.Threshold(scheme.Threshold)),
+		HighThreshold:   txnbuild.NewThreshold(txnbuild.Threshold(scheme.Threshold)),
+		Signer:          &txnbuild.Signer{Address: p.InitiatorSigner.Address(), Weight: txnbuild.Threshold(scheme.SignerWeight)},
 	})
 	if !p.Asset.IsNative() {
 		tp.Operations = append(tp.Operations, &txnbuild.ChangeTrust{
 			Line:          p.Asset.MustToChangeTrustAsset(),
-			Limit:         amount.StringFromInt64(math.MaxInt64),
+			Limit:         amount.StringFromInt64(initiatorTrustLimit),
 			SourceAccount: p.InitiatorChannelAccount.Address(),
 		})
 	}
@@ -83,7 +118,7 @@ func Open(p OpenParams) (*txnbuild.Transaction, error) {
 	tp.Operations = append(tp.Operations, &txnbuild.BeginSponsoringFutureReserves{SourceAccount: p.InitiatorSigner.Address(), SponsoredID: p.ResponderChannelAccount.Address()})
 	tp.Operations = append(tp.Operations, &txnbuild.SetOptions{
 		SourceAccount: p.ResponderChannelAccount.Address(),
-		Signer:        &txnbuild.Signer{Address: p.InitiatorSigner.Address(), Weight: 1},
+		Signer:        &txnbuild.Signer{Address: p.InitiatorSigner.Address(), Weight: txnbuild.Threshold(scheme.SignerWeight)},
 	})
 	tp.Operations = append(tp.Operations, &txnbuild.EndSponsoringFutureReserves{SourceAccount: p.ResponderChannelAccount.Address()})
 
@@ -91,16 +126,16 @@ func Open(p OpenParams) (*txnbuild.Transaction, error) {
 	tp.Operations = append(tp.Operations, &txnbuild.BeginSponsoringFutureReserves{SourceAccount: p.ResponderSigner.Address(), SponsoredID: p.ResponderChannelAccount.Address()})
 	tp.Operations = append(tp.Operations, &txnbuild.SetOptions{
 		SourceAccount:   p.ResponderChannelAccount.Address(),
-		MasterWeight:    txnbuild.NewThreshold(0),
-		LowThreshold:    txnbuild.NewThreshold(2),
-		MediumThreshold: txnbuild.NewThreshold(2),
-		HighThreshold:   txnbuild.NewThreshold(2),
-		Signer:          &txnbuild.Signer{Address: p.ResponderSigner.Address(), Weight: 1},
+		MasterWeight:    txnbuild.NewThreshold(txnbuild.Threshold(scheme.MasterWeight)),
+		LowThreshold:    txnbuild.NewThreshold(txnbuild.Threshold(scheme.Threshold)),
+		MediumThreshold: txnbuild.NewThreshold(txnbuild.Threshold(scheme.Threshold)),
+		HighThreshold:   txnbuild.NewThreshold(txnbuild.Threshold(scheme.Threshold)),
+		Signer:          &txnbuild.Signer{Address: p.ResponderSigner.Address(), Weight: txnbuild.Threshold(scheme.SignerWeight)},
 	})
 	if !p.Asset.IsNative() {
 		tp.Operations = append(tp.Operations, &txnbuild.ChangeTrust{
 			Line:          p.Asset.MustToChangeTrustAsset(),
-			Limit:         amount.StringFromInt64(math.MaxInt64),
+			Limit:         amount.StringFromInt64(responderTrustLimit),
 			SourceAccount: p.ResponderChannelAccount.Address(),
 		})
 	}
@@ -110,7 +145,7 @@ func Open(p OpenParams) (*txnbuild.Transaction, error) {
 	tp.Operations = append(tp.Operations, &txnbuild.BeginSponsoringFutureReserves{SourceAccount: p.ResponderSigner.Address(), SponsoredID: p.InitiatorChannelAccount.Address()})
 	tp.Operations = append(tp.Operations, &txnbuild.SetOptions{
 		SourceAccount: p.InitiatorChannelAccount.Address(),
-		Signer:        &txnbuild.Signer{Address: p.ResponderSigner.Address(), Weight: 1},
+		Signer:        &txnbuild.Signer{Address: p.ResponderSigner.Address(), Weight: txnbuild.Threshold(scheme.SignerWeight)},
 	})
 	tp.Operations = append(tp.Operations, &txnbuild.EndSponsoringFutureReserves{SourceAccount: p.InitiatorChannelAccount.Address()})
 
@@ -0,0 +1,128 @@
+package txbuild
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpen_timeboundsMatchExpiresAt(t *testing.T) {
+	expiresAt := time.Now().Add(5 * time.Minute)
+	tx, err := Open(OpenParams{
+		InitiatorSigner:         keypair.MustRandom().FromAddress(),
+		ResponderSigner:         keypair.MustRandom().FromAddress(),
+		InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+		ExpiresAt:               expiresAt,
+		ConfirmingSigner:        keypair.MustRandom().FromAddress(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), tx.Timebounds().MinTime)
+	assert.Equal(t, expiresAt.Unix(), tx.Timebounds().MaxTime)
+}
+
+func TestOpen_expiresAt_checkAfterEpoch(t *testing.T) {
+	testCases := []time.Time{
+		{},
+		time.Unix(0, 0),
+		time.Unix(-1, 0),
+	}
+	for _, expiresAt := range testCases {
+		_, err := Open(OpenParams{
+			InitiatorSigner:         keypair.MustRandom().FromAddress(),
+			ResponderSigner:         keypair.MustRandom().FromAddress(),
+			InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+			ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+			StartSequence:           101,
+			Asset:                   txnbuild.NativeAsset{},
+			ExpiresAt:               expiresAt,
+			ConfirmingSigner:        keypair.MustRandom().FromAddress(),
+		})
+		assert.EqualError(t, err, "invalid expires at: must be after unix epoch")
+	}
+}
+
+func TestOpen_signerScheme(t *testing.T) {
+	initiatorSigner := keypair.MustRandom().FromAddress()
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	params := OpenParams{
+		InitiatorSigner:         initiatorSigner,
+		ResponderSigner:         keypair.MustRandom().FromAddress(),
+		InitiatorChannelAccount: initiatorChannelAccount,
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		StartSequence:           101,
+		Asset:                   txnbuild.NativeAsset{},
+		ExpiresAt:               time.Now().Add(5 * time.Minute),
+		ConfirmingSigner:        keypair.MustRandom().FromAddress(),
+		SignerScheme:            SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4},
+	}
+
+	tx, err := Open(params)
+	require.NoError(t, err)
+	setOptions := tx.Operations()[1].(*txnbuild.SetOptions)
+	assert.Equal(t, initiatorChannelAccount.Address(), setOptions.SourceAccount)
+	assert.Equal(t, txnbuild.Threshold(1), *setOptions.MasterWeight)
+	assert.Equal(t, txnbuild.Threshold(4), *setOptions.LowThreshold)
+	assert.Equal(t, txnbuild.Threshold(4), *setOptions.MediumThreshold)
+	assert.Equal(t, txnbuild.Threshold(4), *setOptions.HighThreshold)
+	assert.Equal(t, &txnbuild.Signer{Address: initiatorSigner.Address(), Weight: 2}, setOptions.Signer)
+
+	// A scheme that lets one participant meet the thresholds alone is
+	// rejected.
+	params.SignerScheme = SignerScheme{MasterWeight: 2, SignerWeight: 2, Threshold: 4}
+	_, err = Open(params)
+	assert.EqualError(t, err, "invalid signer scheme: master weight 2 and one signer with weight 2 meet threshold 4")
+}
+
+func TestOpen_trustLimit(t *testing.T) {
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	responderChannelAccount := keypair.MustRandom().FromAddress()
+	asset := txnbuild.CreditAsset{Code: "ABC", Issuer: "GAU4CFXQI6HLK5PPY2JWU3GMRJIIQNLF24XRAHX235F7QTG6BEKLGQ36"}
+	params := OpenParams{
+		InitiatorSigner:         keypair.MustRandom().FromAddress(),
+		ResponderSigner:         keypair.MustRandom().FromAddress(),
+		InitiatorChannelAccount: initiatorChannelAccount,
+		ResponderChannelAccount: responderChannelAccount,
+		StartSequence:           101,
+		Asset:                   asset,
+		ExpiresAt:               time.Now().Add(5 * time.Minute),
+		ConfirmingSigner:        keypair.MustRandom().FromAddress(),
+	}
+	changeTrustLimits := func(tx *txnbuild.Transaction) map[string]string {
+		limits := map[string]string{}
+		for _, op := range tx.Operations() {
+			if ct, ok := op.(*txnbuild.ChangeTrust); ok {
+				limits[ct.SourceAccount] = ct.Limit
+			}
+		}
+		return limits
+	}
+
+	// Without limits the trustlines have the maximum limit.
+	tx, err := Open(params)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		initiatorChannelAccount.Address(): "922337203685.4775807",
+		responderChannelAccount.Address(): "922337203685.4775807",
+	}, changeTrustLimits(tx))
+
+	// The limit of each participant's trustline is set.
+	params.InitiatorTrustLimit = 1000_0000000
+	params.ResponderTrustLimit = 50_0000000
+	tx, err = Open(params)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		initiatorChannelAccount.Address(): "1000.0000000",
+		responderChannelAccount.Address(): "50.0000000",
+	}, changeTrustLimits(tx))
+
+	params.ResponderTrustLimit = -1
+	_, err = Open(params)
+	assert.EqualError(t, err, "invalid trust limit: cannot be negative")
+}
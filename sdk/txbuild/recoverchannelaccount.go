@@ -0,0 +1,67 @@
+package txbuild
+
+import (
+	"fmt"
+
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+)
+
+type RecoverChannelAccountParams struct {
+	ChannelAccount *keypair.FromAddress
+	Creator        *keypair.FromAddress
+	SequenceNumber int64
+	Asset          txnbuild.Asset
+	AssetBalance   int64
+}
+
+// RecoverChannelAccount builds a transaction that returns the funds of a
+// channel account that never became part of an open channel to its creator.
+// If the asset is not native, the channel account's balance of the asset is
+// paid to the creator and the trustline is removed, so that the channel
+// account can then be merged into the creator.
+//
+// The channel account must still be in the state CreateChannelAccount leaves
+// it in, with the creator as its only signer, and so the transaction must be
+// signed by the creator. Once a channel has opened, the channel account also
+// has the other participant's signer and the transaction is not valid.
+func RecoverChannelAccount(p RecoverChannelAccountParams) (*txnbuild.Transaction, error) {
+	if p.AssetBalance < 0 {
+		return nil, fmt.Errorf("invalid asset balance: cannot be negative")
+	}
+
+	ops := []txnbuild.Operation{}
+	if p.Asset != nil && !p.Asset.IsNative() {
+		if p.AssetBalance > 0 {
+			ops = append(ops, &txnbuild.Payment{
+				Destination: p.Creator.Address(),
+				Amount:      amount.StringFromInt64(p.AssetBalance),
+				Asset:       p.Asset,
+			})
+		}
+		ops = append(ops, &txnbuild.ChangeTrust{
+			Line:  p.Asset.MustToChangeTrustAsset(),
+			Limit: "0",
+		})
+	}
+	ops = append(ops, &txnbuild.AccountMerge{
+		Destination: p.Creator.Address(),
+	})
+
+	tx, err := txnbuild.NewTransaction(
+		txnbuild.TransactionParams{
+			SourceAccount: &txnbuild.SimpleAccount{
+				AccountID: p.ChannelAccount.Address(),
+				Sequence:  p.SequenceNumber,
+			},
+			BaseFee:    0,
+			Timebounds: txnbuild.NewTimeout(300),
+			Operations: ops,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,62 @@
+package txbuild
+
+import (
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverChannelAccount_native(t *testing.T) {
+	channelAccount := keypair.MustRandom().FromAddress()
+	creator := keypair.MustRandom().FromAddress()
+
+	tx, err := RecoverChannelAccount(RecoverChannelAccountParams{
+		ChannelAccount: channelAccount,
+		Creator:        creator,
+		SequenceNumber: 101,
+		Asset:          txnbuild.NativeAsset{},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, channelAccount.Address(), tx.SourceAccount().AccountID)
+	assert.Equal(t, int64(101), tx.SourceAccount().Sequence)
+	assert.Equal(t, []txnbuild.Operation{
+		&txnbuild.AccountMerge{Destination: creator.Address()},
+	}, tx.Operations())
+}
+
+func TestRecoverChannelAccount_credit(t *testing.T) {
+	channelAccount := keypair.MustRandom().FromAddress()
+	creator := keypair.MustRandom().FromAddress()
+	asset := txnbuild.CreditAsset{Code: "ABCD", Issuer: keypair.MustRandom().Address()}
+
+	tx, err := RecoverChannelAccount(RecoverChannelAccountParams{
+		ChannelAccount: channelAccount,
+		Creator:        creator,
+		SequenceNumber: 101,
+		Asset:          asset,
+		AssetBalance:   100_0000000,
+	})
+	require.NoError(t, err)
+	ops := tx.Operations()
+	require.Len(t, ops, 3)
+	assert.Equal(t, &txnbuild.Payment{Destination: creator.Address(), Amount: "100.0000000", Asset: asset}, ops[0])
+	require.IsType(t, &txnbuild.ChangeTrust{}, ops[1])
+	assert.Equal(t, "0", ops[1].(*txnbuild.ChangeTrust).Limit)
+	assert.Equal(t, &txnbuild.AccountMerge{Destination: creator.Address()}, ops[2])
+
+	// Without a balance of the asset, only the trustline is removed.
+	tx, err = RecoverChannelAccount(RecoverChannelAccountParams{
+		ChannelAccount: channelAccount,
+		Creator:        creator,
+		SequenceNumber: 101,
+		Asset:          asset,
+	})
+	require.NoError(t, err)
+	ops = tx.Operations()
+	require.Len(t, ops, 2)
+	require.IsType(t, &txnbuild.ChangeTrust{}, ops[0])
+	require.IsType(t, &txnbuild.AccountMerge{}, ops[1])
+}
@@ -0,0 +1,21 @@
+package txbuild
+
+import "github.com/stellar/go/txnbuild"
+
+// ChannelAccountSubentries returns the number of subentries a channel account
+// holds once a channel is open: one signer for each participant, and a
+// trustline if the asset is not native.
+func ChannelAccountSubentries(asset txnbuild.BasicAsset) int64 {
+	const signers = 2
+	if asset == nil || asset.IsNative() {
+		return signers
+	}
+	return signers + 1
+}
+
+// MinimumBalance returns the minimum native balance an account must hold to
+// cover the reserves for the account and the given number of subentries, given
+// the base reserve of the network.
+func MinimumBalance(baseReserve int64, subentries int64) int64 {
+	return (2 + subentries) * baseReserve
+}
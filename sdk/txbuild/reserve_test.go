@@ -0,0 +1,21 @@
+package txbuild
+
+import (
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelAccountSubentries(t *testing.T) {
+	assert.Equal(t, int64(2), ChannelAccountSubentries(txnbuild.NativeAsset{}))
+	assert.Equal(t, int64(3), ChannelAccountSubentries(txnbuild.CreditAsset{Code: "ABDC", Issuer: keypair.MustRandom().Address()}))
+}
+
+func TestMinimumBalance(t *testing.T) {
+	assert.Equal(t, int64(0), MinimumBalance(0, 3))
+	assert.Equal(t, int64(1_0000000), MinimumBalance(5000000, 0))
+	assert.Equal(t, int64(2_0000000), MinimumBalance(5000000, 2))
+	assert.Equal(t, int64(2_5000000), MinimumBalance(5000000, 3))
+}
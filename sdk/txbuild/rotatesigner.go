@@ -0,0 +1,95 @@
+package txbuild
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+)
+
+type RotateSignerParams struct {
+	InitiatorChannelAccount *keypair.FromAddress
+	ResponderChannelAccount *keypair.FromAddress
+	OldSigner               *keypair.FromAddress
+	NewSigner               *keypair.FromAddress
+	SequenceNumber          int64
+	// ExpiresAt is the max time of the transaction, after which it cannot
+	// execute, so that both participants know when a rotation that was not
+	// submitted can no longer take effect.
+	ExpiresAt time.Time
+	// SignerScheme is the signer scheme the channel was opened with, and
+	// gives the weight of the new signer. A zero value is the
+	// DefaultSignerScheme.
+	SignerScheme SignerScheme
+}
+
+// RotateSigner builds a transaction that replaces a participant's signer on
+// both channel accounts with a new signer. The old signer is the source of
+// the transaction and sponsors the new signer, so the channel accounts'
+// sequence numbers, which the channel's declaration and close transactions
+// depend on, are not consumed.
+//
+// The new signer is added and the old signer is removed in the same
+// transaction, so that at no point does either channel account have a third
+// signer that would let one participant meet the account thresholds alone.
+// The new signer is given the signer weight of the channel's signer scheme,
+// so that the channel accounts' thresholds can still be met. The transaction
+// must be signed by the old signer and the other participant's signer.
+//
+// Declaration and close transactions signed by the old signer are not valid
+// once the transaction executes, and so the participants must sign the
+// latest agreement with the new signer before submitting it. See
+// state.Channel.ProposeSignerRotation.
+func RotateSigner(p RotateSignerParams) (*txnbuild.Transaction, error) {
+	// A zero or pre-epoch expiry would result in a max time that never
+	// expires.
+	if p.ExpiresAt.Unix() <= 0 {
+		return nil, fmt.Errorf("invalid expires at: must be after unix epoch")
+	}
+	if p.OldSigner.Equal(p.NewSigner) {
+		return nil, fmt.Errorf("new signer is the same as the old signer")
+	}
+	scheme := p.SignerScheme.OrDefault()
+	err := scheme.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	ops := []txnbuild.Operation{}
+	for _, channelAccount := range []*keypair.FromAddress{p.InitiatorChannelAccount, p.ResponderChannelAccount} {
+		ops = append(ops,
+			&txnbuild.BeginSponsoringFutureReserves{
+				SourceAccount: p.OldSigner.Address(),
+				SponsoredID:   channelAccount.Address(),
+			},
+			&txnbuild.SetOptions{
+				SourceAccount: channelAccount.Address(),
+				Signer:        &txnbuild.Signer{Address: p.NewSigner.Address(), Weight: txnbuild.Threshold(scheme.SignerWeight)},
+			},
+			&txnbuild.SetOptions{
+				SourceAccount: channelAccount.Address(),
+				Signer:        &txnbuild.Signer{Address: p.OldSigner.Address(), Weight: 0},
+			},
+			&txnbuild.EndSponsoringFutureReserves{
+				SourceAccount: channelAccount.Address(),
+			},
+		)
+	}
+
+	tx, err := txnbuild.NewTransaction(
+		txnbuild.TransactionParams{
+			SourceAccount: &txnbuild.SimpleAccount{
+				AccountID: p.OldSigner.Address(),
+				Sequence:  p.SequenceNumber,
+			},
+			BaseFee:    0,
+			Timebounds: txnbuild.NewTimebounds(0, p.ExpiresAt.UTC().Unix()),
+			Operations: ops,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,108 @@
+package txbuild
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stellar/go/txnbuild"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateSigner(t *testing.T) {
+	initiatorChannelAccount := keypair.MustRandom().FromAddress()
+	responderChannelAccount := keypair.MustRandom().FromAddress()
+	oldSigner := keypair.MustRandom().FromAddress()
+	newSigner := keypair.MustRandom().FromAddress()
+
+	tx, err := RotateSigner(RotateSignerParams{
+		InitiatorChannelAccount: initiatorChannelAccount,
+		ResponderChannelAccount: responderChannelAccount,
+		OldSigner:               oldSigner,
+		NewSigner:               newSigner,
+		SequenceNumber:          101,
+		ExpiresAt:               time.Unix(1_700_000_000, 0),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, oldSigner.Address(), tx.SourceAccount().AccountID)
+	assert.Equal(t, int64(101), tx.SourceAccount().Sequence)
+	assert.Equal(t, int64(1_700_000_000), tx.Timebounds().MaxTime)
+
+	ops := tx.Operations()
+	require.Len(t, ops, 8)
+	for i, channelAccount := range []*keypair.FromAddress{initiatorChannelAccount, responderChannelAccount} {
+		ops := ops[i*4 : i*4+4]
+		require.IsType(t, &txnbuild.BeginSponsoringFutureReserves{}, ops[0])
+		assert.Equal(t, oldSigner.Address(), ops[0].(*txnbuild.BeginSponsoringFutureReserves).SourceAccount)
+		assert.Equal(t, channelAccount.Address(), ops[0].(*txnbuild.BeginSponsoringFutureReserves).SponsoredID)
+
+		require.IsType(t, &txnbuild.SetOptions{}, ops[1])
+		add := ops[1].(*txnbuild.SetOptions)
+		assert.Equal(t, channelAccount.Address(), add.SourceAccount)
+		assert.Equal(t, &txnbuild.Signer{Address: newSigner.Address(), Weight: 1}, add.Signer)
+
+		require.IsType(t, &txnbuild.SetOptions{}, ops[2])
+		remove := ops[2].(*txnbuild.SetOptions)
+		assert.Equal(t, channelAccount.Address(), remove.SourceAccount)
+		assert.Equal(t, &txnbuild.Signer{Address: oldSigner.Address(), Weight: 0}, remove.Signer)
+
+		require.IsType(t, &txnbuild.EndSponsoringFutureReserves{}, ops[3])
+		assert.Equal(t, channelAccount.Address(), ops[3].(*txnbuild.EndSponsoringFutureReserves).SourceAccount)
+	}
+}
+
+func TestRotateSigner_signerScheme(t *testing.T) {
+	oldSigner := keypair.MustRandom().FromAddress()
+	newSigner := keypair.MustRandom().FromAddress()
+	params := RotateSignerParams{
+		InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		OldSigner:               oldSigner,
+		NewSigner:               newSigner,
+		SequenceNumber:          101,
+		ExpiresAt:               time.Unix(1_700_000_000, 0),
+		SignerScheme:            SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4},
+	}
+
+	// The new signer has the weight of the scheme on both channel accounts.
+	tx, err := RotateSigner(params)
+	require.NoError(t, err)
+	ops := tx.Operations()
+	require.Len(t, ops, 8)
+	for _, op := range []txnbuild.Operation{ops[1], ops[5]} {
+		require.IsType(t, &txnbuild.SetOptions{}, op)
+		assert.Equal(t, &txnbuild.Signer{Address: newSigner.Address(), Weight: 2}, op.(*txnbuild.SetOptions).Signer)
+	}
+
+	// An invalid scheme is an error.
+	params.SignerScheme = SignerScheme{SignerWeight: 2, Threshold: 2}
+	_, err = RotateSigner(params)
+	assert.Error(t, err)
+}
+
+func TestRotateSigner_sameSigner(t *testing.T) {
+	signer := keypair.MustRandom().FromAddress()
+	_, err := RotateSigner(RotateSignerParams{
+		InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		OldSigner:               signer,
+		NewSigner:               signer,
+		SequenceNumber:          101,
+		ExpiresAt:               time.Unix(1_700_000_000, 0),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "new signer is the same as the old signer")
+}
+
+func TestRotateSigner_noExpiry(t *testing.T) {
+	_, err := RotateSigner(RotateSignerParams{
+		InitiatorChannelAccount: keypair.MustRandom().FromAddress(),
+		ResponderChannelAccount: keypair.MustRandom().FromAddress(),
+		OldSigner:               keypair.MustRandom().FromAddress(),
+		NewSigner:               keypair.MustRandom().FromAddress(),
+		SequenceNumber:          101,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid expires at")
+}
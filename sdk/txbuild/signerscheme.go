@@ -0,0 +1,56 @@
+package txbuild
+
+import (
+	"fmt"
+)
+
+// SignerScheme is the weights and thresholds the open transaction sets on
+// both channel accounts. The zero value is DefaultSignerScheme.
+//
+// MasterWeight is the weight of each channel account's master key, which
+// allows whoever holds the key, such as the creator of the channel account, to
+// retain a recovery signer that cannot act alone. SignerWeight is the weight
+// of each participant's signer, and Threshold is the low, medium and high
+// threshold of the channel accounts.
+type SignerScheme struct {
+	MasterWeight uint8
+	SignerWeight uint8
+	Threshold    uint8
+}
+
+// DefaultSignerScheme is the signer scheme of a 2-of-2 multisig of both
+// participants' signers, where the master key has no weight.
+var DefaultSignerScheme = SignerScheme{
+	MasterWeight: 0,
+	SignerWeight: 1,
+	Threshold:    2,
+}
+
+// OrDefault returns the scheme, or DefaultSignerScheme if the scheme is the
+// zero value.
+func (s SignerScheme) OrDefault() SignerScheme {
+	if s == (SignerScheme{}) {
+		return DefaultSignerScheme
+	}
+	return s
+}
+
+// Validate returns an error if the scheme does not require the signers of
+// both participants to meet the thresholds, and so payments and closes could
+// be authorized by one participant alone.
+func (s SignerScheme) Validate() error {
+	master, signer, threshold := int(s.MasterWeight), int(s.SignerWeight), int(s.Threshold)
+	if signer == 0 {
+		return fmt.Errorf("invalid signer scheme: signer weight must be greater than 0")
+	}
+	if signer >= threshold {
+		return fmt.Errorf("invalid signer scheme: signer weight %d meets threshold %d alone", signer, threshold)
+	}
+	if 2*signer < threshold {
+		return fmt.Errorf("invalid signer scheme: both signers with weight %d do not meet threshold %d", signer, threshold)
+	}
+	if master+signer >= threshold {
+		return fmt.Errorf("invalid signer scheme: master weight %d and one signer with weight %d meet threshold %d", master, signer, threshold)
+	}
+	return nil
+}
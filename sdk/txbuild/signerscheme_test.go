@@ -0,0 +1,37 @@
+package txbuild
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignerScheme_OrDefault(t *testing.T) {
+	assert.Equal(t, DefaultSignerScheme, SignerScheme{}.OrDefault())
+	s := SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4}
+	assert.Equal(t, s, s.OrDefault())
+}
+
+func TestSignerScheme_Validate(t *testing.T) {
+	testCases := []struct {
+		scheme  SignerScheme
+		wantErr string
+	}{
+		{DefaultSignerScheme, ""},
+		{SignerScheme{MasterWeight: 1, SignerWeight: 2, Threshold: 4}, ""},
+		{SignerScheme{MasterWeight: 0, SignerWeight: 128, Threshold: 255}, ""},
+		{SignerScheme{MasterWeight: 0, SignerWeight: 0, Threshold: 0}, "invalid signer scheme: signer weight must be greater than 0"},
+		{SignerScheme{MasterWeight: 0, SignerWeight: 2, Threshold: 2}, "invalid signer scheme: signer weight 2 meets threshold 2 alone"},
+		{SignerScheme{MasterWeight: 0, SignerWeight: 1, Threshold: 3}, "invalid signer scheme: both signers with weight 1 do not meet threshold 3"},
+		{SignerScheme{MasterWeight: 1, SignerWeight: 1, Threshold: 2}, "invalid signer scheme: master weight 1 and one signer with weight 1 meet threshold 2"},
+		{SignerScheme{MasterWeight: 2, SignerWeight: 2, Threshold: 4}, "invalid signer scheme: master weight 2 and one signer with weight 2 meet threshold 4"},
+	}
+	for _, tc := range testCases {
+		err := tc.scheme.Validate()
+		if tc.wantErr == "" {
+			assert.NoError(t, err, "%+v", tc.scheme)
+		} else {
+			assert.EqualError(t, err, tc.wantErr, "%+v", tc.scheme)
+		}
+	}
+}